@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ageHeaders start files encrypted with age, binary or armored.
+var ageHeaders = []string{"age-encryption.org/v1\n", "-----BEGIN AGE ENCRYPTED FILE-----"}
+
+// ageWriter encrypts what is written to it into a file with the age tool.
+type ageWriter struct {
+	io.WriteCloser // age's stdin
+	cmd            *exec.Cmd
+	f              *os.File
+}
+
+// ageEncrypt returns a writer that encrypts to recipient into f. The
+// recipient is an age public key, an SSH public key, or a file with one
+// or more of them, such as ~/.ssh/id_ed25519.pub.
+func ageEncrypt(f *os.File, recipient string) (*ageWriter, error) {
+	args := []string{"-r", recipient}
+	if _, err := os.Stat(recipient); err == nil {
+		args = []string{"-R", recipient}
+	}
+	cmd := exec.Command("age", args...)
+	cmd.Stdout = f
+	cmd.Stderr = os.Stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("encrypting needs age (https://age-encryption.org): %w", err)
+	}
+	return &ageWriter{WriteCloser: in, cmd: cmd, f: f}, nil
+}
+
+// Close finishes the encrypted file.
+func (a *ageWriter) Close() error {
+	a.WriteCloser.Close()
+	err := a.cmd.Wait()
+	if cerr := a.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// readMaybeEncrypted reads path, decrypting it with identity, a private age
+// or SSH key file, when it was encrypted with age.
+func readMaybeEncrypted(path, identity string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	encrypted := false
+	for _, h := range ageHeaders {
+		encrypted = encrypted || strings.HasPrefix(string(data), h)
+	}
+	if !encrypted {
+		return data, nil
+	}
+	if identity == "" {
+		return nil, fmt.Errorf("%s is encrypted; pass the key to decrypt it with -i", path)
+	}
+	var out, errOut bytes.Buffer
+	cmd := exec.Command("age", "-d", "-i", identity)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(errOut.String()); msg != "" {
+			return nil, fmt.Errorf("decrypting %s: %s", path, msg)
+		}
+		return nil, fmt.Errorf("decrypting %s needs age (https://age-encryption.org): %w", path, err)
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/f0i/status-light/pkg/backend"
+	"github.com/f0i/status-light/pkg/state"
+	"github.com/f0i/status-light/pkg/wrap"
+)
+
+// cmdAttach observes a process that was started without sl. Output of
+// another process's terminal can't be read without ptrace, so this works
+// when the process writes to a regular file or runs inside a tmux pane,
+// whose contents tmux can hand us.
+func cmdAttach(args []string) int {
+	fs := flag.NewFlagSet("attach", flag.ExitOnError)
+	tool := fs.String("tool", "", "config `name` to load (default: detected from the process's command line)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s attach [options] <pid>\n\nOptions:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+	pid, err := strconv.Atoi(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid pid: %s\n", fs.Arg(0))
+		return 1
+	}
+
+	procDir := fmt.Sprintf("/proc/%d", pid)
+	target, err := os.Readlink(filepath.Join(procDir, "fd", "1"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot inspect process %d: %v\n", pid, err)
+		return 1
+	}
+	if *tool == "" {
+		// The command line shows the tool behind an interpreter, such as
+		// node running claude's cli.js
+		if cmdline, err := os.ReadFile(filepath.Join(procDir, "cmdline")); err == nil && len(cmdline) > 1 {
+			*tool = detectTool(strings.Split(strings.TrimSuffix(string(cmdline), "\x00"), "\x00"))
+		} else if comm, err := os.ReadFile(filepath.Join(procDir, "comm")); err == nil {
+			*tool = strings.TrimSpace(string(comm))
+		}
+	}
+
+	// Both readers end the stream once the observed process is gone
+	alive := func() bool {
+		stat, err := os.ReadFile(filepath.Join(procDir, "stat"))
+		if err != nil {
+			return false
+		}
+		// The state follows the parenthesized command name; Z is a zombie
+		i := bytes.LastIndexByte(stat, ')')
+		return i >= 0 && i+2 < len(stat) && stat[i+2] != 'Z'
+	}
+	var src io.Reader
+	cols, rows := 80, 24
+	name := ""
+	if info, err := os.Stat(target); err == nil && info.Mode().IsRegular() {
+		src = &followReader{path: target, alive: alive}
+	} else if pane, w, h, ok := tmuxPaneForTTY(target); ok {
+		src = &tmuxPaneReader{pane: pane, alive: alive}
+		cols, rows = w, h
+		name = tmuxName(pane)
+	} else {
+		fmt.Fprintf(os.Stderr, "Cannot capture output of process %d: stdout is %s\n", pid, target)
+		fmt.Fprintf(os.Stderr, "Attach works for processes writing to a file or running inside tmux.\n")
+		return 1
+	}
+
+	cfg := loadConfig(*tool)
+	local := ledFromConfig(cfg)
+	quiet := backend.StartQuiet(cfg.QuietHours, cfg.Presence, local)
+	led := newIndicator(*tool, name, localBackends(cfg, local))
+	mon := state.NewMonitor(cfg.Config, led)
+	mon.Tool = *tool
+	mon.Name = name
+	mon.Quiet = quiet
+	mon.Screen.AutoCR = true
+	mon.Screen.Resize(cols, rows)
+
+	session := wrap.WatchReader(src)
+	mon.Start(mon.Clock.Now())
+	wrap.Loop(mon, session, nil, nil, reportSession(led, mon))
+	backend.Shutdown(led)
+	return 0
+}
+
+// tmuxPaneForTTY finds the tmux pane whose terminal is tty.
+func tmuxPaneForTTY(tty string) (pane string, cols, rows int, ok bool) {
+	out, err := exec.Command("tmux", "list-panes", "-a", "-F", "#{pane_tty} #{pane_id} #{pane_width} #{pane_height}").Output()
+	if err != nil {
+		return "", 0, 0, false
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		f := strings.Fields(line)
+		if len(f) == 4 && f[0] == tty {
+			cols, _ = strconv.Atoi(f[2])
+			rows, _ = strconv.Atoi(f[3])
+			return f[1], cols, rows, true
+		}
+	}
+	return "", 0, 0, false
+}
+
+// tmuxName names the session running in a tmux pane after its title, when
+// something set one, or after its window when that was named by hand, so
+// sessions started without --name can still be told apart. Otherwise it
+// is where to find the pane, such as "work:2" or "work:2.1" in a window
+// with several panes. It returns "" outside tmux.
+func tmuxName(pane string) string {
+	if pane == "" {
+		return ""
+	}
+	out, err := exec.Command("tmux", "display-message", "-p", "-t", pane,
+		"#{pane_title}\t#{host}\t#{host_short}\t#{window_name}\t#{automatic-rename}\t#{session_name}\t#{window_index}\t#{window_panes}\t#{pane_index}").Output()
+	if err != nil {
+		return ""
+	}
+	f := strings.Split(strings.TrimSuffix(string(out), "\n"), "\t")
+	if len(f) != 9 {
+		return ""
+	}
+	title, host, hostShort, window, autoRename := strings.TrimSpace(f[0]), f[1], f[2], strings.TrimSpace(f[3]), f[4]
+	switch {
+	case title != "" && title != host && title != hostShort:
+		// The default title is the host name
+		return title
+	case window != "" && autoRename == "0":
+		return window
+	case f[7] != "1":
+		return fmt.Sprintf("%s:%s.%s", f[5], f[6], f[8])
+	}
+	return fmt.Sprintf("%s:%s", f[5], f[6])
+}
+
+// tmuxPaneReader polls a pane's visible contents. Each time they change it
+// emits a full redraw, so the screen model mirrors the pane and unchanged
+// contents count as silence.
+type tmuxPaneReader struct {
+	pane  string
+	alive func() bool
+	last  []byte
+	pend  []byte
+}
+
+func (t *tmuxPaneReader) Read(p []byte) (int, error) {
+	for len(t.pend) == 0 {
+		if !t.alive() {
+			return 0, io.EOF
+		}
+		out, err := exec.Command("tmux", "capture-pane", "-p", "-t", t.pane).Output()
+		if err != nil {
+			return 0, io.EOF
+		}
+		if !bytes.Equal(out, t.last) {
+			t.last = out
+			t.pend = append([]byte("\x1b[H\x1b[2J"), bytes.ReplaceAll(bytes.TrimRight(out, "\n"), []byte("\n"), []byte("\r\n"))...)
+			break
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+	n := copy(p, t.pend)
+	t.pend = t.pend[n:]
+	return n, nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// AutoAnswerConfig answers prompts that would otherwise hold up an
+// unattended run, such as "Press Enter to continue" overnight. Nothing is
+// answered unless rules are given.
+type AutoAnswerConfig struct {
+	Rules []AutoAnswerRule `json:"rules"`
+	// File every answer is appended to, by default auto-answer.log in
+	// the status-light config directory
+	Log string `json:"log"`
+}
+
+// AutoAnswerRule answers one prompt. It only fires while the session is
+// waiting with exactly Prompt as the last line on screen, after AfterMs
+// without anyone typing, and at most Max times per session.
+type AutoAnswerRule struct {
+	Prompt  string   `json:"prompt"`
+	AfterMs int      `json:"after_ms"`
+	Keys    []string `json:"keys"` // from state.Keys, e.g. ["y", "enter"]
+	Max     int      `json:"max"`  // default 1
+}
+
+// minAutoAnswer is the shortest wait before answering, so whoever is at
+// the terminal gets to answer first.
+const minAutoAnswer = 5 * time.Second
+
+// autoAnswerOff is the kill switch "sl auto-answer off" creates in the
+// status-light config directory. Every session checks it before answering.
+const autoAnswerOff = "auto-answer.off"
+
+func autoAnswerDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "status-light"), nil
+}
+
+// problems lists what is wrong with r; rules with problems are skipped.
+func (r AutoAnswerRule) problems() []string {
+	var problems []string
+	if strings.TrimSpace(r.Prompt) == "" {
+		problems = append(problems, "auto_answer rule without a prompt is skipped")
+		return problems
+	}
+	if time.Duration(r.AfterMs)*time.Millisecond < minAutoAnswer {
+		problems = append(problems, fmt.Sprintf("auto_answer rule for %q is skipped: after_ms must be at least %d", r.Prompt, minAutoAnswer.Milliseconds()))
+	}
+	if len(r.Keys) == 0 {
+		problems = append(problems, fmt.Sprintf("auto_answer rule for %q is skipped: it has no keys", r.Prompt))
+	}
+	for _, k := range r.Keys {
+		// Only single keys, so that no rule can type a command into an agent
+		if _, ok := state.Keys[k]; !ok {
+			problems = append(problems, fmt.Sprintf("auto_answer rule for %q is skipped: unknown key %q (%s)", r.Prompt, k, state.KeyNames()))
+		}
+	}
+	return problems
+}
+
+// autoAnswer answers the prompts of one session. Its tick runs on the
+// monitor's goroutine, so it can read the screen.
+type autoAnswer struct {
+	mon     *state.Monitor
+	input   io.Writer // the wrapped program's input
+	rules   []AutoAnswerRule
+	answers []int // per rule
+	log     string
+	dir     string
+
+	typed  time.Time // last input seen
+	screen string    // screen while waiting
+	since  time.Time // when it appeared
+	closed bool      // its prompt was answered or refused
+}
+
+// startAutoAnswer returns the rules of cfg that are valid, ready to be
+// ticked, or nil when there are none.
+func startAutoAnswer(cfg *AutoAnswerConfig, mon *state.Monitor, input io.Writer) *autoAnswer {
+	if cfg == nil {
+		return nil
+	}
+	a := &autoAnswer{mon: mon, input: input, log: os.ExpandEnv(cfg.Log)}
+	for _, r := range cfg.Rules {
+		if problems := r.problems(); len(problems) > 0 {
+			for _, p := range problems {
+				fmt.Fprintf(os.Stderr, "%s\n", p)
+			}
+			continue
+		}
+		r.Prompt = strings.TrimSpace(r.Prompt)
+		a.rules = append(a.rules, r)
+	}
+	if len(a.rules) == 0 {
+		return nil
+	}
+	a.answers = make([]int, len(a.rules))
+	a.dir, _ = autoAnswerDir()
+	if a.log == "" && a.dir != "" {
+		a.log = filepath.Join(a.dir, "auto-answer.log")
+	}
+	return a
+}
+
+// tick answers the prompt on screen when a rule's time has come.
+func (a *autoAnswer) tick() {
+	now := a.mon.Clock.Now()
+	var lines []string
+	if a.mon.State == state.Waiting {
+		lines = a.mon.Screen.Lines()
+	}
+	line := ""
+	if len(lines) > 0 {
+		line = strings.TrimSpace(lines[len(lines)-1])
+	}
+	if typed := a.mon.LastInput(); typed.After(a.typed) {
+		// Someone is there: the prompt is theirs to answer
+		a.typed = typed
+		a.since = now
+	}
+	// The same prompt again shows on a screen that scrolled
+	if screen := strings.Join(lines, "\n"); screen != a.screen {
+		a.screen, a.since, a.closed = screen, now, false
+	}
+	if line == "" || a.closed {
+		return
+	}
+	for i, r := range a.rules {
+		limit := r.Max
+		if limit <= 0 {
+			limit = 1
+		}
+		if r.Prompt != line || now.Sub(a.since) < time.Duration(r.AfterMs)*time.Millisecond {
+			continue
+		}
+		a.closed = true
+		switch {
+		case a.answers[i] >= limit:
+			a.record(now, "not answered %q: max of %d answers reached", line, limit)
+		case a.killed():
+			a.record(now, "not answered %q: auto-answer is off", line)
+		case state.DryRunf("auto-answer: %q with %s", line, strings.Join(r.Keys, " ")):
+		case a.record(now, "answered %q with %s after %s", line, strings.Join(r.Keys, " "), now.Sub(a.since).Round(time.Second)):
+			// Only what could be logged is answered
+			a.answers[i]++
+			for _, k := range r.Keys {
+				a.input.Write([]byte(state.Keys[k]))
+			}
+		}
+		return
+	}
+}
+
+// killed reports whether "sl auto-answer off" is in effect.
+func (a *autoAnswer) killed() bool {
+	if a.dir == "" {
+		return true
+	}
+	_, err := os.Stat(filepath.Join(a.dir, autoAnswerOff))
+	return err == nil
+}
+
+// record appends what happened to the log and reports whether it could.
+func (a *autoAnswer) record(now time.Time, format string, args ...any) bool {
+	line := fmt.Sprintf("%s %s (pid %d): %s\n", now.Format(time.RFC3339), a.mon.Label(), os.Getpid(), fmt.Sprintf(format, args...))
+	if os.Getenv("DEBUG_SL") != "" {
+		fmt.Fprintf(os.Stderr, "[DEBUG] Auto-answer: %s", line)
+	}
+	if a.log == "" {
+		return false
+	}
+	os.MkdirAll(filepath.Dir(a.log), 0755)
+	f, err := os.OpenFile(a.log, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Auto-answer log: %v\n", err)
+		return false
+	}
+	defer f.Close()
+	_, err = f.WriteString(line)
+	return err == nil
+}
+
+// cmdAutoAnswer turns auto-answering off and on again for all sessions,
+// or shows whether it is on and the last answers.
+func cmdAutoAnswer(args []string) int {
+	dir, err := autoAnswerDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	kill := filepath.Join(dir, autoAnswerOff)
+	switch {
+	case len(args) == 0:
+		if _, err := os.Stat(kill); err == nil {
+			fmt.Println("Auto-answer is off")
+		} else {
+			fmt.Println("Auto-answer is on for configs with auto_answer rules")
+		}
+		log := filepath.Join(dir, "auto-answer.log")
+		f, err := os.Open(log)
+		if err != nil {
+			return 0
+		}
+		defer f.Close()
+		var lines []string
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		fmt.Printf("\nLast answers in %s:\n", log)
+		for _, l := range lines[max(len(lines)-10, 0):] {
+			fmt.Printf("  %s\n", l)
+		}
+		return 0
+	case len(args) == 1 && args[0] == "off":
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		if err := os.WriteFile(kill, nil, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		fmt.Println("Auto-answer is off for all sessions")
+		return 0
+	case len(args) == 1 && args[0] == "on":
+		if err := os.Remove(kill); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		fmt.Println("Auto-answer is on")
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "Usage: %s auto-answer [on|off]\n", os.Args[0])
+	return 2
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/f0i/status-light/internal/testfeed"
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// answering ticks auto-answer after the monitor, as sl does.
+type answering struct {
+	*state.Monitor
+	a *autoAnswer
+}
+
+func (m answering) Tick(now time.Time) {
+	m.Monitor.Tick(now)
+	m.a.tick()
+}
+
+func TestAutoAnswer(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		max    int
+		off    bool
+		sent   string
+		log    []string
+	}{
+		{"answered", `0s "Continue? (y/n) "`, 0, false, "y\r",
+			[]string{`answered "Continue? (y/n)" with y enter after 5s`}},
+		{"too early", `0s "Continue? (y/n) "
+			3s "\r\nContinue? (y/n) "`, 0, false, "y\r",
+			[]string{`answered "Continue? (y/n)" with y enter after 5s`}},
+		{"max reached", `0s "Continue? (y/n) "
+			6s "\r\nContinue? (y/n) "`, 0, false, "y\r",
+			[]string{`answered "Continue? (y/n)" with y enter after 5s`, `not answered "Continue? (y/n)": max of 1 answers reached`}},
+		{"max of 2", `0s "Continue? (y/n) "
+			6s "\r\nContinue? (y/n) "`, 2, false, "y\ry\r",
+			[]string{`answered "Continue? (y/n)" with y enter after 5s`, `answered "Continue? (y/n)" with y enter after 5s`}},
+		{"other prompt", `0s "Overwrite? (y/n) "`, 0, false, "", nil},
+		{"off", `0s "Continue? (y/n) "`, 0, true, "",
+			[]string{`not answered "Continue? (y/n)": auto-answer is off`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Setenv("XDG_CONFIG_HOME", dir)
+			if tt.off {
+				os.MkdirAll(filepath.Join(dir, "status-light"), 0755)
+				os.WriteFile(filepath.Join(dir, "status-light", autoAnswerOff), nil, 0644)
+			}
+			steps, err := testfeed.Parse(strings.NewReader(tt.script))
+			if err != nil {
+				t.Fatal(err)
+			}
+			clock := testfeed.NewClock()
+			cfg := state.Config{Patterns: state.Patterns{Waiting: []string{`\(y/n\)`}}}
+			mon := state.NewMonitor(cfg, testfeed.NewRecorder(clock))
+			mon.Clock = clock
+			var input bytes.Buffer
+			rules := []AutoAnswerRule{{Prompt: "Continue? (y/n)", AfterMs: 5000, Keys: []string{"y", "enter"}, Max: tt.max}}
+			a := startAutoAnswer(&AutoAnswerConfig{Rules: rules}, mon, &input)
+			testfeed.Play(answering{mon, a}, clock, steps, 15*time.Second)
+
+			if input.String() != tt.sent {
+				t.Errorf("sent %q, want %q", input.String(), tt.sent)
+			}
+			data, _ := os.ReadFile(filepath.Join(dir, "status-light", "auto-answer.log"))
+			var log []string
+			for _, l := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+				if _, msg, ok := strings.Cut(l, "): "); ok {
+					log = append(log, msg)
+				}
+			}
+			if strings.Join(log, "\n") != strings.Join(tt.log, "\n") {
+				t.Errorf("logged %q, want %q", log, tt.log)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/f0i/status-light/pkg/backend"
+	"github.com/f0i/status-light/pkg/state"
+	"golang.org/x/term"
+)
+
+// localBackends returns the LED together with the other outputs enabled in
+// cfg.
+func localBackends(cfg Config, led *backend.LEDController) state.Indicator {
+	out := backend.Indicators{led}
+	if z := cfg.Zones; z != nil {
+		out[0] = backend.NewZones(zoneIndicator(led, z.Activity), zoneIndicator(led, z.Attention))
+	}
+	var fallback state.Indicator
+	if f := cfg.Failover; f != nil && f.Fallback == "title" {
+		if term.IsTerminal(int(os.Stdout.Fd())) {
+			fallback = backend.NewTitle(os.Stdout)
+		} else {
+			fmt.Fprintf(os.Stderr, "Ignoring failover: the title needs a terminal\n")
+		}
+	}
+	if cfg.Lamp != nil {
+		if f := cfg.Failover; f != nil && f.Fallback == "lamp" {
+			fallback = backend.NewCommandLamp(*cfg.Lamp)
+		} else {
+			out = append(out, backend.NewCommandLamp(*cfg.Lamp))
+		}
+	}
+	if cfg.BLE != nil {
+		if l, err := backend.NewBLELamp(*cfg.BLE); err != nil {
+			fmt.Fprintf(os.Stderr, "Ignoring ble: %v\n", err)
+		} else if f := cfg.Failover; f != nil && f.Fallback == "ble" {
+			fallback = l
+		} else {
+			out = append(out, l)
+		}
+	}
+	if fallback != nil {
+		out[0] = backend.NewFailover(out[0], fallback, time.Duration(cfg.Failover.CheckMs)*time.Millisecond)
+	}
+	if cfg.StateFile != "" {
+		out = append(out, backend.NewStateFile(cfg.StateFile))
+	}
+	// Last, so the bar finds the state file already updated
+	if cfg.BarSignal > 0 {
+		out = append(out, backend.NewBarSignal(cfg.BarSignal))
+	}
+	if len(out) == 1 {
+		return out[0]
+	}
+	return out
+}
+
+// zoneIndicator returns the indicator for one channel of "zones".
+func zoneIndicator(led *backend.LEDController, zone backend.Zone) state.Indicator {
+	if zone.Lamp != nil {
+		return backend.NewCommandLamp(*zone.Lamp)
+	}
+	return led.Zone(zone.Pixels)
+}
+
+// ledFromConfig returns an LED controller set up for cfg. It also selects
+// the theme, which applies to all backends.
+func ledFromConfig(cfg Config) *backend.LEDController {
+	applyTheme(cfg)
+	l := backend.NewLEDController()
+	l.Pixels = cfg.LEDCount
+	l.Device = cfg.LEDDevice
+	l.Fade = time.Duration(cfg.FadeMs) * time.Millisecond
+	return l
+}
+
+func applyTheme(cfg Config) {
+	if err := backend.SetTheme(cfg.Theme); err != nil {
+		fmt.Fprintf(os.Stderr, "Ignoring theme: %v\n", err)
+	}
+}
+
+// screenLines is how many lines of a session the daemon keeps.
+const screenLines = 5
+
+// reportSession returns a tick callback that sends the end of mon's screen
+// and the session's cost to the daemon about once a second, or nil when
+// led isn't connected to one.
+func reportSession(led state.Indicator, mon *state.Monitor) func() {
+	// The simulated LED and others may wrap the client
+	c, ok := led.(interface{ Client() *backend.DaemonClient })
+	if !ok || c.Client() == nil {
+		return nil
+	}
+	client := c.Client()
+	ticks := 0
+	var last string
+	var cost float64
+	return func() {
+		if ticks++; ticks%10 != 0 {
+			return
+		}
+		lines := mon.Screen.Lines()
+		lines = lines[max(len(lines)-screenLines, 0):]
+		if joined := strings.Join(lines, "\n"); joined != last {
+			last = joined
+			client.SetScreen(lines)
+		}
+		if mon.Cost != cost {
+			cost = mon.Cost
+			client.SetCost(cost)
+		}
+	}
+}
+
+// newIndicator uses the daemon when one is running and the local backends
+// otherwise. name labels the session there, if given.
+func newIndicator(tool, name string, local state.Indicator) state.Indicator {
+	if c, err := backend.DialDaemon(backend.SocketPath(), tool, name, local); err == nil {
+		if os.Getenv("DEBUG_SL") != "" {
+			fmt.Fprintf(os.Stderr, "[DEBUG] Connected to daemon at %s\n", backend.SocketPath())
+		}
+		return c
+	}
+	return local
+}
+
+// cmdBackend runs "sl backend <command>", which helps set up backends.
+func cmdBackend(args []string) int {
+	if len(args) == 0 || args[0] != "scan-ble" {
+		fmt.Fprintf(os.Stderr, "Usage: %s backend scan-ble [--duration 10s]\n", os.Args[0])
+		return 2
+	}
+	fs := flag.NewFlagSet("scan-ble", flag.ExitOnError)
+	duration := fs.Duration("duration", 10*time.Second, "how long to listen for lights")
+	fs.Parse(args[1:])
+
+	fmt.Fprintf(os.Stderr, "Scanning for %s...\n", *duration)
+	seen := map[string]backend.BLEDevice{}
+	err := backend.ScanBLE(*duration, func(dev backend.BLEDevice) {
+		// Names often come only with some of the advertisements
+		if old, ok := seen[dev.Address]; ok && dev.Name == "" {
+			dev.Name = old.Name
+		}
+		seen[dev.Address] = dev
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Scan failed: %v\n", err)
+		return 1
+	}
+	var devs []backend.BLEDevice
+	for _, dev := range seen {
+		devs = append(devs, dev)
+	}
+	sort.Slice(devs, func(i, j int) bool { return devs[i].RSSI > devs[j].RSSI })
+	fmt.Printf("%-17s  %4s  %-6s  %s\n", "ADDRESS", "RSSI", "KIND", "NAME")
+	for _, dev := range devs {
+		kind := backend.BLEKind(dev.Name)
+		if kind == "" {
+			kind = "-"
+		}
+		addr := dev.Address
+		if dev.Random {
+			addr += "*"
+		}
+		fmt.Printf("%-18s %4d  %-6s  %s\n", addr, dev.RSSI, kind, dev.Name)
+	}
+	fmt.Println("\n* random address, set \"random_address\": true")
+	return 0
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/f0i/status-light/pkg/backend"
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// barState is what "sl bar" shows.
+type barState struct {
+	State   string
+	Effect  state.Effect
+	Tooltip string
+}
+
+// readBarState asks the daemon for the state and falls back to the state
+// file.
+func readBarState(stateFile string) barState {
+	if st, err := queryStatus(backend.SocketPath()); err == nil {
+		bs := barState{State: st.State, Effect: st.Effect}
+		var lines []string
+		for _, s := range st.Sessions {
+			lines = append(lines, fmt.Sprintf("%s: %s", sessionLabel(s.Tool, s.Name), s.State))
+		}
+		bs.Tooltip = strings.Join(lines, "\n")
+		return bs
+	}
+	if stateFile != "" {
+		if data, err := os.ReadFile(backend.NewStateFile(stateFile).Path()); err == nil {
+			fields := strings.Fields(string(data))
+			if len(fields) >= 3 {
+				bs := barState{State: fields[0]}
+				if fields[2] != "solid" {
+					bs.Effect = state.Effect(fields[2])
+				}
+				return bs
+			}
+		}
+	}
+	return barState{State: "unknown"}
+}
+
+func (bs barState) color() string {
+	st, ok := state.ParseState(bs.State)
+	if !ok || bs.Effect == state.EffectOff {
+		return "#808080"
+	}
+	r, g, b := backend.EffectColor(st, bs.Effect)
+	return fmt.Sprintf("#%02x%02x%02x", r, g, b)
+}
+
+func (bs barState) print(format string) {
+	switch format {
+	case "i3blocks":
+		// full_text, short_text and color lines
+		fmt.Printf("%s\n%s\n%s\n", bs.State, bs.State[:1], bs.color())
+	default:
+		class := bs.State
+		if bs.Effect != state.EffectSolid {
+			class += " " + string(bs.Effect)
+		}
+		out, _ := json.Marshal(map[string]string{
+			"text":    bs.State,
+			"alt":     bs.State,
+			"class":   class,
+			"tooltip": bs.Tooltip,
+		})
+		fmt.Println(string(out))
+	}
+}
+
+// cmdBar prints the state for a status bar: waybar custom module JSON or
+// i3blocks lines. With -follow it keeps printing a line per change, for
+// bars that read a continuous stream.
+func cmdBar(args []string) int {
+	fs := flag.NewFlagSet("bar", flag.ExitOnError)
+	format := fs.String("format", "waybar", "output `format`: waybar or i3blocks")
+	follow := fs.Bool("follow", false, "keep running and print every change")
+	interval := fs.Duration("interval", time.Second, "poll `interval` with -follow")
+	fs.Parse(args)
+	if *format != "waybar" && *format != "i3blocks" {
+		fmt.Fprintf(os.Stderr, "Unknown format %q\n", *format)
+		return 2
+	}
+
+	cfg := loadConfig("daemon")
+	applyTheme(cfg)
+	stateFile := cfg.StateFile
+	last := readBarState(stateFile)
+	last.print(*format)
+	for *follow {
+		time.Sleep(*interval)
+		if bs := readBarState(stateFile); bs != last {
+			bs.print(*format)
+			last = bs
+		}
+	}
+	return 0
+}
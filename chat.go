@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// ChatConfig posts waiting prompts to a Telegram or Discord bot. With
+// Reply set, an answer to the posted prompt is typed into the wrapped
+// program followed by Enter, e.g. "y" to approve an agent's action.
+type ChatConfig struct {
+	Telegram *TelegramChat `json:"telegram"`
+	Discord  *DiscordChat  `json:"discord"`
+	Reply    bool          `json:"reply"`
+}
+
+type TelegramChat struct {
+	Token  string `json:"token"`
+	ChatID int64  `json:"chat_id"`
+	// Users whose replies are typed into the session, by user ID; replies
+	// need at least one
+	Users []int64 `json:"users"`
+}
+
+type DiscordChat struct {
+	Token     string `json:"token"` // bot token
+	ChannelID string `json:"channel_id"`
+	// Users whose replies are typed into the session, by user ID; replies
+	// need at least one
+	Users []string `json:"users"`
+}
+
+// problems lists what keeps c from working as configured.
+func (c *ChatConfig) problems() []string {
+	var problems []string
+	if !c.Reply {
+		return nil
+	}
+	if t := c.Telegram; t != nil && t.Token != "" && len(t.Users) == 0 {
+		problems = append(problems, "chat telegram replies are ignored: set users to the user IDs allowed to answer")
+	}
+	if d := c.Discord; d != nil && d.Token != "" && len(d.Users) == 0 {
+		problems = append(problems, "chat discord replies are ignored: set users to the user IDs allowed to answer")
+	}
+	return problems
+}
+
+// chatService is a chat the bridge posts to.
+type chatService interface {
+	name() string
+	// answerable reports whether anyone is allowed to reply
+	answerable() bool
+	// send posts text, as an answer to the message replyTo if set, and
+	// returns the new message's id
+	send(text, replyTo string) (string, error)
+	// replies waits a while for answers to the message prompt
+	replies(prompt string) ([]string, error)
+}
+
+// chatBridge posts prompts when the monitor starts waiting and, if
+// enabled, forwards the answers to the session.
+type chatBridge struct {
+	mon   *state.Monitor
+	input io.Writer // the wrapped program's input
+	reply bool
+	debug bool
+
+	mu      sync.Mutex
+	waiting bool
+	prompts map[chatService]string // id of the open prompt message
+}
+
+// startChat connects the monitor to the configured chats. It returns nil
+// when none is configured.
+func startChat(cfg *ChatConfig, mon *state.Monitor, input io.Writer) *chatBridge {
+	if cfg == nil {
+		return nil
+	}
+	b := &chatBridge{
+		mon:     mon,
+		input:   input,
+		reply:   cfg.Reply,
+		debug:   os.Getenv("DEBUG_SL") != "",
+		prompts: make(map[chatService]string),
+	}
+	var services []chatService
+	if t := cfg.Telegram; t != nil && t.Token != "" {
+		services = append(services, &telegram{TelegramChat: *t})
+	}
+	if d := cfg.Discord; d != nil && d.Token != "" {
+		services = append(services, &discord{DiscordChat: *d})
+	}
+	if len(services) == 0 {
+		return nil
+	}
+	for _, p := range cfg.problems() {
+		fmt.Fprintf(os.Stderr, "%s\n", p)
+	}
+	for _, s := range services {
+		b.prompts[s] = ""
+		if b.reply && s.answerable() {
+			go b.listen(s)
+		}
+	}
+	return b
+}
+
+// observe is the monitor's Observe callback. It runs on the monitor's
+// goroutine, so it can read the screen.
+func (b *chatBridge) observe(e state.Event) {
+	if e.Pattern != "" {
+		return
+	}
+	b.mu.Lock()
+	b.waiting = e.State == state.Waiting
+	for s := range b.prompts {
+		b.prompts[s] = ""
+	}
+	b.mu.Unlock()
+	if e.State != state.Waiting || b.mon.Suppressed() {
+		return
+	}
+	text := b.mon.Label() + " is waiting"
+	if lines := b.mon.Screen.Lines(); len(lines) > 0 {
+		text += ":\n" + strings.TrimSpace(lines[len(lines)-1])
+	}
+	for s := range b.prompts {
+		if b.reply && s.answerable() {
+			go b.post(s, text+"\n\nReply to this message to answer.")
+		} else {
+			go b.post(s, text)
+		}
+	}
+}
+
+func (b *chatBridge) post(s chatService, text string) {
+	id, err := s.send(text, "")
+	if err != nil {
+		if b.debug {
+			fmt.Fprintf(os.Stderr, "[DEBUG] %s: %v\n", s.name(), err)
+		}
+		return
+	}
+	b.mu.Lock()
+	if b.waiting {
+		b.prompts[s] = id
+	}
+	b.mu.Unlock()
+}
+
+// listen forwards answers to the open prompt on s to the session.
+func (b *chatBridge) listen(s chatService) {
+	for {
+		b.mu.Lock()
+		prompt := b.prompts[s]
+		b.mu.Unlock()
+		if prompt == "" {
+			time.Sleep(time.Second)
+			continue
+		}
+		answers, err := s.replies(prompt)
+		if err != nil {
+			if b.debug {
+				fmt.Fprintf(os.Stderr, "[DEBUG] %s: %v\n", s.name(), err)
+			}
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		for _, a := range answers {
+			// Only the first answer to a still open prompt counts
+			b.mu.Lock()
+			open := b.prompts[s] == prompt
+			if open {
+				for other := range b.prompts {
+					b.prompts[other] = ""
+				}
+			}
+			b.mu.Unlock()
+			if !open {
+				break
+			}
+			if b.debug {
+				fmt.Fprintf(os.Stderr, "[DEBUG] %s answer: %q\n", s.name(), a)
+			}
+			b.input.Write([]byte(a + "\r"))
+			s.send("Sent: "+a, prompt)
+		}
+	}
+}
+
+// chatRequest sends a request with an optional JSON body and decodes the
+// JSON answer into v.
+func chatRequest(method, endpoint string, header http.Header, body, v any) error {
+	r := bytes.NewReader(nil)
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, endpoint, r)
+	if err != nil {
+		return fmt.Errorf("%s: invalid URL", method)
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	client := &http.Client{Timeout: 40 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		if e, ok := err.(*url.Error); ok {
+			e.URL = req.URL.Scheme + "://" + req.URL.Host + chatPath(req.URL.Path)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: %s", method, chatPath(req.URL.Path), resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// chatPath is path for errors and debug output, without the bot token
+// Telegram puts in it.
+func chatPath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, p := range parts {
+		if strings.HasPrefix(p, "bot") && strings.Contains(p, ":") {
+			parts[i] = "bot<token>"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// telegram talks to the Bot API, reading answers with getUpdates long
+// polling.
+type telegram struct {
+	TelegramChat
+	offset int64
+}
+
+func (t *telegram) name() string { return "Telegram" }
+
+func (t *telegram) answerable() bool { return len(t.Users) > 0 }
+
+func (t *telegram) endpoint(method string) string {
+	return "https://api.telegram.org/bot" + t.Token + "/" + method
+}
+
+func (t *telegram) send(text, replyTo string) (string, error) {
+	msg := map[string]any{"chat_id": t.ChatID, "text": text}
+	if replyTo != "" {
+		msg["reply_parameters"] = map[string]any{"message_id": json.Number(replyTo)}
+	}
+	var resp struct {
+		Result struct {
+			MessageID int64 `json:"message_id"`
+		} `json:"result"`
+	}
+	if err := chatRequest("POST", t.endpoint("sendMessage"), nil, msg, &resp); err != nil {
+		return "", err
+	}
+	return fmt.Sprint(resp.Result.MessageID), nil
+}
+
+func (t *telegram) replies(prompt string) ([]string, error) {
+	var resp struct {
+		Result []struct {
+			UpdateID int64 `json:"update_id"`
+			Message  *struct {
+				Chat struct {
+					ID int64 `json:"id"`
+				} `json:"chat"`
+				From *struct {
+					ID int64 `json:"id"`
+				} `json:"from"`
+				Text    string `json:"text"`
+				ReplyTo *struct {
+					MessageID int64 `json:"message_id"`
+				} `json:"reply_to_message"`
+			} `json:"message"`
+		} `json:"result"`
+	}
+	q := url.Values{"offset": {fmt.Sprint(t.offset)}, "timeout": {"30"}}
+	if err := chatRequest("GET", t.endpoint("getUpdates")+"?"+q.Encode(), nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, u := range resp.Result {
+		t.offset = u.UpdateID + 1
+		m := u.Message
+		if m == nil || m.Chat.ID != t.ChatID || m.From == nil || !slices.Contains(t.Users, m.From.ID) || m.ReplyTo == nil || fmt.Sprint(m.ReplyTo.MessageID) != prompt {
+			continue
+		}
+		out = append(out, m.Text)
+	}
+	return out, nil
+}
+
+// discord talks to the REST API and polls the channel for answers, since
+// a bot without a gateway connection can't be pushed messages. Reading
+// them needs the bot's Message Content intent.
+type discord struct {
+	DiscordChat
+	after string
+}
+
+func (d *discord) name() string { return "Discord" }
+
+func (d *discord) answerable() bool { return len(d.Users) > 0 }
+
+func (d *discord) endpoint() string {
+	return "https://discord.com/api/v10/channels/" + d.ChannelID + "/messages"
+}
+
+func (d *discord) header() http.Header {
+	return http.Header{"Authorization": {"Bot " + d.Token}}
+}
+
+func (d *discord) send(text, replyTo string) (string, error) {
+	msg := map[string]any{"content": text}
+	if replyTo != "" {
+		msg["message_reference"] = map[string]string{"message_id": replyTo}
+	}
+	var resp struct {
+		ID string `json:"id"`
+	}
+	if err := chatRequest("POST", d.endpoint(), d.header(), msg, &resp); err != nil {
+		return "", err
+	}
+	return resp.ID, nil
+}
+
+func (d *discord) replies(prompt string) ([]string, error) {
+	time.Sleep(3 * time.Second)
+	if newerID(prompt, d.after) {
+		d.after = prompt
+	}
+	var msgs []struct {
+		ID      string `json:"id"`
+		Content string `json:"content"`
+		Author  struct {
+			ID  string `json:"id"`
+			Bot bool   `json:"bot"`
+		} `json:"author"`
+		Reference *struct {
+			MessageID string `json:"message_id"`
+		} `json:"message_reference"`
+	}
+	if err := chatRequest("GET", d.endpoint()+"?after="+d.after+"&limit=50", d.header(), nil, &msgs); err != nil {
+		return nil, err
+	}
+	var out []string
+	// Newest first; answers are returned in the order they were written
+	for i := len(msgs) - 1; i >= 0; i-- {
+		m := msgs[i]
+		if newerID(m.ID, d.after) {
+			d.after = m.ID
+		}
+		if m.Author.Bot || !slices.Contains(d.Users, m.Author.ID) || m.Reference == nil || m.Reference.MessageID != prompt {
+			continue
+		}
+		out = append(out, m.Content)
+	}
+	return out, nil
+}
+
+// newerID compares Discord snowflakes, which grow over time.
+func newerID(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) > len(b)
+	}
+	return a > b
+}
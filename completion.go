@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// completionCommands lists the subcommands for shell completion with their
+// flags and fixed arguments, and what else their arguments can be:
+// "tools" (config names), "profiles" or "command" (a program to wrap).
+var completionCommands = []struct {
+	name  string
+	words []string
+	more  string
+}{
+	{"watch", []string{"-f", "--tool", "--cols", "--rows"}, ""},
+	{"attach", []string{"--tool"}, ""},
+	{"daemon", []string{"--socket", "--pprof", "install-service", "uninstall-service"}, ""},
+	{"snooze", []string{"off"}, ""},
+	{"set", []string{"idle", "thinking", "waiting", "error", "stalled", "syncing", "success", "responding", "auto", "--for", "--effect"}, ""},
+	{"status", []string{"--json", "--follow"}, ""},
+	{"profile", []string{"use", "off"}, ""},
+	{"auto-answer", []string{"on", "off"}, ""},
+	{"history", []string{"--since", "--json"}, ""},
+	{"send", []string{"--token"}, ""},
+	{"bar", []string{"--format", "--follow", "--interval"}, ""},
+	{"backend", []string{"scan-ble", "--duration"}, ""},
+	{"config", []string{"init", "graph", "lint", "--force", "--stdout", "--format"}, "tools"},
+	{"patterns", []string{"list", "install", "--registry", "--sha256", "--force"}, ""},
+	{"tune", nil, "command"},
+	{"learn", nil, "command"},
+	{"replay", []string{"--tool", "-i", "--print"}, ""},
+	{"run", []string{"--name", "--split", "--logs"}, "profiles"},
+	{"exec", []string{"--status-only", "--hold"}, ""},
+	{"rpc", nil, ""},
+	{"completion", []string{"bash", "zsh", "fish"}, ""},
+	{"doctor", nil, ""},
+	{"led", []string{"test", "--tool", "--delay", "--dry-run"}, ""},
+	{"self-update", []string{"--check", "--force", "--repo"}, ""},
+}
+
+// wrapperFlags are the options of "sl <command>".
+var wrapperFlags = []string{"--name", "--no-pty", "--stderr", "--sim", "--pprof", "--restart", "--porcelain", "--tee", "--tee-plain", "--encrypt", "--dry-run"}
+
+func cmdCompletion(args []string) int {
+	if len(args) == 2 && args[0] == "--names" {
+		for _, name := range completionNames(args[1]) {
+			fmt.Println(name)
+		}
+		return 0
+	}
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s completion bash|zsh|fish\n", os.Args[0])
+		return 2
+	}
+	switch args[0] {
+	case "bash":
+		fmt.Print(bashCompletion())
+	case "zsh":
+		fmt.Print(zshCompletion())
+	case "fish":
+		fmt.Print(fishCompletion())
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown shell %q (bash, zsh or fish)\n", args[0])
+		return 2
+	}
+	return 0
+}
+
+// completionNames returns the tool configs or launch profiles in the
+// current directory, for the generated scripts to call while completing.
+func completionNames(kind string) []string {
+	var names []string
+	switch kind {
+	case "tools":
+		paths, _ := filepath.Glob("configs/*.json")
+		if dir, err := packDir(); err == nil {
+			installed, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+			paths = append(paths, installed...)
+		}
+		for _, p := range paths {
+			name := strings.TrimSuffix(filepath.Base(p), ".json")
+			if "configs/"+name+".json" != profilesPath && name != "daemon" && !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	case "profiles":
+		profiles, _ := loadProfiles()
+		for name := range profiles {
+			names = append(names, "@"+name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func subcommandNames() string {
+	names := make([]string, len(completionCommands))
+	for i, c := range completionCommands {
+		names[i] = c.name
+	}
+	return strings.Join(names, " ")
+}
+
+func bashCompletion() string {
+	var cases strings.Builder
+	for _, c := range completionCommands {
+		fmt.Fprintf(&cases, "\t%s)\n\t\twords=%q\n", c.name, strings.Join(c.words, " "))
+		switch c.more {
+		case "tools":
+			cases.WriteString("\t\t[ \"$prev\" = init ] && words=\"$(sl completion --names tools 2>/dev/null)\"\n")
+		case "profiles":
+			cases.WriteString("\t\t[ \"$COMP_CWORD\" -eq 2 ] && words=\"$(sl completion --names profiles 2>/dev/null)\"\n")
+		case "command":
+			cases.WriteString("\t\t[ \"$COMP_CWORD\" -eq 2 ] && COMPREPLY=($(compgen -c -- \"$cur\"))\n\t\treturn\n")
+		}
+		cases.WriteString("\t\t;;\n")
+	}
+	return fmt.Sprintf(`# bash completion for sl; load with: source <(sl completion bash)
+_sl() {
+	local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"
+	local cmd="${COMP_WORDS[1]}" words
+	if [ "$prev" = --tool ]; then
+		COMPREPLY=($(compgen -W "$(sl completion --names tools 2>/dev/null)" -- "$cur"))
+		return
+	fi
+	if [ "$COMP_CWORD" -eq 1 ]; then
+		if [[ "$cur" == -* ]]; then
+			COMPREPLY=($(compgen -W %q -- "$cur"))
+		else
+			COMPREPLY=($(compgen -W %q -- "$cur") $(compgen -c -- "$cur"))
+		fi
+		return
+	fi
+	case "$cmd" in
+%s	*)
+		# A wrapped command: complete its program name, then files
+		case "$prev" in
+		-*) [[ "$cmd" == -* ]] && COMPREPLY=($(compgen -c -- "$cur")) ;;
+		esac
+		return
+		;;
+	esac
+	COMPREPLY=($(compgen -W "$words" -- "$cur"))
+}
+complete -o default -F _sl sl
+`, strings.Join(wrapperFlags, " "), subcommandNames(), cases.String())
+}
+
+func zshCompletion() string {
+	var cases strings.Builder
+	for _, c := range completionCommands {
+		fmt.Fprintf(&cases, "\t%s)\n", c.name)
+		switch c.more {
+		case "tools":
+			cases.WriteString("\t\t[[ ${words[CURRENT-1]} == init ]] && { compadd -- ${(f)\"$(sl completion --names tools 2>/dev/null)\"}; return }\n")
+		case "profiles":
+			cases.WriteString("\t\t(( CURRENT == 3 )) && compadd -- ${(f)\"$(sl completion --names profiles 2>/dev/null)\"}\n\t\treturn\n")
+		case "command":
+			cases.WriteString("\t\t(( CURRENT == 3 )) && _command_names -e || _files\n\t\treturn\n")
+		}
+		if len(c.words) > 0 {
+			fmt.Fprintf(&cases, "\t\tcompadd -- %s\n", strings.Join(c.words, " "))
+		}
+		cases.WriteString("\t\t;;\n")
+	}
+	return fmt.Sprintf(`#compdef sl
+# zsh completion for sl; load with: source <(sl completion zsh)
+_sl() {
+	if [[ ${words[CURRENT-1]} == --tool ]]; then
+		compadd -- ${(f)"$(sl completion --names tools 2>/dev/null)"}
+		return
+	fi
+	if (( CURRENT == 2 )); then
+		if [[ $PREFIX == -* ]]; then
+			compadd -- %s
+		else
+			compadd -- %s
+			_command_names -e
+		fi
+		return
+	fi
+	case ${words[2]} in
+%s	-*)
+		# Options of a wrapped command, then its program name
+		[[ ${words[CURRENT-1]} == -* ]] && _command_names -e || _files
+		;;
+	*)
+		_files
+		;;
+	esac
+}
+compdef _sl sl
+`, strings.Join(wrapperFlags, " "), subcommandNames(), cases.String())
+}
+
+func fishCompletion() string {
+	var b strings.Builder
+	b.WriteString("# fish completion for sl; load with: sl completion fish | source\n")
+	b.WriteString("complete -c sl -l tool -xa '(sl completion --names tools 2>/dev/null)'\n")
+	fmt.Fprintf(&b, "complete -c sl -n __fish_use_subcommand -xa '%s'\n", subcommandNames())
+	b.WriteString("complete -c sl -n __fish_use_subcommand -a '(__fish_complete_command)'\n")
+	for _, f := range wrapperFlags {
+		fmt.Fprintf(&b, "complete -c sl -n __fish_use_subcommand -l %s\n", strings.TrimPrefix(f, "--"))
+	}
+	for _, c := range completionCommands {
+		cond := "__fish_seen_subcommand_from " + c.name
+		for _, w := range c.words {
+			switch {
+			case strings.HasPrefix(w, "--"):
+				fmt.Fprintf(&b, "complete -c sl -n '%s' -l %s\n", cond, w[2:])
+			case strings.HasPrefix(w, "-"):
+				fmt.Fprintf(&b, "complete -c sl -n '%s' -s %s\n", cond, w[1:])
+			default:
+				fmt.Fprintf(&b, "complete -c sl -n '%s' -xa %s\n", cond, w)
+			}
+		}
+		switch c.more {
+		case "tools":
+			fmt.Fprintf(&b, "complete -c sl -n '%s; and __fish_seen_subcommand_from init' -xa '(sl completion --names tools 2>/dev/null)'\n", cond)
+		case "profiles":
+			fmt.Fprintf(&b, "complete -c sl -n '%s' -xa '(sl completion --names profiles 2>/dev/null)'\n", cond)
+		case "command":
+			fmt.Fprintf(&b, "complete -c sl -n '%s' -a '(__fish_complete_command)'\n", cond)
+		}
+	}
+	return b.String()
+}
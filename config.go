@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// stripComments removes // line comments outside of strings, so configs
+// written by "sl config init" can explain themselves.
+func stripComments(data []byte) []byte {
+	var out bytes.Buffer
+	inString, escaped := false, false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case inString:
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '/' && i+1 < len(data) && data[i+1] == '/':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+			if i < len(data) {
+				out.WriteByte('\n')
+			}
+			continue
+		}
+		out.WriteByte(c)
+	}
+	return out.Bytes()
+}
+
+const configTemplate = `// Config for %[1]s, used by "sl %[1]s ..." (see README.md).
+// Lines starting with // are comments. The Go version strips them; the Zig
+// version needs plain JSON with only "patterns" and "idle_threshold_ms".
+{
+  // Regular expressions (Go RE2 syntax) matched against the output
+  "patterns": {
+    // Red: the tool needs input. Checked against the last 20 screen lines
+    // once the output pauses.
+    "waiting": %[2]s,
+    // Yellow: the tool is working. Checked against every chunk of output.
+    "thinking": %[3]s,
+    // Green for "success_hold_ms" (5000 by default), even over the next
+    // prompt: a task finished cleanly
+    // "success": ["All tests passed", "Done!"],
+    // Magenta: something failed. Stays until new activity replaces it.
+    "error": %[4]s
+  },
+
+  // How far up waiting patterns look: a number of lines, "last_line" or
+  // "screen"; the default is the last 20 lines. Per pattern with
+  // "waiting_windows".
+  // "waiting_window": "last_line",
+  // "waiting_windows": { "\\(y/n\\)": "last_line" },
+
+  // Prompts only recognizable by what came before them: once the output
+  // has matched "after" in order, the next pause with a prompt-looking
+  // last line means "waiting" ("state" to change it)
+  // "sequences": [{ "after": ["Welcome to myrepl"], "prompt": "^> ?$" }],
+
+  // Treat the terminal bell as a prompt right away; "notify" shows a
+  // desktop notification instead and "both" does both. Bells from
+  // full-screen programs only count with "alt_screen"
+  // "bell": { "action": "waiting" },
+
+  // Treat desktop notifications the tool sends with OSC 9 or OSC 777 as a
+  // prompt, same actions as "bell"; "reemit": false keeps them from the
+  // terminal
+  // "osc_notify": { "action": "waiting" },
+
+  // Don't look for prompts while an editor or pager uses the alternate screen
+  // "ignore_alt_screen": true,
+
+  // For commands that print megabytes per second: match only every Nth
+  // chunk of output, or only its last N complete lines
+  // "sampling": { "every": 4, "lines": 20 },
+
+  // Patterns for stderr when running with --stderr, same keys as "patterns"
+  // "stderr_patterns": { "error": ["error:", "FAILED"] },
+
+  // Pause in the output before it counts as idle (Python and Zig versions)
+  "idle_threshold_ms": %[5]d,
+
+  // Switch the LED off after being idle this long, 0 keeps it on
+  "off_after_idle_ms": 0,
+
+  // Number of pixels; with 2 or more, progress is drawn as a bar
+  "led_count": 1,
+
+  // Colors: "default", "colorblind-deuteranopia", "high-contrast" or
+  // "monochrome-brightness"
+  // "theme": "default",
+
+  // Show idle/thinking and waiting/error on separate pixels or lamps
+  // "zones": { "activity": { "pixels": [0] }, "attention": { "pixels": [1] } },
+
+  // Write LED commands to a serial device instead of running ./led
+  // "led_device": "/dev/ttyACM0",
+  // While the LED is unplugged or failing, show the state in the terminal
+  // title ("title"), or on the "lamp" or "ble" light below instead
+  // "failover": { "fallback": "title", "check_ms": 10000 },
+
+  // Blend between state colors over this long instead of switching at once
+  // "fade_ms": 300,
+
+  // Prefix key for shortcuts: prefix twice cycles normal/dim/off,
+  // prefix + a acknowledges a waiting prompt
+  // "hotkey": "ctrl-\\",
+
+  // Other outputs
+  // Set user variables (sl_state, sl_color, ...) for iTerm2 and WezTerm
+  // "user_vars": true,
+  // Color the kitty tab (and border) per state; needs allow_remote_control
+  // "kitty": { "border": true },
+  // Say state changes out loud ("Claude is waiting for input") with
+  // espeak-ng, or say on macOS; "phrases" replaces the built-in ones
+  // "speech": { "phrases": { "waiting": "{tool} needs you", "error": "{tool} failed" } },
+  // Also remove these from --tee copies, besides keys, tokens and emails
+  // "redact": ["ACME-[0-9a-f]{32}"],
+  // "state_file": "~/.cache/status-light/state",
+  // "bar_signal": 8,
+  // "lamp": { "on": "blink1-tool --rgb={hex}", "off": "blink1-tool --off" },
+  // A Bluetooth LE strip, see "sl backend scan-ble"
+  // "ble": { "address": "A4:C1:38:12:34:56", "kind": "govee" },
+
+  // Dim or switch off the LED at night or while you are away
+  // "quiet_hours": { "start": "22:00", "end": "07:00", "mode": "dim", "brightness": 32 },
+  // "presence": { "away_command": "test $(xprintidle) -gt 300000", "interval_ms": 10000, "mode": "off" },
+  // Or ask the desktop over D-Bus, and notify right away while away
+  // "presence": { "idle": "auto", "away_after_ms": 300000, "escalate": true },
+
+  // Keep the LED and notifications still while you type into the tool
+  // "typing_ms": 3000,
+  // Show "responding" (pink) instead of waiting while you type an answer
+  // "responding_ms": 3000,
+
+  // Show green once the tool has been thinking this long in total, until
+  // the next prompt or error
+  // "focus": { "after_ms": 120000 },
+
+  // Spinners redrawn in place (braille, |/-\ and the like) count as
+  // thinking without a pattern; false turns that off
+  // "spinner": false,
+
+  // Show git clones, fetches and pushes that take a while as "syncing",
+  // so network waits don't look like thinking
+  // "sync": { "after_ms": 2000 },
+
+  // Warn in the theme's warning color while idle once the session's cost,
+  // as printed by "Total cost: $1.23", exceeds this many dollars
+  // "cost": { "budget": 5.00 },
+
+  // Follow a task runner's queue, such as "task 3/10", as the progress bar
+  // of a strip across tasks; a pattern's two groups are done and total
+  // "progress": { "pattern": "Processed (\\d+) of (\\d+)" },
+
+  // Show a stall when the tool goes silent while thinking and uses no CPU
+  // for this long, e.g. on a hung API call
+  // "stall": { "after_ms": 60000 },
+
+  // How long the output must pause before deciding between waiting and
+  // idle, per state instead of idle_threshold_ms, or learned from how fast
+  // the tool streams
+  // "silence": { "states_ms": { "thinking": 1500 }, "adaptive": true },
+  // Output with fewer visible characters than this doesn't count as
+  // activity unless a pattern matches it (default 1)
+  // "min_output_chars": 3,
+
+  // Push a notification to your phone when the tool starts waiting
+  // "push": { "ntfy": { "topic": "my-agent-alerts" }, "min_thinking_ms": 60000 },
+  // "errors" also pushes errors; "watch" sends them with high priority so a
+  // paired smartwatch vibrates
+  // "push": { "home_assistant": { "url": "http://homeassistant.local:8123", "token": "...", "service": "mobile_app_watch" }, "errors": true, "watch": true },
+
+  // Post prompts to a Telegram or Discord bot; with "reply", answers are
+  // typed into the program
+  // "chat": { "telegram": { "token": "123456:bot-token", "chat_id": 987654321, "users": [987654321] }, "reply": true },
+
+  // Shell commands run on entering or leaving a state, with SL_STATE,
+  // SL_PREV_STATE and SL_TOOL set
+  // "hooks": { "waiting": { "enter": "playerctl pause", "exit": "playerctl play" } },
+
+  // Make states louder once they last too long, e.g.
+  // { "state": "waiting", "after_ms": 600000, "effect": "blink", "notify": true, "webhook": "" }
+  "escalations": []
+}
+`
+
+// configInit renders the starter config for tool with the built-in
+// defaults filled in.
+func configInit(tool string) string {
+	def := state.DefaultConfig()
+	list := func(patterns []string) string {
+		if len(patterns) == 0 {
+			return "[]"
+		}
+		quoted := make([]string, len(patterns))
+		for i, p := range patterns {
+			b, _ := json.Marshal(p)
+			quoted[i] = string(b)
+		}
+		return "[" + strings.Join(quoted, ", ") + "]"
+	}
+	return fmt.Sprintf(configTemplate, tool, list(def.Patterns.Waiting), list(def.Patterns.Thinking), list(def.Patterns.Error), def.IdleThresholdMs)
+}
+
+func cmdConfig(args []string) int {
+	if len(args) > 0 && args[0] == "graph" {
+		return cmdConfigGraph(args[1:])
+	}
+	if len(args) > 0 && args[0] == "lint" {
+		return cmdConfigLint(args[1:])
+	}
+	if len(args) == 0 || args[0] != "init" {
+		fmt.Fprintf(os.Stderr, "Usage: %s config init [options] <tool>\n       %s config graph [--format dot|mermaid] <tool>\n       %s config lint [tool...]\n", os.Args[0], os.Args[0], os.Args[0])
+		return 2
+	}
+	fs := flag.NewFlagSet("config init", flag.ExitOnError)
+	force := fs.Bool("force", false, "overwrite an existing config")
+	stdout := fs.Bool("stdout", false, "print the config instead of writing it")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s config init [options] <tool>\n\nWrites configs/<tool>.json with all settings and their defaults.\n\nOptions:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args[1:])
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	tool := fs.Arg(0)
+	content := configInit(tool)
+	if *stdout {
+		fmt.Print(content)
+		return 0
+	}
+
+	path := filepath.Join("configs", tool+".json")
+	if _, err := os.Stat(path); err == nil && !*force {
+		fmt.Fprintf(os.Stderr, "%s already exists (use -force to overwrite)\n", path)
+		return 1
+	}
+	if err := os.MkdirAll("configs", 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create configs: %v\n", err)
+		return 1
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write %s: %v\n", path, err)
+		return 1
+	}
+	fmt.Printf("Wrote %s\n", path)
+	return 0
+}
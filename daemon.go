@@ -0,0 +1,562 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/f0i/status-light/pkg/backend"
+	"github.com/f0i/status-light/pkg/state"
+)
+
+type daemonSession struct {
+	ID       string
+	Tool     string
+	Name     string // label given with --name
+	PID      int
+	State    state.State
+	Effect   state.Effect
+	Progress float64
+	Since    time.Time
+	Lines    []string
+	Cost     float64
+
+	out *connWriter
+}
+
+// connWriter is the only writer of a socket connection, so replies,
+// events and messages for its session don't interleave. Messages are
+// queued and written by one goroutine, so they can be sent with d.mu held
+// without a slow client holding up the daemon.
+type connWriter struct {
+	out    chan any
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newConnWriter(conn net.Conn) *connWriter {
+	w := &connWriter{out: make(chan any, 64), closed: make(chan struct{})}
+	go func() {
+		enc := json.NewEncoder(conn)
+		for {
+			select {
+			case <-w.closed:
+				return
+			case v := <-w.out:
+				if enc.Encode(v) != nil {
+					w.close()
+					return
+				}
+			}
+		}
+	}()
+	return w
+}
+
+// queue sends v without waiting. It reports false if the connection is
+// gone or so far behind that its queue is full.
+func (w *connWriter) queue(v any) bool {
+	select {
+	case <-w.closed:
+		return false
+	case w.out <- v:
+		return true
+	default:
+		return false
+	}
+}
+
+// write sends v, waiting for room in the queue. Must be called without
+// d.mu held.
+func (w *connWriter) write(v any) bool {
+	select {
+	case <-w.closed:
+		return false
+	case w.out <- v:
+		return true
+	}
+}
+
+func (w *connWriter) close() {
+	w.once.Do(func() { close(w.closed) })
+}
+
+// Daemon owns the LED and shows the most urgent state of all connected
+// sessions, so several wrapped tools can share one light.
+type Daemon struct {
+	mu       sync.Mutex
+	sessions map[string]*daemonSession
+	led      state.Indicator
+	shown    state.State
+	effect   state.Effect
+	lit      bool
+	debug    bool
+
+	snoozeUntil time.Time
+	snoozeTimer *time.Timer
+
+	override      *override // set with "sl set", shown instead of the sessions
+	overrideTimer *time.Timer
+
+	calendar *backend.CalendarWatch
+
+	started    time.Time
+	lastChange time.Time
+
+	history []HistoryEntry // oldest first, at most historySize
+
+	remoteInput *RemoteInput
+	subscribers map[chan StreamEvent]bool
+
+	quiet    *backend.Quiet
+	profile  string        // switched with "sl profile use"
+	profiles []string      // in configs/daemon.json
+	notify   profileNotify // of the profile, for the sessions
+}
+
+func NewDaemon(led state.Indicator) *Daemon {
+	return &Daemon{
+		sessions:    make(map[string]*daemonSession),
+		subscribers: make(map[chan StreamEvent]bool),
+		led:         led,
+		debug:       os.Getenv("DEBUG_SL") != "",
+		started:     time.Now(),
+	}
+}
+
+// override is a state forced with "sl set"; a zero until holds it until
+// it is cleared.
+type override struct {
+	state  state.State
+	effect state.Effect
+	until  time.Time
+}
+
+// statePriority orders states by how much they need the user's attention.
+var statePriority = map[state.State]int{
+	state.Idle:       0,
+	state.Success:    1,
+	state.Responding: 2, // the user is already on it
+	state.Thinking:   3,
+	state.Syncing:    4,
+	state.Stalled:    5,
+	state.Error:      6,
+	state.Waiting:    7,
+}
+
+// effectRank decides between sessions in the same state: an escalated
+// session wins over a calm one, and a dark one only if all are dark.
+var effectRank = map[state.Effect]int{
+	state.EffectOff:    0,
+	state.EffectDim:    1,
+	state.EffectSafe:   2,
+	state.EffectSolid:  3,
+	state.EffectBudget: 4,
+	state.EffectBlink:  5,
+}
+
+// update recomputes the aggregate state and refreshes the LED. Must be
+// called with d.mu held.
+func (d *Daemon) update() {
+	// While snoozed the LED keeps whatever it showed; resuming calls update
+	// again
+	if time.Now().Before(d.snoozeUntil) {
+		return
+	}
+	if o := d.override; o != nil {
+		d.light(o.state, o.effect)
+		d.led.SetProgress(-1)
+		return
+	}
+	// The most urgent session also decides the effect
+	var winner *daemonSession
+	for _, s := range d.sessions {
+		if winner == nil || statePriority[s.State] > statePriority[winner.State] ||
+			(s.State == winner.State && effectRank[s.Effect] > effectRank[winner.Effect]) {
+			winner = s
+		}
+	}
+	// A meeting counts like one more session
+	if c := d.calendar; c.Current() != nil && (winner == nil || statePriority[c.State] > statePriority[winner.State] ||
+		c.State == winner.State && effectRank[c.Effect] >= effectRank[winner.Effect]) {
+		d.light(c.State, c.Effect)
+		d.led.SetProgress(-1)
+		return
+	}
+	if winner == nil {
+		if d.lit {
+			d.led.TurnOff()
+			d.lit = false
+			d.lastChange = time.Now()
+			d.publish(d.lightEvent())
+		}
+		return
+	}
+	d.light(winner.State, winner.Effect)
+	d.led.SetProgress(winner.Progress)
+}
+
+// light shows st with effect unless the LED already does. Must be called
+// with d.mu held.
+func (d *Daemon) light(st state.State, effect state.Effect) {
+	if d.lit && st == d.shown && effect == d.effect {
+		return
+	}
+	if !d.lit || st != d.shown {
+		d.lastChange = time.Now()
+	}
+	d.shown, d.effect, d.lit = st, effect, true
+	d.led.SetEffect(st, effect)
+	d.publish(d.lightEvent())
+}
+
+// setOverride forces o regardless of the sessions, or returns to automatic
+// mode when o is nil. Must be called with d.mu held.
+func (d *Daemon) setOverride(o *override) {
+	if d.overrideTimer != nil {
+		d.overrideTimer.Stop()
+		d.overrideTimer = nil
+	}
+	d.override = o
+	if o != nil && !o.until.IsZero() {
+		d.overrideTimer = time.AfterFunc(time.Until(o.until), func() {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			if d.debug {
+				fmt.Fprintf(os.Stderr, "[DEBUG] Override over\n")
+			}
+			d.override = nil
+			d.update()
+		})
+	}
+	if d.debug {
+		switch {
+		case o == nil:
+			fmt.Fprintf(os.Stderr, "[DEBUG] Override cleared\n")
+		case o.until.IsZero():
+			fmt.Fprintf(os.Stderr, "[DEBUG] Override: %s %s\n", o.state, o.effect)
+		default:
+			fmt.Fprintf(os.Stderr, "[DEBUG] Override: %s %s until %s\n", o.state, o.effect, o.until.Format("15:04:05"))
+		}
+	}
+}
+
+// snooze pauses LED updates for dur, or resumes them when dur is 0, and
+// tells every session about it. Must be called with d.mu held.
+func (d *Daemon) snooze(dur time.Duration) {
+	if d.snoozeTimer != nil {
+		d.snoozeTimer.Stop()
+		d.snoozeTimer = nil
+	}
+	d.snoozeUntil = time.Time{}
+	if dur > 0 {
+		d.snoozeUntil = time.Now().Add(dur)
+		d.snoozeTimer = time.AfterFunc(dur, func() {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			if d.debug {
+				fmt.Fprintf(os.Stderr, "[DEBUG] Snooze over\n")
+			}
+			d.update()
+		})
+	}
+	if d.debug {
+		fmt.Fprintf(os.Stderr, "[DEBUG] Snooze for %s\n", dur)
+	}
+	for _, s := range d.sessions {
+		d.sendSnooze(s)
+	}
+}
+
+// sendSnooze tells a session how much of the snooze is left. Must be
+// called with d.mu held.
+func (d *Daemon) sendSnooze(s *daemonSession) {
+	left := time.Until(d.snoozeUntil)
+	if left < 0 {
+		left = 0
+	}
+	s.out.queue(backend.Message{Type: "snooze", DurationMs: left.Milliseconds()})
+}
+
+// Serve accepts session connections until the listener is closed.
+func (d *Daemon) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go d.handle(conn)
+	}
+}
+
+func (d *Daemon) handle(conn net.Conn) {
+	defer conn.Close()
+	w := newConnWriter(conn)
+	defer w.close()
+	var sess *daemonSession
+	var unsubscribe func()
+	defer func() {
+		if unsubscribe != nil {
+			unsubscribe()
+		}
+		if sess != nil {
+			d.mu.Lock()
+			delete(d.sessions, sess.ID)
+			d.record(sess, "ended")
+			d.update()
+			d.mu.Unlock()
+			if d.debug {
+				fmt.Fprintf(os.Stderr, "[DEBUG] Session %s disconnected\n", sess.ID)
+			}
+		}
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var msg backend.Message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			continue
+		}
+		if msg.Type == "subscribe" {
+			if unsubscribe == nil {
+				unsubscribe = d.streamTo(w)
+			}
+			continue
+		}
+		// Replies are written once d.mu is released
+		var reply any
+		d.mu.Lock()
+		switch msg.Type {
+		case "hello":
+			if sess == nil {
+				sess = &daemonSession{ID: msg.Session, Tool: msg.Tool, Name: msg.Name, PID: msg.PID, State: state.Idle, Progress: -1, Since: time.Now(), out: w}
+				d.sessions[sess.ID] = sess
+				d.record(sess, "idle")
+				if time.Now().Before(d.snoozeUntil) {
+					d.sendSnooze(sess)
+				}
+				if d.profile != "" {
+					d.sendProfile(sess)
+				}
+				if d.debug {
+					fmt.Fprintf(os.Stderr, "[DEBUG] Session %s connected (%s)\n", sess.ID, sessionLabel(sess.Tool, sess.Name))
+				}
+			}
+		case "state":
+			if st, ok := state.ParseState(msg.State); ok && sess != nil {
+				if st != sess.State {
+					sess.Since = time.Now()
+					sess.Progress = -1
+					d.record(sess, st.String())
+				}
+				sess.State, sess.Effect = st, msg.Effect
+			}
+		case "screen":
+			if sess != nil {
+				sess.Lines = msg.Lines
+			}
+		case "progress":
+			if sess != nil && msg.Progress != nil {
+				sess.Progress = *msg.Progress
+			}
+		case "cost":
+			if sess != nil && msg.Cost != nil {
+				sess.Cost = *msg.Cost
+			}
+		case "snooze":
+			d.snooze(time.Duration(msg.DurationMs) * time.Millisecond)
+		case "set":
+			if msg.State == "auto" {
+				d.setOverride(nil)
+			} else if st, ok := state.ParseState(msg.State); ok {
+				o := &override{state: st, effect: msg.Effect}
+				if msg.DurationMs > 0 {
+					o.until = time.Now().Add(time.Duration(msg.DurationMs) * time.Millisecond)
+				}
+				d.setOverride(o)
+			}
+		case "status":
+			reply = d.status()
+		case "history":
+			reply = History{Entries: slices.Clone(d.history)}
+		case "send":
+			reply = backend.Message{Type: "send", Error: d.send(msg)}
+		case "profile":
+			m := backend.Message{Type: "profile"}
+			if err := d.useProfile(msg.Data); err != nil {
+				m.Error = err.Error()
+			}
+			reply = m
+		}
+		d.update()
+		d.mu.Unlock()
+		if reply != nil {
+			w.write(reply)
+		}
+	}
+}
+
+// Sessions returns a snapshot of the connected sessions ordered by ID.
+func (d *Daemon) Sessions() []daemonSession {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make([]daemonSession, 0, len(d.sessions))
+	for _, s := range d.sessions {
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// status describes the daemon for "sl status". Must be called with d.mu
+// held.
+func (d *Daemon) status() Status {
+	now := time.Now()
+	st := Status{
+		State:    "off",
+		UptimeS:  int64(now.Sub(d.started).Seconds()),
+		Sessions: []SessionStatus{},
+	}
+	if d.lit {
+		st.State, st.Effect = d.shown.String(), d.effect
+	}
+	if !d.lastChange.IsZero() {
+		st.Since = d.lastChange.Unix()
+	}
+	if now.Before(d.snoozeUntil) {
+		st.SnoozedUntil = d.snoozeUntil.Unix()
+	}
+	if ev := d.calendar.Current(); ev != nil {
+		st.Meeting, st.MeetingUntil = ev.Summary, ev.End.Unix()
+	}
+	if o := d.override; o != nil {
+		st.Override = o.state.String()
+		if !o.until.IsZero() {
+			st.OverrideUntil = o.until.Unix()
+		}
+	}
+	for _, s := range d.sessions {
+		st.Sessions = append(st.Sessions, SessionStatus{
+			ID:       s.ID,
+			Tool:     s.Tool,
+			Name:     s.Name,
+			PID:      s.PID,
+			State:    s.State.String(),
+			Effect:   s.Effect,
+			Progress: s.Progress,
+			Since:    s.Since.Unix(),
+			Lines:    s.Lines,
+			Cost:     s.Cost,
+		})
+		st.Cost += s.Cost
+	}
+	sort.Slice(st.Sessions, func(i, j int) bool { return st.Sessions[i].ID < st.Sessions[j].ID })
+	st.Profile, st.Profiles = d.profile, d.profiles
+	for _, h := range backend.HealthOf(d.led) {
+		b := BackendStatus{Backend: h.Backend, Failures: h.Failures, Streak: h.Streak, Error: h.Error, Failing: h.Persistent()}
+		if h.Streak > 0 {
+			b.Since = h.Since.Unix()
+		}
+		st.Backends = append(st.Backends, b)
+	}
+	return st
+}
+
+func cmdDaemon(args []string) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "install-service":
+			return cmdInstallService(args[1:])
+		case "uninstall-service":
+			return cmdUninstallService(args[1:])
+		}
+	}
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	path := fs.String("socket", backend.SocketPath(), "unix socket `path` to listen on")
+	pprofAddr := fs.String("pprof", "", "serve runtime profiles on `addr`, e.g. :6060")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s daemon [options]\n       %s daemon install-service|uninstall-service\n\nOptions:\n", os.Args[0], os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *pprofAddr != "" {
+		servePprof(*pprofAddr)
+	}
+
+	// Prefer a socket handed over by systemd; otherwise create our own
+	l, activated, err := activationListener()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Socket activation failed: %v\n", err)
+		return 1
+	}
+	if l == nil {
+		if c, err := net.Dial("unix", *path); err == nil {
+			c.Close()
+			fmt.Fprintf(os.Stderr, "Daemon already running on %s\n", *path)
+			return 1
+		}
+		os.Remove(*path)
+		if l, err = listenPrivate(*path); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to listen on %s: %v\n", *path, err)
+			return 1
+		}
+	}
+
+	cfg := loadConfig("daemon")
+	led := ledFromConfig(cfg)
+	d := NewDaemon(localBackends(cfg, led))
+	d.quiet = backend.StartQuiet(cfg.QuietHours, cfg.Presence, led)
+	d.profiles = slices.Sorted(maps.Keys(cfg.Profiles))
+	d.remoteInput = cfg.RemoteInput
+	if cfg.Calendar != nil {
+		c, err := backend.StartCalendar(*cfg.Calendar, func() {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			d.update()
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Ignoring calendar: %v\n", err)
+		}
+		d.mu.Lock()
+		d.calendar = c
+		d.update()
+		d.mu.Unlock()
+	}
+	if cfg.HTTP != nil && cfg.HTTP.Listen != "" {
+		go d.serveHTTP(*cfg.HTTP)
+	}
+	if cfg.Heartbeat != nil {
+		go d.heartbeat(*cfg.Heartbeat)
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		sdNotify("STOPPING=1")
+		l.Close()
+	}()
+
+	sdNotify("READY=1")
+	d.Serve(l)
+
+	d.mu.Lock()
+	if d.snoozeTimer != nil {
+		d.snoozeTimer.Stop()
+	}
+	backend.Shutdown(d.led)
+	d.mu.Unlock()
+	if !activated {
+		os.Remove(*path)
+	}
+	return 0
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/f0i/status-light/pkg/backend"
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// profileKeys are the settings a daemon profile may have: those the daemon
+// can switch while sessions run, the theme, the backends and where
+// notifications go, plus "notifications": false to hold them all back,
+// e.g. for a demo.
+var profileKeys = []string{
+	"theme", "led_count", "led_device", "fade_ms", "lamp", "ble", "zones",
+	"failover", "state_file", "bar_signal", "quiet_hours", "presence",
+	"push", "notifications",
+}
+
+// profileNotify is what a profile tells the sessions.
+type profileNotify struct {
+	Push          *state.Push `json:"push"`
+	Notifications *bool       `json:"notifications"`
+}
+
+// checkProfile refuses settings the daemon can't switch at runtime.
+func checkProfile(raw json.RawMessage) error {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &keys); err != nil {
+		return err
+	}
+	for key := range keys {
+		if !slices.Contains(profileKeys, key) {
+			return fmt.Errorf("%q can't be switched while the daemon runs", key)
+		}
+	}
+	return nil
+}
+
+// useProfile reads configs/daemon.json again, lays the profile name over
+// it ("" for none) and replaces the backends, quiet hours and the
+// sessions' notification settings with the result. Must be called with
+// d.mu held.
+func (d *Daemon) useProfile(name string) error {
+	cfg := loadConfig("daemon")
+	d.profiles = slices.Sorted(maps.Keys(cfg.Profiles))
+	var notify profileNotify
+	if name != "" {
+		raw, ok := cfg.Profiles[name]
+		if !ok {
+			return fmt.Errorf("unknown profile %q (known: %s)", name, strings.Join(d.profiles, ", "))
+		}
+		if err := checkProfile(raw); err != nil {
+			return fmt.Errorf("profile %s: %v", name, err)
+		}
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			return fmt.Errorf("profile %s: %v", name, err)
+		}
+		json.Unmarshal(raw, &notify)
+	}
+
+	// The old backends go dark before the new ones, which may drive the
+	// same device, take over
+	d.led.TurnOff()
+	backend.Close(d.led)
+	d.quiet.Stop()
+	led := ledFromConfig(cfg)
+	d.quiet = backend.StartQuiet(cfg.QuietHours, cfg.Presence, led)
+	d.led = localBackends(cfg, led)
+	d.lit = false
+	d.profile = name
+	d.notify = notify
+	for _, s := range d.sessions {
+		d.sendProfile(s)
+	}
+	if d.debug {
+		fmt.Fprintf(os.Stderr, "[DEBUG] Profile: %q\n", name)
+	}
+	return nil
+}
+
+// sendProfile tells a session where the profile sends notifications. Must
+// be called with d.mu held.
+func (d *Daemon) sendProfile(s *daemonSession) {
+	mute := d.notify.Notifications != nil && !*d.notify.Notifications
+	s.out.queue(backend.Message{Type: "profile", Data: d.profile, Push: d.notify.Push, Mute: mute})
+}
+
+// cmdProfile switches the daemon's profile, or lists the profiles.
+func cmdProfile(args []string) int {
+	switch {
+	case len(args) == 0:
+		st, err := queryStatus(backend.SocketPath())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "No daemon running on %s: %v\n", backend.SocketPath(), err)
+			return 1
+		}
+		if len(st.Profiles) == 0 {
+			fmt.Println("No profiles in configs/daemon.json")
+		}
+		for _, name := range st.Profiles {
+			mark := " "
+			if name == st.Profile {
+				mark = "*"
+			}
+			fmt.Printf("%s %s\n", mark, name)
+		}
+		return 0
+	case len(args) == 2 && args[0] == "use", len(args) == 1 && args[0] == "off":
+		name := ""
+		if args[0] == "use" {
+			name = args[1]
+		}
+		var reply backend.Message
+		if err := queryDaemon(backend.SocketPath(), backend.Message{Type: "profile", Data: name}, &reply); err != nil {
+			fmt.Fprintf(os.Stderr, "No daemon running on %s: %v\n", backend.SocketPath(), err)
+			return 1
+		}
+		if reply.Error != "" {
+			fmt.Fprintf(os.Stderr, "%s\n", reply.Error)
+			return 1
+		}
+		if name == "" {
+			fmt.Println("Using configs/daemon.json without a profile")
+		} else {
+			fmt.Printf("Using profile %s\n", name)
+		}
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "Usage: %s profile [use <name>|off]\n", os.Args[0])
+	return 2
+}
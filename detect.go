@@ -0,0 +1,272 @@
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// packageTools are the configs for npm and PyPI packages not named after
+// their command.
+var packageTools = map[string]string{
+	"claude-code": "claude",
+	"aider-chat":  "aider",
+	"gemini-cli":  "gemini",
+}
+
+// runners start a package's command: "npx claude", "uv run aider". The
+// value is the subcommand that does it, if they need one.
+var runners = map[string][]string{
+	"npx": nil, "pnpx": nil, "bunx": nil, "uvx": nil,
+	"npm": {"exec", "x"}, "pnpm": {"dlx", "exec"}, "yarn": {"dlx", "exec"},
+	"bun": {"x"}, "uv": {"run", "tool"}, "pipx": {"run"},
+}
+
+// valueFlags are options of runners and interpreters that take the next
+// argument as their value.
+var valueFlags = []string{"-p", "--package", "--from", "--with", "--python", "-W", "-X", "-r", "--require", "-C", "--chdir"}
+
+// nodeModule finds the npm package in a path or a script, such as
+// claude-code in .../node_modules/@anthropic-ai/claude-code/cli.js.
+var nodeModule = regexp.MustCompile(`node_modules[/\\](?:@[^/\\]+[/\\])?([^/\\"'\s]+)`)
+
+// detectTool returns the config name for a command line. Behind package
+// runners, interpreters, env and wrapper scripts it looks for the tool
+// they start ("sl npx claude", "sl python -m aider") and takes the first
+// one with a config; SL_TOOL overrides it.
+func detectTool(args []string) string {
+	if tool := os.Getenv("SL_TOOL"); tool != "" {
+		return tool
+	}
+	candidates := toolCandidates(args, 0)
+	for _, tool := range candidates {
+		if hasConfig(tool) {
+			return tool
+		}
+	}
+	return candidates[0]
+}
+
+// hasConfig reports whether configs/, the installed packs or the
+// project's .statuslight.json have a config for tool.
+func hasConfig(tool string) bool {
+	if path := findProjectConfig(); path != "" {
+		if data, err := os.ReadFile(path); err == nil {
+			if sections, err := projectSections(data); err == nil && tool != "" && sections[tool] != nil {
+				return true
+			}
+		}
+	}
+	paths := []string{filepath.Join("configs", tool+".json")}
+	if dir, err := packDir(); err == nil {
+		paths = append(paths, filepath.Join(dir, tool+".json"))
+	}
+	for _, p := range paths {
+		if _, err := os.Stat(p); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// toolCandidates lists the tools a command line may run, innermost first
+// and the command itself last.
+func toolCandidates(args []string, depth int) []string {
+	name := commandName(args[0])
+	if depth > 4 {
+		return []string{name}
+	}
+	rest := args[1:]
+	var inner []string
+	switch {
+	case name == "env":
+		for len(rest) > 0 && (strings.HasPrefix(rest[0], "-") || strings.Contains(rest[0], "=")) {
+			rest = skipOption(rest)
+		}
+		if len(rest) > 0 {
+			inner = toolCandidates(rest, depth+1)
+		}
+	case isRunner(name):
+		if sub := runners[name]; sub != nil {
+			if len(rest) == 0 || !slices.Contains(sub, rest[0]) {
+				break
+			}
+			rest = rest[1:]
+			if name == "uv" && len(rest) > 0 && rest[0] == "run" {
+				// uv tool run
+				rest = rest[1:]
+			}
+		}
+		var packages []string
+		for len(rest) > 0 && strings.HasPrefix(rest[0], "-") {
+			if value, ok := optionValue(rest); ok && (rest[0] == "-p" || strings.HasPrefix(rest[0], "--package") || strings.HasPrefix(rest[0], "--from")) {
+				packages = append(packages, packageTool(value))
+			}
+			rest = skipOption(rest)
+		}
+		if len(rest) > 0 {
+			inner = toolCandidates(append([]string{packageTool(rest[0])}, rest[1:]...), depth+1)
+		}
+		inner = append(inner, packages...)
+	case isInterpreter(name):
+		inner = interpreterCandidates(name, rest, depth)
+	default:
+		inner = scriptCandidates(args[0], true, depth)
+	}
+	candidates := []string{}
+	for _, c := range append(inner, name) {
+		if c != "" && !slices.Contains(candidates, c) {
+			candidates = append(candidates, c)
+		}
+	}
+	return candidates
+}
+
+// interpreterCandidates looks at what an interpreter runs: a module with
+// -m, a command with -c for shells, or a script.
+func interpreterCandidates(name string, rest []string, depth int) []string {
+	shell := slices.Contains([]string{"sh", "bash", "zsh", "dash", "fish"}, name)
+	if name == "deno" && len(rest) > 0 && rest[0] == "run" {
+		rest = rest[1:]
+	}
+	for len(rest) > 0 && strings.HasPrefix(rest[0], "-") {
+		switch rest[0] {
+		case "-m":
+			if len(rest) > 1 {
+				module, _, _ := strings.Cut(rest[1], ".")
+				return []string{packageTool(module)}
+			}
+			return nil
+		case "-c", "-lc", "-ic":
+			if shell && len(rest) > 1 {
+				if fields := strings.Fields(rest[1]); len(fields) > 0 {
+					return toolCandidates(fields, depth+1)
+				}
+			}
+			return nil
+		}
+		rest = skipOption(rest)
+	}
+	if len(rest) == 0 {
+		return nil
+	}
+	return scriptCandidates(rest[0], false, depth)
+}
+
+// scriptCandidates resolves a command, searched in PATH, or a script: the
+// npm package a symlink or npm shim leads to, and the command a shell
+// wrapper execs, before the script's own name and that of its directory.
+func scriptCandidates(script string, search bool, depth int) []string {
+	path := script
+	if search && !strings.ContainsAny(script, `/\`) {
+		if found, err := exec.LookPath(script); err == nil {
+			path = found
+		}
+	}
+	var out []string
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	if m := nodeModule.FindStringSubmatch(filepath.ToSlash(path)); m != nil {
+		out = append(out, packageTool(m[1]))
+	}
+	text := readScript(path)
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if command, ok := strings.CutPrefix(line, "exec "); ok {
+			if fields := strings.Fields(strings.NewReplacer(`"`, "", `'`, "").Replace(command)); len(fields) > 0 {
+				out = append(out, toolCandidates(fields, depth+1)...)
+			}
+		}
+	}
+	if m := nodeModule.FindStringSubmatch(text); m != nil {
+		out = append(out, packageTool(m[1]))
+	}
+	base := filepath.Base(path)
+	out = append(out, filepath.Base(script), strings.TrimSuffix(base, filepath.Ext(base)))
+	if dir := filepath.Base(filepath.Dir(path)); dir != "bin" && dir != "." && dir != string(filepath.Separator) {
+		out = append(out, packageTool(dir))
+	}
+	return out
+}
+
+// readScript returns the start of a script, or nothing for binaries.
+func readScript(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	data, _ := io.ReadAll(io.LimitReader(f, 64<<10))
+	ext := strings.ToLower(filepath.Ext(path))
+	if !strings.HasPrefix(string(data), "#!") && ext != ".cmd" && ext != ".bat" && ext != ".ps1" {
+		return ""
+	}
+	return string(data)
+}
+
+// commandName is the name of a command without its directory and, on
+// Windows, its extension.
+func commandName(command string) string {
+	name := filepath.Base(command)
+	for _, ext := range []string{".exe", ".cmd", ".bat"} {
+		if n, ok := strings.CutSuffix(strings.ToLower(name), ext); ok {
+			return n
+		}
+	}
+	return name
+}
+
+// packageTool turns a package such as @anthropic-ai/claude-code@latest
+// into the tool it provides.
+func packageTool(pkg string) string {
+	if at := strings.LastIndexByte(pkg, '@'); at > 0 {
+		pkg = pkg[:at]
+	}
+	if i := strings.IndexAny(pkg, "=<>[~"); i > 0 {
+		// PyPI versions and extras: aider-chat==0.80, aider-chat[browser]
+		pkg = pkg[:i]
+	}
+	pkg = pkg[strings.LastIndexByte(pkg, '/')+1:]
+	if tool, ok := packageTools[pkg]; ok {
+		return tool
+	}
+	return pkg
+}
+
+func isInterpreter(name string) bool {
+	for _, prefix := range []string{"python", "pypy", "node", "deno", "ruby", "perl"} {
+		if v, ok := strings.CutPrefix(name, prefix); ok && strings.Trim(v, "0123456789.") == "" {
+			return true
+		}
+	}
+	return slices.Contains([]string{"sh", "bash", "zsh", "dash", "fish"}, name)
+}
+
+// optionValue returns the value of the option that starts args.
+func optionValue(args []string) (string, bool) {
+	if name, value, ok := strings.Cut(args[0], "="); ok && strings.HasPrefix(name, "-") {
+		return value, true
+	}
+	if slices.Contains(valueFlags, args[0]) && len(args) > 1 {
+		return args[1], true
+	}
+	return "", false
+}
+
+// skipOption drops the option that starts args, with its value.
+func skipOption(args []string) []string {
+	if !strings.Contains(args[0], "=") && slices.Contains(valueFlags, args[0]) && len(args) > 1 {
+		return args[2:]
+	}
+	return args[1:]
+}
+
+func isRunner(name string) bool {
+	_, ok := runners[name]
+	return ok
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/f0i/status-light/pkg/backend"
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// scriptDevice is the serial port the led script writes to.
+const scriptDevice = "/dev/ttyACM0"
+
+// doctor collects the results of "sl doctor" checks.
+type doctor struct {
+	failed int
+}
+
+func (d *doctor) ok(format string, args ...any) {
+	fmt.Printf("ok    %s\n", fmt.Sprintf(format, args...))
+}
+
+// note reports something that is fine but worth knowing.
+func (d *doctor) note(format string, args ...any) {
+	fmt.Printf("--    %s\n", fmt.Sprintf(format, args...))
+}
+
+// warn reports a problem that only matters for some setups.
+func (d *doctor) warn(fix, format string, args ...any) {
+	fmt.Printf("warn  %s\n", fmt.Sprintf(format, args...))
+	d.fix(fix)
+}
+
+func (d *doctor) fail(fix, format string, args ...any) {
+	d.failed++
+	fmt.Printf("FAIL  %s\n", fmt.Sprintf(format, args...))
+	d.fix(fix)
+}
+
+func (d *doctor) fix(fix string) {
+	if fix != "" {
+		fmt.Printf("      %s\n", fix)
+	}
+}
+
+// cmdDoctor checks what a new setup tends to get wrong, the configs, the
+// LED and its permissions, the other backends and the daemon, and says
+// how to fix it.
+func cmdDoctor(args []string) int {
+	if len(args) != 0 {
+		fmt.Fprintf(os.Stderr, "Usage: %s doctor\n", os.Args[0])
+		return 2
+	}
+	d := &doctor{}
+	configs := d.checkConfigs()
+	d.checkProject()
+	d.checkLED(configs)
+	d.checkBackends(configs)
+	d.checkDaemon()
+	if d.failed > 0 {
+		fmt.Printf("\n%d problem(s) found\n", d.failed)
+		return 1
+	}
+	return 0
+}
+
+// checkConfigs parses every config in configs/ and returns the valid ones.
+func (d *doctor) checkConfigs() map[string]Config {
+	configs := map[string]Config{}
+	paths, _ := filepath.Glob("configs/*.json")
+	if len(paths) == 0 {
+		wd, _ := os.Getwd()
+		d.warn("sl reads configs/ relative to the current directory; create one with: sl config init <tool>",
+			"no configs in %s, the built-in patterns are used", wd)
+		return configs
+	}
+	for _, path := range paths {
+		if path == profilesPath {
+			if _, err := loadProfiles(); err != nil {
+				d.fail("fix the JSON syntax; // comments are allowed", "%v", err)
+			} else {
+				d.ok("%s", path)
+			}
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			d.fail("", "%v", err)
+			continue
+		}
+		var cfg Config
+		if err := json.Unmarshal(stripComments(data), &cfg); err != nil {
+			d.fail("fix the JSON syntax; // comments are allowed", "%s: %v", path, err)
+			continue
+		}
+		configs[path] = cfg
+		problems := configProblems(data, cfg)
+		if len(problems) == 0 {
+			d.ok("%s", path)
+		}
+		for _, p := range problems {
+			d.warn("", "%s: %s", path, p)
+		}
+	}
+	return configs
+}
+
+// checkProject checks the project's .statuslight.json, if there is one.
+func (d *doctor) checkProject() {
+	path := findProjectConfig()
+	if path == "" {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		d.fail("", "%v", err)
+		return
+	}
+	sections, err := projectSections(data)
+	if err != nil {
+		d.fail("the file is ignored; project files may only set patterns, thresholds and the theme", "%s: %v", path, err)
+		return
+	}
+	found := 0
+	for _, name := range slices.Sorted(maps.Keys(sections)) {
+		var cfg Config
+		if err := json.Unmarshal(sections[name], &cfg); err != nil {
+			d.fail("the file is ignored", "%s: %v", path, err)
+			return
+		}
+		label := path
+		if name != "" {
+			label += " (tools." + name + ")"
+		}
+		for _, p := range configProblems(sections[name], cfg) {
+			d.warn("", "%s: %s", label, p)
+			found++
+		}
+	}
+	if found == 0 {
+		d.ok("%s", path)
+	}
+}
+
+// configProblems lists settings of a parsed config that are ignored or
+// can't work.
+func configProblems(data []byte, cfg Config) []string {
+	var problems []string
+	dec := json.NewDecoder(bytes.NewReader(stripComments(data)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&Config{}); err != nil {
+		problems = append(problems, fmt.Sprintf("%v (misspelled?)", strings.TrimPrefix(err.Error(), "json: ")))
+	}
+	p := cfg.Patterns
+	for _, list := range [][]string{p.Waiting, p.Thinking, p.Error, p.Success} {
+		for _, pattern := range list {
+			if _, err := regexp.Compile(pattern); err != nil {
+				problems = append(problems, fmt.Sprintf("pattern %q is skipped: %v", pattern, err))
+			}
+		}
+	}
+	for _, s := range cfg.Sequences {
+		if len(s.After) == 0 {
+			problems = append(problems, "sequence without \"after\" patterns is skipped")
+		}
+		for _, pattern := range append(slices.Clone([]string(s.After)), s.Prompt) {
+			if _, err := regexp.Compile(pattern); err != nil {
+				problems = append(problems, fmt.Sprintf("sequence with pattern %q is skipped: %v", pattern, err))
+			}
+		}
+		if _, ok := state.ParseState(s.State); s.State != "" && !ok {
+			problems = append(problems, fmt.Sprintf("sequence with unknown state %q is skipped", s.State))
+		}
+	}
+	if s := cfg.Silence; s != nil {
+		for _, name := range slices.Sorted(maps.Keys(s.StatesMs)) {
+			if _, ok := state.ParseState(name); !ok {
+				problems = append(problems, fmt.Sprintf("silence for unknown state %q is skipped", name))
+			}
+		}
+	}
+	for _, pattern := range cfg.Redact {
+		if _, err := regexp.Compile(pattern); err != nil {
+			problems = append(problems, fmt.Sprintf("redact pattern %q is skipped: %v", pattern, err))
+		}
+	}
+	if p := cfg.Progress; p != nil && p.Pattern != "" {
+		if re, err := regexp.Compile(p.Pattern); err != nil {
+			problems = append(problems, fmt.Sprintf("progress pattern %q is skipped: %v", p.Pattern, err))
+		} else if n := re.NumSubexp(); n != 1 && n != 2 {
+			problems = append(problems, fmt.Sprintf("progress pattern %q is skipped: needs one group for a percentage or two for a count", p.Pattern))
+		}
+	}
+	if p := cfg.Push; p != nil && p.HomeAssistant != nil && (p.HomeAssistant.URL == "" || p.HomeAssistant.Token == "" || p.HomeAssistant.Service == "") {
+		problems = append(problems, "push home_assistant needs url, token and service")
+	}
+	for _, name := range slices.Sorted(maps.Keys(cfg.Profiles)) {
+		if err := checkProfile(cfg.Profiles[name]); err != nil {
+			problems = append(problems, fmt.Sprintf("profile %s is refused: %v", name, err))
+		}
+	}
+	for _, e := range cfg.Expect {
+		if err := e.Check(); err != nil {
+			problems = append(problems, fmt.Sprintf("expect is skipped: %v", err))
+		}
+	}
+	if h := cfg.HTTP; h != nil && h.Listen != "" {
+		if _, err := h.address(); err != nil {
+			problems = append(problems, fmt.Sprintf("http dashboard is off: %v", err))
+		}
+	}
+	if c := cfg.Chat; c != nil {
+		problems = append(problems, c.problems()...)
+	}
+	if a := cfg.AutoAnswer; a != nil {
+		for _, r := range a.Rules {
+			problems = append(problems, r.problems()...)
+		}
+	}
+	if b := cfg.Bell; b != nil && !slices.Contains([]string{"", "waiting", "notify", "both"}, b.Action) {
+		problems = append(problems, fmt.Sprintf("unknown bell action %q (waiting, notify or both)", b.Action))
+	}
+	if o := cfg.OSCNotify; o != nil && !slices.Contains([]string{"", "waiting", "notify", "both"}, o.Action) {
+		problems = append(problems, fmt.Sprintf("unknown osc_notify action %q (waiting, notify or both)", o.Action))
+	}
+	if f := cfg.Failover; f != nil {
+		switch {
+		case !slices.Contains([]string{"title", "lamp", "ble"}, f.Fallback):
+			problems = append(problems, fmt.Sprintf("unknown failover fallback %q (title, lamp or ble)", f.Fallback))
+		case f.Fallback == "lamp" && cfg.Lamp == nil, f.Fallback == "ble" && cfg.BLE == nil:
+			problems = append(problems, fmt.Sprintf("failover fallback %q isn't configured", f.Fallback))
+		}
+	}
+	if cfg.Theme != "" && !slices.Contains(backend.ThemeNames(), cfg.Theme) {
+		problems = append(problems, fmt.Sprintf("unknown theme %q (available: %s)", cfg.Theme, strings.Join(backend.ThemeNames(), ", ")))
+	}
+	return problems
+}
+
+// checkLED checks the led script and the serial devices the configs write
+// to.
+func (d *doctor) checkLED(configs map[string]Config) {
+	devices := map[string]bool{}
+	script := len(configs) == 0
+	for _, cfg := range configs {
+		if cfg.LEDDevice != "" {
+			devices[cfg.LEDDevice] = true
+		} else {
+			script = true
+		}
+	}
+	if script {
+		exe, _ := os.Executable()
+		path := filepath.Join(filepath.Dir(exe), "led")
+		info, err := os.Stat(path)
+		switch {
+		case err != nil:
+			d.fail(fmt.Sprintf("copy the led script next to sl, or set \"led_device\" in the config to write the script's lines to %s directly", scriptDevice),
+				"led script: %s not found", path)
+		case runtime.GOOS != "windows" && info.Mode().Perm()&0o111 == 0:
+			d.fail("chmod +x "+path, "led script: %s is not executable", path)
+		default:
+			d.ok("led script %s", path)
+			devices[scriptDevice] = true
+		}
+	}
+	for _, dev := range slices.Sorted(maps.Keys(devices)) {
+		d.checkDevice(dev)
+	}
+	if ports := serialPorts(); len(ports) > 0 {
+		d.note("serial ports: %s", strings.Join(ports, " "))
+	}
+}
+
+// checkDevice checks that the LED's serial device can be written.
+func (d *doctor) checkDevice(dev string) {
+	f, err := os.OpenFile(dev, os.O_WRONLY, 0)
+	switch {
+	case err == nil:
+		f.Close()
+		d.ok("LED device %s is writable", dev)
+	case errors.Is(err, fs.ErrNotExist):
+		fix := "plug in the controller, or set \"led_device\" to its port"
+		if ports := serialPorts(); len(ports) > 0 {
+			fix += "; found: " + strings.Join(ports, " ")
+		}
+		d.fail(fix, "LED device %s not found", dev)
+	case errors.Is(err, fs.ErrPermission):
+		d.fail(groupFix(serialGroup(), "sudo chmod a+rw "+dev+" (until the next replug)"), "LED device %s: permission denied", dev)
+	default:
+		d.fail("", "LED device %s: %v", dev, err)
+	}
+}
+
+// serialPorts lists the USB serial ports an LED controller may be on.
+func serialPorts() []string {
+	var patterns []string
+	switch runtime.GOOS {
+	case "linux":
+		patterns = []string{"/dev/ttyACM*", "/dev/ttyUSB*"}
+	case "darwin":
+		patterns = []string{"/dev/cu.usbmodem*", "/dev/cu.usbserial*"}
+	}
+	var ports []string
+	for _, p := range patterns {
+		matches, _ := filepath.Glob(p)
+		ports = append(ports, matches...)
+	}
+	return ports
+}
+
+// serialGroup returns the group that owns serial ports on this system:
+// dialout on Debian and Raspberry Pi OS, uucp on Arch.
+func serialGroup() string {
+	for _, name := range []string{"dialout", "uucp"} {
+		if _, err := user.LookupGroup(name); err == nil {
+			return name
+		}
+	}
+	return ""
+}
+
+// groupFix explains how to get access through group, or falls back to
+// other.
+func groupFix(group, other string) string {
+	if _, err := user.LookupGroup(group); err != nil {
+		return other
+	}
+	if inGroup(group) {
+		return fmt.Sprintf("you are in the %s group, but not in this session yet: log out and back in", group)
+	}
+	return fmt.Sprintf("sudo usermod -aG %s $USER, then log out and back in", group)
+}
+
+// inGroup reports whether the current user is a member of group.
+func inGroup(group string) bool {
+	u, err := user.Current()
+	if err != nil {
+		return false
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return false
+	}
+	ids, _ := u.GroupIds()
+	return slices.Contains(ids, g.Gid)
+}
+
+// checkBackends checks the command lamps, speech, BLE lights and, on a
+// Raspberry Pi, GPIO access for lamps wired to its pins.
+func (d *doctor) checkBackends(configs map[string]Config) {
+	var lamps []*backend.Lamp
+	ble := false
+	for _, path := range slices.Sorted(maps.Keys(configs)) {
+		cfg := configs[path]
+		lamps = append(lamps, cfg.Lamp)
+		if z := cfg.Zones; z != nil {
+			lamps = append(lamps, z.Activity.Lamp, z.Attention.Lamp)
+		}
+		ble = ble || cfg.BLE != nil
+	}
+	checked := map[string]bool{}
+	for _, lamp := range lamps {
+		if lamp == nil {
+			continue
+		}
+		for _, command := range []string{lamp.On, lamp.Off} {
+			fields := strings.Fields(command)
+			if len(fields) == 0 || checked[fields[0]] {
+				continue
+			}
+			checked[fields[0]] = true
+			if _, err := exec.LookPath(fields[0]); err != nil {
+				d.fail("install it or use its full path in \"lamp\"", "lamp command %s not found", fields[0])
+			} else {
+				d.ok("lamp command %s", fields[0])
+			}
+		}
+	}
+
+	for _, path := range slices.Sorted(maps.Keys(configs)) {
+		s := configs[path].Speech
+		if s == nil {
+			continue
+		}
+		name := backend.SpeechCommand(*s)[0]
+		if checked[name] {
+			continue
+		}
+		checked[name] = true
+		if _, err := exec.LookPath(name); err != nil {
+			d.fail("install it or set \"command\" in \"speech\"", "speech command %s not found", name)
+		} else {
+			d.ok("speech command %s", name)
+		}
+	}
+
+	if ble {
+		if runtime.GOOS != "linux" {
+			d.fail("remove \"ble\" from the config", "BLE lights are only supported on Linux")
+		} else if adapters, _ := filepath.Glob("/sys/class/bluetooth/hci*"); len(adapters) == 0 {
+			d.fail("enable Bluetooth (sudo rfkill unblock bluetooth) or plug in an adapter", "BLE: no Bluetooth adapter")
+		} else {
+			d.ok("Bluetooth adapter %s", filepath.Base(adapters[0]))
+		}
+	}
+
+	if _, err := os.Stat("/dev/gpiomem"); err == nil {
+		if f, err := os.Open("/dev/gpiomem"); err == nil {
+			f.Close()
+			d.ok("GPIO access")
+		} else {
+			d.warn(groupFix("gpio", "sudo chmod a+rw /dev/gpiomem"), "no GPIO access, lamp commands can't switch the Pi's pins: %v", err)
+		}
+	}
+}
+
+// checkDaemon reports whether the daemon answers on its socket.
+func (d *doctor) checkDaemon() {
+	path := backend.SocketPath()
+	st, err := queryStatus(path)
+	switch {
+	case err == nil:
+		d.ok("daemon at %s, %d session(s)", path, len(st.Sessions))
+	case errors.Is(err, fs.ErrNotExist):
+		d.note("no daemon running, sessions drive the LED themselves (sl daemon install-service to share it)")
+	default:
+		d.warn(fmt.Sprintf("restart it with sl daemon, or remove the stale socket: rm %s", path),
+			"daemon socket %s does not answer: %v", path, err)
+	}
+}
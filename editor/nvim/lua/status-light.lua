@@ -0,0 +1,145 @@
+-- Shows the state of the agents sl runs in Neovim's statusline. The sl
+-- daemon pushes every change over its socket, so nothing is polled.
+--
+--   vim.opt.runtimepath:append("/path/to/status-light/editor/nvim")
+--   require("status-light").setup()
+--   vim.o.statusline = "%f %m%=%{%v:lua.require'status-light'.statusline()%} "
+--
+-- While this Neovim has terminals running sl, their sessions are shown;
+-- otherwise what the light shows. The daemon must be running (sl daemon).
+
+local M = {
+  light = nil, -- last "light" event
+  sessions = {}, -- "session" events by session id
+  retry_ms = 5000,
+}
+
+local uv = vim.uv or vim.loop
+
+-- socket_path finds the daemon's socket the way sl does.
+local function socket_path()
+  local path = os.getenv("SL_SOCKET")
+  if path and path ~= "" then
+    return path
+  end
+  local dir = os.getenv("XDG_RUNTIME_DIR")
+  if dir and dir ~= "" then
+    return dir .. "/status-light.sock"
+  end
+  local tmp = (os.getenv("TMPDIR") or "/tmp"):gsub("/$", "")
+  return tmp .. "/status-light-" .. uv.getuid() .. ".sock"
+end
+
+local function redraw()
+  vim.schedule(function()
+    vim.cmd("redrawstatus!")
+  end)
+end
+
+local function handle(line)
+  local ok, ev = pcall(vim.json.decode, line)
+  if not ok or type(ev) ~= "table" then
+    return
+  end
+  if ev.type == "light" then
+    M.light = ev
+  elseif ev.type == "session" then
+    M.sessions[ev.session] = ev.state ~= "ended" and ev or nil
+  end
+  redraw()
+end
+
+-- connect subscribes to the daemon's events and reconnects when it
+-- restarts.
+local function connect()
+  local pipe = uv.new_pipe(false)
+  local function retry()
+    if not pipe:is_closing() then
+      pipe:close()
+    end
+    M.light, M.sessions = nil, {}
+    redraw()
+    vim.defer_fn(connect, M.retry_ms)
+  end
+  pipe:connect(socket_path(), function(err)
+    if err then
+      retry()
+      return
+    end
+    pipe:write(vim.json.encode({ type = "subscribe" }) .. "\n")
+    local buf = ""
+    pipe:read_start(function(rerr, data)
+      if rerr or not data then
+        retry()
+        return
+      end
+      buf = buf .. data
+      while true do
+        local nl = buf:find("\n", 1, true)
+        if not nl then
+          break
+        end
+        handle(buf:sub(1, nl - 1))
+        buf = buf:sub(nl + 1)
+      end
+    end)
+  end)
+end
+
+-- own returns the sessions of sl processes running in this Neovim's
+-- terminals.
+local function own()
+  local pids = {}
+  for _, b in ipairs(vim.api.nvim_list_bufs()) do
+    if vim.bo[b].buftype == "terminal" then
+      local pid = vim.b[b].terminal_job_pid
+      if pid then
+        pids[pid] = true
+      end
+    end
+  end
+  local out = {}
+  for _, s in pairs(M.sessions) do
+    if pids[s.pid] then
+      table.insert(out, s)
+    end
+  end
+  table.sort(out, function(a, b)
+    return a.session < b.session
+  end)
+  return out
+end
+
+-- item formats one state in its color.
+local function item(label, ev)
+  if not ev.color then
+    return label
+  end
+  local group = "StatusLight" .. ev.color:sub(2)
+  vim.api.nvim_set_hl(0, group, { fg = ev.color, bold = true })
+  return "%#" .. group .. "#● %*" .. label
+end
+
+-- statusline returns the states for 'statusline' with %{% %}.
+function M.statusline()
+  local sessions = own()
+  if #sessions > 0 then
+    local parts = {}
+    for _, s in ipairs(sessions) do
+      table.insert(parts, item((s.name or s.tool) .. " " .. s.state, s))
+    end
+    return table.concat(parts, "  ")
+  end
+  if M.light and M.light.state ~= "off" then
+    return item(M.light.state, M.light)
+  end
+  return ""
+end
+
+function M.setup(opts)
+  opts = opts or {}
+  M.retry_ms = opts.retry_ms or M.retry_ms
+  connect()
+end
+
+return M
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/f0i/status-light/pkg/backend"
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// StreamEvent is one message of the /events stream and of "subscribe" on
+// the socket: "light" when the light's state changes, "session" when a
+// session's does (State "ended" when it disconnects). New subscribers
+// first get the current light and sessions.
+type StreamEvent struct {
+	Type    string       `json:"type"`
+	Time    int64        `json:"time"` // unix ms
+	State   string       `json:"state"`
+	Effect  state.Effect `json:"effect,omitempty"`
+	Session string       `json:"session,omitempty"`
+	Tool    string       `json:"tool,omitempty"`
+	Name    string       `json:"name,omitempty"`
+	PID     int          `json:"pid,omitempty"`
+	Color   string       `json:"color,omitempty"` // "#rrggbb" in the theme, for states
+}
+
+// colored fills in the color of e's state.
+func (e StreamEvent) colored() StreamEvent {
+	if st, ok := state.ParseState(e.State); ok {
+		r, g, b := backend.EffectColor(st, e.Effect)
+		e.Color = fmt.Sprintf("#%02x%02x%02x", r, g, b)
+	}
+	return e
+}
+
+// lightEvent describes what the LED shows. Must be called with d.mu held.
+func (d *Daemon) lightEvent() StreamEvent {
+	e := StreamEvent{Type: "light", Time: time.Now().UnixMilli(), State: "off"}
+	if d.lit {
+		e.State, e.Effect = d.shown.String(), d.effect
+	}
+	return e
+}
+
+// publish sends e to every subscriber; slow ones miss events rather than
+// holding up the daemon. Must be called with d.mu held.
+func (d *Daemon) publish(e StreamEvent) {
+	for ch := range d.subscribers {
+		select {
+		case ch <- e.colored():
+		default:
+		}
+	}
+}
+
+// subscribe returns a channel of events that starts with the current
+// state, and a function to end the subscription.
+func (d *Daemon) subscribe() (<-chan StreamEvent, func()) {
+	d.mu.Lock()
+	ch := make(chan StreamEvent, 64+len(d.sessions))
+	ch <- d.lightEvent().colored()
+	for _, s := range d.status().Sessions {
+		ch <- StreamEvent{Type: "session", Time: s.Since * 1000, State: s.State, Effect: s.Effect, Session: s.ID, Tool: s.Tool, Name: s.Name, PID: s.PID}.colored()
+	}
+	d.subscribers[ch] = true
+	d.mu.Unlock()
+	return ch, func() {
+		d.mu.Lock()
+		delete(d.subscribers, ch)
+		d.mu.Unlock()
+	}
+}
+
+// streamTo sends the events to a socket connection as JSON lines, for
+// editors and other local clients, until the returned function is called
+// or the connection fails.
+func (d *Daemon) streamTo(w *connWriter) (stop func()) {
+	events, done := d.subscribe()
+	quit := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-quit:
+				return
+			case e := <-events:
+				if !w.write(e) {
+					return
+				}
+			}
+		}
+	}()
+	return func() {
+		done()
+		close(quit)
+	}
+}
+
+// serveEvents streams events as Server-Sent Events, or over a WebSocket
+// when the request asks for an upgrade.
+func (d *Daemon) serveEvents(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		d.serveWebSocket(w, r)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	events, done := d.subscribe()
+	defer done()
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case e := <-events:
+			data, _ := json.Marshal(e)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+		}
+		flusher.Flush()
+	}
+}
+
+// serveWebSocket sends every event as a text message. Messages from the
+// client are ignored apart from ping and close.
+func (d *Daemon) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	// Browsers let any page open a WebSocket, unlike reading an event
+	// stream from another origin
+	if !allowedOrigin(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	hj, ok := w.(http.Hijacker)
+	if key == "" || !ok {
+		http.Error(w, "bad websocket request", http.StatusBadRequest)
+		return
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	sum := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(sum[:]))
+	if rw.Flush() != nil {
+		return
+	}
+
+	var mu sync.Mutex // serializes frames
+	send := func(opcode byte, payload []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := writeFrame(rw.Writer, opcode, payload); err != nil {
+			return err
+		}
+		return rw.Flush()
+	}
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			opcode, payload, err := readFrame(rw.Reader)
+			if err != nil || opcode == wsClose {
+				return
+			}
+			if opcode == wsPing {
+				send(wsPong, payload)
+			}
+		}
+	}()
+
+	events, done := d.subscribe()
+	defer done()
+	for {
+		select {
+		case <-closed:
+			send(wsClose, nil)
+			return
+		case e := <-events:
+			data, _ := json.Marshal(e)
+			if send(wsText, data) != nil {
+				return
+			}
+		}
+	}
+}
+
+// allowedOrigin reports whether r comes from the dashboard itself, a page
+// on this machine or a client that isn't a browser, which sends no Origin.
+func allowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	host := u.Hostname()
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xA
+)
+
+// writeFrame writes an unmasked, unfragmented frame, as servers send them.
+func writeFrame(w *bufio.Writer, opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xffff:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// readFrame reads one frame from a client and unmasks its payload.
+func readFrame(r *bufio.Reader) (opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(r, head[:]); err != nil {
+		return
+	}
+	opcode = head[0] & 0x0f
+	n := uint64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err = io.ReadFull(r, b[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err = io.ReadFull(r, b[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	if n > 1<<16 {
+		return 0, nil, fmt.Errorf("websocket frame too large")
+	}
+	var mask [4]byte
+	if head[1]&0x80 != 0 {
+		if _, err = io.ReadFull(r, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/f0i/status-light/pkg/backend"
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// cmdExec runs "sl exec --status-only <command>", which shows only whether
+// a build or test run is still going and how it ended: thinking while it
+// runs, then success or a blinking error, held for a while. No
+// patterns are matched and the command's output isn't touched. It returns
+// the command's exit code.
+// exitCode is the status sl exits with after the command ended with err:
+// the command's own, 1 when a signal ended it, or 127 when it couldn't be
+// started.
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		return max(exitErr.ExitCode(), 1)
+	}
+	return 127
+}
+
+func cmdExec(args []string) int {
+	fs := flag.NewFlagSet("exec", flag.ExitOnError)
+	fs.Bool("status-only", true, "ignore the output, show only running and the exit status")
+	hold := fs.Duration("hold", 5*time.Second, "how long the result is shown; ctrl-c ends it early")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s exec --status-only [--hold 5s] <command> [args...]\n\nOptions:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	args = fs.Args()
+
+	tool := detectTool(args)
+	cfg := loadConfig(tool)
+	local := ledFromConfig(cfg)
+	led := newIndicator(tool, "", localBackends(cfg, local))
+	defer backend.Shutdown(led)
+
+	// ctrl-c goes to the command, which shares the terminal; sl stays to
+	// show how it ended
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	led.SetState(state.Thinking)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	err := cmd.Run()
+	code := exitCode(err)
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		led.SetState(state.Success)
+	case errors.As(err, &exitErr):
+		led.SetEffect(state.Error, state.EffectBlink)
+	default:
+		fmt.Fprintf(os.Stderr, "Failed to start command: %v\n", err)
+		led.SetEffect(state.Error, state.EffectBlink)
+	}
+
+	// Signals sent while the command ran were its own
+	for len(sigs) > 0 {
+		<-sigs
+	}
+	select {
+	case <-sigs:
+	case <-time.After(*hold):
+	}
+	return code
+}
@@ -1,4 +1,4 @@
-module sl
+module github.com/f0i/status-light
 
 go 1.24.0
 
@@ -6,7 +6,6 @@ toolchain go1.24.4
 
 require (
 	github.com/creack/pty v1.1.21
+	golang.org/x/sys v0.39.0
 	golang.org/x/term v0.38.0
 )
-
-require golang.org/x/sys v0.39.0 // indirect
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/f0i/status-light/pkg/backend"
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// cmdConfigGraph runs "sl config graph", which draws the state machine a
+// tool's config sets up, to review patterns and thresholds at a glance.
+func cmdConfigGraph(args []string) int {
+	fs := flag.NewFlagSet("config graph", flag.ExitOnError)
+	format := fs.String("format", "dot", "`dot` for Graphviz or mermaid")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s config graph [options] <tool>\n\nPrints the states of configs/<tool>.json and what moves a session between\nthem, e.g. for \"sl config graph claude | dot -Tsvg > claude.svg\".\n\nOptions:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	cfg := loadConfig(fs.Arg(0))
+	transitions := state.Transitions(cfg.Config)
+	switch *format {
+	case "dot":
+		fmt.Print(graphDot(fs.Arg(0), transitions))
+	case "mermaid":
+		fmt.Print(graphMermaid(transitions))
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown format %q (dot or mermaid)\n", *format)
+		return 2
+	}
+	return 0
+}
+
+// graphStates returns the states in transitions in the order of the enum,
+// then "off".
+func graphStates(transitions []state.Transition) []string {
+	used := map[string]bool{}
+	for _, t := range transitions {
+		used[t.From], used[t.To] = true, true
+	}
+	var out []string
+	for st := state.Idle; st <= state.Responding; st++ {
+		if used[st.String()] {
+			out = append(out, st.String())
+		}
+	}
+	if used["off"] {
+		out = append(out, "off")
+	}
+	return out
+}
+
+// graphColor returns a state's color in the theme as #rrggbb.
+func graphColor(name string) string {
+	st, ok := state.ParseState(name)
+	if !ok {
+		return "#000000"
+	}
+	r, g, b := backend.EffectColor(st, state.EffectSolid)
+	return fmt.Sprintf("#%02x%02x%02x", r, g, b)
+}
+
+func graphDot(tool string, transitions []state.Transition) string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "digraph %q {\n\trankdir=LR;\n\tnode [shape=box, style=\"rounded,filled\", fontname=\"sans-serif\"];\n\tedge [fontname=\"sans-serif\", fontsize=10];\n", tool)
+	out.WriteString("\tany [label=\"any state\", shape=plaintext, style=\"\"];\n")
+	for _, name := range graphStates(transitions) {
+		fmt.Fprintf(&out, "\t%s [fillcolor=%q, fontcolor=%q];\n", name, graphColor(name), graphFontColor(name))
+	}
+	for _, t := range transitions {
+		from := t.From
+		if from == "" {
+			from = "any"
+		}
+		fmt.Fprintf(&out, "\t%s -> %s [label=%q];\n", from, t.To, t.Trigger)
+	}
+	out.WriteString("}\n")
+	return out.String()
+}
+
+func graphMermaid(transitions []state.Transition) string {
+	var out strings.Builder
+	out.WriteString("flowchart LR\n")
+	out.WriteString("    any([any state])\n")
+	for _, name := range graphStates(transitions) {
+		fmt.Fprintf(&out, "    %s[%s]\n    style %s fill:%s,color:%s\n", name, name, name, graphColor(name), graphFontColor(name))
+	}
+	for _, t := range transitions {
+		from := t.From
+		if from == "" {
+			from = "any"
+		}
+		// Mermaid labels can't hold double quotes
+		fmt.Fprintf(&out, "    %s -->|\"%s\"| %s\n", from, strings.ReplaceAll(t.Trigger, `"`, "#quot;"), t.To)
+	}
+	return out.String()
+}
+
+// graphFontColor keeps labels readable on light and dark fills.
+func graphFontColor(name string) string {
+	st, ok := state.ParseState(name)
+	if !ok {
+		return "#ffffff"
+	}
+	r, g, b := backend.EffectColor(st, state.EffectSolid)
+	if r*299+g*587+b*114 > 128000 {
+		return "#000000"
+	}
+	return "#ffffff"
+}
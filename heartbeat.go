@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/f0i/status-light/pkg/backend"
+)
+
+// Heartbeat lets external monitoring, such as an Uptime Kuma push monitor
+// or a Home Assistant sensor, alert when the daemon itself dies while an
+// agent runs: every interval it pings URL, publishes to an MQTT topic and
+// rewrites File, whichever are set. When the beats stop, the light can't
+// be trusted.
+type Heartbeat struct {
+	// URL is fetched with GET; {state} and {sessions} are replaced by
+	// what the light shows and how many sessions are connected
+	URL        string         `json:"url"`
+	MQTT       *MQTTHeartbeat `json:"mqtt"`
+	File       string         `json:"file"`        // ~ and $VARS are expanded
+	IntervalMs int            `json:"interval_ms"` // default 60s
+}
+
+// MQTTHeartbeat publishes the beat as a retained JSON message, e.g.
+// {"state":"thinking","sessions":2,"time":1760000000}.
+type MQTTHeartbeat struct {
+	Broker   string `json:"broker"` // host:port, 1883 by default
+	Topic    string `json:"topic"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+const defaultHeartbeatInterval = time.Minute
+
+// beat is what one heartbeat reports.
+type beat struct {
+	State    string `json:"state"`
+	Sessions int    `json:"sessions"`
+	Time     int64  `json:"time"`
+}
+
+// heartbeat beats until the process ends, the first time right away.
+func (d *Daemon) heartbeat(h Heartbeat) {
+	interval := defaultHeartbeatInterval
+	if h.IntervalMs > 0 {
+		interval = time.Duration(h.IntervalMs) * time.Millisecond
+	}
+	for {
+		d.mu.Lock()
+		b := beat{State: "off", Sessions: len(d.sessions), Time: time.Now().Unix()}
+		if d.lit {
+			b.State = d.shown.String()
+		}
+		d.mu.Unlock()
+		d.beat(h, b)
+		time.Sleep(interval)
+	}
+}
+
+// beat sends b everywhere h asks for. Failures are only reported in debug
+// mode; the monitoring notices the missing beat.
+func (d *Daemon) beat(h Heartbeat, b beat) {
+	report := func(target string, err error) {
+		if d.debug && err != nil {
+			fmt.Fprintf(os.Stderr, "[DEBUG] Heartbeat to %s failed: %v\n", target, err)
+		}
+	}
+	if h.URL != "" {
+		report("URL", pingURL(h.URL, b))
+	}
+	if m := h.MQTT; m != nil && m.Broker != "" && m.Topic != "" {
+		payload, _ := json.Marshal(b)
+		report("MQTT", publishMQTT(*m, payload))
+	}
+	if h.File != "" {
+		line := fmt.Sprintf("%d %s %d\n", b.Time, b.State, b.Sessions)
+		report("file", os.WriteFile(backend.ExpandPath(h.File), []byte(line), 0644))
+	}
+}
+
+func pingURL(raw string, b beat) error {
+	u := strings.NewReplacer("{state}", url.QueryEscape(b.State), "{sessions}", strconv.Itoa(b.Sessions)).Replace(raw)
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+// publishMQTT connects to the broker with MQTT 3.1.1, publishes payload
+// retained at QoS 0 and disconnects, so no connection has to be kept up
+// between beats.
+func publishMQTT(m MQTTHeartbeat, payload []byte) error {
+	addr := m.Broker
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "1883")
+	}
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// CONNECT with a clean session and a keep-alive of a minute
+	flags := byte(0x02)
+	body := append(mqttString("MQTT"), 4, 0, 0, 60)
+	payloadFields := mqttString(fmt.Sprintf("status-light-%d", os.Getpid()))
+	if m.Username != "" {
+		flags |= 0x80
+		payloadFields = append(payloadFields, mqttString(m.Username)...)
+	}
+	if m.Password != "" {
+		flags |= 0x40
+		payloadFields = append(payloadFields, mqttString(m.Password)...)
+	}
+	body[7] = flags
+	if _, err := conn.Write(mqttPacket(0x10, append(body, payloadFields...))); err != nil {
+		return err
+	}
+	ack := make([]byte, 4)
+	if _, err := io.ReadFull(bufio.NewReader(conn), ack); err != nil {
+		return err
+	}
+	if ack[0] != 0x20 || ack[3] != 0 {
+		return fmt.Errorf("broker refused the connection (code %d)", ack[3])
+	}
+
+	// PUBLISH, retained, then DISCONNECT
+	if _, err := conn.Write(mqttPacket(0x31, append(mqttString(m.Topic), payload...))); err != nil {
+		return err
+	}
+	_, err = conn.Write([]byte{0xe0, 0})
+	return err
+}
+
+// mqttString encodes s with its length in front.
+func mqttString(s string) []byte {
+	return append([]byte{byte(len(s) >> 8), byte(len(s))}, s...)
+}
+
+// mqttPacket adds the fixed header with the remaining length.
+func mqttPacket(kind byte, body []byte) []byte {
+	out := []byte{kind}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		out = append(out, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(out, body...)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/f0i/status-light/pkg/backend"
+)
+
+// historySize bounds the transitions the daemon remembers.
+const historySize = 1000
+
+// HistoryEntry is one state change of a session. State is a state name, or
+// "ended" when the session disconnected.
+type HistoryEntry struct {
+	Time    int64  `json:"time"` // unix milliseconds
+	Session string `json:"session"`
+	Tool    string `json:"tool"`
+	Name    string `json:"name,omitempty"`
+	PID     int    `json:"pid"`
+	State   string `json:"state"`
+}
+
+// History is the daemon's answer to a "history" message.
+type History struct {
+	Entries []HistoryEntry `json:"entries"`
+}
+
+// record remembers that s entered st. Must be called with d.mu held.
+func (d *Daemon) record(s *daemonSession, st string) {
+	if len(d.history) == historySize {
+		d.history = append(d.history[:0], d.history[1:]...)
+	}
+	e := HistoryEntry{
+		Time:    time.Now().UnixMilli(),
+		Session: s.ID,
+		Tool:    s.Tool,
+		Name:    s.Name,
+		PID:     s.PID,
+		State:   st,
+	}
+	d.history = append(d.history, e)
+	d.publish(StreamEvent{Type: "session", Time: e.Time, State: st, Session: s.ID, Tool: s.Tool, Name: s.Name, PID: s.PID})
+}
+
+// historySpan is a history entry with the time until the session's next
+// change; End is zero while the state lasts.
+type historySpan struct {
+	HistoryEntry
+	Start, End time.Time
+}
+
+// spans pairs every entry with the session's following one.
+func spans(entries []HistoryEntry) []historySpan {
+	out := make([]historySpan, len(entries))
+	next := map[string]time.Time{}
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		out[i] = historySpan{HistoryEntry: e, Start: time.UnixMilli(e.Time), End: next[e.Session]}
+		next[e.Session] = out[i].Start
+	}
+	return out
+}
+
+// cmdHistory prints when sessions were thinking or waiting, e.g. to see how
+// long a prompt waited during lunch.
+func cmdHistory(args []string) int {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	since := fs.Duration("since", 0, "only show states that lasted into the last `duration`, e.g. 1h")
+	asJSON := fs.Bool("json", false, "print machine-readable JSON")
+	fs.Parse(args)
+
+	var h History
+	if err := queryDaemon(backend.SocketPath(), backend.Message{Type: "history"}, &h); err != nil {
+		fmt.Fprintf(os.Stderr, "No daemon running on %s: %v\n", backend.SocketPath(), err)
+		return 1
+	}
+	now := time.Now()
+	var shown []historySpan
+	for _, s := range spans(h.Entries) {
+		if *since > 0 && !s.End.IsZero() && s.End.Before(now.Add(-*since)) {
+			continue
+		}
+		shown = append(shown, s)
+	}
+
+	if *asJSON {
+		entries := make([]HistoryEntry, len(shown))
+		for i, s := range shown {
+			entries[i] = s.HistoryEntry
+		}
+		json.NewEncoder(os.Stdout).Encode(History{Entries: entries})
+		return 0
+	}
+	if len(shown) == 0 {
+		fmt.Println("No history")
+		return 0
+	}
+	for _, s := range shown {
+		when := s.Start.Format("15:04:05")
+		if s.Start.YearDay() != now.YearDay() || s.Start.Year() != now.Year() {
+			when = s.Start.Format("Jan 2 15:04")
+		}
+		took := ""
+		switch {
+		case s.State == "ended":
+		case s.End.IsZero():
+			took = now.Sub(s.Start).Round(time.Second).String() + " (now)"
+		default:
+			took = s.End.Sub(s.Start).Round(time.Second).String()
+		}
+		line := fmt.Sprintf("%-11s %-8d %-12s %-9s %s", when, s.PID, sessionLabel(s.Tool, s.Name), s.State, took)
+		fmt.Println(strings.TrimRight(line, " "))
+	}
+	return 0
+}
@@ -0,0 +1,292 @@
+// Package dbus is a minimal D-Bus client: it connects to the session or
+// system bus and makes method calls with basic argument types, which is
+// all sl needs for desktop notifications and idle state. It keeps the
+// binary free of a D-Bus library.
+package dbus
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ObjectPath is marshaled with the D-Bus type "o".
+type ObjectPath string
+
+// Conn is a connection to a message bus.
+type Conn struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	mu     sync.Mutex
+	serial uint32
+}
+
+// SessionBus connects to the user's session bus.
+func SessionBus() (*Conn, error) {
+	addr := os.Getenv("DBUS_SESSION_BUS_ADDRESS")
+	if addr == "" {
+		addr = fmt.Sprintf("unix:path=/run/user/%d/bus", os.Getuid())
+	}
+	return Dial(addr)
+}
+
+// SystemBus connects to the system bus.
+func SystemBus() (*Conn, error) {
+	addr := os.Getenv("DBUS_SYSTEM_BUS_ADDRESS")
+	if addr == "" {
+		addr = "unix:path=/run/dbus/system_bus_socket"
+	}
+	return Dial(addr)
+}
+
+// Dial connects to the first unix socket in a bus address such as
+// "unix:path=/run/user/1000/bus" and says hello to the bus.
+func Dial(address string) (*Conn, error) {
+	var path string
+	for _, a := range strings.Split(address, ";") {
+		params, ok := strings.CutPrefix(a, "unix:")
+		if !ok {
+			continue
+		}
+		for _, kv := range strings.Split(params, ",") {
+			k, v, _ := strings.Cut(kv, "=")
+			switch k {
+			case "path":
+				path = unescape(v)
+			case "abstract":
+				path = "@" + unescape(v)
+			}
+		}
+		if path != "" {
+			break
+		}
+	}
+	if path == "" {
+		return nil, fmt.Errorf("unsupported bus address %q", address)
+	}
+	nc, err := net.DialTimeout("unix", path, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{conn: nc, r: bufio.NewReader(nc)}
+	if err := c.auth(); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	if _, err := c.Call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "Hello"); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// unescape decodes the %xx escapes of address values.
+func unescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// auth authenticates as the process's user.
+func (c *Conn) auth() error {
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	defer c.conn.SetDeadline(time.Time{})
+	uid := strconv.Itoa(os.Getuid())
+	if _, err := fmt.Fprintf(c.conn, "\x00AUTH EXTERNAL %x\r\n", uid); err != nil {
+		return err
+	}
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "OK ") {
+		return fmt.Errorf("authentication failed: %s", strings.TrimSpace(line))
+	}
+	_, err = fmt.Fprint(c.conn, "BEGIN\r\n")
+	return err
+}
+
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// Error is an error reply.
+type Error struct {
+	Name    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return e.Name
+	}
+	return e.Name + ": " + e.Message
+}
+
+// Call invokes a method and returns the values of the reply. Arguments may
+// be string, ObjectPath, bool, int32, uint32, uint64, []string and
+// map[string]any (a dictionary of variants).
+func (c *Conn) Call(dest string, path ObjectPath, iface, method string, args ...any) ([]any, error) {
+	var sig strings.Builder
+	body := &encoder{}
+	for _, a := range args {
+		s, err := signature(a)
+		if err != nil {
+			return nil, err
+		}
+		sig.WriteString(s)
+		body.value(a)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.serial++
+	serial := c.serial
+
+	msg := &encoder{}
+	msg.buf = append(msg.buf, 'l', 1, 0, 1)
+	msg.u32(uint32(len(body.buf)))
+	msg.u32(serial)
+	// Header fields by code: path, interface, member, destination and
+	// signature
+	fields := map[byte]any{1: path, 3: method, 6: dest}
+	if iface != "" {
+		fields[2] = iface
+	}
+	if sig.Len() > 0 {
+		fields[8] = signatureValue(sig.String())
+	}
+	msg.array(8, func() {
+		for _, code := range []byte{1, 2, 3, 6, 8} {
+			if v, ok := fields[code]; ok {
+				msg.align(8)
+				msg.buf = append(msg.buf, code)
+				msg.variant(v)
+			}
+		}
+	})
+	msg.align(8)
+	msg.buf = append(msg.buf, body.buf...)
+
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	defer c.conn.SetDeadline(time.Time{})
+	if _, err := c.conn.Write(msg.buf); err != nil {
+		return nil, err
+	}
+	for {
+		m, err := c.read()
+		if err != nil {
+			return nil, err
+		}
+		if m.replySerial != serial {
+			continue
+		}
+		values, err := m.values()
+		if err != nil {
+			return nil, err
+		}
+		if m.kind == 3 {
+			e := &Error{Name: m.errorName}
+			if len(values) > 0 {
+				e.Message, _ = values[0].(string)
+			}
+			return nil, e
+		}
+		return values, nil
+	}
+}
+
+// message is a received message.
+type message struct {
+	kind        byte
+	order       binary.ByteOrder
+	replySerial uint32
+	errorName   string
+	signature   string
+	body        []byte
+}
+
+func (c *Conn) read() (*message, error) {
+	head := make([]byte, 16)
+	if _, err := io.ReadFull(c.r, head); err != nil {
+		return nil, err
+	}
+	m := &message{kind: head[1], order: binary.LittleEndian}
+	switch head[0] {
+	case 'l':
+	case 'B':
+		m.order = binary.BigEndian
+	default:
+		return nil, errors.New("invalid message")
+	}
+	bodyLen := m.order.Uint32(head[4:])
+	fieldsLen := m.order.Uint32(head[12:])
+	if bodyLen > 1<<24 || fieldsLen > 1<<16 {
+		return nil, errors.New("message too large")
+	}
+	rest := make([]byte, (fieldsLen+7)&^7+bodyLen)
+	if _, err := io.ReadFull(c.r, rest); err != nil {
+		return nil, err
+	}
+	// Offsets in the header count from the start of the message
+	d := &decoder{buf: append(head, rest[:fieldsLen]...), pos: 16, order: m.order}
+	for d.pos < len(d.buf) {
+		d.align(8)
+		code, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.value("v")
+		if err != nil {
+			return nil, err
+		}
+		switch code {
+		case 4:
+			m.errorName, _ = v.(string)
+		case 5:
+			m.replySerial, _ = v.(uint32)
+		case 8:
+			if s, ok := v.(signatureValue); ok {
+				m.signature = string(s)
+			}
+		}
+	}
+	m.body = rest[len(rest)-int(bodyLen):]
+	return m, nil
+}
+
+// values decodes the body.
+func (m *message) values() ([]any, error) {
+	d := &decoder{buf: m.body, order: m.order}
+	var out []any
+	for sig := m.signature; sig != ""; {
+		t, rest, err := nextType(sig)
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.value(t)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+		sig = rest
+	}
+	return out, nil
+}
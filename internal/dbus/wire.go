@@ -0,0 +1,340 @@
+package dbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// signatureValue is marshaled with the D-Bus type "g".
+type signatureValue string
+
+// signature returns the D-Bus type of v.
+func signature(v any) (string, error) {
+	switch v := v.(type) {
+	case bool:
+		return "b", nil
+	case int32:
+		return "i", nil
+	case uint32:
+		return "u", nil
+	case uint64:
+		return "t", nil
+	case string:
+		return "s", nil
+	case ObjectPath:
+		return "o", nil
+	case signatureValue:
+		return "g", nil
+	case []string:
+		return "as", nil
+	case map[string]any:
+		for _, e := range v {
+			if _, err := signature(e); err != nil {
+				return "", err
+			}
+		}
+		return "a{sv}", nil
+	}
+	return "", fmt.Errorf("unsupported D-Bus value %T", v)
+}
+
+// encoder marshals little-endian values. Alignment is relative to the
+// start of buf, which must be where the message or its body starts.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) align(n int) {
+	for len(e.buf)%n != 0 {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *encoder) u32(v uint32) {
+	e.align(4)
+	e.buf = binary.LittleEndian.AppendUint32(e.buf, v)
+}
+
+func (e *encoder) str(s string) {
+	e.u32(uint32(len(s)))
+	e.buf = append(append(e.buf, s...), 0)
+}
+
+func (e *encoder) sig(s string) {
+	e.buf = append(append(append(e.buf, byte(len(s))), s...), 0)
+}
+
+// array writes the length of what elements appends, which starts at an
+// elemAlign boundary.
+func (e *encoder) array(elemAlign int, elements func()) {
+	e.u32(0)
+	at := len(e.buf)
+	e.align(elemAlign)
+	start := len(e.buf)
+	elements()
+	binary.LittleEndian.PutUint32(e.buf[at-4:], uint32(len(e.buf)-start))
+}
+
+func (e *encoder) variant(v any) {
+	s, _ := signature(v)
+	e.sig(s)
+	e.value(v)
+}
+
+// value marshals v, whose type signature accepts.
+func (e *encoder) value(v any) {
+	switch v := v.(type) {
+	case bool:
+		b := uint32(0)
+		if v {
+			b = 1
+		}
+		e.u32(b)
+	case int32:
+		e.u32(uint32(v))
+	case uint32:
+		e.u32(v)
+	case uint64:
+		e.align(8)
+		e.buf = binary.LittleEndian.AppendUint64(e.buf, v)
+	case string:
+		e.str(v)
+	case ObjectPath:
+		e.str(string(v))
+	case signatureValue:
+		e.sig(string(v))
+	case []string:
+		e.array(4, func() {
+			for _, s := range v {
+				e.str(s)
+			}
+		})
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		e.array(8, func() {
+			for _, k := range keys {
+				e.align(8)
+				e.str(k)
+				e.variant(v[k])
+			}
+		})
+	}
+}
+
+// decoder unmarshals values. Containers become []any, dictionaries
+// map[any]any and variants their value.
+type decoder struct {
+	buf   []byte
+	pos   int
+	order binary.ByteOrder
+}
+
+var errShort = errors.New("message too short")
+
+func (d *decoder) align(n int) {
+	d.pos = (d.pos + n - 1) / n * n
+}
+
+func (d *decoder) take(n int) ([]byte, error) {
+	if d.pos+n > len(d.buf) {
+		return nil, errShort
+	}
+	b := d.buf[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *decoder) byte() (byte, error) {
+	b, err := d.take(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (d *decoder) u32() (uint32, error) {
+	d.align(4)
+	b, err := d.take(4)
+	if err != nil {
+		return 0, err
+	}
+	return d.order.Uint32(b), nil
+}
+
+func (d *decoder) str() (string, error) {
+	n, err := d.u32()
+	if err != nil {
+		return "", err
+	}
+	b, err := d.take(int(n) + 1)
+	if err != nil {
+		return "", err
+	}
+	return string(b[:n]), nil
+}
+
+func (d *decoder) sig() (string, error) {
+	n, err := d.byte()
+	if err != nil {
+		return "", err
+	}
+	b, err := d.take(int(n) + 1)
+	if err != nil {
+		return "", err
+	}
+	return string(b[:n]), nil
+}
+
+// value decodes one complete type t.
+func (d *decoder) value(t string) (any, error) {
+	switch t[0] {
+	case 'y':
+		return d.byte()
+	case 'b':
+		v, err := d.u32()
+		return v != 0, err
+	case 'n', 'q':
+		d.align(2)
+		b, err := d.take(2)
+		if err != nil {
+			return nil, err
+		}
+		if t[0] == 'n' {
+			return int16(d.order.Uint16(b)), nil
+		}
+		return d.order.Uint16(b), nil
+	case 'i':
+		v, err := d.u32()
+		return int32(v), err
+	case 'u', 'h':
+		return d.u32()
+	case 'x', 't', 'd':
+		d.align(8)
+		b, err := d.take(8)
+		if err != nil {
+			return nil, err
+		}
+		switch t[0] {
+		case 'x':
+			return int64(d.order.Uint64(b)), nil
+		case 'd':
+			return math.Float64frombits(d.order.Uint64(b)), nil
+		}
+		return d.order.Uint64(b), nil
+	case 's':
+		return d.str()
+	case 'o':
+		s, err := d.str()
+		return ObjectPath(s), err
+	case 'g':
+		s, err := d.sig()
+		return signatureValue(s), err
+	case 'v':
+		s, err := d.sig()
+		if err != nil {
+			return nil, err
+		}
+		if s == "" {
+			return nil, errors.New("empty variant")
+		}
+		return d.value(s)
+	case 'a':
+		n, err := d.u32()
+		if err != nil {
+			return nil, err
+		}
+		elem := t[1:]
+		d.align(alignment(elem[0]))
+		end := d.pos + int(n)
+		if end > len(d.buf) {
+			return nil, errShort
+		}
+		if elem[0] == '{' {
+			out := map[any]any{}
+			for d.pos < end {
+				kv, err := d.value("(" + elem[1:len(elem)-1] + ")")
+				if err != nil {
+					return nil, err
+				}
+				pair := kv.([]any)
+				out[pair[0]] = pair[1]
+			}
+			return out, nil
+		}
+		out := []any{}
+		for d.pos < end {
+			v, err := d.value(elem)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+		return out, nil
+	case '(':
+		d.align(8)
+		var out []any
+		for sig := t[1 : len(t)-1]; sig != ""; {
+			f, rest, err := nextType(sig)
+			if err != nil {
+				return nil, err
+			}
+			v, err := d.value(f)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+			sig = rest
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unsupported D-Bus type %q", t)
+}
+
+func alignment(t byte) int {
+	switch t {
+	case 'y', 'g', 'v':
+		return 1
+	case 'n', 'q':
+		return 2
+	case 'x', 't', 'd', '(', '{':
+		return 8
+	}
+	return 4
+}
+
+// nextType splits the first complete type off a signature.
+func nextType(sig string) (string, string, error) {
+	switch sig[0] {
+	case 'a':
+		if len(sig) < 2 {
+			break
+		}
+		t, _, err := nextType(sig[1:])
+		if err != nil {
+			return "", "", err
+		}
+		return sig[:1+len(t)], sig[1+len(t):], nil
+	case '(', '{':
+		depth := 0
+		for i := 0; i < len(sig); i++ {
+			switch sig[i] {
+			case '(', '{':
+				depth++
+			case ')', '}':
+				if depth--; depth == 0 {
+					return sig[:i+1], sig[i+1:], nil
+				}
+			}
+		}
+	default:
+		return sig[:1], sig[1:], nil
+	}
+	return "", "", fmt.Errorf("invalid signature %q", sig)
+}
@@ -0,0 +1,22 @@
+// Package shell runs the commands users put in configs, such as hooks and
+// lamp commands, with the platform's shell.
+package shell
+
+import (
+	"context"
+	"os/exec"
+	"runtime"
+)
+
+// Command runs command with the platform's shell.
+func Command(command string) *exec.Cmd {
+	return CommandContext(context.Background(), command)
+}
+
+// CommandContext is Command, killed when ctx is done.
+func CommandContext(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
@@ -0,0 +1,188 @@
+// Package testfeed replays timed output scripts through a state.Machine
+// with a fake clock, so detection timing (silence thresholds, debounce,
+// escalations) can be checked without a terminal or sleeping.
+//
+// A script has one step per line: the time since start, optionally the
+// stream, and the output as a Go string literal. Lines with only a time
+// let the clock run until then; # starts a comment.
+//
+//	0s     "Compiling...\n"
+//	1.2s   stderr "warning: unused\n"
+//	3s     "Continue? (y/n) "
+//	5s
+package testfeed
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// Step is output arriving At after the start. An empty Data only advances
+// the clock.
+type Step struct {
+	At     time.Duration
+	Stream string
+	Data   string
+}
+
+// Parse reads a script.
+func Parse(r io.Reader) ([]Step, error) {
+	var steps []Step
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		at, rest, _ := strings.Cut(line, " ")
+		d, err := time.ParseDuration(at)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		step := Step{At: d, Stream: "stdout"}
+		rest = strings.TrimSpace(rest)
+		for _, stream := range []string{"stdout", "stderr"} {
+			if r, ok := strings.CutPrefix(rest, stream+" "); ok {
+				step.Stream, rest = stream, strings.TrimSpace(r)
+			}
+		}
+		if rest != "" {
+			if step.Data, err = strconv.Unquote(rest); err != nil {
+				return nil, fmt.Errorf("line %d: output must be a quoted string: %s", n, rest)
+			}
+		}
+		if len(steps) > 0 && d < steps[len(steps)-1].At {
+			return nil, fmt.Errorf("line %d: %s is before the previous step", n, at)
+		}
+		steps = append(steps, step)
+	}
+	return steps, scanner.Err()
+}
+
+// Clock is a fake state.Clock that only moves when told to.
+type Clock struct {
+	now time.Time
+}
+
+// NewClock returns a clock set to a fixed date, so runs are reproducible.
+func NewClock() *Clock {
+	return &Clock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *Clock) Now() time.Time { return c.now }
+
+// Advance moves the clock forward by d.
+func (c *Clock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+// Change is one call the machine made to its indicator.
+type Change struct {
+	At       time.Duration
+	State    state.State
+	Effect   state.Effect
+	Progress float64 // set for progress updates, -1 otherwise
+	Off      bool
+}
+
+func (c Change) String() string {
+	switch {
+	case c.Off:
+		return fmt.Sprintf("%s off", c.At)
+	case c.Progress >= 0:
+		return fmt.Sprintf("%s progress %.2f", c.At, c.Progress)
+	case c.Effect != state.EffectSolid:
+		return fmt.Sprintf("%s %s %s", c.At, c.State, c.Effect)
+	}
+	return fmt.Sprintf("%s %s", c.At, c.State)
+}
+
+// Recorder is an indicator that remembers every call, timed by a clock.
+type Recorder struct {
+	Changes []Change
+
+	clock *Clock
+	start time.Time
+}
+
+// NewRecorder returns a recorder that times changes relative to the
+// clock's current time.
+func NewRecorder(clock *Clock) *Recorder {
+	return &Recorder{clock: clock, start: clock.Now()}
+}
+
+func (r *Recorder) add(c Change) {
+	c.At = r.clock.Now().Sub(r.start)
+	r.Changes = append(r.Changes, c)
+}
+
+func (r *Recorder) SetState(st state.State) {
+	r.SetEffect(st, state.EffectSolid)
+}
+
+func (r *Recorder) SetEffect(st state.State, effect state.Effect) {
+	r.add(Change{State: st, Effect: effect, Progress: -1})
+}
+
+func (r *Recorder) SetProgress(progress float64) {
+	r.add(Change{Progress: progress})
+}
+
+func (r *Recorder) TurnOff() {
+	r.add(Change{Progress: -1, Off: true})
+}
+
+// States returns the states shown, without progress updates, effects or
+// repeats; handy for comparing against an expected sequence.
+func (r *Recorder) States() []state.State {
+	var out []state.State
+	for _, c := range r.Changes {
+		if c.Off || c.Progress >= 0 {
+			continue
+		}
+		if len(out) == 0 || out[len(out)-1] != c.State {
+			out = append(out, c.State)
+		}
+	}
+	return out
+}
+
+// Play feeds the steps to m, ticking every state.TickInterval like a live
+// session, and keeps ticking until the clock reaches until.
+func Play(m state.Machine, clock *Clock, steps []Step, until time.Duration) {
+	start := clock.Now()
+	m.Start(start)
+	next := start.Add(state.TickInterval)
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+	// tickUntil runs every tick due before t
+	tickUntil := func(t time.Time) {
+		for !next.After(t) {
+			clock.Advance(next.Sub(clock.Now()))
+			m.Tick(next)
+			next = next.Add(state.TickInterval)
+		}
+		clock.Advance(t.Sub(clock.Now()))
+	}
+	for _, s := range steps {
+		tickUntil(at(s.At))
+		if s.Data != "" {
+			m.Feed([]byte(s.Data), s.Stream, clock.Now())
+		}
+	}
+	tickUntil(at(until))
+}
+
+// Replay runs a script through a new Monitor for cfg and returns what it
+// showed.
+func Replay(cfg state.Config, steps []Step, until time.Duration) *Recorder {
+	clock := NewClock()
+	rec := NewRecorder(clock)
+	m := state.NewMonitor(cfg, rec)
+	m.Clock = clock
+	Play(m, clock, steps, until)
+	return rec
+}
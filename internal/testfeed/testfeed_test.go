@@ -0,0 +1,60 @@
+package testfeed
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/f0i/status-light/pkg/state"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []Step
+		err    string
+	}{
+		{"steps", "# comment\n0s \"a\\n\"\n\n1.2s stderr \"b\"\n3s\n",
+			[]Step{{0, "stdout", "a\n"}, {1200 * time.Millisecond, "stderr", "b"}, {3 * time.Second, "stdout", ""}}, ""},
+		{"bad time", "soon \"a\"\n", nil, "line 1"},
+		{"unquoted", "0s a\n", nil, "line 1: output must be a quoted string"},
+		{"out of order", "2s \"a\"\n1s \"b\"\n", nil, "line 2: 1s is before the previous step"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(tt.script))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecorderStates(t *testing.T) {
+	clock := NewClock()
+	r := NewRecorder(clock)
+	r.SetState(state.Idle)
+	clock.Advance(time.Second)
+	r.SetState(state.Thinking)
+	r.SetProgress(0.5)
+	r.SetEffect(state.Thinking, state.EffectBlink)
+	r.TurnOff()
+	want := []state.State{state.Idle, state.Thinking}
+	if got := r.States(); !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := r.Changes[1].String(); got != "1s thinking" {
+		t.Errorf("got %q, want %q", got, "1s thinking")
+	}
+}
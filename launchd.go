@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+const launchdLabel = "io.github.f0i.status-light"
+
+const launchdPlist = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>%s</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>%s</string>
+		<string>daemon</string>
+	</array>
+	<key>WorkingDirectory</key>
+	<string>%s</string>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+	<key>StandardErrorPath</key>
+	<string>%s</string>
+</dict>
+</plist>
+`
+
+func launchdPlistPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "Library", "LaunchAgents", launchdLabel+".plist"), nil
+}
+
+// launchctl runs launchctl, reporting failures without aborting, since
+// bootout fails harmlessly when the agent isn't loaded.
+func launchctl(args ...string) error {
+	cmd := exec.Command("launchctl", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// installLaunchd writes a LaunchAgent plist for the daemon and loads it
+// into the user's GUI domain, replacing a previously loaded version.
+func installLaunchd(exe, workDir string, printOnly, load bool) int {
+	logPath := filepath.Join(os.TempDir(), "status-light.log")
+	plist := fmt.Sprintf(launchdPlist, launchdLabel, html.EscapeString(exe), html.EscapeString(workDir), html.EscapeString(logPath))
+	if printOnly {
+		fmt.Print(plist)
+		return 0
+	}
+
+	path, err := launchdPlistPath()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot find home directory: %v\n", err)
+		return 1
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create %s: %v\n", filepath.Dir(path), err)
+		return 1
+	}
+	if err := os.WriteFile(path, []byte(plist), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write %s: %v\n", path, err)
+		return 1
+	}
+	fmt.Printf("Wrote %s\n", path)
+	if !load {
+		fmt.Printf("\nLoad with:\n  launchctl bootstrap gui/%d %s\n", os.Getuid(), path)
+		return 0
+	}
+
+	domain := fmt.Sprintf("gui/%d", os.Getuid())
+	launchctl("bootout", domain+"/"+launchdLabel)
+	if err := launchctl("bootstrap", domain, path); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load %s: %v\n", launchdLabel, err)
+		return 1
+	}
+	fmt.Printf("Loaded %s\n", launchdLabel)
+	return 0
+}
+
+// uninstallLaunchd unloads the agent and removes its plist.
+func uninstallLaunchd() int {
+	path, err := launchdPlistPath()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot find home directory: %v\n", err)
+		return 1
+	}
+	launchctl("bootout", fmt.Sprintf("gui/%d/%s", os.Getuid(), launchdLabel))
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "Failed to remove %s: %v\n", path, err)
+		return 1
+	}
+	fmt.Printf("Removed %s\n", path)
+	return 0
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/f0i/status-light/pkg/state"
+	"github.com/f0i/status-light/pkg/wrap"
+	"golang.org/x/term"
+)
+
+const (
+	learnPause    = 700 * time.Millisecond // quiet screen that makes a moment
+	learnBusy     = 2 * time.Second        // moments taken while output flows
+	learnContext  = 3                      // lines shown per moment
+	learnMinRunes = 4                      // shorter patterns match too much
+	learnMaxLines = 40                     // lines per label compared pairwise
+	learnDigits   = '\uE000'               // stands for a run of digits
+)
+
+// learnMoment is the end of the screen at one point of a recorded session.
+type learnMoment struct {
+	at    time.Duration
+	pause bool     // output had stopped
+	lines []string // last non-blank lines, the final one last
+	label string
+}
+
+// cmdLearn runs `sl learn <command>`: it records a session, asks which of
+// its moments were thinking, waiting or an error, and writes patterns for
+// them to the tool's config.
+func cmdLearn(args []string) int {
+	fs := flag.NewFlagSet("learn", flag.ExitOnError)
+	out := fs.String("o", "", "config `file` to write (default configs/<tool>.json)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s learn [-o file] <command> [args...]\n\nRuns the command, then shows the moments where its output stopped or\nchanged and asks what the tool was doing at each:\n  w/t/e  waiting/thinking/error\n  s      skip\n  q      done, propose patterns\n\nOptions:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	args = fs.Args()
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		fmt.Fprintln(os.Stderr, "sl learn needs a terminal")
+		return 1
+	}
+	tool := detectTool(args)
+	path := *out
+	if path == "" {
+		path = filepath.Join("configs", tool+".json")
+	}
+
+	moments, err := learnRecord(tool, args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to start command: %v\n", err)
+		return 1
+	}
+	moments = uniqueMoments(moments)
+	if len(moments) == 0 {
+		fmt.Println("Nothing recorded")
+		return 0
+	}
+
+	oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err == nil {
+		defer term.Restore(int(os.Stdin.Fd()), oldState)
+	}
+	keys := learnKeys()
+	fmt.Printf("\r\n%d moment(s) recorded. What was %s doing?\r\n", len(moments), tool)
+label:
+	for i := range moments {
+		m := &moments[i]
+		kind := "output flowing"
+		if m.pause {
+			kind = "output stopped"
+		}
+		fmt.Printf("\r\n[%d/%d] %s after %s\r\n", i+1, len(moments), kind, m.at.Round(time.Second))
+		for _, line := range m.lines {
+			fmt.Printf("  │ %s\r\n", line)
+		}
+		fmt.Print("[w]aiting [t]hinking [e]rror [s]kip [q]uit: ")
+		for m.label == "" {
+			b, ok := <-keys
+			if !ok || b == 'q' || b == 0x03 {
+				fmt.Print("\r\n")
+				break label
+			}
+			switch b {
+			case 'w':
+				m.label = "waiting"
+			case 't':
+				m.label = "thinking"
+			case 'e':
+				m.label = "error"
+			case 's':
+				m.label = "skip"
+			}
+		}
+		fmt.Printf("%s\r\n", m.label)
+	}
+
+	learned := learnPatterns(moments)
+	if len(learned.Waiting)+len(learned.Thinking)+len(learned.Error) == 0 {
+		fmt.Print("\r\nNo patterns found, label more moments or write them with sl tune\r\n")
+		return 1
+	}
+	fmt.Print("\r\nProposed patterns:\r\n")
+	for _, l := range []struct {
+		name string
+		list []string
+	}{{"waiting", learned.Waiting}, {"thinking", learned.Thinking}, {"error", learned.Error}} {
+		for _, p := range l.list {
+			fmt.Printf("  %-8s %s\r\n", l.name, p)
+		}
+	}
+
+	// Patterns already in the file are kept
+	var existing Config
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(stripComments(data), &existing); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\r\n", path, err)
+			return 1
+		}
+	}
+	patterns := existing.Patterns
+	patterns.Waiting = mergePatterns(patterns.Waiting, learned.Waiting)
+	patterns.Thinking = mergePatterns(patterns.Thinking, learned.Thinking)
+	patterns.Error = mergePatterns(patterns.Error, learned.Error)
+
+	fmt.Printf("\r\nAdd them to %s? [y/n] ", path)
+	for {
+		b, ok := <-keys
+		if !ok || b == 'n' || b == 'q' || b == 0x03 {
+			fmt.Print("n\r\n")
+			return 0
+		}
+		if b == 'y' {
+			fmt.Print("y\r\n")
+			break
+		}
+	}
+	if err := savePatterns(path, patterns); err != nil {
+		fmt.Fprintf(os.Stderr, "Saving %s: %v\r\n", path, err)
+		return 1
+	}
+	fmt.Printf("Saved %s, try it with sl tune %s\r\n", path, strings.Join(args, " "))
+	return 0
+}
+
+// The keyboard goes to the recorded command until learnKeys is called,
+// then to learnInput.
+var (
+	learnLabeling atomic.Bool
+	learnInput    = make(chan byte, 64)
+)
+
+// learnKeys switches the keyboard from the command to the labeling.
+func learnKeys() <-chan byte {
+	learnLabeling.Store(true)
+	return learnInput
+}
+
+// learnRecord runs the command like the wrapper does and returns the
+// moments where its screen stopped changing, and some while it changed.
+func learnRecord(tool string, args []string) ([]learnMoment, error) {
+	cfg := loadConfig(tool)
+	mon := state.NewMonitor(cfg.Config, nopIndicator{})
+	mon.Tool = tool
+	session, err := wrap.StartPTY(exec.Command(args[0], args[1:]...), false)
+	if err != nil {
+		return nil, err
+	}
+	resize := func() {
+		if cols, rows, ok := session.Resize(); ok {
+			mon.Screen.Resize(cols, rows)
+		}
+	}
+	resize()
+	winch := make(chan os.Signal, 1)
+	defer wrap.NotifyResize(winch)()
+
+	oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err == nil {
+		defer term.Restore(int(os.Stdin.Fd()), oldState)
+	}
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				close(learnInput)
+				return
+			}
+			if !learnLabeling.Load() {
+				session.Write(buf[:n])
+				continue
+			}
+			for _, b := range buf[:n] {
+				learnInput <- b
+			}
+		}
+	}()
+
+	var moments []learnMoment
+	began := time.Now()
+	var last string
+	var changed, busy time.Time
+	taken := true
+	tick := func() {
+		now := time.Now()
+		lines := tailLines(mon.Screen.Lines(), learnContext)
+		if key := strings.Join(lines, "\n"); key != last {
+			last, changed, taken = key, now, false
+		}
+		if len(lines) == 0 {
+			return
+		}
+		switch quiet := now.Sub(changed); {
+		case quiet >= learnPause && !taken:
+			moments = append(moments, learnMoment{at: now.Sub(began), pause: true, lines: lines})
+			taken = true
+		case quiet < learnPause && now.Sub(busy) >= learnBusy:
+			moments = append(moments, learnMoment{at: now.Sub(began), lines: lines})
+			busy = now
+		}
+	}
+	mon.Start(mon.Clock.Now())
+	wrap.Loop(mon, session, winch, resize, tick)
+	session.Wait()
+	// The screen the command left behind
+	if lines := tailLines(mon.Screen.Lines(), learnContext); len(lines) > 0 && !taken {
+		moments = append(moments, learnMoment{at: time.Since(began), pause: true, lines: lines})
+	}
+	return moments, nil
+}
+
+// tailLines returns the last n non-blank lines.
+func tailLines(lines []string, n int) []string {
+	var tail []string
+	for i := len(lines) - 1; i >= 0 && len(tail) < n; i-- {
+		if strings.TrimSpace(lines[i]) != "" {
+			tail = append([]string{lines[i]}, tail...)
+		}
+	}
+	return tail
+}
+
+// uniqueMoments drops moments whose final line was seen before, counting
+// lines that only differ in their numbers as the same.
+func uniqueMoments(moments []learnMoment) []learnMoment {
+	seen := map[string]bool{}
+	var unique []learnMoment
+	for _, m := range moments {
+		key := fmt.Sprint(m.pause, learnNormalize(m.lines[len(m.lines)-1]))
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, m)
+		}
+	}
+	return unique
+}
+
+// learnNormalize trims a line and replaces runs of digits with learnDigits.
+func learnNormalize(line string) string {
+	var b strings.Builder
+	digits := false
+	for _, r := range strings.TrimSpace(line) {
+		if unicode.IsDigit(r) {
+			if !digits {
+				b.WriteRune(learnDigits)
+			}
+			digits = true
+			continue
+		}
+		digits = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// learnPatterns proposes patterns for the labeled moments: for each label,
+// the longest substrings its final lines have in common that no final
+// line with another label contains, few enough to match all of them.
+func learnPatterns(moments []learnMoment) state.Patterns {
+	lines := map[string][]string{}
+	for _, m := range moments {
+		if m.label != "" && m.label != "skip" {
+			line := strings.TrimSpace(m.lines[len(m.lines)-1])
+			if !slices.Contains(lines[m.label], line) {
+				lines[m.label] = append(lines[m.label], line)
+			}
+		}
+	}
+	propose := func(label string) []string {
+		var others []string
+		for l, ls := range lines {
+			if l != label {
+				others = append(others, ls...)
+			}
+		}
+		pos := lines[label]
+		if len(pos) > learnMaxLines {
+			pos = pos[:learnMaxLines]
+		}
+
+		// Candidates are the common substrings of every pair and, for lines
+		// nothing else has much in common with, the line itself
+		var candidates []*regexp.Regexp
+		seen := map[string]bool{}
+		add := func(s string) {
+			s = strings.TrimSpace(s)
+			if len([]rune(s)) < learnMinRunes || seen[s] {
+				return
+			}
+			seen[s] = true
+			re, err := regexp.Compile(strings.ReplaceAll(regexp.QuoteMeta(s), string(learnDigits), `\d+`))
+			if err != nil || slices.ContainsFunc(others, re.MatchString) {
+				return
+			}
+			candidates = append(candidates, re)
+		}
+		normalized := make([]string, len(pos))
+		for i, line := range pos {
+			normalized[i] = learnNormalize(line)
+		}
+		for i := range normalized {
+			for j := i + 1; j < len(normalized); j++ {
+				add(longestCommon(normalized[i], normalized[j]))
+			}
+		}
+		for _, n := range normalized {
+			add(n)
+		}
+
+		// Pick the one matching the most lines still uncovered, the longest
+		// on a tie, until all are covered
+		var picked []string
+		covered := make([]bool, len(pos))
+		for {
+			var best *regexp.Regexp
+			bestCount := 0
+			for _, re := range candidates {
+				count := 0
+				for i, line := range pos {
+					if !covered[i] && re.MatchString(line) {
+						count++
+					}
+				}
+				if count > bestCount || count == bestCount && count > 0 && len(re.String()) > len(best.String()) {
+					best, bestCount = re, count
+				}
+			}
+			if best == nil {
+				return picked
+			}
+			picked = append(picked, best.String())
+			for i, line := range pos {
+				covered[i] = covered[i] || best.MatchString(line)
+			}
+		}
+	}
+	return state.Patterns{
+		Waiting:  propose("waiting"),
+		Thinking: propose("thinking"),
+		Error:    propose("error"),
+	}
+}
+
+// longestCommon returns the longest substring of a and b.
+func longestCommon(a, b string) string {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	bestLen, bestEnd := 0, 0
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			if ra[i-1] == rb[j-1] {
+				cur[j] = prev[j-1] + 1
+				if cur[j] > bestLen {
+					bestLen, bestEnd = cur[j], i
+				}
+			} else {
+				cur[j] = 0
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return string(ra[bestEnd-bestLen : bestEnd])
+}
+
+// mergePatterns appends the patterns of add that list doesn't have yet.
+func mergePatterns(list, add []string) []string {
+	for _, p := range add {
+		if !slices.Contains(list, p) {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+// nopIndicator discards the monitor's updates.
+type nopIndicator struct{}
+
+func (nopIndicator) SetState(state.State)                {}
+func (nopIndicator) SetEffect(state.State, state.Effect) {}
+func (nopIndicator) SetProgress(float64)                 {}
+func (nopIndicator) TurnOff()                            {}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/f0i/status-light/pkg/backend"
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// cmdLED runs "sl led test", which steps the configured backends through
+// every state and effect so wiring and colors can be checked without
+// wrapping a command.
+func cmdLED(args []string) int {
+	if len(args) == 0 || args[0] != "test" {
+		fmt.Fprintf(os.Stderr, "Usage: %s led test [--tool name] [--delay 1.5s] [--dry-run]\n", os.Args[0])
+		return 2
+	}
+	fs := flag.NewFlagSet("led test", flag.ExitOnError)
+	tool := fs.String("tool", "default", "config `name` whose LED and backends are tested")
+	delay := fs.Duration("delay", 1500*time.Millisecond, "how long each step is shown")
+	dryRun := fs.Bool("dry-run", false, "print the led commands, lamp commands and writes instead of running them")
+	fs.Parse(args[1:])
+	if *dryRun {
+		state.DryRun = os.Stdout
+	}
+
+	cfg := loadConfig(*tool)
+	local := ledFromConfig(cfg)
+	led := localBackends(cfg, local)
+	if _, err := queryStatus(backend.SocketPath()); err == nil {
+		fmt.Fprintf(os.Stderr, "[sl] The daemon is running and may repaint the LED during the test\n")
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	step := func(format string, args ...any) bool {
+		fmt.Printf(format+"\n", args...)
+		select {
+		case <-sigs:
+			backend.Shutdown(led)
+			return false
+		case <-time.After(*delay):
+			return true
+		}
+	}
+
+	for st := state.Idle; st <= state.Responding; st++ {
+		for _, effect := range []state.Effect{state.EffectSolid, state.EffectBlink, state.EffectDim} {
+			led.SetEffect(st, effect)
+			name := string(effect)
+			if name == "" {
+				name = "solid"
+			}
+			if !step("%-9s %s", st, name) {
+				return 130
+			}
+		}
+	}
+	led.SetEffect(state.Thinking, state.EffectSafe)
+	if !step("%-9s %s", state.Thinking, state.EffectSafe) {
+		return 130
+	}
+	if cfg.LEDCount > 1 {
+		led.SetState(state.Thinking)
+		for _, p := range []float64{0, 0.25, 0.5, 0.75, 1} {
+			led.SetProgress(p)
+			if !step("%-9s progress %.0f%%", state.Thinking, p*100) {
+				return 130
+			}
+		}
+		led.SetProgress(-1)
+	}
+	backend.Shutdown(led)
+	fmt.Println("off")
+	return 0
+}
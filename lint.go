@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"regexp/syntax"
+	"slices"
+
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// cmdConfigLint runs "sl config lint", which goes beyond the checks of
+// "sl doctor" for pattern packs: patterns that are slow, can never match
+// or overlap between states, and settings that nothing uses.
+func cmdConfigLint(args []string) int {
+	fs := flag.NewFlagSet("config lint", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s config lint [tool...]\n\nChecks configs/<tool>.json, or every config in configs/.\n", os.Args[0])
+	}
+	fs.Parse(args)
+	var paths []string
+	for _, tool := range fs.Args() {
+		paths = append(paths, filepath.Join("configs", tool+".json"))
+	}
+	if len(paths) == 0 {
+		paths, _ = filepath.Glob("configs/*.json")
+		paths = slices.DeleteFunc(paths, func(p string) bool { return p == profilesPath })
+	}
+	if len(paths) == 0 {
+		fmt.Fprintf(os.Stderr, "No configs in configs/\n")
+		return 1
+	}
+	found := 0
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			found++
+			continue
+		}
+		var cfg Config
+		if err := json.Unmarshal(stripComments(data), &cfg); err != nil {
+			fmt.Printf("%s: %v\n", path, err)
+			found++
+			continue
+		}
+		problems := append(configProblems(data, cfg), lintConfig(cfg)...)
+		for _, p := range problems {
+			fmt.Printf("%s: %s\n", path, p)
+		}
+		found += len(problems)
+	}
+	if found > 0 {
+		return 1
+	}
+	return 0
+}
+
+// lintList is a config's list of patterns, and how it is matched.
+type lintList struct {
+	name     string
+	patterns []string
+	chunks   bool // against chunks of output rather than screen lines
+}
+
+// lintConfig lists what "sl config lint" finds in cfg besides the
+// problems "sl doctor" reports.
+func lintConfig(cfg Config) []string {
+	var problems []string
+	p := cfg.Patterns
+	lists := []lintList{
+		{"waiting", p.Waiting, false},
+		{"thinking", p.Thinking, true},
+		{"error", p.Error, true},
+		{"success", p.Success, true},
+	}
+	if s := cfg.StderrPatterns; s != nil {
+		lists = append(lists,
+			lintList{"stderr thinking", s.Thinking, true},
+			lintList{"stderr error", s.Error, true},
+			lintList{"stderr success", s.Success, true})
+	}
+	if s := cfg.Sync; s != nil {
+		lists = append(lists, lintList{"sync", s.Patterns, true})
+	}
+	for _, s := range cfg.Sequences {
+		lists = append(lists, lintList{"sequence", s.After, true})
+	}
+	for _, e := range cfg.Expect {
+		lists = append(lists, lintList{"expect", []string{e.Pattern}, false})
+	}
+	for _, l := range lists {
+		for i, pattern := range l.patterns {
+			if slices.Contains(l.patterns[:i], pattern) {
+				problems = append(problems, fmt.Sprintf("%s pattern %q is listed twice", l.name, pattern))
+				continue
+			}
+			problems = append(problems, lintPattern(l, pattern)...)
+		}
+	}
+	problems = append(problems, lintOverlaps(lists[:4])...)
+
+	// Settings keyed by a pattern or state that doesn't exist
+	var settings []string
+	for pattern := range cfg.WaitingWindows {
+		if !slices.ContainsFunc(p.Waiting, func(w string) bool { return state.WindowKey(pattern, w) }) {
+			settings = append(settings, fmt.Sprintf("waiting_windows has %q, which isn't a waiting pattern", pattern))
+		}
+	}
+	for name := range cfg.Hooks {
+		if _, ok := state.ParseState(name); !ok {
+			settings = append(settings, fmt.Sprintf("hooks for unknown state %q never run", name))
+		}
+	}
+	for _, e := range cfg.Escalations {
+		if _, ok := state.ParseState(e.State); !ok {
+			settings = append(settings, fmt.Sprintf("escalation for unknown state %q never fires", e.State))
+		}
+	}
+	// Maps come in random order
+	slices.Sort(settings)
+	return append(problems, settings...)
+}
+
+// lintPattern checks one pattern of l for what is slow or never matches.
+func lintPattern(l lintList, pattern string) []string {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		// "sl doctor" reports it
+		return nil
+	}
+	var problems []string
+	warn := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf("%s pattern %q ", l.name, pattern)+fmt.Sprintf(format, args...))
+	}
+	if nestedRepeat(re) {
+		warn("repeats a repetition, like (a+)+; the Python version can take exponential time on output that almost matches")
+	} else if adjacentRepeats(re) {
+		warn("has two repetitions in a row that can match the same text, like .*.*; the Python version backtracks between them")
+	}
+	top := concat(re)
+	if len(top) > 0 && isRepeatOfAny(top[0]) {
+		warn("starts with .* or .+, which only makes matching slower")
+	}
+	if neverMatches(top) {
+		warn("can never match: it requires text before ^ or after $")
+	} else if l.chunks && len(top) > 0 && top[0].Op == syntax.OpBeginText {
+		warn("is matched against chunks of output, so ^ only matches where a chunk starts; (?m)^ matches at every line")
+	} else if l.chunks && len(top) > 0 && top[len(top)-1].Op == syntax.OpEndText {
+		warn("is matched against chunks of output, so $ only matches where a chunk ends; (?m)$ matches at every line")
+	}
+	return problems
+}
+
+// concat returns the parts of a pattern that are matched one after the
+// other, looking through capture groups.
+func concat(re *syntax.Regexp) []*syntax.Regexp {
+	for re.Op == syntax.OpCapture {
+		re = re.Sub[0]
+	}
+	if re.Op == syntax.OpConcat {
+		return re.Sub
+	}
+	return []*syntax.Regexp{re}
+}
+
+// unbounded reports whether re repeats without an upper limit.
+func unbounded(re *syntax.Regexp) bool {
+	return re.Op == syntax.OpStar || re.Op == syntax.OpPlus || re.Op == syntax.OpRepeat && re.Max == -1
+}
+
+func hasUnbounded(re *syntax.Regexp) bool {
+	if unbounded(re) {
+		return true
+	}
+	return slices.ContainsFunc(re.Sub, hasUnbounded)
+}
+
+// nestedRepeat reports whether re repeats something that contains an
+// unbounded repetition itself, such as (a+)+ or (\w*\s)*.
+func nestedRepeat(re *syntax.Regexp) bool {
+	repeats := unbounded(re) || re.Op == syntax.OpRepeat && re.Max > 1
+	if repeats && slices.ContainsFunc(re.Sub, hasUnbounded) {
+		return true
+	}
+	return slices.ContainsFunc(re.Sub, nestedRepeat)
+}
+
+// adjacentRepeats reports whether re has two unbounded repetitions in a
+// row that both match any character or the same class, such as .*.* or
+// \s+\s*.
+func adjacentRepeats(re *syntax.Regexp) bool {
+	if re.Op == syntax.OpConcat {
+		for i := 1; i < len(re.Sub); i++ {
+			a, b := re.Sub[i-1], re.Sub[i]
+			if unbounded(a) && unbounded(b) && (isRepeatOfAny(a) || isRepeatOfAny(b) || a.Sub[0].Equal(b.Sub[0])) {
+				return true
+			}
+		}
+	}
+	return slices.ContainsFunc(re.Sub, adjacentRepeats)
+}
+
+// isRepeatOfAny reports whether re is .* or .+.
+func isRepeatOfAny(re *syntax.Regexp) bool {
+	return unbounded(re) && (re.Sub[0].Op == syntax.OpAnyCharNotNL || re.Sub[0].Op == syntax.OpAnyChar)
+}
+
+// neverMatches reports whether parts require text before the start or
+// after the end of the text.
+func neverMatches(parts []*syntax.Regexp) bool {
+	for i, part := range parts {
+		switch part.Op {
+		case syntax.OpBeginText:
+			if slices.ContainsFunc(parts[:i], consumes) {
+				return true
+			}
+		case syntax.OpEndText:
+			if slices.ContainsFunc(parts[i+1:], consumes) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// consumes reports whether re always matches at least one character.
+func consumes(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return len(re.Rune) > 0
+	case syntax.OpCharClass, syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return true
+	case syntax.OpCapture, syntax.OpPlus:
+		return consumes(re.Sub[0])
+	case syntax.OpRepeat:
+		return re.Min > 0 && consumes(re.Sub[0])
+	case syntax.OpConcat:
+		return slices.ContainsFunc(re.Sub, consumes)
+	case syntax.OpAlternate:
+		for _, sub := range re.Sub {
+			if !consumes(sub) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// lintOverlaps finds literal patterns of one state that a pattern of
+// another state matches too, so the same text can mean either.
+func lintOverlaps(lists []lintList) []string {
+	var problems []string
+	for _, a := range lists {
+		for _, pa := range a.patterns {
+			literal, ok := literalText(pa)
+			if !ok {
+				continue
+			}
+			for _, b := range lists {
+				if b.name == a.name {
+					continue
+				}
+				for _, pb := range b.patterns {
+					problem := fmt.Sprintf("%s pattern %q also matches %s pattern %q", b.name, pb, a.name, pa)
+					if re, err := regexp.Compile(pb); err == nil && re.MatchString(literal) && !slices.Contains(problems, problem) {
+						problems = append(problems, problem)
+					}
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// literalText returns the text a pattern matches when it is plain text.
+func literalText(pattern string) (string, bool) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return "", false
+	}
+	for re.Op == syntax.OpCapture {
+		re = re.Sub[0]
+	}
+	if re.Op != syntax.OpLiteral {
+		return "", false
+	}
+	return string(re.Rune), true
+}
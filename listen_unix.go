@@ -0,0 +1,17 @@
+//go:build !windows
+
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenPrivate creates the daemon's socket readable by the user only.
+// The umask applies as the socket is created, so there's no moment in
+// which others could connect, as there would be with a chmod afterwards.
+func listenPrivate(path string) (net.Listener, error) {
+	old := syscall.Umask(0177)
+	defer syscall.Umask(old)
+	return net.Listen("unix", path)
+}
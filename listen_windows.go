@@ -0,0 +1,9 @@
+package main
+
+import "net"
+
+// listenPrivate creates the daemon's socket. Windows has no umask; the
+// socket gets the permissions of the directory it is created in.
+func listenPrivate(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
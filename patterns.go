@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultRegistry is where "sl patterns" takes pattern packs from: a
+// directory with one <tool>.json per tool and a SHA256SUMS listing them,
+// so packs for new tools reach users without a release of sl.
+const defaultRegistry = "https://raw.githubusercontent.com/f0i/status-light/main/packs"
+
+// packKeys are the settings a pattern pack may have. A pack from the
+// internet only tells how to read a tool's output; settings that run
+// commands, send requests or change how the light looks stay with the user.
+var packKeys = []string{
+	"patterns", "stderr_patterns", "waiting_window", "waiting_windows",
+	"idle_threshold_ms", "typing_ms", "ignore_alt_screen", "sampling",
+	"sync", "sequences", "success_hold_ms", "spinner", "bell", "osc_notify",
+	"progress", "silence", "responding_ms", "min_output_chars",
+}
+
+// packDir is where installed packs go, configs/ in the user's config
+// directory, e.g. ~/.config/status-light/configs.
+func packDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "status-light", "configs"), nil
+}
+
+// cmdPatterns runs "sl patterns", which lists and installs pattern packs
+// from a registry.
+func cmdPatterns(args []string) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "install":
+			return cmdPatternsInstall(args[1:])
+		case "list":
+			return cmdPatternsList(args[1:])
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Usage: %s patterns list [--registry url]\n       %s patterns install [--registry url] [--sha256 sum] [--force] <tool>\n", os.Args[0], os.Args[0])
+	return 2
+}
+
+// registryFlag adds --registry, whose default can be changed with
+// SL_REGISTRY.
+func registryFlag(fs *flag.FlagSet) *string {
+	registry := defaultRegistry
+	if env := os.Getenv("SL_REGISTRY"); env != "" {
+		registry = env
+	}
+	return fs.String("registry", registry, "`url` of the pattern pack registry (or set SL_REGISTRY)")
+}
+
+func cmdPatternsList(args []string) int {
+	fs := flag.NewFlagSet("patterns list", flag.ExitOnError)
+	registry := registryFlag(fs)
+	fs.Parse(args)
+	sums, err := fetchRegistry(*registry, checksumsAsset)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	dir, _ := packDir()
+	for _, tool := range registryPacks(sums) {
+		note := ""
+		if _, err := os.Stat(filepath.Join(dir, tool+".json")); err == nil {
+			note = " (installed)"
+		}
+		fmt.Printf("%s%s\n", tool, note)
+	}
+	return 0
+}
+
+func cmdPatternsInstall(args []string) int {
+	fs := flag.NewFlagSet("patterns install", flag.ExitOnError)
+	registry := registryFlag(fs)
+	pin := fs.String("sha256", "", "install only a pack with this `checksum`, whatever the registry lists")
+	force := fs.Bool("force", false, "replace a pack that is already installed")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s patterns install [options] <tool>\n\nDownloads <tool>.json from the registry, checks it against the registry's\n%s or the checksum given, and installs it for the current user.\n\nOptions:\n", os.Args[0], checksumsAsset)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	tool := fs.Arg(0)
+	if tool == "" || strings.ContainsAny(tool, `/\`) || strings.HasPrefix(tool, ".") {
+		fmt.Fprintf(os.Stderr, "Invalid tool name %q\n", tool)
+		return 2
+	}
+	dir, err := packDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	path := filepath.Join(dir, tool+".json")
+	if _, err := os.Stat(path); err == nil && !*force {
+		fmt.Fprintf(os.Stderr, "%s exists, use --force to replace it\n", path)
+		return 1
+	}
+
+	name := tool + ".json"
+	data, err := fetchRegistry(*registry, name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if *pin != "" {
+		sum := sha256.Sum256(data)
+		if !strings.EqualFold(hex.EncodeToString(sum[:]), *pin) {
+			fmt.Fprintf(os.Stderr, "%s doesn't match the checksum given, not installed\n", name)
+			return 1
+		}
+	} else {
+		sums, err := fetchRegistry(*registry, checksumsAsset)
+		if err == nil {
+			err = verifyChecksum(sums, name, data)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+	}
+	if err := checkPack(data); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		return 1
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	fmt.Printf("Installed %s\n", path)
+	if _, err := os.Stat(filepath.Join("configs", name)); err == nil {
+		fmt.Printf("configs/%s in this directory is used instead of it\n", name)
+	}
+	return 0
+}
+
+// fetchRegistry downloads a file of the registry.
+func fetchRegistry(registry, name string) ([]byte, error) {
+	req, err := http.NewRequest("GET", strings.TrimSuffix(registry, "/")+"/"+name, nil)
+	if err != nil {
+		return nil, err
+	}
+	return fetch(&http.Client{Timeout: time.Minute}, req)
+}
+
+// registryPacks returns the tools a registry's SHA256SUMS lists packs for.
+func registryPacks(sums []byte) []string {
+	var tools []string
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if tool, ok := strings.CutSuffix(strings.TrimPrefix(fields[1], "*"), ".json"); ok {
+			tools = append(tools, tool)
+		}
+	}
+	sort.Strings(tools)
+	return tools
+}
+
+// checkPack refuses packs that don't parse, have settings a pack may not
+// have, or that "sl doctor" finds problems in.
+func checkPack(data []byte) error {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(stripComments(data), &keys); err != nil {
+		return err
+	}
+	for key := range keys {
+		if !slices.Contains(packKeys, key) {
+			return fmt.Errorf("has %q, which pattern packs may not set", key)
+		}
+	}
+	var cfg Config
+	if err := json.Unmarshal(stripComments(data), &cfg); err != nil {
+		return err
+	}
+	if problems := configProblems(data, cfg); len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
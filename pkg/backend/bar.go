@@ -0,0 +1,64 @@
+package backend
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// BarSignal tells status bars to refresh by sending them SIGRTMIN+n, the
+// way waybar custom modules and i3blocks blocks with "signal" expect. The
+// bar then runs "sl bar" to fetch the new state.
+type BarSignal struct {
+	signal int
+	debug  bool
+
+	mu     sync.Mutex
+	state  state.State
+	effect state.Effect
+	shown  bool
+}
+
+func NewBarSignal(signal int) *BarSignal {
+	return &BarSignal{signal: signal, debug: os.Getenv("DEBUG_SL") != ""}
+}
+
+func (b *BarSignal) SetState(st state.State) {
+	b.SetEffect(st, state.EffectSolid)
+}
+
+func (b *BarSignal) SetEffect(st state.State, effect state.Effect) {
+	b.mu.Lock()
+	changed := !b.shown || st != b.state || effect != b.effect
+	b.state, b.effect, b.shown = st, effect, true
+	b.mu.Unlock()
+	if changed {
+		b.notify()
+	}
+}
+
+func (b *BarSignal) SetProgress(progress float64) {}
+
+func (b *BarSignal) TurnOff() {
+	b.mu.Lock()
+	b.shown = false
+	b.mu.Unlock()
+	b.notify()
+}
+
+func (b *BarSignal) notify() {
+	sig := "-RTMIN+" + strconv.Itoa(b.signal)
+	if b.debug {
+		fmt.Fprintf(os.Stderr, "[DEBUG] Signalling status bars with %s\n", sig)
+	}
+	for _, bar := range []string{"waybar", "i3blocks"} {
+		if state.DryRunf("pkill %s -x %s", sig, bar) {
+			continue
+		}
+		exec.Command("pkill", sig, "-x", bar).Run()
+	}
+}
@@ -0,0 +1,251 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// BLELight configures a Bluetooth LE light strip or bulb that is controlled
+// directly, without the vendor's app or cloud. "sl backend scan-ble" lists
+// nearby lights with their address and kind.
+type BLELight struct {
+	Address string `json:"address"` // e.g. "A4:C1:38:12:34:56"
+	Kind    string `json:"kind"`    // "govee" or "elk"
+	// Random is set for lights that advertise a random address
+	Random bool `json:"random_address"`
+}
+
+// bleProtocol describes how a kind of light is written to.
+type bleProtocol struct {
+	// characteristic the commands are written to
+	uuid  string
+	on    func(r, g, b int) [][]byte
+	off   func() [][]byte
+	names []string // advertised name prefixes, for scanning
+}
+
+var bleProtocols = map[string]bleProtocol{
+	// Govee H6xxx strips and bulbs: 20 byte packets with an XOR checksum
+	"govee": {
+		uuid: "00010203-0405-0607-0809-0a0b0c0d2b11",
+		on: func(r, g, b int) [][]byte {
+			return [][]byte{goveePacket(0x01, 0x01), goveePacket(0x05, 0x02, byte(r), byte(g), byte(b))}
+		},
+		off:   func() [][]byte { return [][]byte{goveePacket(0x01, 0x00)} },
+		names: []string{"ihoment_", "Govee_", "GBK_"},
+	},
+	// The ELK-BLEDOM controller in many unbranded and Tuya-app strips
+	"elk": {
+		uuid: "0000fff3-0000-1000-8000-00805f9b34fb",
+		on: func(r, g, b int) [][]byte {
+			return [][]byte{
+				{0x7e, 0x00, 0x04, 0xf0, 0x00, 0x01, 0xff, 0x00, 0xef},
+				{0x7e, 0x00, 0x05, 0x03, byte(r), byte(g), byte(b), 0x00, 0xef},
+			}
+		},
+		off:   func() [][]byte { return [][]byte{{0x7e, 0x00, 0x04, 0x00, 0x00, 0x00, 0xff, 0x00, 0xef}} },
+		names: []string{"ELK-BLEDOM", "BLEDOM", "ELK-"},
+	},
+}
+
+func goveePacket(cmd byte, data ...byte) []byte {
+	p := make([]byte, 20)
+	p[0], p[1] = 0x33, cmd
+	copy(p[2:19], data)
+	for _, b := range p[:19] {
+		p[19] ^= b
+	}
+	return p
+}
+
+// BLEKind guesses the kind of a light from its advertised name.
+func BLEKind(name string) string {
+	for kind, p := range bleProtocols {
+		for _, prefix := range p.names {
+			if strings.HasPrefix(name, prefix) {
+				return kind
+			}
+		}
+	}
+	return ""
+}
+
+// BLEDevice is a device found by ScanBLE.
+type BLEDevice struct {
+	Address string
+	Random  bool
+	Name    string
+	RSSI    int
+}
+
+var errBLEUnsupported = errors.New("Bluetooth LE lights are only supported on Linux")
+
+// bleConn is a connection to a light's command characteristic.
+type bleConn interface {
+	write(value []byte) error
+	Close() error
+}
+
+// BLELamp shows the state on a BLE light. Writes happen on a goroutine
+// that keeps the connection open and reconnects after errors; changes that
+// arrive meanwhile replace the ones not yet written.
+type BLELamp struct {
+	light BLELight
+	proto bleProtocol
+	debug bool
+
+	mu      sync.Mutex
+	pending [][]byte
+	gen     int // counts queued changes
+	last    string
+	wake    chan struct{}
+	closed  bool
+}
+
+func NewBLELamp(light BLELight) (*BLELamp, error) {
+	proto, ok := bleProtocols[light.Kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown BLE light kind %q (known: govee, elk)", light.Kind)
+	}
+	if _, err := parseBLEAddress(light.Address); err != nil {
+		return nil, err
+	}
+	l := &BLELamp{
+		light: light,
+		proto: proto,
+		debug: os.Getenv("DEBUG_SL") != "",
+		wake:  make(chan struct{}, 1),
+	}
+	go l.run()
+	return l, nil
+}
+
+func (l *BLELamp) SetState(st state.State) {
+	l.SetEffect(st, state.EffectSolid)
+}
+
+// SetEffect shows blink as solid; BLE lights are too slow to blink in
+// time.
+func (l *BLELamp) SetEffect(st state.State, effect state.Effect) {
+	if effect == state.EffectOff {
+		l.TurnOff()
+		return
+	}
+	r, g, b := EffectColor(st, effect)
+	if effect == state.EffectDim {
+		r, g, b = r*DimBrightness/255, g*DimBrightness/255, b*DimBrightness/255
+	}
+	l.queue(fmt.Sprintf("%d,%d,%d", r, g, b), l.proto.on(r, g, b))
+}
+
+// SetProgress is not shown; the light has a single color.
+func (l *BLELamp) SetProgress(progress float64) {}
+
+func (l *BLELamp) TurnOff() {
+	l.queue("off", l.proto.off())
+}
+
+// queue replaces the pending packets unless they would show what the light
+// already shows.
+func (l *BLELamp) queue(key string, packets [][]byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if key == l.last || l.closed {
+		return
+	}
+	l.last = key
+	if state.DryRun != nil {
+		hex := make([]string, len(packets))
+		for i, p := range packets {
+			hex[i] = fmt.Sprintf("%x", p)
+		}
+		state.DryRunf("BLE %s <- %s", l.light.Address, strings.Join(hex, " "))
+		return
+	}
+	l.pending = packets
+	l.gen++
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+// close ends the writer and its connection once the pending packets,
+// such as turning the light off, are written.
+func (l *BLELamp) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.closed {
+		l.closed = true
+		close(l.wake)
+	}
+}
+
+func (l *BLELamp) run() {
+	var conn bleConn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	for range l.wake {
+		for {
+			l.mu.Lock()
+			packets, gen := l.pending, l.gen
+			l.mu.Unlock()
+			if packets == nil {
+				break
+			}
+			err := error(nil)
+			if conn == nil {
+				conn, err = dialBLE(l.light, l.proto.uuid)
+			}
+			for _, p := range packets {
+				if err == nil {
+					err = conn.write(p)
+				}
+			}
+			if err != nil {
+				if l.debug {
+					fmt.Fprintf(os.Stderr, "[DEBUG] BLE %s: %v\n", l.light.Address, err)
+				}
+				if conn != nil {
+					conn.Close()
+					conn = nil
+				}
+				l.mu.Lock()
+				closed := l.closed
+				l.mu.Unlock()
+				if errors.Is(err, errBLEUnsupported) || closed {
+					return
+				}
+				time.Sleep(5 * time.Second)
+				continue
+			}
+			l.mu.Lock()
+			if l.gen == gen {
+				l.pending = nil
+			}
+			l.mu.Unlock()
+		}
+	}
+}
+
+// parseBLEAddress parses "AA:BB:CC:DD:EE:FF".
+func parseBLEAddress(s string) ([6]byte, error) {
+	var addr [6]byte
+	if _, err := fmt.Sscanf(s, "%02x:%02x:%02x:%02x:%02x:%02x", &addr[0], &addr[1], &addr[2], &addr[3], &addr[4], &addr[5]); err != nil || len(s) != 17 {
+		return addr, fmt.Errorf("invalid Bluetooth address %q", s)
+	}
+	return addr, nil
+}
+
+func formatBLEAddress(addr [6]byte) string {
+	return fmt.Sprintf("%02X:%02X:%02X:%02X:%02X:%02X", addr[0], addr[1], addr[2], addr[3], addr[4], addr[5])
+}
@@ -0,0 +1,231 @@
+package backend
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	attCID = 4 // L2CAP channel of the attribute protocol on LE links
+
+	attError           = 0x01
+	attReadByType      = 0x08
+	attReadByTypeResp  = 0x09
+	attWriteCommand    = 0x52
+	attNotFound        = 0x0a
+	gattCharacteristic = 0x2803
+
+	hciFilter      = 2 // socket option
+	hciCommandPkt  = 0x01
+	hciEventPkt    = 0x04
+	hciLEMetaEvent = 0x3e
+	hciLEAdvReport = 0x02
+)
+
+// attConn writes to one characteristic over a raw L2CAP socket, which
+// needs no BlueZ D-Bus library.
+type attConn struct {
+	fd     int
+	handle uint16
+}
+
+func dialBLE(light BLELight, uuid string) (bleConn, error) {
+	addr, err := parseBLEAddress(light.Address)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_SEQPACKET|unix.SOCK_CLOEXEC, unix.BTPROTO_L2CAP)
+	if err != nil {
+		return nil, err
+	}
+	c := &attConn{fd: fd}
+	addrType := uint8(unix.BDADDR_LE_PUBLIC)
+	if light.Random {
+		addrType = unix.BDADDR_LE_RANDOM
+	}
+	tv := unix.NsecToTimeval((5 * time.Second).Nanoseconds())
+	unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv)
+	if err := unix.Bind(fd, &unix.SockaddrL2{CID: attCID, AddrType: unix.BDADDR_LE_PUBLIC}); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("bind: %w", err)
+	}
+	if err := unix.Connect(fd, &unix.SockaddrL2{CID: attCID, Addr: addr, AddrType: addrType}); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("connect: %w", err)
+	}
+	if c.handle, err = c.findCharacteristic(uuid); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// findCharacteristic returns the value handle of the characteristic with
+// the given UUID.
+func (c *attConn) findCharacteristic(uuid string) (uint16, error) {
+	start := uint16(0x0001)
+	for {
+		req := []byte{attReadByType, 0, 0, 0xff, 0xff, 0, 0}
+		binary.LittleEndian.PutUint16(req[1:], start)
+		binary.LittleEndian.PutUint16(req[5:], gattCharacteristic)
+		resp, err := c.request(req)
+		if err != nil {
+			return 0, err
+		}
+		if resp[0] == attError {
+			break
+		}
+		if resp[0] != attReadByTypeResp || len(resp) < 2 || resp[1] < 7 {
+			return 0, fmt.Errorf("unexpected ATT response 0x%02x", resp[0])
+		}
+		size := int(resp[1])
+		var last uint16
+		for entry := resp[2:]; len(entry) >= size; entry = entry[size:] {
+			last = binary.LittleEndian.Uint16(entry)
+			value := binary.LittleEndian.Uint16(entry[3:])
+			if attUUID(entry[5:size]) == uuid {
+				return value, nil
+			}
+		}
+		if last == 0xffff || last < start {
+			break
+		}
+		start = last + 1
+	}
+	return 0, fmt.Errorf("characteristic %s not found", uuid)
+}
+
+// attUUID formats a little-endian 16 or 128 bit UUID.
+func attUUID(b []byte) string {
+	if len(b) == 2 {
+		return fmt.Sprintf("0000%04x-0000-1000-8000-00805f9b34fb", binary.LittleEndian.Uint16(b))
+	}
+	be := make([]byte, len(b))
+	for i := range b {
+		be[i] = b[len(b)-1-i]
+	}
+	return fmt.Sprintf("%x-%x-%x-%x-%x", be[0:4], be[4:6], be[6:8], be[8:10], be[10:])
+}
+
+// request sends an ATT request and returns the response, skipping
+// notifications.
+func (c *attConn) request(req []byte) ([]byte, error) {
+	if _, err := unix.Write(c.fd, req); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 512)
+	for {
+		n, err := unix.Read(c.fd, buf)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, errors.New("connection closed")
+		}
+		// Responses have odd opcodes up to 0x1f; 0x1b and 0x1d are
+		// notifications and indications
+		if op := buf[0]; op != 0x1b && op != 0x1d {
+			return buf[:n], nil
+		}
+	}
+}
+
+func (c *attConn) write(value []byte) error {
+	pdu := make([]byte, 3, 3+len(value))
+	pdu[0] = attWriteCommand
+	binary.LittleEndian.PutUint16(pdu[1:], c.handle)
+	_, err := unix.Write(c.fd, append(pdu, value...))
+	return err
+}
+
+func (c *attConn) Close() error {
+	return unix.Close(c.fd)
+}
+
+// ScanBLE listens for advertising lights for d and calls found for each
+// advertisement. It uses the first adapter and needs CAP_NET_RAW and
+// CAP_NET_ADMIN, e.g. root.
+func ScanBLE(d time.Duration, found func(BLEDevice)) error {
+	fd, err := unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.BTPROTO_HCI)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	if err := unix.Bind(fd, &unix.SockaddrHCI{Dev: 0, Channel: unix.HCI_CHANNEL_RAW}); err != nil {
+		return fmt.Errorf("bind: %w", err)
+	}
+	// Only LE meta events
+	filter := make([]byte, 16)
+	binary.LittleEndian.PutUint32(filter[0:], 1<<hciEventPkt)
+	binary.LittleEndian.PutUint32(filter[8:], 1<<(hciLEMetaEvent-32))
+	if err := unix.SetsockoptString(fd, unix.SOL_HCI, hciFilter, string(filter)); err != nil {
+		return fmt.Errorf("filter: %w", err)
+	}
+
+	// LE Set Scan Parameters: active scan, 10 ms interval and window
+	if err := hciCommand(fd, 0x000b, 0x01, 0x10, 0x00, 0x10, 0x00, 0x00, 0x00); err != nil {
+		return err
+	}
+	// LE Set Scan Enable, with duplicates so RSSI stays current
+	if err := hciCommand(fd, 0x000c, 0x01, 0x00); err != nil {
+		return err
+	}
+	defer hciCommand(fd, 0x000c, 0x00, 0x00)
+
+	tv := unix.NsecToTimeval((500 * time.Millisecond).Nanoseconds())
+	unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv)
+	buf := make([]byte, 260)
+	for end := time.Now().Add(d); time.Now().Before(end); {
+		n, err := unix.Read(fd, buf)
+		if err == unix.EAGAIN || err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if n < 5 || buf[0] != hciEventPkt || buf[1] != hciLEMetaEvent || buf[3] != hciLEAdvReport {
+			continue
+		}
+		parseAdvReports(buf[5:n], int(buf[4]), found)
+	}
+	return nil
+}
+
+// hciCommand sends an LE controller command.
+func hciCommand(fd int, ocf uint16, params ...byte) error {
+	pkt := []byte{hciCommandPkt, 0, 0, byte(len(params))}
+	binary.LittleEndian.PutUint16(pkt[1:], 0x08<<10|ocf)
+	_, err := unix.Write(fd, append(pkt, params...))
+	return err
+}
+
+// parseAdvReports decodes the reports of one advertising report event.
+func parseAdvReports(b []byte, count int, found func(BLEDevice)) {
+	for ; count > 0 && len(b) >= 10; count-- {
+		var addr [6]byte
+		for i := range addr {
+			addr[i] = b[7-i]
+		}
+		dev := BLEDevice{Address: formatBLEAddress(addr), Random: b[1] == 1}
+		size := int(b[8])
+		if len(b) < 10+size {
+			return
+		}
+		// Advertising data: length, type, value
+		for ad := b[9 : 9+size]; len(ad) > 1 && len(ad) > int(ad[0]); ad = ad[1+int(ad[0]):] {
+			if ad[0] > 0 && (ad[1] == 0x08 || ad[1] == 0x09) {
+				dev.Name = string(ad[2 : 1+int(ad[0])])
+			}
+			if ad[0] == 0 {
+				break
+			}
+		}
+		dev.RSSI = int(int8(b[9+size]))
+		found(dev)
+		b = b[10+size:]
+	}
+}
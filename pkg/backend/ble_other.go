@@ -0,0 +1,15 @@
+//go:build !linux
+
+package backend
+
+import "time"
+
+func dialBLE(light BLELight, uuid string) (bleConn, error) {
+	return nil, errBLEUnsupported
+}
+
+// ScanBLE listens for advertising lights for d and calls found for each
+// advertisement.
+func ScanBLE(d time.Duration, found func(BLEDevice)) error {
+	return errBLEUnsupported
+}
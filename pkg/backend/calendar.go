@@ -0,0 +1,227 @@
+package backend
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// Calendar shows meetings on the daemon's LED, turning the light into a
+// desk presence light: while an event runs, it counts as a session in
+// State, so it wins over calmer sessions and loses to more urgent ones.
+// URL is an ICS feed (webcal:// works too) or, with CalDAV set, a CalDAV
+// calendar collection. Events marked free or cancelled are ignored, and so
+// are all-day events unless AllDay is set.
+type Calendar struct {
+	URL       string       `json:"url"`
+	CalDAV    bool         `json:"caldav"`
+	Username  string       `json:"username"`
+	Password  string       `json:"password"`
+	State     string       `json:"state"` // default "error"
+	Effect    state.Effect `json:"effect"`
+	AllDay    bool         `json:"all_day"`
+	RefreshMs int          `json:"refresh_ms"` // default 5 minutes
+}
+
+const (
+	calendarCheck   = 15 * time.Second
+	calendarTimeout = 30 * time.Second
+	// calendarAhead is how far ahead events are read, so a server that
+	// goes away doesn't end meetings early
+	calendarAhead = 24 * time.Hour
+)
+
+// CalendarWatch keeps the events of a Calendar and reports when one
+// starts or ends.
+type CalendarWatch struct {
+	cal      Calendar
+	State    state.State
+	Effect   state.Effect
+	changed  func()
+	client   *http.Client
+	debug    bool
+	mu       sync.Mutex
+	events   []CalendarEvent
+	current  *CalendarEvent
+	fetched  time.Time
+	interval time.Duration
+}
+
+// StartCalendar fetches cal and keeps it current, calling changed, which
+// must not block for long, whenever an event starts or ends.
+func StartCalendar(cal Calendar, changed func()) (*CalendarWatch, error) {
+	if cal.URL == "" {
+		return nil, fmt.Errorf("calendar needs a url")
+	}
+	st := state.Error
+	if cal.State != "" {
+		var ok bool
+		if st, ok = state.ParseState(cal.State); !ok {
+			return nil, fmt.Errorf("unknown calendar state %q", cal.State)
+		}
+	}
+	w := &CalendarWatch{
+		cal:      cal,
+		State:    st,
+		Effect:   cal.Effect,
+		changed:  changed,
+		client:   &http.Client{Timeout: calendarTimeout},
+		debug:    os.Getenv("DEBUG_SL") != "",
+		interval: 5 * time.Minute,
+	}
+	if cal.RefreshMs > 0 {
+		w.interval = time.Duration(cal.RefreshMs) * time.Millisecond
+	}
+	go w.loop()
+	return w, nil
+}
+
+// Current returns the event running now, or nil. A nil *CalendarWatch
+// has none.
+func (w *CalendarWatch) Current() *CalendarEvent {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.current
+}
+
+func (w *CalendarWatch) loop() {
+	w.refresh(time.Now())
+	for now := range time.Tick(calendarCheck) {
+		if now.Sub(w.fetched) >= w.interval {
+			w.refresh(now)
+		} else {
+			w.check(now)
+		}
+	}
+}
+
+// refresh reads the calendar again. On errors the events read before are
+// kept.
+func (w *CalendarWatch) refresh(now time.Time) {
+	w.fetched = now
+	from, to := now.Add(-time.Hour), now.Add(calendarAhead)
+	events, err := w.fetch(from, to)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Calendar: %v\n", err)
+	} else {
+		if w.debug {
+			fmt.Fprintf(os.Stderr, "[DEBUG] Calendar: %d event(s) in the next %s\n", len(events), calendarAhead)
+		}
+		w.mu.Lock()
+		w.events = events
+		w.mu.Unlock()
+	}
+	w.check(now)
+}
+
+// check updates the current event and reports a change.
+func (w *CalendarWatch) check(now time.Time) {
+	w.mu.Lock()
+	var current *CalendarEvent
+	for i, ev := range w.events {
+		if !now.Before(ev.Start) && now.Before(ev.End) {
+			current = &w.events[i]
+			break
+		}
+	}
+	changed := (current == nil) != (w.current == nil) || current != nil && *current != *w.current
+	w.current = current
+	w.mu.Unlock()
+	if !changed {
+		return
+	}
+	if w.debug {
+		if current != nil {
+			fmt.Fprintf(os.Stderr, "[DEBUG] Calendar: %q until %s\n", current.Summary, current.End.Format("15:04"))
+		} else {
+			fmt.Fprintf(os.Stderr, "[DEBUG] Calendar: no event\n")
+		}
+	}
+	w.changed()
+}
+
+func (w *CalendarWatch) fetch(from, to time.Time) ([]CalendarEvent, error) {
+	url := w.cal.URL
+	if rest, ok := strings.CutPrefix(url, "webcal://"); ok {
+		url = "https://" + rest
+	}
+	if !w.cal.CalDAV {
+		data, err := w.get("GET", url, "", nil)
+		if err != nil {
+			return nil, err
+		}
+		return parseICS(data, from, to, w.cal.AllDay)
+	}
+
+	// Ask the server for the occurrences in the range, recurrences expanded
+	const stamp = "20060102T150405Z"
+	query := fmt.Sprintf(`<?xml version="1.0" encoding="utf-8"?>
+<c:calendar-query xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+  <d:prop><c:calendar-data><c:expand start="%[1]s" end="%[2]s"/></c:calendar-data></d:prop>
+  <c:filter><c:comp-filter name="VCALENDAR"><c:comp-filter name="VEVENT">
+    <c:time-range start="%[1]s" end="%[2]s"/>
+  </c:comp-filter></c:comp-filter></c:filter>
+</c:calendar-query>`, from.UTC().Format(stamp), to.UTC().Format(stamp))
+	data, err := w.get("REPORT", url, query, map[string]string{"Depth": "1", "Content-Type": "application/xml; charset=utf-8"})
+	if err != nil {
+		return nil, err
+	}
+	var events []CalendarEvent
+	dec := xml.NewDecoder(strings.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("CalDAV response: %w", err)
+		}
+		if el, ok := tok.(xml.StartElement); ok && el.Name.Local == "calendar-data" {
+			var ics string
+			if err := dec.DecodeElement(&ics, &el); err != nil {
+				return nil, fmt.Errorf("CalDAV response: %w", err)
+			}
+			evs, err := parseICS(ics, from, to, w.cal.AllDay)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, evs...)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Start.Before(events[j].Start) })
+	return events, nil
+}
+
+func (w *CalendarWatch) get(method, url, body string, header map[string]string) (string, error) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	if w.cal.Username != "" {
+		req.SetBasicAuth(w.cal.Username, w.cal.Password)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("%s %s: %s", method, req.URL.Redacted(), resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	return string(data), err
+}
@@ -0,0 +1,218 @@
+package backend
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// Message is one line of the daemon protocol. Sessions send "hello" once and
+// then "state" updates; the connection closing ends the session. "snooze"
+// pauses the LED for DurationMs (0 resumes); the daemon forwards it to all
+// sessions so they hold back notifications too. "set" shows State with
+// Effect regardless of the sessions for DurationMs (0 until cleared), and
+// State "auto" returns to showing the sessions. "send" asks the daemon to
+// pass Data to a session as "input", typed into the wrapped program; the
+// daemon answers with a "send" message whose Error is empty on success.
+// "subscribe" turns the connection into a stream of the daemon's events,
+// one JSON object per line, for editors and other local clients.
+// "profile" switches the daemon to the profile named in Data ("" for none)
+// and is answered like "send"; the daemon passes the profile's Push
+// settings on to every session, and Mute when it turns notifications off.
+type Message struct {
+	Type       string       `json:"type"`
+	Session    string       `json:"session,omitempty"`
+	Tool       string       `json:"tool,omitempty"`
+	Name       string       `json:"name,omitempty"` // the session's label, with "hello"
+	PID        int          `json:"pid,omitempty"`
+	State      string       `json:"state,omitempty"`
+	Effect     state.Effect `json:"effect,omitempty"`
+	Progress   *float64     `json:"progress,omitempty"`
+	DurationMs int64        `json:"duration_ms,omitempty"`
+	Data       string       `json:"data,omitempty"`
+	Token      string       `json:"token,omitempty"`
+	Error      string       `json:"error,omitempty"`
+	Lines      []string     `json:"lines,omitempty"` // last visible lines, with "screen"
+	Cost       *float64     `json:"cost,omitempty"`  // dollars spent, with "cost"
+	Push       *state.Push  `json:"push,omitempty"`  // with "profile"
+	Mute       bool         `json:"mute,omitempty"`  // with "profile"
+}
+
+// SocketPath returns where the daemon listens: $SL_SOCKET, then the user's
+// runtime dir, then a per-user file in the temp dir.
+func SocketPath() string {
+	if p := os.Getenv("SL_SOCKET"); p != "" {
+		return p
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "status-light.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("status-light-%d.sock", os.Getuid()))
+}
+
+// DaemonClient reports a session's state to the daemon. If the daemon goes
+// away it falls back to driving the LED directly.
+type DaemonClient struct {
+	mu       sync.Mutex
+	conn     net.Conn
+	enc      *json.Encoder
+	fallback state.Indicator
+	debug    bool
+
+	snoozeUntil time.Time
+	muted       bool        // by the daemon's profile
+	push        *state.Push // of the daemon's profile
+	input       func([]byte)
+}
+
+// DialDaemon connects to the daemon and registers a session for tool,
+// labeled name if that isn't empty.
+func DialDaemon(path, tool, name string, fallback state.Indicator) (*DaemonClient, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	c := &DaemonClient{
+		conn:     conn,
+		enc:      json.NewEncoder(conn),
+		fallback: fallback,
+		debug:    os.Getenv("DEBUG_SL") != "",
+	}
+	hello := Message{Type: "hello", Session: strconv.Itoa(os.Getpid()), Tool: tool, Name: name, PID: os.Getpid()}
+	if err := c.enc.Encode(hello); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go c.listen(conn)
+	return c, nil
+}
+
+// listen handles messages the daemon pushes to the session.
+func (c *DaemonClient) listen(conn net.Conn) {
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var msg Message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			continue
+		}
+		var input func([]byte)
+		c.mu.Lock()
+		switch msg.Type {
+		case "snooze":
+			c.snoozeUntil = time.Now().Add(time.Duration(msg.DurationMs) * time.Millisecond)
+		case "profile":
+			c.push, c.muted = msg.Push, msg.Mute
+		case "input":
+			input = c.input
+		}
+		c.mu.Unlock()
+		// Delivered here rather than in a goroutine each, so input sent
+		// in several messages arrives in order. Unlocked, as a full PTY
+		// may block.
+		if input != nil {
+			input([]byte(msg.Data))
+		}
+	}
+}
+
+// SetScreen sends the last visible lines of the session to the daemon, for
+// its dashboard.
+func (c *DaemonClient) SetScreen(lines []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		c.enc.Encode(Message{Type: "screen", Lines: lines})
+	}
+}
+
+// SetCost sends what the session has spent to the daemon.
+func (c *DaemonClient) SetCost(cost float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		c.enc.Encode(Message{Type: "cost", Cost: &cost})
+	}
+}
+
+// SetInput sets where input sent through the daemon ("sl send") goes,
+// usually the session's PTY. Without it, input is dropped.
+func (c *DaemonClient) SetInput(input func([]byte)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.input = input
+}
+
+// Client returns c, for callers that look for the daemon client behind
+// wrapping indicators.
+func (c *DaemonClient) Client() *DaemonClient {
+	return c
+}
+
+// Snoozed reports whether the daemon asked to hold back notifications,
+// with a snooze or a profile that turns them off.
+func (c *DaemonClient) Snoozed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.muted || time.Now().Before(c.snoozeUntil)
+}
+
+// Push returns where the daemon's profile sends push notifications, or nil
+// to use the session's own settings.
+func (c *DaemonClient) Push() *state.Push {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.push
+}
+
+func (c *DaemonClient) SetState(st state.State) {
+	c.SetEffect(st, state.EffectSolid)
+}
+
+func (c *DaemonClient) SetEffect(st state.State, effect state.Effect) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		if err := c.enc.Encode(Message{Type: "state", State: st.String(), Effect: effect}); err == nil {
+			return
+		}
+		if c.debug {
+			fmt.Fprintf(os.Stderr, "[DEBUG] Lost daemon connection, using local LED\n")
+		}
+		c.conn.Close()
+		c.conn = nil
+	}
+	c.fallback.SetEffect(st, effect)
+}
+
+func (c *DaemonClient) SetProgress(progress float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		if err := c.enc.Encode(Message{Type: "progress", Progress: &progress}); err == nil {
+			return
+		}
+		c.conn.Close()
+		c.conn = nil
+	}
+	c.fallback.SetProgress(progress)
+}
+
+// TurnOff ends the session; the daemon decides what the LED shows next.
+func (c *DaemonClient) TurnOff() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+		return
+	}
+	c.fallback.TurnOff()
+}
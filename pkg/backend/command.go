@@ -0,0 +1,137 @@
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// commandTimeout bounds how long a led script or lamp command may run. A
+// hung one would otherwise block every later state change behind it.
+const commandTimeout = 5 * time.Second
+
+// persistentFailures is how many failures in a row "sl status" reports.
+const persistentFailures = 3
+
+// runCommand runs a led script or lamp command and kills it, together with
+// whatever it started, when it takes longer than commandTimeout. The error
+// includes the first line the command wrote to stderr.
+func runCommand(cmd *exec.Cmd) error {
+	var stderr bytes.Buffer
+	cmd.Stdout = nil
+	cmd.Stderr = &stderr
+	// Don't wait for stragglers that still hold stderr
+	cmd.WaitDelay = time.Second
+	ownGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	var timedOut atomic.Bool
+	timer := time.AfterFunc(commandTimeout, func() {
+		timedOut.Store(true)
+		killGroup(cmd)
+	})
+	err := cmd.Wait()
+	timer.Stop()
+	if timedOut.Load() {
+		return fmt.Errorf("killed after %s", commandTimeout)
+	}
+	if msg, _, _ := strings.Cut(strings.TrimSpace(stderr.String()), "\n"); err != nil && msg != "" {
+		return fmt.Errorf("%w: %s", err, msg)
+	}
+	return err
+}
+
+// Health is how the commands of a backend fared.
+type Health struct {
+	Backend  string
+	Failures int       // in total
+	Streak   int       // failures in a row, 0 after a success
+	Error    string    // of the last failure
+	Since    time.Time // when the streak started
+}
+
+// Persistent reports whether the backend keeps failing.
+func (h Health) Persistent() bool {
+	return h.Streak >= persistentFailures
+}
+
+// health counts the failures of a backend.
+type health struct {
+	name  string
+	debug bool
+
+	mu sync.Mutex
+	h  Health
+}
+
+func newHealth(name string) *health {
+	return &health{name: name, debug: os.Getenv("DEBUG_SL") != "", h: Health{Backend: name}}
+}
+
+// record notes the outcome of one command.
+func (h *health) record(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err == nil {
+		if h.debug && h.h.Streak > 0 {
+			fmt.Fprintf(os.Stderr, "[DEBUG] %s works again after %d failure(s)\n", h.name, h.h.Streak)
+		}
+		h.h.Streak = 0
+		return
+	}
+	if h.h.Streak == 0 {
+		h.h.Since = time.Now()
+	}
+	h.h.Failures++
+	h.h.Streak++
+	h.h.Error = err.Error()
+	if h.debug {
+		fmt.Fprintf(os.Stderr, "[DEBUG] %s failed (%d in total): %v\n", h.name, h.h.Failures, err)
+	}
+}
+
+func (h *health) get() Health {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.h
+}
+
+// HealthOf returns the health of the backends behind i that run commands
+// and have failed at least once.
+func HealthOf(i state.Indicator) []Health {
+	var out []Health
+	seen := map[*health]bool{}
+	var walk func(state.Indicator)
+	walk = func(i state.Indicator) {
+		switch i := i.(type) {
+		case Indicators:
+			for _, each := range i {
+				walk(each)
+			}
+		case *Zones:
+			walk(i.Activity)
+			walk(i.Attention)
+		case *Failover:
+			walk(i.Primary)
+			walk(i.Fallback)
+		case interface{ health() *health }:
+			// Zones of a strip share its health
+			if h := i.health(); !seen[h] {
+				seen[h] = true
+				if got := h.get(); got.Failures > 0 {
+					out = append(out, got)
+				}
+			}
+		}
+	}
+	walk(i)
+	return out
+}
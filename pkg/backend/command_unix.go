@@ -0,0 +1,18 @@
+//go:build !windows
+
+package backend
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// ownGroup starts cmd in its own process group, so killGroup also gets
+// what a led script started, such as a hung serial write.
+func ownGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func killGroup(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,15 @@
+package backend
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// ownGroup is a no-op on Windows; taskkill finds the children.
+func ownGroup(cmd *exec.Cmd) {}
+
+func killGroup(cmd *exec.Cmd) {
+	if exec.Command("taskkill", "/F", "/T", "/PID", strconv.Itoa(cmd.Process.Pid)).Run() != nil {
+		cmd.Process.Kill()
+	}
+}
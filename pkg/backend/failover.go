@@ -0,0 +1,184 @@
+package backend
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// FailoverConfig shows the state on a fallback while the LED is gone, such
+// as a USB light that was unplugged, so the status isn't lost entirely.
+// Fallback names the backend: "title" (the terminal's window title),
+// "lamp" or "ble", which then stop showing the state while the LED works.
+type FailoverConfig struct {
+	Fallback string `json:"fallback"`
+	CheckMs  int    `json:"check_ms"` // health check interval, default 10s
+}
+
+const defaultFailoverCheck = 10 * time.Second
+
+// Failover drives Primary, and Fallback instead while Primary fails. A
+// failed command switches over at once; a health check every interval
+// notices an unplugged device between changes, and switches back once the
+// device is there again and takes the current state.
+type Failover struct {
+	Primary  state.Indicator
+	Fallback state.Indicator
+	debug    bool
+
+	done     chan struct{}
+	closing  sync.Once
+	mu       sync.Mutex
+	down     bool
+	on       bool
+	state    state.State
+	effect   state.Effect
+	progress float64
+}
+
+// checker is a backend with a health check that doesn't change what it
+// shows.
+type checker interface {
+	Check() error
+}
+
+// NewFailover starts the health checks of primary every interval.
+func NewFailover(primary, fallback state.Indicator, interval time.Duration) *Failover {
+	if interval <= 0 {
+		interval = defaultFailoverCheck
+	}
+	f := &Failover{Primary: primary, Fallback: fallback, debug: os.Getenv("DEBUG_SL") != "", progress: -1, done: make(chan struct{})}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				f.check()
+			case <-f.done:
+				return
+			}
+		}
+	}()
+	return f
+}
+
+// close ends the health checks.
+func (f *Failover) close() {
+	f.closing.Do(func() { close(f.done) })
+}
+
+func (f *Failover) SetState(st state.State) {
+	f.SetEffect(st, state.EffectSolid)
+}
+
+func (f *Failover) SetEffect(st state.State, effect state.Effect) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if st != f.state {
+		f.progress = -1
+	}
+	f.on, f.state, f.effect = true, st, effect
+	f.current().SetEffect(st, effect)
+	f.noticeFailure()
+}
+
+func (f *Failover) SetProgress(progress float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.progress = progress
+	f.current().SetProgress(progress)
+	f.noticeFailure()
+}
+
+func (f *Failover) TurnOff() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.on = false
+	f.current().TurnOff()
+	f.noticeFailure()
+}
+
+// current returns where the state is shown. Must be called with f.mu held.
+func (f *Failover) current() state.Indicator {
+	if f.down {
+		return f.Fallback
+	}
+	return f.Primary
+}
+
+// failing reports whether the primary's last command failed.
+func (f *Failover) failing() bool {
+	for _, h := range HealthOf(f.Primary) {
+		if h.Streak > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// noticeFailure switches to the fallback when the primary just failed.
+// Must be called with f.mu held.
+func (f *Failover) noticeFailure() {
+	if !f.down && f.failing() {
+		f.switchTo(true, "it failed")
+	}
+}
+
+// check runs the health check.
+func (f *Failover) check() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var err error
+	if c, ok := f.Primary.(checker); ok {
+		err = c.Check()
+	}
+	switch {
+	case !f.down && err != nil:
+		f.switchTo(true, err.Error())
+	case f.down && err == nil:
+		// Back only if it takes the state
+		f.replay(f.Primary)
+		if !f.failing() {
+			f.switchTo(false, "it works again")
+		}
+	}
+}
+
+// switchTo moves the state to the fallback or back to the primary. Must be
+// called with f.mu held.
+func (f *Failover) switchTo(down bool, why string) {
+	if f.debug {
+		to := "the LED"
+		if down {
+			to = "the fallback"
+		}
+		fmt.Fprintf(os.Stderr, "[DEBUG] Failover to %s: %s\n", to, why)
+	}
+	f.down = down
+	if down {
+		f.replay(f.Fallback)
+	} else {
+		f.Fallback.TurnOff()
+	}
+}
+
+// replay shows the current state on i. Must be called with f.mu held.
+func (f *Failover) replay(i state.Indicator) {
+	if !f.on {
+		i.TurnOff()
+		return
+	}
+	i.SetEffect(f.state, f.effect)
+	if f.progress >= 0 {
+		i.SetProgress(f.progress)
+	}
+}
+
+// Snoozed reports whether the primary or the fallback was snoozed.
+func (f *Failover) Snoozed() bool {
+	return Indicators{f.Primary, f.Fallback}.Snoozed()
+}
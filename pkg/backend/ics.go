@@ -0,0 +1,362 @@
+package backend
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CalendarEvent is one occurrence of a calendar event.
+type CalendarEvent struct {
+	Summary    string
+	Start, End time.Time
+}
+
+// maxOccurrences bounds the expansion of a recurring event, so a daily
+// event from years ago can't take long.
+const maxOccurrences = 50000
+
+// icsEvent is a VEVENT as read from an iCalendar file.
+type icsEvent struct {
+	uid        string
+	summary    string
+	start, end time.Time
+	allDay     bool
+	rrule      map[string]string
+	exdates    []time.Time
+	recurrence time.Time // RECURRENCE-ID of a moved occurrence
+	free       bool      // TRANSP:TRANSPARENT or cancelled
+}
+
+// parseICS returns the occurrences of the events in an iCalendar file
+// that overlap [from, to). All-day events are left out unless allDay is
+// set, and so are events marked free or cancelled.
+func parseICS(data string, from, to time.Time, allDay bool) ([]CalendarEvent, error) {
+	events, err := readICS(data)
+	if err != nil {
+		return nil, err
+	}
+	// Occurrences moved by a RECURRENCE-ID copy replace the original one
+	moved := map[string]map[int64]bool{}
+	for _, ev := range events {
+		if !ev.recurrence.IsZero() {
+			if moved[ev.uid] == nil {
+				moved[ev.uid] = map[int64]bool{}
+			}
+			moved[ev.uid][ev.recurrence.Unix()] = true
+		}
+	}
+	var out []CalendarEvent
+	for _, ev := range events {
+		if ev.free || ev.allDay && !allDay {
+			continue
+		}
+		skip := moved[ev.uid]
+		if !ev.recurrence.IsZero() {
+			skip = nil
+		}
+		for _, ex := range ev.exdates {
+			if skip == nil {
+				skip = map[int64]bool{}
+			}
+			skip[ex.Unix()] = true
+		}
+		length := ev.end.Sub(ev.start)
+		ev.expand(to, func(start time.Time) {
+			end := start.Add(length)
+			if ev.allDay {
+				// Whole days, also across a DST change
+				end = start.AddDate(0, 0, int(length.Hours()+12)/24)
+			}
+			if !skip[start.Unix()] && end.After(from) && start.Before(to) {
+				out = append(out, CalendarEvent{Summary: ev.summary, Start: start, End: end})
+			}
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Start.Before(out[j].Start) })
+	return out, nil
+}
+
+// readICS reads the VEVENTs of an iCalendar file.
+func readICS(data string) ([]icsEvent, error) {
+	// Long lines are folded by a line break followed by a space or tab
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	data = strings.NewReplacer("\n ", "", "\n\t", "").Replace(data)
+
+	var events []icsEvent
+	var ev *icsEvent
+	depth := 0 // nesting inside the VEVENT, e.g. a VALARM
+	for _, line := range strings.Split(data, "\n") {
+		name, params, value, ok := icsProperty(line)
+		if !ok {
+			continue
+		}
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			ev, depth = &icsEvent{}, 0
+			continue
+		case ev == nil:
+			continue
+		case name == "BEGIN":
+			depth++
+			continue
+		case name == "END" && depth > 0:
+			depth--
+			continue
+		case name == "END" && value == "VEVENT":
+			if ev.start.IsZero() {
+				return nil, fmt.Errorf("event %q has no start", ev.summary)
+			}
+			if ev.end.IsZero() {
+				ev.end = ev.start
+				if ev.allDay {
+					ev.end = ev.start.AddDate(0, 0, 1)
+				}
+			}
+			events = append(events, *ev)
+			ev = nil
+			continue
+		case depth > 0:
+			continue
+		}
+
+		var err error
+		switch name {
+		case "UID":
+			ev.uid = value
+		case "SUMMARY":
+			ev.summary = icsText(value)
+		case "DTSTART":
+			ev.start, ev.allDay, err = icsTime(value, params)
+		case "DTEND":
+			ev.end, _, err = icsTime(value, params)
+		case "DURATION":
+			var d time.Duration
+			if d, err = icsDuration(value); err == nil && !ev.start.IsZero() {
+				ev.end = ev.start.Add(d)
+			}
+		case "RECURRENCE-ID":
+			ev.recurrence, _, err = icsTime(value, params)
+		case "RRULE":
+			ev.rrule = map[string]string{}
+			for _, part := range strings.Split(value, ";") {
+				if k, v, ok := strings.Cut(part, "="); ok {
+					ev.rrule[strings.ToUpper(k)] = v
+				}
+			}
+		case "EXDATE":
+			for _, v := range strings.Split(value, ",") {
+				t, _, err := icsTime(v, params)
+				if err != nil {
+					return nil, err
+				}
+				ev.exdates = append(ev.exdates, t)
+			}
+		case "TRANSP":
+			ev.free = ev.free || value == "TRANSPARENT"
+		case "STATUS":
+			ev.free = ev.free || value == "CANCELLED"
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return events, nil
+}
+
+// icsProperty splits a content line into its name, parameters and value.
+func icsProperty(line string) (name string, params map[string]string, value string, ok bool) {
+	line = strings.TrimRight(line, "\r")
+	// The value starts at the first colon outside a quoted parameter
+	quoted, colon := false, -1
+	for i, c := range line {
+		if c == '"' {
+			quoted = !quoted
+		} else if c == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return "", nil, "", false
+	}
+	parts := strings.Split(line[:colon], ";")
+	params = map[string]string{}
+	for _, p := range parts[1:] {
+		if k, v, ok := strings.Cut(p, "="); ok {
+			params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+		}
+	}
+	return strings.ToUpper(parts[0]), params, line[colon+1:], true
+}
+
+// icsText undoes the escaping of text values.
+func icsText(s string) string {
+	return strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(s)
+}
+
+// icsTime parses a DATE-TIME in UTC, in the zone given by TZID or in
+// local time, or a DATE, which reports allDay.
+func icsTime(value string, params map[string]string) (t time.Time, allDay bool, err error) {
+	loc := time.Local
+	if tzid := params["TZID"]; tzid != "" {
+		// Windows zone names and the like fall back to local time
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+	switch {
+	case params["VALUE"] == "DATE" || len(value) == 8:
+		t, err = time.ParseInLocation("20060102", value, time.Local)
+		return t, true, err
+	case strings.HasSuffix(value, "Z"):
+		t, err = time.Parse("20060102T150405Z", value)
+	default:
+		t, err = time.ParseInLocation("20060102T150405", value, loc)
+	}
+	return t, false, err
+}
+
+// icsDuration parses a DURATION such as PT1H30M or P1D.
+func icsDuration(value string) (time.Duration, error) {
+	s, neg := value, false
+	if s != "" && (s[0] == '-' || s[0] == '+') {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+	if !strings.HasPrefix(s, "P") {
+		return 0, fmt.Errorf("bad duration %q", value)
+	}
+	units := map[byte]time.Duration{'W': 7 * 24 * time.Hour, 'D': 24 * time.Hour, 'H': time.Hour, 'M': time.Minute, 'S': time.Second}
+	var d time.Duration
+	n := ""
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= '0' && c <= '9':
+			n += string(c)
+		case c == 'T':
+		case units[c] != 0 && n != "":
+			v, _ := strconv.Atoi(n)
+			d += time.Duration(v) * units[c]
+			n = ""
+		default:
+			return 0, fmt.Errorf("bad duration %q", value)
+		}
+	}
+	if neg {
+		d = -d
+	}
+	return d, nil
+}
+
+var icsWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// expand calls fn with the start of every occurrence that begins before
+// to, in order. It knows the common rules: DAILY, WEEKLY with BYDAY,
+// MONTHLY on a day of the month or the nth weekday, and YEARLY, each with
+// INTERVAL, COUNT and UNTIL. Other parts of a rule are ignored.
+func (ev *icsEvent) expand(to time.Time, fn func(time.Time)) {
+	if ev.rrule == nil {
+		fn(ev.start)
+		return
+	}
+	r := ev.rrule
+	interval, _ := strconv.Atoi(r["INTERVAL"])
+	interval = max(interval, 1)
+	count, _ := strconv.Atoi(r["COUNT"])
+	var until time.Time
+	if u := r["UNTIL"]; u != "" {
+		until, _, _ = icsTime(u, map[string]string{})
+		if len(u) == 8 {
+			until = until.AddDate(0, 0, 1).Add(-time.Second)
+		}
+	}
+
+	s := ev.start
+	// Occurrences keep the wall clock time of the first one across DST
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, s.Hour(), s.Minute(), s.Second(), 0, s.Location())
+	}
+	seen := 0
+	for period := 0; seen < maxOccurrences; period++ {
+		var starts []time.Time
+		switch r["FREQ"] {
+		case "DAILY":
+			starts = []time.Time{at(s.Year(), s.Month(), s.Day()+period*interval)}
+		case "WEEKLY":
+			// Weeks start on Monday
+			monday := s.Day() - (int(s.Weekday())+6)%7 + period*interval*7
+			days := []time.Weekday{s.Weekday()}
+			if by := r["BYDAY"]; by != "" {
+				days = nil
+				for _, d := range strings.Split(by, ",") {
+					if wd, ok := icsWeekdays[d]; ok {
+						days = append(days, wd)
+					}
+				}
+			}
+			for _, wd := range days {
+				starts = append(starts, at(s.Year(), s.Month(), monday+(int(wd)+6)%7))
+			}
+		case "MONTHLY":
+			first := at(s.Year(), s.Month()+time.Month(period*interval), 1)
+			if by := r["BYDAY"]; by != "" {
+				for _, d := range strings.Split(by, ",") {
+					if t, ok := nthWeekday(first, d); ok {
+						starts = append(starts, t)
+					}
+				}
+			} else if t := first.AddDate(0, 0, s.Day()-1); t.Month() == first.Month() {
+				starts = []time.Time{t}
+			}
+		case "YEARLY":
+			if t := at(s.Year()+period*interval, s.Month(), s.Day()); t.Day() == s.Day() {
+				starts = []time.Time{t}
+			}
+		default:
+			fn(ev.start)
+			return
+		}
+		sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+		for _, t := range starts {
+			if t.Before(s) {
+				continue
+			}
+			if !t.Before(to) || !until.IsZero() && t.After(until) || count > 0 && seen >= count {
+				return
+			}
+			seen++
+			fn(t)
+		}
+		if len(starts) == 0 && period > maxOccurrences {
+			return
+		}
+	}
+}
+
+// nthWeekday returns the day of first's month given by a BYDAY entry such
+// as 2TU (second Tuesday) or -1FR (last Friday).
+func nthWeekday(first time.Time, day string) (time.Time, bool) {
+	if len(day) < 3 {
+		return time.Time{}, false
+	}
+	wd, ok := icsWeekdays[day[len(day)-2:]]
+	n, err := strconv.Atoi(day[:len(day)-2])
+	if !ok || err != nil || n == 0 {
+		return time.Time{}, false
+	}
+	var t time.Time
+	if n > 0 {
+		t = first.AddDate(0, 0, (int(wd)-int(first.Weekday())+7)%7+(n-1)*7)
+	} else {
+		last := first.AddDate(0, 1, -1)
+		t = last.AddDate(0, 0, -((int(last.Weekday())-int(wd)+7)%7)+(n+1)*7)
+	}
+	return t, t.Month() == first.Month()
+}
@@ -0,0 +1,79 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/f0i/status-light/internal/dbus"
+)
+
+// desktopAway asks the desktop over D-Bus whether the user is away: the
+// screen is locked or blanked, or there was no input for after. source
+// picks the service; "auto" tries the screensaver and then logind.
+func desktopAway(source string, after time.Duration) (bool, error) {
+	switch source {
+	case "screensaver":
+		return screensaverAway(after)
+	case "logind":
+		return logindAway()
+	case "auto":
+		away, err := screensaverAway(after)
+		if err == nil && away {
+			return true, nil
+		}
+		away2, err2 := logindAway()
+		if err2 != nil {
+			return false, errors.Join(err, err2)
+		}
+		return away2, nil
+	}
+	return false, fmt.Errorf("unknown idle source %q", source)
+}
+
+// screensaverAway checks the session's screensaver and, where available,
+// the input idle time from GNOME (Mutter) or KDE.
+func screensaverAway(after time.Duration) (bool, error) {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	v, err := conn.Call("org.freedesktop.ScreenSaver", "/org/freedesktop/ScreenSaver", "org.freedesktop.ScreenSaver", "GetActive")
+	if err != nil {
+		return false, err
+	}
+	if active, _ := v[0].(bool); active {
+		return true, nil
+	}
+	if v, err := conn.Call("org.gnome.Mutter.IdleMonitor", "/org/gnome/Mutter/IdleMonitor/Core", "org.gnome.Mutter.IdleMonitor", "GetIdletime"); err == nil {
+		ms, _ := v[0].(uint64)
+		return time.Duration(ms)*time.Millisecond >= after, nil
+	}
+	if v, err := conn.Call("org.freedesktop.ScreenSaver", "/org/freedesktop/ScreenSaver", "org.freedesktop.ScreenSaver", "GetSessionIdleTime"); err == nil {
+		s, _ := v[0].(uint32)
+		return time.Duration(s)*time.Second >= after, nil
+	}
+	return false, nil
+}
+
+// logindAway reads the idle and lock hints of the caller's login session,
+// which the desktop sets after its own idle timeout.
+func logindAway() (bool, error) {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	for _, hint := range []string{"IdleHint", "LockedHint"} {
+		v, err := conn.Call("org.freedesktop.login1", "/org/freedesktop/login1/session/auto", "org.freedesktop.DBus.Properties", "Get",
+			"org.freedesktop.login1.Session", hint)
+		if err != nil {
+			return false, err
+		}
+		if set, _ := v[0].(bool); set {
+			return true, nil
+		}
+	}
+	return false, nil
+}
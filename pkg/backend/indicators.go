@@ -0,0 +1,93 @@
+package backend
+
+import "github.com/f0i/status-light/pkg/state"
+
+// Indicators shows every state on several indicators at once, e.g. the LED
+// and a state file.
+type Indicators []state.Indicator
+
+func (is Indicators) SetState(st state.State) {
+	for _, i := range is {
+		i.SetState(st)
+	}
+}
+
+func (is Indicators) SetEffect(st state.State, effect state.Effect) {
+	for _, i := range is {
+		i.SetEffect(st, effect)
+	}
+}
+
+func (is Indicators) SetProgress(progress float64) {
+	for _, i := range is {
+		i.SetProgress(progress)
+	}
+}
+
+func (is Indicators) TurnOff() {
+	for _, i := range is {
+		i.TurnOff()
+	}
+}
+
+// Snoozed reports whether any of the indicators was snoozed, so wrapping
+// the daemon client doesn't hide it.
+func (is Indicators) Snoozed() bool {
+	for _, i := range is {
+		if s, ok := i.(interface{ Snoozed() bool }); ok && s.Snoozed() {
+			return true
+		}
+	}
+	return false
+}
+
+// Push returns the push settings the first indicator that has them sets
+// for the session, so wrapping the daemon client doesn't hide them.
+func (is Indicators) Push() *state.Push {
+	for _, i := range is {
+		if p, ok := i.(interface{ Push() *state.Push }); ok && p.Push() != nil {
+			return p.Push()
+		}
+	}
+	return nil
+}
+
+// Client returns the daemon client among the indicators, however deeply
+// nested, or nil, so wrapping the client doesn't hide it.
+func (is Indicators) Client() *DaemonClient {
+	for _, i := range is {
+		if c, ok := i.(interface{ Client() *DaemonClient }); ok && c.Client() != nil {
+			return c.Client()
+		}
+	}
+	return nil
+}
+
+// Close releases the goroutines and devices of i and the backends it
+// wraps, for backends that are replaced while sl runs or at exit. i must
+// not be used afterwards.
+func Close(i state.Indicator) {
+	switch i := i.(type) {
+	case Indicators:
+		for _, each := range i {
+			Close(each)
+		}
+	case *Zones:
+		Close(i.Activity)
+		Close(i.Attention)
+	case *Failover:
+		i.close()
+		Close(i.Primary)
+		Close(i.Fallback)
+	case interface{ close() }:
+		i.close()
+	}
+}
+
+// Shutdown turns i off for good when sl exits. The LED drops updates that
+// come in after it, so a ticker that hasn't stopped yet can't light it
+// again.
+func Shutdown(i state.Indicator) {
+	i.TurnOff()
+	Close(i)
+}
@@ -0,0 +1,116 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// Kitty colors the tab, and with Border the window border, of the kitty
+// window sl runs in, through kitty's remote control protocol on the
+// terminal itself. kitty.conf needs allow_remote_control yes. The colors
+// the config sets are restored when sl exits.
+type Kitty struct {
+	Border bool `json:"border"`
+}
+
+// kittyVersion is the protocol version sent with commands; kitty accepts
+// any version it knows.
+var kittyVersion = []int{0, 26, 0}
+
+// KittyTab recolors a kitty tab and window border per state.
+type KittyTab struct {
+	out    io.Writer
+	border bool
+
+	mu   sync.Mutex
+	last int // color shown, -1 for the ones from kitty.conf
+}
+
+// NewKittyTab returns the indicator for the kitty window on out, or an
+// error outside kitty.
+func NewKittyTab(k Kitty, out io.Writer) (*KittyTab, error) {
+	if os.Getenv("KITTY_WINDOW_ID") == "" {
+		return nil, fmt.Errorf("not running in kitty")
+	}
+	return &KittyTab{out: out, border: k.Border, last: -1}, nil
+}
+
+func (k *KittyTab) SetState(st state.State) {
+	k.SetEffect(st, state.EffectSolid)
+}
+
+func (k *KittyTab) SetEffect(st state.State, effect state.Effect) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if effect == state.EffectOff {
+		k.reset()
+		return
+	}
+	r, g, b := EffectColor(st, effect)
+	if effect == state.EffectDim {
+		r, g, b = r*DimBrightness/255, g*DimBrightness/255, b*DimBrightness/255
+	}
+	color := r<<16 | g<<8 | b
+	if color == k.last {
+		return
+	}
+	k.last = color
+	// Dark text on light colors
+	fg := 0xffffff
+	if (r*299+g*587+b*114)/1000 > 140 {
+		fg = 0x000000
+	}
+	k.send("set-tab-color", map[string]any{
+		"self":   true,
+		"colors": map[string]int{"active_bg": color, "inactive_bg": color, "active_fg": fg, "inactive_fg": fg},
+	})
+	if k.border {
+		k.send("set-colors", map[string]any{
+			"self":   true,
+			"colors": map[string]int{"active_border_color": color, "inactive_border_color": color},
+		})
+	}
+}
+
+// SetProgress is not shown; kitty tabs have no room for it.
+func (k *KittyTab) SetProgress(progress float64) {}
+
+func (k *KittyTab) TurnOff() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.reset()
+}
+
+// reset goes back to the colors from kitty.conf.
+func (k *KittyTab) reset() {
+	if k.last < 0 {
+		return
+	}
+	k.last = -1
+	k.send("set-tab-color", map[string]any{
+		"self":   true,
+		"colors": map[string]any{"active_bg": nil, "inactive_bg": nil, "active_fg": nil, "inactive_fg": nil},
+	})
+	if k.border {
+		k.send("set-colors", map[string]any{"self": true, "reset": true})
+	}
+}
+
+// send writes a remote control command that expects no response.
+func (k *KittyTab) send(cmd string, payload map[string]any) {
+	data, err := json.Marshal(map[string]any{
+		"cmd":         cmd,
+		"version":     kittyVersion,
+		"no_response": true,
+		"payload":     payload,
+	})
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(k.out, "\x1bP@kitty-cmd%s\x1b\\", data)
+}
@@ -0,0 +1,124 @@
+package backend
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/f0i/status-light/internal/shell"
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// Lamp configures a light that is controlled by shell commands, such as a
+// USB HID lamp (blink1-tool, busylight) or the MacBook keyboard backlight.
+//
+// On is run for every change with these placeholders replaced: {state},
+// {effect}, {hex} (rrggbb), {r}, {g}, {b}, {brightness} (0-255) and {level}
+// (0.0-1.0, brighter for states that need attention, for single-color
+// lights like a keyboard backlight). Off is run when nothing is shown.
+type Lamp struct {
+	On  string `json:"on"`
+	Off string `json:"off"`
+}
+
+// lampLevels maps states to a brightness for lights without color.
+var lampLevels = map[state.State]float64{
+	state.Idle:       0.1,
+	state.Success:    0.25,
+	state.Responding: 0.3,
+	state.Thinking:   0.4,
+	state.Syncing:    0.5,
+	state.Stalled:    0.6,
+	state.Error:      0.7,
+	state.Waiting:    1.0,
+}
+
+// CommandLamp drives a Lamp.
+type CommandLamp struct {
+	lamp  Lamp
+	debug bool
+
+	mu    sync.Mutex
+	last  string
+	runMu sync.Mutex
+	fails *health
+}
+
+func NewCommandLamp(lamp Lamp) *CommandLamp {
+	return &CommandLamp{lamp: lamp, debug: os.Getenv("DEBUG_SL") != "", fails: newHealth("lamp")}
+}
+
+// health returns how the lamp's commands fared.
+func (c *CommandLamp) health() *health {
+	return c.fails
+}
+
+func (c *CommandLamp) SetState(st state.State) {
+	c.SetEffect(st, state.EffectSolid)
+}
+
+func (c *CommandLamp) SetEffect(st state.State, effect state.Effect) {
+	if effect == state.EffectOff {
+		c.run(c.lamp.Off)
+		return
+	}
+	brightness := 255
+	if effect == state.EffectDim {
+		brightness = DimBrightness
+	}
+	r, g, b := EffectColor(st, effect)
+	level := lampLevels[st] * float64(brightness) / 255
+	name := string(effect)
+	if effect == state.EffectSolid {
+		name = "solid"
+	}
+	c.run(strings.NewReplacer(
+		"{state}", st.String(),
+		"{effect}", name,
+		"{hex}", fmt.Sprintf("%02x%02x%02x", r, g, b),
+		"{r}", strconv.Itoa(r),
+		"{g}", strconv.Itoa(g),
+		"{b}", strconv.Itoa(b),
+		"{brightness}", strconv.Itoa(brightness),
+		"{level}", strconv.FormatFloat(level, 'f', 2, 64),
+	).Replace(c.lamp.On))
+}
+
+// SetProgress is not shown; lamps have a single pixel.
+func (c *CommandLamp) SetProgress(progress float64) {}
+
+func (c *CommandLamp) TurnOff() {
+	c.run(c.lamp.Off)
+}
+
+// run executes command unless it is the one that ran last, since lamp tools
+// can be slow to start.
+func (c *CommandLamp) run(command string) {
+	c.mu.Lock()
+	if command == "" || command == c.last {
+		c.mu.Unlock()
+		return
+	}
+	c.last = command
+	c.mu.Unlock()
+
+	if c.debug {
+		fmt.Fprintf(os.Stderr, "[DEBUG] Lamp: %s\n", command)
+	}
+	if state.DryRunf("lamp: %s", command) {
+		return
+	}
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
+	if err := runCommand(shell.Command(command)); err != nil {
+		// Run it again next time; the lamp may not show it
+		c.mu.Lock()
+		c.last = ""
+		c.mu.Unlock()
+		c.fails.record(err)
+		return
+	}
+	c.fails.record(nil)
+}
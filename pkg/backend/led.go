@@ -0,0 +1,581 @@
+// Package backend contains the outputs a state can be shown on: the LED
+// (led script or serial device), command lamps, a state file, status bar
+// refresh signals and the shared daemon.
+package backend
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// DimBrightness is used by EffectDim and as the default for quiet hours.
+const DimBrightness = 32
+
+const (
+	blinkInterval = 250 * time.Millisecond
+	fadeStep      = 30 * time.Millisecond
+	// plugPoll is how often Device is checked for being unplugged or
+	// plugged back in
+	plugPoll = time.Second
+)
+
+// LEDController drives the LED through the led script or a serial device.
+// Updates only change what should be shown; a worker goroutine paints the
+// changes in order, so a late update can't paint over a newer one, and
+// runs the blink and fade effects.
+type LEDController struct {
+	ledScript string
+	debug     bool
+
+	// Pixels is the number of individually addressable LEDs. With more than
+	// one, Thinking progress is drawn as a bar.
+	Pixels int
+
+	// Device, when set, receives the lines the led script would write to
+	// its serial port (e.g. "a 255 255 000 255": r g b brightness) instead
+	// of the led script being run. This drives
+	// a microcontroller on a serial port such as COM3 or /dev/ttyACM0. When
+	// it is unplugged and plugged back in, it gets the current state again.
+	Device string
+	dev    *os.File
+	watch  sync.Once
+
+	// Fade, when set, blends from one color to the next over this long
+	// instead of switching at once. Every step is a separate led command,
+	// so it works best with Device.
+	Fade time.Duration
+
+	only   []int          // pixels of a zone, see Zone
+	parent *LEDController // owner of the device for zones
+	zones  []*LEDController
+
+	mu         sync.Mutex // guards the fields below
+	state      state.State
+	effect     state.Effect
+	shown      bool
+	brightness int
+	progress   float64 // -1 when unknown
+	redraw     bool    // paint even if nothing changed
+	done       bool    // shut down: updates are dropped
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	kick    chan struct{}      // something changed
+	flushes chan chan struct{} // closed once the changes so far are painted
+
+	// Only the worker touches these
+	drawn   ledFrame // last frame painted
+	painted ledColor // last solid color sent, the start of a fade
+	anim    *ledAnimation
+
+	runMu  sync.Mutex // serializes led script invocations
+	closed bool       // guarded by runMu
+	fails  *health
+}
+
+// ledFrame is what the LED should show, compared by the worker to what it
+// painted last.
+type ledFrame struct {
+	valid      bool
+	shown      bool
+	state      state.State
+	effect     state.Effect
+	brightness int
+	lit        int // pixels of the progress bar, -1 without one
+}
+
+// ledAnimation is a running blink or fade.
+type ledAnimation struct {
+	blink      bool
+	on         bool // blink
+	state      state.State
+	brightness int
+	from, to   ledColor // fade
+	step       int
+	steps      int
+}
+
+func NewLEDController() *LEDController {
+	exePath, _ := os.Executable()
+	dir := filepath.Dir(exePath)
+	l := &LEDController{
+		ledScript:  filepath.Join(dir, "led"),
+		debug:      os.Getenv("DEBUG_SL") != "",
+		brightness: 255,
+		progress:   -1,
+		fails:      newHealth("led"),
+	}
+	l.start()
+	return l
+}
+
+// start runs the worker until the controller is closed.
+func (l *LEDController) start() {
+	l.ctx, l.cancel = context.WithCancel(context.Background())
+	l.kick = make(chan struct{}, 1)
+	l.flushes = make(chan chan struct{})
+	go l.work()
+}
+
+// Check reports whether the device or led script is there, without
+// sending anything to it.
+func (l *LEDController) Check() error {
+	if l.parent != nil {
+		return l.parent.Check()
+	}
+	if l.Device != "" {
+		_, err := os.Stat(l.Device)
+		return err
+	}
+	_, err := os.Stat(l.ledScript)
+	return err
+}
+
+// health returns how the led script or device fared.
+func (l *LEDController) health() *health {
+	if l.parent != nil {
+		return l.parent.health()
+	}
+	return l.fails
+}
+
+func (l *LEDController) SetState(st state.State) {
+	l.SetEffect(st, state.EffectSolid)
+}
+
+// SetEffect shows state with the given effect, replacing any running one.
+func (l *LEDController) SetEffect(st state.State, effect state.Effect) {
+	l.update(func() {
+		if st != l.state {
+			l.progress = -1
+		}
+		l.state, l.effect, l.shown = st, effect, true
+	})
+}
+
+// SetProgress sets the Thinking progress (0-1, or -1 for unknown). It is only
+// rendered on LED strips; the bar is redrawn when the number of lit pixels
+// changes.
+func (l *LEDController) SetProgress(progress float64) {
+	l.update(func() { l.progress = progress })
+}
+
+// barPixels returns how many pixels the progress bar lights, or -1 when no
+// bar is shown. Must be called with l.mu held.
+func (l *LEDController) barPixels() int {
+	if l.Pixels < 2 || len(l.only) > 0 || l.progress < 0 || l.state != state.Thinking || l.effect != state.EffectSolid || l.brightness == 0 {
+		return -1
+	}
+	return int(l.progress*float64(l.Pixels) + 0.5)
+}
+
+// SetBrightness changes the brightness (0 = off, 255 = full) and re-renders
+// the current state with it.
+func (l *LEDController) SetBrightness(brightness int) {
+	l.update(func() { l.brightness = brightness })
+	l.mu.Lock()
+	zones := l.zones
+	l.mu.Unlock()
+	for _, z := range zones {
+		z.SetBrightness(brightness)
+	}
+}
+
+// Zone returns a controller for some pixels of l's strip, e.g. to show
+// activity and attention on separate LEDs. It shares l's led script or
+// device and follows its brightness.
+func (l *LEDController) Zone(pixels []int) *LEDController {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	z := &LEDController{
+		ledScript:  l.ledScript,
+		debug:      l.debug,
+		Pixels:     l.Pixels,
+		Fade:       l.Fade,
+		brightness: l.brightness,
+		progress:   -1,
+		only:       pixels,
+		parent:     l,
+	}
+	z.start()
+	l.zones = append(l.zones, z)
+	return z
+}
+
+// update changes what the LED should show and has the worker paint it.
+// After the controller is shut down it does nothing.
+func (l *LEDController) update(change func()) {
+	l.mu.Lock()
+	if l.done {
+		l.mu.Unlock()
+		return
+	}
+	change()
+	l.mu.Unlock()
+	select {
+	case l.kick <- struct{}{}:
+	default:
+		// The worker hasn't picked up the last change yet and will see
+		// this one with it
+	}
+}
+
+// flush waits until the worker has painted every change made so far.
+func (l *LEDController) flush() {
+	ack := make(chan struct{})
+	select {
+	case l.flushes <- ack:
+	case <-l.ctx.Done():
+		return
+	}
+	select {
+	case <-ack:
+	case <-l.ctx.Done():
+	}
+}
+
+// work paints the changes and steps the effects until the controller is
+// closed.
+func (l *LEDController) work() {
+	ticker := time.NewTicker(time.Hour)
+	ticker.Stop()
+	defer ticker.Stop()
+	for {
+		var ack chan struct{}
+		select {
+		case <-l.ctx.Done():
+			return
+		case <-ticker.C:
+			if !l.animate() {
+				ticker.Stop()
+			}
+			continue
+		case <-l.kick:
+		case ack = <-l.flushes:
+		}
+		if changed, every := l.render(); changed && every > 0 {
+			ticker.Reset(every)
+		} else if changed {
+			ticker.Stop()
+		}
+		if ack != nil {
+			close(ack)
+		}
+	}
+}
+
+// render paints the current frame if it differs from the last one painted.
+// It reports whether it did, and how often the effect it started needs a
+// step, if any.
+func (l *LEDController) render() (bool, time.Duration) {
+	l.mu.Lock()
+	f := ledFrame{valid: true, shown: l.shown, state: l.state, effect: l.effect, brightness: l.brightness, lit: -1}
+	if f.shown {
+		f.lit = l.barPixels()
+	}
+	redraw := l.redraw
+	l.redraw = false
+	l.mu.Unlock()
+	if f == l.drawn && !redraw {
+		return false, 0
+	}
+	l.drawn = f
+	l.anim = nil
+	if redraw {
+		l.painted = ledColor{}
+	}
+
+	switch {
+	case !f.shown:
+		if l.debug {
+			fmt.Fprintf(os.Stderr, "[DEBUG] LED: turning off\n")
+		}
+		l.painted = ledColor{}
+		l.runMu.Lock()
+		l.paint("o")
+		l.runMu.Unlock()
+		return true, 0
+	case f.lit >= 0:
+		l.painted = ledColor{}
+		l.showBar(f.state, f.brightness, f.lit)
+		return true, 0
+	case f.effect == state.EffectBlink && f.brightness > 0:
+		l.painted = ledColor{}
+		l.anim = &ledAnimation{blink: true, state: f.state, brightness: f.brightness}
+		l.animate()
+		return true, blinkInterval
+	}
+	brightness := f.brightness
+	switch f.effect {
+	case state.EffectOff:
+		brightness = 0
+	case state.EffectDim:
+		brightness = min(brightness, DimBrightness)
+	}
+	r, g, b := EffectColor(f.state, f.effect)
+	from, to := l.painted, ledColor{r, g, b, brightness}
+	l.painted = to
+	if l.Fade > 0 && from != to {
+		if l.debug {
+			fmt.Fprintf(os.Stderr, "[DEBUG] LED fade: %v -> %v\n", from, to)
+		}
+		l.anim = &ledAnimation{from: from, to: to, steps: max(int(l.Fade/fadeStep), 1)}
+		if l.animate() {
+			return true, fadeStep
+		}
+		return true, 0
+	}
+	l.show(f.state, f.effect, brightness)
+	return true, 0
+}
+
+// ledColor is a color with its brightness, as sent to the led script.
+type ledColor struct {
+	r, g, b, brightness int
+}
+
+// animate paints the next step of the running effect and reports whether
+// more steps follow.
+func (l *LEDController) animate() bool {
+	a := l.anim
+	if a == nil {
+		return false
+	}
+	l.runMu.Lock()
+	defer l.runMu.Unlock()
+	if a.blink {
+		a.on = !a.on
+		if a.on {
+			l.paint(stateArgs(a.state, state.EffectBlink, a.brightness)...)
+		} else {
+			l.paint("o")
+		}
+		return true
+	}
+	a.step++
+	mix := func(x, y int) int { return x + (y-x)*a.step/a.steps }
+	c := ledColor{mix(a.from.r, a.to.r), mix(a.from.g, a.to.g), mix(a.from.b, a.to.b), mix(a.from.brightness, a.to.brightness)}
+	if c.brightness == 0 {
+		l.paint("o")
+	} else {
+		l.paint("a", "0", strconv.Itoa(c.r), strconv.Itoa(c.g), strconv.Itoa(c.b), strconv.Itoa(c.brightness))
+	}
+	if a.step >= a.steps {
+		l.anim = nil
+		return false
+	}
+	return true
+}
+
+// stateArgs returns the led script arguments for a state.
+func stateArgs(st state.State, effect state.Effect, brightness int) []string {
+	r, g, b := EffectColor(st, effect)
+	return []string{"a", "0", strconv.Itoa(r), strconv.Itoa(g), strconv.Itoa(b), strconv.Itoa(brightness)}
+}
+
+func (l *LEDController) show(st state.State, effect state.Effect, brightness int) {
+	args := []string{"o"}
+	if brightness > 0 {
+		args = stateArgs(st, effect, brightness)
+	}
+	if l.debug {
+		fmt.Fprintf(os.Stderr, "[DEBUG] LED State: %s -> ./led %v\n", st, args)
+	}
+	l.runMu.Lock()
+	l.paint(args...)
+	l.runMu.Unlock()
+}
+
+// showBar lights the first lit pixels in the state's color and clears the
+// rest.
+func (l *LEDController) showBar(st state.State, brightness, lit int) {
+	color := stateArgs(st, state.EffectSolid, brightness)[2:]
+	if l.debug {
+		fmt.Fprintf(os.Stderr, "[DEBUG] LED progress: %d/%d pixels\n", lit, l.Pixels)
+	}
+	l.runMu.Lock()
+	defer l.runMu.Unlock()
+	for i := 0; i < l.Pixels; i++ {
+		if i < lit {
+			l.run(append([]string{"c", strconv.Itoa(i)}, color...)...)
+		} else {
+			l.run("c", strconv.Itoa(i), "0", "0", "0", "0")
+		}
+	}
+}
+
+// paint sends an "a" or "o" command, or the same color to each pixel of a
+// zone so the rest of the strip is left alone. Must be called with
+// l.runMu held.
+func (l *LEDController) paint(args ...string) {
+	if len(l.only) == 0 {
+		l.run(args...)
+		return
+	}
+	color := []string{"0", "0", "0", "0"}
+	if args[0] == "a" {
+		color = args[2:]
+	}
+	for _, p := range l.only {
+		l.run(append([]string{"c", strconv.Itoa(p)}, color...)...)
+	}
+}
+
+// run invokes the led script. Must be called with l.runMu held.
+func (l *LEDController) run(args ...string) {
+	if l.parent != nil {
+		l.parent.runMu.Lock()
+		defer l.parent.runMu.Unlock()
+		l.parent.run(args...)
+		return
+	}
+	if l.Device != "" {
+		l.write(args)
+		return
+	}
+	if state.DryRunf("%s %s", l.ledScript, strings.Join(args, " ")) {
+		return
+	}
+	l.fails.record(runCommand(exec.Command(l.ledScript, args...)))
+}
+
+// write sends one command line to Device, reopening it after errors so a
+// replugged device recovers. Must be called with l.runMu held.
+func (l *LEDController) write(args []string) {
+	line := deviceLine(args)
+	if state.DryRunf("%s <- %q", l.Device, line) {
+		return
+	}
+	if l.closed {
+		return
+	}
+	l.watch.Do(func() { go l.watchDevice() })
+	if l.dev == nil {
+		f, err := os.OpenFile(l.Device, os.O_WRONLY, 0)
+		if err != nil {
+			l.fails.record(err)
+			return
+		}
+		l.dev = f
+	}
+	_, err := l.dev.WriteString(line)
+	if err != nil {
+		l.dev.Close()
+		l.dev = nil
+	}
+	l.fails.record(err)
+}
+
+// deviceLine formats a led command the way the led script writes it to
+// the serial port: "a" takes r g b brightness without the pixel, "c" the
+// pixel and the color, zero padded.
+func deviceLine(args []string) string {
+	n := make([]any, len(args)-1)
+	for i, a := range args[1:] {
+		n[i], _ = strconv.Atoi(a)
+	}
+	switch {
+	case args[0] == "a" && len(n) == 5:
+		return fmt.Sprintf("a %03d %03d %03d %03d\n", n[1:]...)
+	case args[0] == "c" && len(n) == 5:
+		return fmt.Sprintf("c %02d %03d %03d %03d %03d\n", n...)
+	}
+	return strings.Join(args, " ") + "\n"
+}
+
+// watchDevice polls Device: it closes it when the device node is gone, so
+// an unplugged light isn't written to, and when it can be opened again,
+// shows the current state on it. A serial port can come back under another
+// name (/dev/ttyACM1); /dev/serial/by-id/ names stay the same.
+func (l *LEDController) watchDevice() {
+	ticker := time.NewTicker(plugPoll)
+	defer ticker.Stop()
+	for range ticker.C {
+		l.runMu.Lock()
+		if l.closed {
+			l.runMu.Unlock()
+			return
+		}
+		if l.dev != nil {
+			// Windows has no device nodes to look at; writes fail instead
+			if _, err := os.Stat(l.Device); err != nil && runtime.GOOS != "windows" {
+				if l.debug {
+					fmt.Fprintf(os.Stderr, "[DEBUG] LED device %s unplugged\n", l.Device)
+				}
+				l.dev.Close()
+				l.dev = nil
+				l.fails.record(err)
+			}
+			l.runMu.Unlock()
+			continue
+		}
+		f, err := os.OpenFile(l.Device, os.O_WRONLY, 0)
+		if err != nil {
+			l.runMu.Unlock()
+			continue
+		}
+		if l.debug {
+			fmt.Fprintf(os.Stderr, "[DEBUG] LED device %s plugged in\n", l.Device)
+		}
+		l.dev = f
+		l.runMu.Unlock()
+		l.replay()
+	}
+}
+
+// replay sends the current state again, e.g. to a device that was just
+// plugged in and doesn't know it.
+func (l *LEDController) replay() {
+	l.update(func() { l.redraw = true })
+	l.mu.Lock()
+	zones := l.zones
+	l.mu.Unlock()
+	for _, z := range zones {
+		z.replay()
+	}
+}
+
+// close turns the LED off for good and closes the device, for a controller
+// that is replaced or at exit. Updates that come in later, e.g. from a
+// ticker that hasn't stopped yet, are dropped. Zones close the whole strip.
+func (l *LEDController) close() {
+	if l.parent != nil {
+		l.parent.close()
+		return
+	}
+	for _, c := range append(l.zones, l) {
+		c.shutdown()
+	}
+	l.runMu.Lock()
+	defer l.runMu.Unlock()
+	l.closed = true
+	if l.dev != nil {
+		l.dev.Close()
+		l.dev = nil
+	}
+}
+
+// shutdown has the worker paint the LED off, drops later updates and
+// cancels the worker.
+func (l *LEDController) shutdown() {
+	l.update(func() { l.shown, l.done = false, true })
+	l.flush()
+	l.cancel()
+}
+
+// TurnOff turns the LED off and returns once it is, so it isn't left on
+// when sl exits right after.
+func (l *LEDController) TurnOff() {
+	l.update(func() { l.shown = false })
+	l.flush()
+}
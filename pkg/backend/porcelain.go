@@ -0,0 +1,92 @@
+package backend
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// Porcelain writes every change as a line for other programs to read,
+// such as an editor extension or a wrapper script. The format is stable:
+// a kind, a value and the unix time in seconds, separated by tabs.
+//
+//	state	waiting	1699999999
+//	effect	blink	1699999999
+//	progress	0.40	1699999999
+//	off	-	1699999999
+//
+// An effect line follows a state line only when the effect isn't solid
+// or changes back to it. A state line also ends the progress, and -1 ends
+// it otherwise. Lines of other kinds may be added later and should be
+// skipped.
+type Porcelain struct {
+	mu       sync.Mutex
+	w        io.Writer
+	on       bool
+	state    state.State
+	effect   state.Effect
+	progress float64
+}
+
+// NewPorcelain writes to w. Write errors are ignored, so a reader that
+// goes away doesn't disturb the session.
+func NewPorcelain(w io.Writer) *Porcelain {
+	return &Porcelain{w: w, progress: -1}
+}
+
+func (p *Porcelain) SetState(st state.State) {
+	p.SetEffect(st, state.EffectSolid)
+}
+
+func (p *Porcelain) SetEffect(st state.State, effect state.Effect) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.on || st != p.state {
+		p.line("state", st.String())
+		if effect != state.EffectSolid {
+			p.line("effect", string(effect))
+		} else if p.on && p.effect != state.EffectSolid {
+			p.line("effect", "solid")
+		}
+		p.progress = -1
+	} else if effect != p.effect {
+		name := string(effect)
+		if effect == state.EffectSolid {
+			name = "solid"
+		}
+		p.line("effect", name)
+	}
+	p.on, p.state, p.effect = true, st, effect
+}
+
+func (p *Porcelain) SetProgress(progress float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if progress == p.progress {
+		return
+	}
+	p.progress = progress
+	value := "-1"
+	if progress >= 0 {
+		value = strconv.FormatFloat(progress, 'f', 2, 64)
+	}
+	p.line("progress", value)
+}
+
+func (p *Porcelain) TurnOff() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.on {
+		p.line("off", "-")
+	}
+	p.on = false
+}
+
+// line writes one line. Must be called with p.mu held.
+func (p *Porcelain) line(kind, value string) {
+	fmt.Fprintf(p.w, "%s\t%s\t%d\n", kind, value, time.Now().Unix())
+}
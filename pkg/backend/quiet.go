@@ -0,0 +1,179 @@
+package backend
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/f0i/status-light/internal/shell"
+)
+
+// QuietHours dims or switches off the LED during a daily time window, e.g.
+// 22:00-07:00. Notifications are suppressed during the window.
+type QuietHours struct {
+	Start      string `json:"start"`
+	End        string `json:"end"`
+	Mode       string `json:"mode"`       // "dim" (default) or "off"
+	Brightness int    `json:"brightness"` // used by "dim", default 32
+}
+
+// Presence runs AwayCommand periodically; exit status 0 means the user is
+// away (screensaver active, long input idle time) and the LED is dimmed like
+// during quiet hours. On Linux, Idle asks the desktop instead (see
+// desktopAway). With Escalate, notifications go out while the user is
+// away instead of being held back, without waiting for the escalation
+// thresholds.
+type Presence struct {
+	AwayCommand string `json:"away_command"`
+	Idle        string `json:"idle"`          // "screensaver", "logind" or "auto"
+	AwayAfterMs int    `json:"away_after_ms"` // input idle time, default 5 minutes
+	Escalate    bool   `json:"escalate"`
+	IntervalMs  int    `json:"interval_ms"`
+	Mode        string `json:"mode"`
+	Brightness  int    `json:"brightness"`
+}
+
+// Quiet applies quiet hours and presence to an LED controller.
+type Quiet struct {
+	hours    *QuietHours
+	presence *Presence
+	led      *LEDController
+	debug    bool
+
+	mu    sync.Mutex
+	quiet bool
+	away  bool
+	done  chan struct{}
+}
+
+// StartQuiet starts watching the schedule and presence; either may be nil.
+// It returns nil when neither is configured; a nil *Quiet never suppresses
+// anything.
+func StartQuiet(hours *QuietHours, presence *Presence, led *LEDController) *Quiet {
+	if hours == nil && (presence == nil || presence.AwayCommand == "" && presence.Idle == "") {
+		return nil
+	}
+	q := &Quiet{
+		hours:    hours,
+		presence: presence,
+		led:      led,
+		debug:    os.Getenv("DEBUG_SL") != "",
+		done:     make(chan struct{}),
+	}
+	q.check(time.Now())
+	go q.loop()
+	return q
+}
+
+func (q *Quiet) loop() {
+	interval := 30 * time.Second
+	if q.presence != nil && q.presence.IntervalMs > 0 {
+		interval = min(interval, time.Duration(q.presence.IntervalMs)*time.Millisecond)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			q.check(now)
+		case <-q.done:
+			return
+		}
+	}
+}
+
+// Stop ends the checks, for settings that are replaced.
+func (q *Quiet) Stop() {
+	if q != nil {
+		close(q.done)
+	}
+}
+
+func (q *Quiet) check(now time.Time) {
+	quiet := q.hours != nil && inWindow(now, q.hours.Start, q.hours.End)
+	away := false
+	if p := q.presence; p != nil {
+		if p.AwayCommand != "" {
+			away = shell.Command(p.AwayCommand).Run() == nil
+		}
+		if p.Idle != "" && !away {
+			after := 5 * time.Minute
+			if p.AwayAfterMs > 0 {
+				after = time.Duration(p.AwayAfterMs) * time.Millisecond
+			}
+			var err error
+			if away, err = desktopAway(p.Idle, after); err != nil && q.debug {
+				fmt.Fprintf(os.Stderr, "[DEBUG] Idle state: %v\n", err)
+			}
+		}
+	}
+
+	q.mu.Lock()
+	changed := quiet != q.quiet || away != q.away
+	q.quiet, q.away = quiet, away
+	q.mu.Unlock()
+	if !changed {
+		return
+	}
+
+	// Quiet hours win over presence when both apply
+	brightness := 255
+	switch {
+	case quiet:
+		brightness = level(q.hours.Mode, q.hours.Brightness)
+	case away:
+		brightness = level(q.presence.Mode, q.presence.Brightness)
+	}
+	if q.debug {
+		fmt.Fprintf(os.Stderr, "[DEBUG] Quiet=%v away=%v: brightness %d\n", quiet, away, brightness)
+	}
+	q.led.SetBrightness(brightness)
+}
+
+// Suppressed reports whether notifications should be held back.
+func (q *Quiet) Suppressed() bool {
+	if q == nil {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.quiet || q.away && !q.presence.Escalate
+}
+
+// Escalating reports whether the user is away and wants escalations then.
+func (q *Quiet) Escalating() bool {
+	if q == nil {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return !q.quiet && q.away && q.presence.Escalate
+}
+
+func level(mode string, brightness int) int {
+	if mode == "off" {
+		return 0
+	}
+	if brightness <= 0 {
+		return DimBrightness
+	}
+	return min(brightness, 255)
+}
+
+// inWindow reports whether now's wall clock time lies in [start, end). The
+// window may wrap around midnight.
+func inWindow(now time.Time, start, end string) bool {
+	s, err1 := time.Parse("15:04", start)
+	e, err2 := time.Parse("15:04", end)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	m := now.Hour()*60 + now.Minute()
+	sm := s.Hour()*60 + s.Minute()
+	em := e.Hour()*60 + e.Minute()
+	if sm <= em {
+		return m >= sm && m < em
+	}
+	return m >= sm || m < em
+}
@@ -0,0 +1,127 @@
+package backend
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/f0i/status-light/pkg/state"
+	"golang.org/x/term"
+)
+
+// SimOptions configures the simulated LED. Corner draws it as a block in
+// the top right corner of the terminal; Log appends every change to a file.
+type SimOptions struct {
+	Corner bool   `json:"corner"`
+	Log    string `json:"log"`
+}
+
+// Sim stands in for LED hardware during development. It draws the LED as a
+// colored block in the top right corner of the terminal and/or appends
+// every change to a log file, so tests can assert on what would have been
+// shown. Log lines look like the state file ("thinking #ffff00 solid"),
+// plus "progress 0.40" and "off".
+type Sim struct {
+	term *os.File
+	log  *os.File
+
+	mu    sync.Mutex
+	line  string
+	color string // SGR background of the block, empty when dark
+	blink bool
+}
+
+// NewSim returns a simulated LED that draws on tty if opts.Corner is set.
+func NewSim(opts SimOptions, tty *os.File) (*Sim, error) {
+	s := &Sim{}
+	if opts.Log != "" {
+		f, err := os.OpenFile(opts.Log, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		s.log = f
+	}
+	if opts.Corner && tty != nil && term.IsTerminal(int(tty.Fd())) {
+		s.term = tty
+		go s.redraw()
+	}
+	return s, nil
+}
+
+func (s *Sim) SetState(st state.State) {
+	s.SetEffect(st, state.EffectSolid)
+}
+
+func (s *Sim) SetEffect(st state.State, effect state.Effect) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logLine(stateLine(st, effect))
+	r, g, b := EffectColor(st, effect)
+	if effect == state.EffectDim {
+		r, g, b = r*DimBrightness/255, g*DimBrightness/255, b*DimBrightness/255
+	}
+	s.color = fmt.Sprintf("48;2;%d;%d;%d", r, g, b)
+	if effect == state.EffectOff {
+		s.color = ""
+	}
+	s.blink = effect == state.EffectBlink
+	s.draw(true)
+}
+
+func (s *Sim) SetProgress(progress float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logLine(fmt.Sprintf("progress %.2f", progress))
+}
+
+func (s *Sim) TurnOff() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logLine("off")
+	s.color = ""
+	s.draw(true)
+}
+
+// logLine appends line unless it repeats the previous one. Must be called
+// with s.mu held.
+func (s *Sim) logLine(line string) {
+	if s.log == nil || line == s.line {
+		return
+	}
+	s.line = line
+	fmt.Fprintln(s.log, line)
+}
+
+// draw paints the block, or erases it when on is false or the LED is dark.
+// The cursor is saved and restored so the wrapped program doesn't notice.
+// Must be called with s.mu held.
+func (s *Sim) draw(on bool) {
+	if s.term == nil {
+		return
+	}
+	w, _, err := term.GetSize(int(s.term.Fd()))
+	if err != nil || w < 4 {
+		return
+	}
+	sgr := "0"
+	if on && s.color != "" {
+		sgr = s.color
+	}
+	fmt.Fprintf(s.term, "\x1b7\x1b[1;%dH\x1b[%sm   \x1b[0m\x1b8", w-2, sgr)
+}
+
+// redraw repaints the block periodically since the wrapped program may
+// clear the screen, and toggles it for the blink effect. A dark block is
+// left alone so the corner belongs to the program again.
+func (s *Sim) redraw() {
+	on := true
+	for range time.Tick(500 * time.Millisecond) {
+		s.mu.Lock()
+		on = !on || !s.blink
+		if s.color != "" {
+			s.draw(on)
+		}
+		s.mu.Unlock()
+	}
+}
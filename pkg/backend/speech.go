@@ -0,0 +1,168 @@
+package backend
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// Speech announces state changes with a text-to-speech command, for users
+// who can't see a light. Phrases maps state names to what is said, with
+// {tool} replaced by the tool's name; states without a phrase, and those
+// given an empty one, are not announced. Command is the program and its
+// arguments, with {text} replaced by the phrase, which is also in
+// SL_TEXT; by default espeak-ng, say on macOS or the speech synthesizer
+// of PowerShell on Windows. An announcement that comes less than MinGapMs
+// after the last one waits for the gap to pass, and is dropped if the
+// state has changed again by then.
+type Speech struct {
+	Command  []string          `json:"command"`
+	Phrases  map[string]string `json:"phrases"`
+	MinGapMs int               `json:"min_gap_ms"` // default 10s
+}
+
+// speechPhrases are said for the states that need the user, or tell them
+// they can come back.
+var speechPhrases = map[string]string{
+	"waiting": "{tool} is waiting for input",
+	"error":   "{tool} hit an error",
+	"stalled": "{tool} seems stuck",
+	"success": "{tool} is done",
+}
+
+const defaultSpeechGap = 10 * time.Second
+
+// SpeechCommand returns the command s runs.
+func SpeechCommand(s Speech) []string {
+	if len(s.Command) > 0 {
+		return s.Command
+	}
+	switch runtime.GOOS {
+	case "darwin":
+		return []string{"say", "{text}"}
+	case "windows":
+		return []string{"powershell", "-NoProfile", "-Command", "Add-Type -AssemblyName System.Speech; (New-Object System.Speech.Synthesis.SpeechSynthesizer).Speak($env:SL_TEXT)"}
+	}
+	return []string{"espeak-ng", "{text}"}
+}
+
+// Speaker drives a Speech for one session.
+type Speaker struct {
+	command []string
+	phrases map[string]string
+	gap     time.Duration
+	tool    string
+	debug   bool
+
+	mu      sync.Mutex
+	state   state.State
+	on      bool
+	spoken  time.Time // when the last announcement started
+	pending *time.Timer
+	runMu   sync.Mutex // one announcement at a time
+}
+
+// NewSpeaker announces the states of tool.
+func NewSpeaker(s Speech, tool string) *Speaker {
+	phrases := speechPhrases
+	if s.Phrases != nil {
+		phrases = s.Phrases
+	}
+	gap := defaultSpeechGap
+	if s.MinGapMs > 0 {
+		gap = time.Duration(s.MinGapMs) * time.Millisecond
+	}
+	// "claude" reads better as "Claude"
+	if r, size := utf8.DecodeRuneInString(tool); size > 0 {
+		tool = string(unicode.ToUpper(r)) + tool[size:]
+	}
+	return &Speaker{
+		command: SpeechCommand(s),
+		phrases: phrases,
+		gap:     gap,
+		tool:    tool,
+		debug:   os.Getenv("DEBUG_SL") != "",
+	}
+}
+
+func (s *Speaker) SetState(st state.State) {
+	s.SetEffect(st, state.EffectSolid)
+}
+
+// SetEffect announces a new state. The effect doesn't matter, except
+// that nothing is said while the light is off, e.g. during quiet hours.
+func (s *Speaker) SetEffect(st state.State, effect state.Effect) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if effect == state.EffectOff || s.on && st == s.state {
+		return
+	}
+	// A prompt the user stopped answering is the same prompt
+	again := s.on && s.state == state.Responding && st == state.Waiting
+	s.on, s.state = true, st
+	if s.pending != nil {
+		s.pending.Stop()
+		s.pending = nil
+	}
+	text := s.phrases[st.String()]
+	if text == "" || again {
+		return
+	}
+	text = strings.ReplaceAll(text, "{tool}", s.tool)
+	if wait := s.gap - time.Since(s.spoken); wait > 0 {
+		s.pending = time.AfterFunc(wait, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.on && s.state == st {
+				s.say(text)
+			}
+		})
+		return
+	}
+	s.say(text)
+}
+
+// SetProgress is not announced.
+func (s *Speaker) SetProgress(progress float64) {}
+
+func (s *Speaker) TurnOff() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.on = false
+	if s.pending != nil {
+		s.pending.Stop()
+		s.pending = nil
+	}
+}
+
+// say runs the command in the background. Must be called with s.mu held.
+func (s *Speaker) say(text string) {
+	s.spoken = time.Now()
+	args := make([]string, len(s.command))
+	for i, a := range s.command {
+		args[i] = strings.ReplaceAll(a, "{text}", text)
+	}
+	if s.debug {
+		fmt.Fprintf(os.Stderr, "[DEBUG] Speech: %q\n", text)
+	}
+	if state.DryRunf("speech: %q", args) {
+		return
+	}
+	go func() {
+		s.runMu.Lock()
+		defer s.runMu.Unlock()
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Env = append(os.Environ(), "SL_TEXT="+text)
+		if err := cmd.Run(); err != nil && s.debug {
+			fmt.Fprintf(os.Stderr, "[DEBUG] Speech failed: %v\n", err)
+		}
+	}()
+}
@@ -0,0 +1,117 @@
+package backend
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// StateFile writes the current state to a small file for shell prompts and
+// status bars that poll it. The file holds one line:
+//
+//	<state> <#rrggbb> <effect>
+//
+// e.g. "waiting #640000 solid", or "off #000000 off" when nothing is shown.
+// It is replaced atomically so readers never see a partial line.
+type StateFile struct {
+	path  string
+	debug bool
+
+	mu     sync.Mutex
+	state  state.State
+	effect state.Effect
+}
+
+// NewStateFile writes to path; a leading ~ and environment variables are
+// expanded.
+func NewStateFile(path string) *StateFile {
+	return &StateFile{path: ExpandPath(path), debug: os.Getenv("DEBUG_SL") != ""}
+}
+
+// ExpandPath expands a leading ~ and environment variables in path.
+func ExpandPath(path string) string {
+	path = os.ExpandEnv(path)
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, rest)
+		}
+	}
+	return path
+}
+
+// Path returns the expanded path of the file.
+func (f *StateFile) Path() string {
+	return f.path
+}
+
+func (f *StateFile) SetState(st state.State) {
+	f.SetEffect(st, state.EffectSolid)
+}
+
+func (f *StateFile) SetEffect(st state.State, effect state.Effect) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.state, f.effect = st, effect
+	f.write(stateLine(st, effect))
+}
+
+// stateLine describes a state as "<state> <#rrggbb> <effect>".
+func stateLine(st state.State, effect state.Effect) string {
+	if effect == state.EffectOff {
+		return "off #000000 off"
+	}
+	r, g, b := EffectColor(st, effect)
+	name := string(effect)
+	if effect == state.EffectSolid {
+		name = "solid"
+	}
+	return fmt.Sprintf("%s #%02x%02x%02x %s", st, r, g, b, name)
+}
+
+// SetProgress is not written; the file only carries the state.
+func (f *StateFile) SetProgress(progress float64) {}
+
+func (f *StateFile) TurnOff() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.write("off #000000 off")
+}
+
+// write replaces the file with line. Must be called with f.mu held.
+func (f *StateFile) write(line string) {
+	if state.DryRunf("%s <- %q", f.path, line+"\n") {
+		return
+	}
+	dir := filepath.Dir(f.path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		f.fail(err)
+		return
+	}
+	tmp, err := os.CreateTemp(dir, ".state-*")
+	if err != nil {
+		f.fail(err)
+		return
+	}
+	_, err = tmp.WriteString(line + "\n")
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		os.Chmod(tmp.Name(), 0644)
+		err = os.Rename(tmp.Name(), f.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		f.fail(err)
+	}
+}
+
+func (f *StateFile) fail(err error) {
+	if f.debug {
+		fmt.Fprintf(os.Stderr, "[DEBUG] Failed to write state file %s: %v\n", f.path, err)
+	}
+}
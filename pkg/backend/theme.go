@@ -0,0 +1,126 @@
+package backend
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/f0i/status-light/pkg/state"
+)
+
+type rgb struct{ r, g, b int }
+
+// theme is a set of state colors, plus the green of EffectSafe and the
+// warning color of EffectBudget.
+type theme struct {
+	states map[state.State]rgb
+	safe   rgb
+	budget rgb
+}
+
+var themes = map[string]theme{
+	// Match Python version exactly
+	"default": {
+		states: map[state.State]rgb{
+			state.Idle:       {0, 0, 255},     // blue
+			state.Thinking:   {255, 255, 0},   // yellow
+			state.Waiting:    {100, 0, 0},     // red
+			state.Error:      {255, 0, 255},   // magenta
+			state.Stalled:    {255, 80, 0},    // orange
+			state.Syncing:    {0, 255, 255},   // cyan
+			state.Success:    {0, 255, 0},     // green
+			state.Responding: {255, 105, 180}, // pink
+		},
+		safe:   rgb{0, 255, 0},
+		budget: rgb{128, 0, 255}, // purple
+	},
+	// Okabe-Ito colors, which stay apart without telling red from green
+	"colorblind-deuteranopia": {
+		states: map[state.State]rgb{
+			state.Idle:       {0, 114, 178},  // blue
+			state.Thinking:   {240, 228, 66}, // yellow
+			state.Waiting:    {213, 94, 0},   // vermillion
+			state.Error:      {204, 121, 167},
+			state.Stalled:    {230, 159, 0},   // orange
+			state.Syncing:    {0, 158, 115},   // bluish green
+			state.Success:    {86, 180, 233},  // sky blue
+			state.Responding: {150, 150, 150}, // grey
+		},
+		safe:   rgb{86, 180, 233},  // sky blue
+		budget: rgb{255, 255, 255}, // white
+	},
+	"high-contrast": {
+		states: map[state.State]rgb{
+			state.Idle:       {0, 0, 255},
+			state.Thinking:   {255, 255, 255},
+			state.Waiting:    {255, 0, 0},
+			state.Error:      {255, 0, 255},
+			state.Stalled:    {255, 128, 0},
+			state.Syncing:    {0, 255, 255},
+			state.Success:    {0, 255, 0},
+			state.Responding: {255, 128, 128},
+		},
+		safe:   rgb{0, 255, 0},
+		budget: rgb{128, 0, 255},
+	},
+	// White only; the brightness tells the states apart
+	"monochrome-brightness": {
+		states: map[state.State]rgb{
+			state.Idle:       {16, 16, 16},
+			state.Thinking:   {80, 80, 80},
+			state.Waiting:    {255, 255, 255},
+			state.Error:      {160, 160, 160},
+			state.Stalled:    {120, 120, 120},
+			state.Syncing:    {100, 100, 100},
+			state.Success:    {48, 48, 48},
+			state.Responding: {64, 64, 64},
+		},
+		safe:   rgb{40, 40, 40},
+		budget: rgb{200, 200, 200},
+	},
+}
+
+var current = themes["default"]
+
+// SetTheme selects the colors used by all backends. An empty name selects
+// the default theme.
+func SetTheme(name string) error {
+	if name == "" {
+		name = "default"
+	}
+	t, ok := themes[name]
+	if !ok {
+		return fmt.Errorf("unknown theme %q (available: %s)", name, strings.Join(ThemeNames(), ", "))
+	}
+	current = t
+	return nil
+}
+
+// ThemeNames lists the available themes.
+func ThemeNames() []string {
+	names := make([]string, 0, len(themes))
+	for name := range themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// StateColor returns the RGB color of a state in the current theme.
+func StateColor(st state.State) (r, g, b int) {
+	c := current.states[st]
+	return c.r, c.g, c.b
+}
+
+// EffectColor returns the color state is shown in with effect. EffectSafe
+// and EffectBudget replace the state's color with the theme's green and
+// warning color.
+func EffectColor(st state.State, effect state.Effect) (r, g, b int) {
+	switch effect {
+	case state.EffectSafe:
+		return current.safe.r, current.safe.g, current.safe.b
+	case state.EffectBudget:
+		return current.budget.r, current.budget.g, current.budget.b
+	}
+	return StateColor(st)
+}
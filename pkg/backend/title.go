@@ -0,0 +1,78 @@
+package backend
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// Title shows the state in the terminal's window title, e.g. "sl:
+// thinking 40%". It needs nothing but a terminal, which makes it the
+// fallback when the light is gone. The title from before is saved on the
+// terminal's title stack and restored when it is turned off.
+type Title struct {
+	out io.Writer
+
+	mu       sync.Mutex
+	pushed   bool
+	state    state.State
+	effect   state.Effect
+	progress float64
+	last     string
+}
+
+func NewTitle(out io.Writer) *Title {
+	return &Title{out: out, progress: -1}
+}
+
+func (t *Title) SetState(st state.State) {
+	t.SetEffect(st, state.EffectSolid)
+}
+
+func (t *Title) SetEffect(st state.State, effect state.Effect) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if st != t.state {
+		t.progress = -1
+	}
+	t.state, t.effect = st, effect
+	t.show()
+}
+
+func (t *Title) SetProgress(progress float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.progress = progress
+	t.show()
+}
+
+func (t *Title) TurnOff() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.pushed {
+		io.WriteString(t.out, "\x1b[23;0t")
+		t.pushed, t.last = false, ""
+	}
+}
+
+// show writes the title if it changed. Must be called with t.mu held.
+func (t *Title) show() {
+	title := "sl: " + t.state.String()
+	switch {
+	case t.effect == state.EffectOff:
+		title = "sl"
+	case t.state == state.Thinking && t.progress >= 0:
+		title += fmt.Sprintf(" %.0f%%", t.progress*100)
+	}
+	if title == t.last {
+		return
+	}
+	if !t.pushed {
+		io.WriteString(t.out, "\x1b[22;0t")
+		t.pushed = true
+	}
+	t.last = title
+	fmt.Fprintf(t.out, "\x1b]2;%s\a", title)
+}
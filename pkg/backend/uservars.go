@@ -0,0 +1,85 @@
+package backend
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// UserVars reports the state to the terminal sl runs in as user variables,
+// set with the OSC 1337 SetUserVar sequence that iTerm2 and WezTerm both
+// understand: sl_state ("thinking"), sl_color ("#ffff00"), sl_effect
+// ("solid"), sl_progress (percent, empty without) and sl_tool. iTerm2 shows
+// them in badges and tab titles as \(user.sl_state), WezTerm passes them
+// to its user-var-changed event. Inside tmux the sequence is passed
+// through to the outer terminal.
+type UserVars struct {
+	out  io.Writer
+	tool string
+	tmux bool
+
+	mu   sync.Mutex
+	sent map[string]string
+}
+
+// NewUserVars returns user variables for tool, written to out.
+func NewUserVars(out io.Writer, tool string) *UserVars {
+	return &UserVars{
+		out:  out,
+		tool: tool,
+		tmux: os.Getenv("TMUX") != "",
+		sent: map[string]string{},
+	}
+}
+
+func (u *UserVars) SetState(st state.State) {
+	u.SetEffect(st, state.EffectSolid)
+}
+
+func (u *UserVars) SetEffect(st state.State, effect state.Effect) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	fields := strings.Fields(stateLine(st, effect))
+	u.set("sl_tool", u.tool)
+	u.set("sl_state", fields[0])
+	u.set("sl_color", fields[1])
+	u.set("sl_effect", fields[2])
+}
+
+func (u *UserVars) SetProgress(progress float64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	value := ""
+	if progress >= 0 {
+		value = strconv.Itoa(int(progress * 100))
+	}
+	u.set("sl_progress", value)
+}
+
+// TurnOff clears the variables, so the tab looks as it did without sl.
+func (u *UserVars) TurnOff() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, name := range []string{"sl_tool", "sl_state", "sl_color", "sl_effect", "sl_progress"} {
+		u.set(name, "")
+	}
+}
+
+// set sends a variable that changed.
+func (u *UserVars) set(name, value string) {
+	if old, ok := u.sent[name]; ok && old == value || !ok && value == "" {
+		return
+	}
+	u.sent[name] = value
+	seq := fmt.Sprintf("\x1b]1337;SetUserVar=%s=%s\a", name, base64.StdEncoding.EncodeToString([]byte(value)))
+	if u.tmux {
+		seq = "\x1bPtmux;" + strings.ReplaceAll(seq, "\x1b", "\x1b\x1b") + "\x1b\\"
+	}
+	io.WriteString(u.out, seq)
+}
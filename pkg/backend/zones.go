@@ -0,0 +1,76 @@
+package backend
+
+import (
+	"sync"
+
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// Zone is where one channel of Zones is shown: some pixels of the LED
+// strip (all of them when empty) or a command lamp.
+type Zone struct {
+	Pixels []int `json:"pixels"`
+	Lamp   *Lamp `json:"lamp"`
+}
+
+// ZonesConfig assigns the activity and attention channels to zones.
+type ZonesConfig struct {
+	Activity  Zone `json:"activity"`
+	Attention Zone `json:"attention"`
+}
+
+// Zones splits the states over two indicators so a glance tells "busy"
+// from "needs me": Activity shows idle and thinking, Attention shows
+// waiting and error and is dark otherwise.
+type Zones struct {
+	Activity  state.Indicator
+	Attention state.Indicator
+
+	mu sync.Mutex
+}
+
+func NewZones(activity, attention state.Indicator) *Zones {
+	return &Zones{Activity: activity, Attention: attention}
+}
+
+func (z *Zones) SetState(st state.State) {
+	z.SetEffect(st, state.EffectSolid)
+}
+
+func (z *Zones) SetEffect(st state.State, effect state.Effect) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if st == state.Waiting || st == state.Error || st == state.Stalled {
+		// The tool isn't busy while it needs attention
+		calm := effect
+		if calm == state.EffectBlink || calm == state.EffectSafe {
+			calm = state.EffectSolid
+		}
+		z.Activity.SetEffect(state.Idle, calm)
+		z.Attention.SetEffect(st, effect)
+		return
+	}
+	z.Activity.SetEffect(st, effect)
+	z.Attention.SetEffect(st, state.EffectOff)
+}
+
+func (z *Zones) SetProgress(progress float64) {
+	z.Activity.SetProgress(progress)
+}
+
+func (z *Zones) TurnOff() {
+	z.Activity.TurnOff()
+	z.Attention.TurnOff()
+}
+
+// Check runs the health checks of the zones that have one.
+func (z *Zones) Check() error {
+	for _, i := range []state.Indicator{z.Activity, z.Attention} {
+		if c, ok := i.(checker); ok {
+			if err := c.Check(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
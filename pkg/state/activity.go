@@ -0,0 +1,94 @@
+package state
+
+import (
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// echoWindow is how soon after a keystroke output counts as its echo. The
+// terminal echoes at once and line editors redraw within a few ms.
+const echoWindow = 25 * time.Millisecond
+
+// echo reports whether output arriving now is likely the echo of what the
+// user typed rather than the tool's own.
+func (m *Monitor) echo(now time.Time) bool {
+	typed := m.lastInput.Load()
+	if typed == 0 {
+		return false
+	}
+	since := now.Sub(time.Unix(0, typed))
+	return since >= 0 && since < echoWindow
+}
+
+// visibleChars counts the characters in data that show up on the screen:
+// everything but whitespace, control characters and escape sequences.
+// It stops counting at max.
+func visibleChars(data []byte, max int) int {
+	n := 0
+	for i := 0; i < len(data) && n < max; {
+		b := data[i]
+		switch {
+		case b == 0x1b:
+			i = skipEscape(data, i)
+			continue
+		case b < 0x80:
+			if b > ' ' && b != 0x7f {
+				n++
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(data[i:])
+		if !unicode.IsSpace(r) && !unicode.IsControl(r) {
+			n++
+		}
+		i += size
+	}
+	return n
+}
+
+// StripEscapes returns data without escape sequences, e.g. for a readable
+// copy of the output. A sequence cut off at the end of data is dropped.
+func StripEscapes(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); {
+		if data[i] == 0x1b {
+			i = skipEscape(data, i)
+			continue
+		}
+		out = append(out, data[i])
+		i++
+	}
+	return out
+}
+
+// skipEscape returns the index after the escape sequence at data[i].
+func skipEscape(data []byte, i int) int {
+	i++
+	if i >= len(data) {
+		return i
+	}
+	switch data[i] {
+	case '[':
+		// CSI: parameters up to a final byte
+		for i++; i < len(data); i++ {
+			if data[i] >= 0x40 && data[i] <= 0x7e {
+				return i + 1
+			}
+		}
+	case ']', 'P', '_', '^':
+		// OSC and other strings, ended by BEL or ST
+		for i++; i < len(data); i++ {
+			if data[i] == 0x07 {
+				return i + 1
+			}
+			if data[i] == 0x1b && i+1 < len(data) && data[i+1] == '\\' {
+				return i + 2
+			}
+		}
+	default:
+		return i + 1
+	}
+	return i
+}
@@ -0,0 +1,67 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Bell turns the terminal bell, which many tools ring when they need
+// attention, into a prompt right away, without the pause waiting patterns
+// need. Action "waiting" (the default) shows Waiting until the user types
+// or the output changes the state, "notify" only shows a desktop
+// notification and "both" does both. Bells on the alternate screen, e.g.
+// an editor refusing a key, are ignored unless AltScreen is set.
+type Bell struct {
+	Action    string `json:"action"`
+	AltScreen bool   `json:"alt_screen"`
+}
+
+// bellNotifyGap keeps a tool ringing repeatedly from flooding the desktop
+// with notifications.
+const bellNotifyGap = 30 * time.Second
+
+// ringBell handles the bells in the output fed to the screen last.
+func (m *Monitor) ringBell(now time.Time) {
+	main, alt := m.Screen.Bells()
+	if m.bell == nil || !main && !(alt && m.bell.AltScreen) {
+		return
+	}
+	if m.debug {
+		fmt.Fprintf(os.Stderr, "[DEBUG] Bell (alternate screen: %v)\n", !main)
+	}
+	action := m.bell.Action
+	if action == "notify" || action == "both" {
+		if now.Sub(m.bellNotified) < bellNotifyGap {
+			if action == "notify" {
+				return
+			}
+			action = "waiting"
+		} else {
+			m.bellNotified = now
+		}
+	}
+	m.attention(now, action, "bell", "Status light", fmt.Sprintf("%s rang the bell", m.Label()))
+}
+
+// attention handles a prompt the tool signaled, with a bell or a
+// notification, as action says: Waiting until the user types or the
+// output changes the state, a desktop notification, or both.
+func (m *Monitor) attention(now time.Time, action, pattern, title, message string) {
+	if action != "notify" {
+		if m.State != Waiting {
+			m.observe(Event{Time: now, State: Waiting, Pattern: pattern, Stream: "screen"})
+		}
+		m.setState(Waiting, now, pattern)
+		m.attentionAt = now
+	}
+	if (action == "notify" || action == "both") && !m.Suppressed() {
+		go desktopNotify(title, message)
+	}
+}
+
+// attending reports whether a bell or notification still holds Waiting:
+// nothing has been typed since, and no output has changed the state.
+func (m *Monitor) attending() bool {
+	return !m.attentionAt.IsZero() && m.attentionAt.UnixNano() > m.lastInput.Load()
+}
@@ -0,0 +1,134 @@
+package state_test
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// recording replaces the generated session with raw terminal output, e.g.
+// from script(1): go test -bench . ./pkg/state -args -recording $PWD/claude.log
+var recording = flag.String("recording", "", "raw terminal output the benchmarks replay")
+
+// benchSession returns the output the benchmarks replay.
+func benchSession(b *testing.B) []byte {
+	b.Helper()
+	if *recording != "" {
+		data, err := os.ReadFile(*recording)
+		if err != nil {
+			b.Fatal(err)
+		}
+		return data
+	}
+	return generatedSession()
+}
+
+// benchConfig is the claude config, whose patterns are matched.
+func benchConfig(b *testing.B) state.Config {
+	b.Helper()
+	data, err := os.ReadFile(filepath.Join("..", "..", "configs", "claude.json"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	return config(b, string(data))
+}
+
+// benchChunks splits output into reads of the size the wrapper uses.
+func benchChunks(data []byte) [][]byte {
+	var chunks [][]byte
+	for len(data) > 0 {
+		n := min(len(data), 4096)
+		chunks = append(chunks, data[:n])
+		data = data[n:]
+	}
+	return chunks
+}
+
+// generatedSession is what an agent's terminal UI prints during a long
+// task: a spinner line redrawn in place, colored tool calls and their
+// output, an input box repainted below them and the odd full redraw.
+func generatedSession() []byte {
+	var b strings.Builder
+	spinner := []string{"✢", "✳", "✶", "✻", "✽"}
+	for i := 0; b.Len() < 1<<20; i++ {
+		fmt.Fprintf(&b, "\r\x1b[2K\x1b[38;5;174m%s\x1b[39m Thinking… \x1b[2m(%ds · ↑ %.1fk tokens · esc to interrupt)\x1b[22m", spinner[i%len(spinner)], i/10, float64(i)/7)
+		if i%25 == 0 {
+			fmt.Fprintf(&b, "\r\n\x1b[96m●\x1b[39m \x1b[1mBash\x1b[22m(go test ./pkg/state -run TestScreen%d)\r\n", i)
+			for j := 0; j < 8; j++ {
+				fmt.Fprintf(&b, "  ⎿  \x1b[32mok\x1b[39m  \tgithub.com/example/project/pkg/mod%d\t0.%03ds\r\n", j, i%1000)
+			}
+			b.WriteString("\x1b[38;5;244m╭" + strings.Repeat("─", 78) + "╮\r\n│ > " + strings.Repeat(" ", 75) + "│\r\n╰" + strings.Repeat("─", 78) + "╯\x1b[39m\x1b[3A\r")
+		}
+		if i%400 == 0 {
+			b.WriteString("\x1b[2J\x1b[H")
+		}
+	}
+	return []byte(b.String())
+}
+
+// nopIndicator discards the monitor's updates.
+type nopIndicator struct{}
+
+func (nopIndicator) SetState(state.State)                {}
+func (nopIndicator) SetEffect(state.State, state.Effect) {}
+func (nopIndicator) SetProgress(float64)                 {}
+func (nopIndicator) TurnOff()                            {}
+
+func BenchmarkScreen(b *testing.B) {
+	data := benchSession(b)
+	chunks := benchChunks(data)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for b.Loop() {
+		screen := state.NewScreen(120, 40)
+		for _, c := range chunks {
+			screen.Write(c)
+		}
+	}
+}
+
+func BenchmarkMatcher(b *testing.B) {
+	data := benchSession(b)
+	chunks := benchChunks(data)
+	cfg := benchConfig(b)
+	matchers := []*state.Matcher{
+		state.NewMatcher(cfg.Patterns.Thinking),
+		state.NewMatcher(cfg.Patterns.Error),
+	}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for b.Loop() {
+		for _, c := range chunks {
+			for _, m := range matchers {
+				m.Which(c)
+			}
+		}
+	}
+}
+
+// BenchmarkMonitor feeds every chunk followed by silence, so each one also
+// costs a waiting scan of the screen.
+func BenchmarkMonitor(b *testing.B) {
+	data := benchSession(b)
+	chunks := benchChunks(data)
+	cfg := benchConfig(b)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for b.Loop() {
+		mon := state.NewMonitor(cfg, nopIndicator{})
+		mon.Screen.Resize(120, 40)
+		now := time.Unix(0, 0)
+		mon.Start(now)
+		for _, c := range chunks {
+			mon.Feed(c, "stdout", now)
+			now = now.Add(time.Second)
+			mon.Tick(now)
+		}
+	}
+}
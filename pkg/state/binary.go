@@ -0,0 +1,52 @@
+package state
+
+import "unicode/utf8"
+
+// binaryTokenLen is how long a run of base64 or hex characters must be to
+// count as encoded data rather than, say, a commit hash.
+const binaryTokenLen = 64
+
+// isBinary reports whether a chunk of output is data rather than text: raw
+// bytes that aren't UTF-8 or terminal controls, or mostly long unbroken
+// runs of base64 or hex like a dumped file. Patterns can't mean anything
+// in it, and matching on it is slow and finds accidental hits.
+func isBinary(data []byte) bool {
+	if len(data) < binaryTokenLen {
+		return false
+	}
+	odd, encoded, run := 0, 0, 0
+	for i := 0; i < len(data); {
+		b := data[i]
+		size := 1
+		switch {
+		case b >= 0x80:
+			r, n := utf8.DecodeRune(data[i:])
+			if r == utf8.RuneError && n <= 1 {
+				odd++
+			}
+			size = n
+		case b < 0x20 && b != '\n' && b != '\r' && b != '\t' && b != 0x1b && b != '\b' && b != 0x07, b == 0x7f:
+			odd++
+		}
+		if base64Char(b) {
+			run++
+		} else {
+			if run >= binaryTokenLen {
+				encoded += run
+			}
+			run = 0
+		}
+		i += size
+	}
+	if run >= binaryTokenLen {
+		encoded += run
+	}
+	return odd*10 > len(data) || encoded*2 > len(data)
+}
+
+// base64Char reports whether b is in the base64 alphabets, which include
+// the hex digits.
+func base64Char(b byte) bool {
+	return b >= 'A' && b <= 'Z' || b >= 'a' && b <= 'z' || b >= '0' && b <= '9' ||
+		b == '+' || b == '/' || b == '=' || b == '-' || b == '_'
+}
@@ -0,0 +1,29 @@
+package state
+
+import "time"
+
+// TickInterval is how often a Machine expects Tick to be called.
+const TickInterval = 100 * time.Millisecond
+
+// Clock tells the time. The default is the system clock; tests can step a
+// fake one past thresholds without sleeping.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the real time.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time { return time.Now() }
+
+// Machine is the state machine behind a Monitor. All timing comes in
+// through the now arguments, so it can be driven by a fake clock and a
+// scripted feed as well as by a live session.
+type Machine interface {
+	Start(now time.Time)
+	Feed(data []byte, stream string, now time.Time)
+	Tick(now time.Time)
+	Handle(a Action)
+}
+
+var _ Machine = (*Monitor)(nil)
@@ -0,0 +1,53 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// Cost follows what a session has spent, from the running total the tool
+// prints, such as Claude Code's "Total cost: $1.23" after /cost and on
+// exit. Once it exceeds Budget (in dollars), idle is shown in the theme's
+// warning color. Pattern replaces the built-in one; its first group is the
+// amount.
+type Cost struct {
+	Pattern string  `json:"pattern"`
+	Budget  float64 `json:"budget"`
+}
+
+// costPattern matches Claude Code's cost summary, also with colors
+// between the label and the amount.
+var costPattern = regexp.MustCompile(`Total cost:(?:\s|\x1b\[[0-9;]*m)*\$([0-9]+(?:\.[0-9]+)?)`)
+
+// matchCost takes the last total in data as the session's cost.
+func (m *Monitor) matchCost(data []byte) {
+	if m.costRe == nil {
+		return
+	}
+	matches := m.costRe.FindAllSubmatch(data, -1)
+	if len(matches) == 0 || len(matches[len(matches)-1]) < 2 {
+		return
+	}
+	cost, err := strconv.ParseFloat(string(matches[len(matches)-1][1]), 64)
+	if err != nil || cost == m.Cost {
+		return
+	}
+	over := m.overBudget()
+	m.Cost = cost
+	if m.debug {
+		fmt.Fprintf(os.Stderr, "[DEBUG] Session cost: $%.4f\n", cost)
+	}
+	if m.overBudget() != over {
+		if m.debug {
+			fmt.Fprintf(os.Stderr, "[DEBUG] Budget of $%.2f exceeded\n", m.budget)
+		}
+		m.refresh()
+	}
+}
+
+// overBudget reports whether the session has spent more than its budget.
+func (m *Monitor) overBudget() bool {
+	return m.budget > 0 && m.Cost > m.budget
+}
@@ -0,0 +1,53 @@
+package state
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DryRun, when set, receives a line for everything sl would do outside the
+// terminal instead of doing it: the commands it would run, what it would
+// write to devices and files and the requests it would send. It is meant
+// for debugging a config, such as a color mapping, and for bug reports.
+var DryRun io.Writer
+
+var dryRunMu sync.Mutex
+
+// DryRunf describes an action when dry-running and reports whether it
+// must be skipped.
+func DryRunf(format string, args ...any) bool {
+	if DryRun == nil {
+		return false
+	}
+	dryRunMu.Lock()
+	defer dryRunMu.Unlock()
+	fmt.Fprintf(DryRun, "[dry-run] "+format+"\n", args...)
+	return true
+}
+
+// dryRunRequest describes req when dry-running. Authorization headers are
+// left out.
+func dryRunRequest(req *http.Request) bool {
+	if DryRun == nil {
+		return false
+	}
+	var headers []string
+	for name, values := range req.Header {
+		if name != "Authorization" {
+			headers = append(headers, name+": "+strings.Join(values, ", "))
+		}
+	}
+	sort.Strings(headers)
+	body := ""
+	if req.GetBody != nil {
+		if r, err := req.GetBody(); err == nil {
+			data, _ := io.ReadAll(r)
+			body = string(data)
+		}
+	}
+	return DryRunf("%s %s %q %s", req.Method, req.URL, headers, body)
+}
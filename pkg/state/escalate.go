@@ -0,0 +1,93 @@
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Escalation makes a state louder once it has lasted too long, e.g. a prompt
+// that has been waiting for ten minutes starts blinking and notifies.
+type Escalation struct {
+	State   string `json:"state"`
+	AfterMs int    `json:"after_ms"`
+	Effect  Effect `json:"effect"`
+	Notify  bool   `json:"notify"`
+	Webhook string `json:"webhook"`
+}
+
+// checkEscalations fires every rule whose threshold the current state has
+// just crossed. Each rule fires at most once per state entry.
+func (m *Monitor) checkEscalations(now time.Time) {
+	inState := now.Sub(m.lastStateChange)
+	// Nobody sees the LED, so don't wait for the rules' thresholds
+	away := false
+	if q, ok := m.Quiet.(interface{ Escalating() bool }); ok {
+		away = q.Escalating()
+	}
+	for i, e := range m.escalations {
+		if m.escalated[i] || e.State != m.State.String() || (inState < time.Duration(e.AfterMs)*time.Millisecond && !away) {
+			continue
+		}
+		m.escalated[i] = true
+		if m.debug {
+			fmt.Fprintf(os.Stderr, "[DEBUG] Escalating %s after %s\n", m.State, inState.Round(time.Second))
+		}
+		if e.Effect != EffectSolid {
+			m.show(m.State, e.Effect)
+		}
+		msg := fmt.Sprintf("%s has been %s for %s", m.Label(), m.State, inState.Round(time.Second))
+		if m.Suppressed() {
+			continue
+		}
+		if e.Notify {
+			go desktopNotify("Status light", msg)
+		}
+		if e.Webhook != "" {
+			go postWebhook(e.Webhook, map[string]any{
+				"tool":       m.Tool,
+				"name":       m.Name,
+				"state":      m.State.String(),
+				"since":      m.lastStateChange.Unix(),
+				"duration_s": int(inState.Seconds()),
+				"message":    msg,
+			})
+		}
+	}
+}
+
+// Suppressed reports whether notifications and webhooks are held back by
+// quiet hours, presence, a snooze or the user typing.
+func (m *Monitor) Suppressed() bool {
+	if s, ok := m.led.(interface{ Snoozed() bool }); ok && s.Snoozed() {
+		return true
+	}
+	if m.typing(m.Clock.Now()) {
+		return true
+	}
+	return m.Quiet != nil && m.Quiet.Suppressed()
+}
+
+// postWebhook sends payload as JSON. Failures are only reported in debug
+// mode since nobody is around to act on them.
+func postWebhook(url string, payload any) {
+	body, _ := json.Marshal(payload)
+	if DryRunf("POST %s %s", url, body) {
+		return
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err == nil {
+		resp.Body.Close()
+	}
+	if os.Getenv("DEBUG_SL") != "" {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[DEBUG] Webhook %s failed: %v\n", url, err)
+		} else {
+			fmt.Fprintf(os.Stderr, "[DEBUG] Webhook %s: %s\n", url, resp.Status)
+		}
+	}
+}
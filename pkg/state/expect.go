@@ -0,0 +1,145 @@
+package state
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Expect answers a routine prompt like expect does: when the output goes
+// silent with a line matching Pattern last on screen, Text and then Keys
+// are typed into the program instead of showing Waiting. Prompts no rule
+// matches still show Waiting.
+type Expect struct {
+	Pattern string   `json:"pattern"`
+	Text    string   `json:"text"` // typed as is
+	Keys    []string `json:"keys"` // names from Keys, e.g. ["enter"]
+	// Answers per session, 10 by default; after that the prompt shows
+	// Waiting
+	Max int `json:"max"`
+	// How long the screen may stay as it was after an answer before the
+	// prompt is shown as Waiting after all; 5000 by default
+	TimeoutMs int `json:"timeout_ms"`
+}
+
+// Keys are the keys expect rules and auto-answer rules can press, by name.
+var Keys = map[string]string{
+	"enter": "\r", "escape": "\x1b", "tab": "\t", "space": " ",
+	"up": "\x1b[A", "down": "\x1b[B",
+	"y": "y", "n": "n",
+	"0": "0", "1": "1", "2": "2", "3": "3", "4": "4",
+	"5": "5", "6": "6", "7": "7", "8": "8", "9": "9",
+}
+
+// KeyNames lists the names in Keys, for messages.
+func KeyNames() string {
+	return strings.Join(slices.Sorted(maps.Keys(Keys)), ", ")
+}
+
+const (
+	defaultExpectMax     = 10
+	defaultExpectTimeout = 5 * time.Second
+)
+
+// expectRule is an Expect ready to match.
+type expectRule struct {
+	re      *regexp.Regexp
+	input   []byte
+	max     int
+	timeout time.Duration
+	answers int
+}
+
+// Check reports why the monitor would skip e.
+func (e Expect) Check() error {
+	_, err := newExpect(e)
+	return err
+}
+
+func newExpect(e Expect) (*expectRule, error) {
+	if e.Pattern == "" {
+		return nil, fmt.Errorf("expect needs a pattern")
+	}
+	re, err := regexp.Compile(e.Pattern)
+	if err != nil {
+		return nil, err
+	}
+	r := &expectRule{re: re, input: []byte(e.Text), max: e.Max, timeout: time.Duration(e.TimeoutMs) * time.Millisecond}
+	for _, k := range e.Keys {
+		key, ok := Keys[k]
+		if !ok {
+			return nil, fmt.Errorf("unknown key %q (%s)", k, KeyNames())
+		}
+		r.input = append(r.input, key...)
+	}
+	if len(r.input) == 0 {
+		return nil, fmt.Errorf("expect %q has nothing to send", e.Pattern)
+	}
+	if r.max <= 0 {
+		r.max = defaultExpectMax
+	}
+	if r.timeout <= 0 {
+		r.timeout = defaultExpectTimeout
+	}
+	return r, nil
+}
+
+// expecting answers the prompt on screen during silence if an expect rule
+// matches its last line, and reports whether the screen is being taken
+// care of: just answered, or answered and waiting for the program to
+// react. Once a rule has used up its answers, or the screen stays the
+// same past its timeout, the prompt is left to the waiting patterns.
+func (m *Monitor) expecting(lines []string, now time.Time) bool {
+	if len(m.expects) == 0 || m.Send == nil {
+		return false
+	}
+	screen := strings.Join(lines, "\n")
+	if r := m.expectRule; r != nil && screen == m.expectScreen {
+		if now.Sub(m.expectAt) < r.timeout {
+			return true
+		}
+		if m.debug {
+			fmt.Fprintf(os.Stderr, "[DEBUG] Expect %q: no reaction after %s\n", r.re, r.timeout)
+		}
+		m.expectRule = nil
+		return false
+	}
+	if screen == m.expectScreen {
+		// Given up on
+		return false
+	}
+	m.expectRule = nil
+	last := ""
+	for i := len(lines) - 1; i >= 0; i-- {
+		if strings.TrimSpace(lines[i]) != "" {
+			last = lines[i]
+			break
+		}
+	}
+	for _, r := range m.expects {
+		if !r.re.MatchString(last) {
+			continue
+		}
+		m.expectScreen = screen
+		if r.answers >= r.max {
+			if m.debug {
+				fmt.Fprintf(os.Stderr, "[DEBUG] Expect %q: answered %d times, leaving it\n", r.re, r.answers)
+			}
+			return false
+		}
+		r.answers++
+		m.expectRule, m.expectAt = r, now
+		if m.debug {
+			fmt.Fprintf(os.Stderr, "[DEBUG] Expect %q: sending %q\n", r.re, r.input)
+		}
+		if !DryRunf("expect %q: send %q", r.re, r.input) {
+			m.Send(r.input)
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Focus turns long stretches of thinking into a signal that it is safe to
+// look away: once the tool has been thinking for AfterMs in total, the LED
+// shows EffectSafe. Short idle pauses don't reset the count; a prompt or
+// an error does.
+type Focus struct {
+	AfterMs int `json:"after_ms"`
+}
+
+// trackThinking adds up the thinking time when the state changes to next.
+// It returns the total since the last prompt or error, which a prompt or
+// error then resets.
+func (m *Monitor) trackThinking(next State, now time.Time) time.Duration {
+	if m.State == Thinking {
+		m.thinkingTotal += now.Sub(m.lastStateChange)
+	}
+	total := m.thinkingTotal
+	if next == Waiting || next == Error {
+		m.thinkingTotal = 0
+	}
+	return total
+}
+
+// checkFocus shows EffectSafe once enough thinking time has accumulated.
+func (m *Monitor) checkFocus(now time.Time) {
+	if m.focusAfter == 0 || m.State != Thinking || m.effect != EffectSolid {
+		return
+	}
+	total := m.thinkingTotal + now.Sub(m.lastStateChange)
+	if total < m.focusAfter {
+		return
+	}
+	if m.debug {
+		fmt.Fprintf(os.Stderr, "[DEBUG] Thinking for %s: safe to look away\n", total.Round(time.Second))
+	}
+	m.show(Thinking, EffectSafe)
+}
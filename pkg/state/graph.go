@@ -0,0 +1,146 @@
+package state
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Transition is an edge of the state machine a config sets up: what moves
+// a session from one state to another.
+type Transition struct {
+	From    string // a state, or "" for any state
+	To      string // a state, or "off" for the LED turning off
+	Trigger string
+}
+
+// graphPatterns is how many patterns a trigger lists before "+N more".
+const graphPatterns = 3
+
+// Transitions describes the state machine cfg sets up, with the thresholds
+// that apply when cfg leaves them out, for "sl config graph".
+func Transitions(cfg Config) []Transition {
+	m := &Monitor{}
+	m.newSilence(cfg.IdleThresholdMs, cfg.Silence)
+	silence := func(st State) string {
+		s := fmt.Sprintf("silence > %s", m.silenceFor(st))
+		if m.adaptiveMax > 0 {
+			s += fmt.Sprintf(" (learned, up to %s)", m.adaptiveMax)
+		}
+		return s
+	}
+	var out []Transition
+	add := func(from string, to State, trigger string) {
+		out = append(out, Transition{From: from, To: to.String(), Trigger: trigger})
+	}
+
+	// Feed: patterns in the output, checked in this order
+	if len(cfg.Patterns.Error) > 0 {
+		add("", Error, "error pattern "+patternList(cfg.Patterns.Error))
+	}
+	if p := cfg.StderrPatterns; p != nil && len(p.Error) > 0 {
+		add("", Error, "stderr error pattern "+patternList(p.Error))
+	}
+	add("", Error, "command crashed")
+	if len(cfg.Patterns.Success) > 0 {
+		add("", Success, "success pattern "+patternList(cfg.Patterns.Success))
+	}
+	if s := cfg.Sync; s != nil {
+		patterns := "(git)"
+		if len(s.Patterns) > 0 {
+			patterns = patternList(s.Patterns)
+		}
+		after := defaultSyncAfter
+		if s.AfterMs > 0 {
+			after = time.Duration(s.AfterMs) * time.Millisecond
+		}
+		add("", Syncing, fmt.Sprintf("sync pattern %s for %s", patterns, after))
+		add(Syncing.String(), Thinking, "other output")
+	}
+	if len(cfg.Patterns.Thinking) > 0 {
+		add("", Thinking, "thinking pattern "+patternList(cfg.Patterns.Thinking))
+	}
+	if p := cfg.StderrPatterns; p != nil && len(p.Thinking) > 0 {
+		add("", Thinking, "stderr thinking pattern "+patternList(p.Thinking))
+	}
+	if cfg.Spinner == nil || *cfg.Spinner {
+		add("", Thinking, "spinner")
+	}
+	if cfg.Stall != nil && cfg.Stall.AfterMs > 0 {
+		add(Stalled.String(), Thinking, "output")
+	}
+
+	// Signals from the tool
+	if b := cfg.Bell; b != nil && b.Action != "notify" {
+		add("", Waiting, "bell")
+	}
+	if o := cfg.OSCNotify; o != nil && o.Action != "notify" {
+		add("", Waiting, "desktop notification (OSC 9/777)")
+	}
+
+	// Tick: silence
+	for _, w := range waitingMatchers(cfg.Patterns.Waiting, cfg.WaitingWindow, cfg.WaitingWindows) {
+		add(Thinking.String(), Waiting, fmt.Sprintf("%s, waiting pattern %s %s", silence(Thinking), patternList(w.patterns), windowName(w.window)))
+	}
+	for _, s := range cfg.Sequences {
+		st := Waiting
+		if parsed, ok := ParseState(s.State); ok {
+			st = parsed
+		}
+		prompt := s.Prompt
+		if prompt == "" {
+			prompt = "a prompt"
+		} else {
+			prompt = `"` + prompt + `"`
+		}
+		add(Thinking.String(), st, fmt.Sprintf("%s after %s, then %s", silence(Thinking), patternList(s.After), prompt))
+	}
+	if s := cfg.Stall; s != nil && s.AfterMs > 0 {
+		add(Thinking.String(), Stalled, fmt.Sprintf("silent and no CPU for %s", time.Duration(s.AfterMs)*time.Millisecond))
+	}
+	add(Thinking.String(), Idle, silence(Thinking)+", no prompt")
+	if cfg.Sync != nil {
+		add(Syncing.String(), Idle, silence(Syncing)+", sync gone from the last line")
+	}
+	hold := defaultSuccessHold
+	if cfg.SuccessHoldMs > 0 {
+		hold = time.Duration(cfg.SuccessHoldMs) * time.Millisecond
+	}
+	if len(cfg.Patterns.Success) > 0 {
+		add(Success.String(), Idle, fmt.Sprintf("shown for %s", hold))
+	}
+	add(Waiting.String(), Idle, silence(Waiting)+", prompt gone")
+	if cfg.RespondingMs > 0 {
+		responding := time.Duration(cfg.RespondingMs) * time.Millisecond
+		add(Waiting.String(), Responding, "typing")
+		add(Responding.String(), Waiting, fmt.Sprintf("no typing for %s", responding))
+	}
+	if cfg.OffAfterIdleMs > 0 {
+		out = append(out, Transition{From: Idle.String(), To: "off", Trigger: fmt.Sprintf("idle for %s", time.Duration(cfg.OffAfterIdleMs)*time.Millisecond)})
+	}
+	return out
+}
+
+// windowName describes where a waiting pattern is looked for.
+func windowName(w Window) string {
+	switch w {
+	case WindowScreen:
+		return "on the screen"
+	case WindowLastLine:
+		return "on the last line"
+	}
+	return fmt.Sprintf("in the last %d lines", w)
+}
+
+// patternList quotes the first few patterns.
+func patternList(patterns []string) string {
+	var quoted []string
+	for i, p := range patterns {
+		if i == graphPatterns {
+			quoted = append(quoted, fmt.Sprintf("+%d more", len(patterns)-i))
+			break
+		}
+		quoted = append(quoted, `"`+p+`"`)
+	}
+	return strings.Join(quoted, " | ")
+}
@@ -0,0 +1,59 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/f0i/status-light/internal/shell"
+)
+
+// hookTimeout is how long a hook may run before it is killed, so one that
+// hangs doesn't pile up with every state change.
+const hookTimeout = 30 * time.Second
+
+// Hook runs shell commands when a state is entered or left, e.g. to switch
+// an OBS scene or pause music while waiting. The commands see SL_STATE,
+// SL_PREV_STATE and SL_TOOL in their environment.
+type Hook struct {
+	Enter string `json:"enter"`
+	Exit  string `json:"exit"`
+}
+
+// runHooks runs the exit hook of prev and the enter hook of next, in that
+// order, without blocking the monitor.
+func (m *Monitor) runHooks(prev, next State) {
+	var commands []string
+	if h, ok := m.hooks[prev.String()]; ok && h.Exit != "" {
+		commands = append(commands, h.Exit)
+	}
+	if h, ok := m.hooks[next.String()]; ok && h.Enter != "" {
+		commands = append(commands, h.Enter)
+	}
+	if len(commands) == 0 {
+		return
+	}
+	env := append(os.Environ(),
+		"SL_STATE="+next.String(),
+		"SL_PREV_STATE="+prev.String(),
+		"SL_TOOL="+m.Tool,
+		"SL_NAME="+m.Name,
+	)
+	go func() {
+		for _, c := range commands {
+			if DryRunf("hook: %s (SL_STATE=%s)", c, next) {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+			cmd := shell.CommandContext(ctx, c)
+			cmd.Env = env
+			// Don't wait for what it started and left holding its output
+			cmd.WaitDelay = time.Second
+			if err := cmd.Run(); err != nil && m.debug {
+				fmt.Fprintf(os.Stderr, "[DEBUG] Hook %q failed: %v\n", c, err)
+			}
+			cancel()
+		}
+	}()
+}
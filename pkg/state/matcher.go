@@ -0,0 +1,75 @@
+package state
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Matcher combines all patterns of one state into a single alternation so a
+// chunk is scanned once no matter how many patterns are configured. Each
+// alternative is wrapped in a named group so debug output can still report
+// which pattern matched.
+type Matcher struct {
+	re       *regexp.Regexp
+	patterns []string
+	groups   []int
+}
+
+func NewMatcher(patterns []string) *Matcher {
+	m := &Matcher{}
+	var alts []string
+	for _, p := range patterns {
+		// Skip invalid patterns individually so one typo doesn't disable the state
+		if _, err := regexp.Compile(p); err != nil {
+			continue
+		}
+		alts = append(alts, fmt.Sprintf("(?P<sl%d>%s)", len(m.patterns), p))
+		m.patterns = append(m.patterns, p)
+	}
+	if len(alts) == 0 {
+		return m
+	}
+	m.re = regexp.MustCompile(strings.Join(alts, "|"))
+	for i := range m.patterns {
+		m.groups = append(m.groups, m.re.SubexpIndex(fmt.Sprintf("sl%d", i)))
+	}
+	return m
+}
+
+// Len returns the number of usable patterns.
+func (m *Matcher) Len() int {
+	return len(m.patterns)
+}
+
+func (m *Matcher) Match(b []byte) bool {
+	return m.re != nil && m.re.Match(b)
+}
+
+func (m *Matcher) MatchString(s string) bool {
+	return m.re != nil && m.re.MatchString(s)
+}
+
+// Which returns the pattern responsible for the leftmost match in b.
+// Most chunks match nothing, so the cheaper Match runs first and the
+// groups are only located on a hit.
+func (m *Matcher) Which(b []byte) (string, bool) {
+	if !m.Match(b) {
+		return "", false
+	}
+	loc := m.re.FindSubmatchIndex(b)
+	if loc == nil {
+		return "", false
+	}
+	for i, g := range m.groups {
+		if loc[2*g] >= 0 {
+			return m.patterns[i], true
+		}
+	}
+	return "", false
+}
+
+// WhichString is Which for strings.
+func (m *Matcher) WhichString(s string) (string, bool) {
+	return m.Which([]byte(s))
+}
@@ -0,0 +1,39 @@
+package state_test
+
+import (
+	"testing"
+
+	"github.com/f0i/status-light/pkg/state"
+)
+
+func TestMatcherWhich(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		text     string
+		want     string // "" when nothing matches
+	}{
+		{"only match", []string{"Running", `\(y/n\)`}, "Continue? (y/n)", `\(y/n\)`},
+		{"leftmost in the text", []string{`\(y/n\)`, "Continue"}, "Continue? (y/n)", "Continue"},
+		{"first listed at the same place", []string{"Run", "Running"}, "Running tests", "Run"},
+		{"alternation inside a pattern", []string{"a|b", "c"}, "xb c", "a|b"},
+		{"groups inside a pattern", []string{`(\d+) (files?)`, `(?P<n>\d+)%`}, "done 42%", `(?P<n>\d+)%`},
+		{"invalid pattern skipped", []string{"(", "Wait"}, "Wait", "Wait"},
+		{"case flags stay in their pattern", []string{"(?i)error", "Warning"}, "warning: ERROR", "(?i)error"},
+		{"case flags don't leak", []string{"(?i)error", "Warning"}, "warning", ""},
+		{"no match", []string{"Running"}, "idle", ""},
+		{"no patterns", nil, "anything", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := state.NewMatcher(tt.patterns)
+			got, ok := m.WhichString(tt.text)
+			if got != tt.want || ok != (tt.want != "") {
+				t.Errorf("got %q, %v, want %q", got, ok, tt.want)
+			}
+			if m.MatchString(tt.text) != ok {
+				t.Errorf("Match and Which disagree")
+			}
+		})
+	}
+}
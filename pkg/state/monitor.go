@@ -0,0 +1,595 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	minStateDuration = 200 * time.Millisecond
+	// silenceThreshold is used without idle_threshold_ms
+	silenceThreshold = 500 * time.Millisecond
+	// defaultSuccessHold is how long a success pattern is shown
+	defaultSuccessHold = 5 * time.Second
+)
+
+// Monitor turns a stream of output into LED states. It owns the screen
+// model, the compiled patterns and the timing used for silence detection.
+type Monitor struct {
+	Screen *Screen
+	State  State
+	Tool   string
+	Quiet  Suppressor
+
+	// Name labels the session, e.g. "backend-api" when several agents run;
+	// notifications use it instead of Tool when set
+	Name string
+
+	// CPU, when set, returns the processor time the wrapped command has
+	// used so far, or false when it can't be measured
+	CPU func() (time.Duration, bool)
+
+	// Clock is used by the loops that drive the monitor
+	Clock Clock
+
+	// Progress of the current Thinking phase between 0 and 1, or -1 when
+	// the output shows none. With a queue configured, it is the queue's
+	// progress instead.
+	Progress float64
+
+	// Cost is the session's spending in dollars as last printed by the
+	// tool, 0 while unknown
+	Cost float64
+
+	// Observe, when set, is called for every pattern match and state
+	// change, e.g. to show them while tuning patterns
+	Observe func(Event)
+
+	// Send, when set, types into the wrapped program; expect rules
+	// need it
+	Send func([]byte)
+
+	// Tee, when set, gets every chunk of output before it is analyzed,
+	// e.g. to keep a copy
+	Tee func(data []byte, stream string, now time.Time)
+
+	// Actions from hotkeys and other user controls, handled by Run
+	Actions chan Action
+	Mode    Mode
+	acked   bool
+	effect  Effect // last effect requested, before the mode is applied
+
+	debug bool
+	led   Indicator
+
+	waiting        []windowMatcher
+	window         Window
+	ignoreAlt      bool
+	windows        map[string]Window
+	thinking       *Matcher
+	errors         *Matcher
+	success        *Matcher
+	successHold    time.Duration
+	stderrThinking *Matcher
+	stderrErrors   *Matcher
+	stderrOwn      bool
+
+	escalations []Escalation
+	hooks       map[string]Hook
+	escalated   []bool
+
+	offAfterIdle time.Duration
+	dark         bool
+
+	focusAfter    time.Duration
+	thinkingTotal time.Duration // thinking since the last prompt or error, before the current state
+
+	push *Push
+
+	sampling      *Sampling
+	samplers      map[string]*sampler
+	fedBytes      int64
+	analyzedBytes int64
+	coverageSince time.Time
+
+	stallAfter  time.Duration
+	outputState State // state when the last output arrived
+	cpuSince    time.Time
+	cpuUsed     time.Duration
+	cpuAt       time.Time // last sample of CPU, which reads all of /proc
+	cpuLast     time.Duration
+	cpuOK       bool
+
+	sync      *Matcher // nil unless configured
+	syncAfter time.Duration
+	syncSince time.Time // first output of the current network operation
+
+	costRe *regexp.Regexp
+	budget float64
+
+	sequences []*sequence
+
+	expects      []*expectRule
+	expectRule   *expectRule // last to answer, while its timeout runs
+	expectScreen string      // screen it answered or gave up on
+	expectAt     time.Time
+
+	queue *regexp.Regexp // nil unless configured
+
+	spinner    bool
+	spinGlyph  rune // last spinner frame
+	spinFamily int
+	spinAt     time.Time
+
+	bell         *Bell
+	attentionAt  time.Time // a bell or notification holds Waiting since
+	bellNotified time.Time
+	oscNotify    *OSCNotify
+
+	typingFor time.Duration
+	lastInput atomic.Int64 // unix ns, set from the input goroutine
+	held      bool         // a state change waits for typing to stop
+
+	respondingFor time.Duration
+
+	silence     time.Duration // from idle_threshold_ms
+	silences    map[State]time.Duration
+	adaptiveMax time.Duration // 0 unless adaptive
+	gap         time.Duration // usual gap between chunks while thinking
+	gapSamples  int
+	minOutput   int // visible characters a chunk needs to count as activity
+
+	lastOutputTime  time.Time
+	lastStateChange time.Time
+}
+
+func NewMonitor(cfg Config, led Indicator) *Monitor {
+	m := &Monitor{
+		Screen:   NewScreen(80, 24),
+		Progress: -1,
+		Clock:    SystemClock{},
+		Actions:  make(chan Action, 8),
+		debug:    os.Getenv("DEBUG_SL") != "",
+		led:      led,
+		waiting:  waitingMatchers(cfg.Patterns.Waiting, cfg.WaitingWindow, cfg.WaitingWindows),
+		window:   cfg.WaitingWindow,
+		windows:  cfg.WaitingWindows,
+		thinking: NewMatcher(cfg.Patterns.Thinking),
+		errors:   NewMatcher(cfg.Patterns.Error),
+		success:  NewMatcher(cfg.Patterns.Success),
+
+		escalations: cfg.Escalations,
+		escalated:   make([]bool, len(cfg.Escalations)),
+		hooks:       cfg.Hooks,
+
+		offAfterIdle:  time.Duration(cfg.OffAfterIdleMs) * time.Millisecond,
+		typingFor:     time.Duration(cfg.TypingMs) * time.Millisecond,
+		respondingFor: time.Duration(cfg.RespondingMs) * time.Millisecond,
+	}
+	m.push = cfg.Push
+	m.newSilence(cfg.IdleThresholdMs, cfg.Silence)
+	m.minOutput = max(cfg.MinOutputChars, 1)
+	m.successHold = defaultSuccessHold
+	if cfg.SuccessHoldMs > 0 {
+		m.successHold = time.Duration(cfg.SuccessHoldMs) * time.Millisecond
+	}
+	m.ignoreAlt = cfg.IgnoreAltScreen
+	if s := cfg.Sampling; s != nil && (s.Every > 1 || s.Lines > 0) {
+		m.sampling = s
+		m.samplers = map[string]*sampler{}
+	}
+	if cfg.Focus != nil {
+		m.focusAfter = time.Duration(cfg.Focus.AfterMs) * time.Millisecond
+	}
+	if cfg.Stall != nil {
+		m.stallAfter = time.Duration(cfg.Stall.AfterMs) * time.Millisecond
+	}
+	m.costRe = costPattern
+	if c := cfg.Cost; c != nil {
+		m.budget = c.Budget
+		if c.Pattern != "" {
+			if re, err := regexp.Compile(c.Pattern); err == nil && re.NumSubexp() > 0 {
+				m.costRe = re
+			} else if m.debug {
+				fmt.Fprintf(os.Stderr, "[DEBUG] Ignoring cost pattern %q: needs a group for the amount\n", c.Pattern)
+			}
+		}
+	}
+	if s := cfg.Sync; s != nil {
+		patterns := []string(s.Patterns)
+		if len(patterns) == 0 {
+			patterns = syncPatterns
+		}
+		m.sync = NewMatcher(patterns)
+		m.syncAfter = defaultSyncAfter
+		if s.AfterMs > 0 {
+			m.syncAfter = time.Duration(s.AfterMs) * time.Millisecond
+		}
+	}
+	if cfg.Progress != nil {
+		if re, err := newQueue(*cfg.Progress); err == nil {
+			m.queue = re
+		} else if m.debug {
+			fmt.Fprintf(os.Stderr, "[DEBUG] Ignoring progress pattern: %v\n", err)
+		}
+	}
+	m.spinner = cfg.Spinner == nil || *cfg.Spinner
+	m.bell = cfg.Bell
+	m.oscNotify = cfg.OSCNotify
+	for _, s := range cfg.Sequences {
+		if q, err := newSequence(s); err == nil {
+			m.sequences = append(m.sequences, q)
+		} else if m.debug {
+			fmt.Fprintf(os.Stderr, "[DEBUG] Ignoring sequence: %v\n", err)
+		}
+	}
+	for _, e := range cfg.Expect {
+		if r, err := newExpect(e); err == nil {
+			m.expects = append(m.expects, r)
+		} else if m.debug {
+			fmt.Fprintf(os.Stderr, "[DEBUG] Ignoring expect: %v\n", err)
+		}
+	}
+	m.stderrThinking, m.stderrErrors = m.thinking, m.errors
+	if cfg.StderrPatterns != nil {
+		m.stderrThinking = NewMatcher(cfg.StderrPatterns.Thinking)
+		m.stderrErrors = NewMatcher(cfg.StderrPatterns.Error)
+		m.stderrOwn = true
+	}
+	if m.debug {
+		fmt.Fprintf(os.Stderr, "[DEBUG] Thinking patterns: %d\n", m.thinking.Len())
+		fmt.Fprintf(os.Stderr, "[DEBUG] Starting timing-first approach: silence_threshold=%dms\n", int(m.silence.Milliseconds()))
+	}
+	return m
+}
+
+// Event is a pattern match or state change reported to Observe.
+type Event struct {
+	Time    time.Time
+	State   State  // state entered, or the one the pattern belongs to
+	Pattern string // empty for state changes
+	Stream  string // "stdout", "stderr" or "screen" for waiting patterns
+	Reason  string // why the state changed
+}
+
+// Label names the session in notifications: its Name, else the tool.
+func (m *Monitor) Label() string {
+	if m.Name != "" {
+		return m.Name
+	}
+	return m.Tool
+}
+
+// SetPatterns replaces the patterns, e.g. while tuning them. Separate
+// stderr patterns are kept.
+func (m *Monitor) SetPatterns(p Patterns) {
+	m.waiting = waitingMatchers(p.Waiting, m.window, m.windows)
+	m.thinking = NewMatcher(p.Thinking)
+	m.errors = NewMatcher(p.Error)
+	m.success = NewMatcher(p.Success)
+	if !m.stderrOwn {
+		m.stderrThinking, m.stderrErrors = m.thinking, m.errors
+	}
+}
+
+func (m *Monitor) observe(e Event) {
+	if m.Observe != nil {
+		m.Observe(e)
+	}
+}
+
+// Start shows the initial state.
+func (m *Monitor) Start(now time.Time) {
+	m.State = Idle
+	m.lastOutputTime = now
+	m.lastStateChange = now
+	m.show(m.State, EffectSolid)
+}
+
+// show renders a state on the indicator, applying the user's mode.
+func (m *Monitor) show(state State, effect Effect) {
+	m.effect = effect
+	m.held = false
+	if state == Idle && effect == EffectSolid && m.overBudget() {
+		effect = EffectBudget
+	}
+	switch m.Mode {
+	case ModeDim:
+		if effect != EffectOff {
+			effect = EffectDim
+		}
+	case ModeOff:
+		effect = EffectOff
+	}
+	m.led.SetEffect(state, effect)
+	if m.queue != nil && state == Thinking && m.Progress >= 0 {
+		// The queue's progress outlasts state changes
+		m.led.SetProgress(m.Progress)
+	}
+}
+
+// Handle applies a user action.
+func (m *Monitor) Handle(a Action) {
+	switch a {
+	case ActionCycleMode:
+		m.Mode = (m.Mode + 1) % 3
+		if m.debug {
+			fmt.Fprintf(os.Stderr, "[DEBUG] LED mode: %s\n", m.Mode)
+		}
+	case ActionAcknowledge:
+		if m.State != Waiting {
+			return
+		}
+		// Silence the alert until the next state change
+		m.acked = true
+		for i := range m.escalated {
+			m.escalated[i] = true
+		}
+		if m.debug {
+			fmt.Fprintf(os.Stderr, "[DEBUG] Waiting acknowledged\n")
+		}
+	}
+	m.refresh()
+}
+
+// refresh re-renders the current state, e.g. after the mode changed.
+func (m *Monitor) refresh() {
+	if m.acked {
+		m.show(Idle, EffectSolid)
+		return
+	}
+	m.show(m.State, m.effect)
+}
+
+func (m *Monitor) setState(newState State, now time.Time, reason string) {
+	if newState == m.State {
+		return
+	}
+	if m.debug {
+		fmt.Fprintf(os.Stderr, "[DEBUG] State change (%s): %s -> %s\n", reason, m.State, newState)
+	}
+	old := m.State
+	m.runHooks(old, newState)
+	thought := m.trackThinking(newState, now)
+	m.State = newState
+	m.lastStateChange = now
+	m.observe(Event{Time: now, State: newState, Reason: reason})
+	if m.queue == nil {
+		m.Progress = -1
+	}
+	m.attentionAt = time.Time{}
+	m.dark = false
+	m.acked = false
+	clear(m.escalated)
+	if m.typing(now) && newState != Responding {
+		// Keystroke echo would flash the LED while the user is looking
+		m.effect, m.held = EffectSolid, true
+	} else {
+		m.show(m.State, EffectSolid)
+	}
+	if newState == Waiting && old != Responding || newState == Error {
+		m.sendPush(newState, thought)
+	}
+}
+
+// Fail shows an error that didn't come from the output, such as the
+// command crashing: the LED blinks until the next state change.
+func (m *Monitor) Fail(now time.Time, reason string) {
+	m.setState(Error, now, reason)
+	m.show(Error, EffectBlink)
+}
+
+// Typed records that the user typed into the program. It may be called
+// from any goroutine.
+func (m *Monitor) Typed(now time.Time) {
+	m.lastInput.Store(now.UnixNano())
+}
+
+// LastInput returns when the user last typed, or the zero time.
+func (m *Monitor) LastInput() time.Time {
+	if ns := m.lastInput.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+// responding reports whether the user typed within responding_ms, which
+// at a prompt means they are answering it.
+func (m *Monitor) responding(now time.Time) bool {
+	return m.respondingFor > 0 && now.Sub(time.Unix(0, m.lastInput.Load())) < m.respondingFor
+}
+
+// typing reports whether the user typed within typing_ms.
+func (m *Monitor) typing(now time.Time) bool {
+	return m.typingFor > 0 && now.Sub(time.Unix(0, m.lastInput.Load())) < m.typingFor
+}
+
+// Feed handles one chunk of output. stream is "stdout" or "stderr"; stderr
+// uses its own patterns when the config provides them.
+func (m *Monitor) Feed(data []byte, stream string, now time.Time) {
+	thinking, errors := m.thinking, m.errors
+	if stream == "stderr" {
+		thinking, errors = m.stderrThinking, m.stderrErrors
+	}
+
+	if m.Tee != nil {
+		m.Tee(data, stream, now)
+	}
+
+	// Update screen model
+	m.Screen.Write(data)
+
+	// Output that shows next to nothing, like a lone newline or a cursor
+	// movement, and the echo of the user's typing only count as activity
+	// when a pattern matches them
+	before := m.State
+	echo := m.echo(now)
+	active := !echo && visibleChars(data, m.minOutput) >= m.minOutput
+
+	// Check for error, then thinking patterns in the sampled output;
+	// binary data still counts as activity but isn't matched
+	data = m.sample(data, stream)
+	skip := len(data) == 0 || isBinary(data)
+	matched := len(data) > 0
+	if skip {
+		if m.debug && len(data) > 0 {
+			fmt.Fprintf(os.Stderr, "[DEBUG] Skipping binary %s: %d bytes\n", stream, len(data))
+		}
+	} else if pattern, ok := errors.Which(data); ok {
+		if m.debug {
+			fmt.Fprintf(os.Stderr, "[DEBUG] Error pattern matched on %s: %s\n", stream, pattern)
+		}
+		m.observe(Event{Time: now, State: Error, Pattern: pattern, Stream: stream})
+		m.setState(Error, now, "error pattern")
+	} else if pattern, ok := m.success.Which(data); ok {
+		if m.debug {
+			fmt.Fprintf(os.Stderr, "[DEBUG] Success pattern matched on %s: %s\n", stream, pattern)
+		}
+		m.observe(Event{Time: now, State: Success, Pattern: pattern, Stream: stream})
+		m.setState(Success, now, "success pattern")
+	} else if m.matchSync(data, stream, now) {
+		m.setState(Syncing, now, "sync pattern")
+	} else if pattern, ok := thinking.Which(data); ok {
+		if m.debug {
+			fmt.Fprintf(os.Stderr, "[DEBUG] Thinking pattern matched on %s: %s\n", stream, pattern)
+		}
+		m.observe(Event{Time: now, State: Thinking, Pattern: pattern, Stream: stream})
+		m.setState(Thinking, now, "thinking pattern")
+	} else if m.spinning(data, now) {
+		if m.debug {
+			fmt.Fprintf(os.Stderr, "[DEBUG] Spinner on %s\n", stream)
+		}
+		if m.State != Thinking {
+			m.observe(Event{Time: now, State: Thinking, Pattern: "spinner", Stream: stream})
+		}
+		m.setState(Thinking, now, "spinner")
+	} else {
+		matched = false
+		if m.debug {
+			fmt.Fprintf(os.Stderr, "[DEBUG] No thinking patterns in %s: %d bytes (state=%s)\n", stream, len(data), m.State)
+		}
+	}
+	if active || matched {
+		// Update timing
+		m.learnGap(before, now)
+		m.lastOutputTime = now
+
+		if m.State == Stalled || m.State == Syncing && m.syncSince.IsZero() {
+			m.setState(Thinking, now, "output")
+		}
+		m.outputState = m.State
+	} else if m.debug && echo {
+		fmt.Fprintf(os.Stderr, "[DEBUG] Echo of typing in %s: not activity\n", stream)
+	} else if m.debug {
+		fmt.Fprintf(os.Stderr, "[DEBUG] Less than %d visible characters in %s: not activity\n", m.minOutput, stream)
+	}
+
+	if !skip {
+		m.matchCost(data)
+		for _, q := range m.sequences {
+			q.feed(data)
+		}
+	}
+	m.ringBell(now)
+	m.notified(now)
+	if m.queue != nil && !skip {
+		m.matchQueue(data)
+	} else if (m.State == Thinking || m.State == Syncing) && !skip {
+		if p, ok := parseProgress(data); ok && p != m.Progress {
+			m.Progress = p
+			m.led.SetProgress(p)
+		}
+	}
+}
+
+// Flowing tells the monitor that output is still on its way to the
+// terminal. A pause while a slow terminal holds up the child isn't silence.
+func (m *Monitor) Flowing(now time.Time) {
+	m.lastOutputTime = now
+}
+
+// Tick checks for silence and decides between Waiting and Idle.
+func (m *Monitor) Tick(now time.Time) {
+	if m.held && !m.typing(now) {
+		m.refresh()
+	}
+	m.checkEscalations(now)
+	m.checkFocus(now)
+	m.reportCoverage(now)
+
+	// A terminal left open overnight shouldn't keep the light on
+	if m.State == Idle && m.offAfterIdle > 0 && !m.dark && now.Sub(m.lastStateChange) >= m.offAfterIdle {
+		if m.debug {
+			fmt.Fprintf(os.Stderr, "[DEBUG] Idle for %s: LED off\n", m.offAfterIdle)
+		}
+		m.dark = true
+		m.show(Idle, EffectOff)
+	}
+
+	timeSinceOutput := now.Sub(m.lastOutputTime)
+	timeInState := now.Sub(m.lastStateChange)
+	if timeSinceOutput <= m.silenceFor(m.State) || timeInState < minStateDuration {
+		return
+	}
+	// A finished task is shown for a while, also over the prompt after it
+	if m.State == Success && timeInState < m.successHold {
+		return
+	}
+
+	// Check the end of the screen for waiting patterns, each group of
+	// patterns as far up as its window allows
+	foundWaiting := false
+	lines := m.Screen.Lines()
+	if m.ignoreAlt && m.Screen.AltScreen() {
+		lines = nil
+	}
+	// Routine prompts are answered instead
+	if m.expecting(lines, now) {
+		return
+	}
+	for _, w := range m.waiting {
+		for _, line := range w.window.Tail(lines) {
+			pattern, ok := w.WhichString(line)
+			if !ok {
+				continue
+			}
+			foundWaiting = true
+			if m.debug {
+				fmt.Fprintf(os.Stderr, "[DEBUG] Silence > %dms: Found waiting pattern (window %s): %s\n", int(timeSinceOutput.Milliseconds()), w.window, pattern)
+			}
+			if m.State != Waiting && m.State != Responding {
+				m.observe(Event{Time: now, State: Waiting, Pattern: pattern, Stream: "screen"})
+			}
+			break
+		}
+		if foundWaiting {
+			break
+		}
+	}
+
+	// Errors stay visible until new activity replaces them
+	newState := Idle
+	if foundWaiting || m.attending() {
+		newState = Waiting
+	} else if st, ok := m.sequenceState(lines, now); ok {
+		newState = st
+	} else if m.State == Error {
+		newState = Error
+	} else if m.syncing(now) {
+		newState = Syncing
+	} else if m.State == Stalled {
+		newState = Stalled
+	} else if stalled, undecided := m.stalled(now); stalled {
+		newState = Stalled
+	} else if undecided {
+		// Thinking in silence until the stall window tells
+		newState = Thinking
+	}
+	if newState == Waiting && m.responding(now) {
+		newState = Responding
+	}
+	m.setState(newState, now, "silence")
+}
@@ -0,0 +1,174 @@
+package state_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/f0i/status-light/internal/testfeed"
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// feed reads a script from testdata.
+func feed(t testing.TB, name string) []testfeed.Step {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	steps, err := testfeed.Parse(f)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return steps
+}
+
+// config reads a config the way it is written in configs/.
+func config(t testing.TB, data string) state.Config {
+	t.Helper()
+	var cfg state.Config
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("config %s: %v", data, err)
+	}
+	return cfg
+}
+
+func changes(rec *testfeed.Recorder) []string {
+	var out []string
+	for _, c := range rec.Changes {
+		out = append(out, c.String())
+	}
+	return out
+}
+
+const patterns = `"patterns": {"thinking": ["Running"], "waiting": ["\\(y/n\\)"]}`
+
+func TestReplay(t *testing.T) {
+	tests := []struct {
+		name   string
+		feed   string
+		config string
+		until  time.Duration
+		want   []string
+	}{
+		// Silence thresholds
+		{"default threshold", "silence.feed", `{` + patterns + `}`, 5 * time.Second,
+			[]string{"0s idle", "0s thinking", "600ms idle"}},
+		{"idle_threshold_ms", "silence.feed", `{` + patterns + `, "idle_threshold_ms": 2000}`, 5 * time.Second,
+			[]string{"0s idle", "0s thinking", "3.1s idle"}},
+		{"silence per state", "silence.feed", `{` + patterns + `, "silence": {"states_ms": {"thinking": 1500}}}`, 5 * time.Second,
+			[]string{"0s idle", "0s thinking", "2.6s idle"}},
+
+		// Debounce: a state is shown at least 200ms, however short the
+		// threshold
+		{"debounce", "debounce.feed", `{` + patterns + `, "idle_threshold_ms": 50}`, time.Second,
+			[]string{"0s idle", "0s thinking", "200ms idle"}},
+		{"no debounce past 200ms", "debounce.feed", `{` + patterns + `, "idle_threshold_ms": 300}`, time.Second,
+			[]string{"0s idle", "0s thinking", "400ms idle"}},
+
+		// Waiting windows
+		{"default window", "window.feed", `{` + patterns + `}`, time.Second,
+			[]string{"0s idle", "600ms waiting"}},
+		{"last_line window", "window.feed", `{` + patterns + `, "waiting_window": "last_line"}`, time.Second,
+			[]string{"0s idle"}},
+		{"window per pattern", "window.feed", `{` + patterns + `, "waiting_windows": {"\\(y/n\\)": "last_line"}}`, time.Second,
+			[]string{"0s idle"}},
+		{"window of a pattern object", "window.feed", `{"patterns": {"waiting": [{"text": "(y/n)", "literal": true, "ignore_case": true}]}, "waiting_windows": {"(y/n)": "last_line"}}`, time.Second,
+			[]string{"0s idle"}},
+		{"pattern object by its regexp", "window.feed", `{"patterns": {"waiting": [{"text": "(y/n)", "literal": true}]}, "waiting_windows": {"\\(y/n\\)": "last_line"}}`, time.Second,
+			[]string{"0s idle"}},
+		{"window of 2 lines", "window.feed", `{` + patterns + `, "waiting_window": 2}`, time.Second,
+			[]string{"0s idle", "600ms waiting"}},
+
+		// Stalls: without a CPU source silence alone counts, and the tool
+		// is thinking until the stall window ends
+		{"stall", "stall.feed", `{` + patterns + `, "stall": {"after_ms": 3000}}`, 5 * time.Second,
+			[]string{"0s idle", "0s thinking", "3.6s stalled"}},
+
+		// Escalation
+		{"escalation", "prompt.feed", `{` + patterns + `, "escalations": [{"state": "waiting", "after_ms": 3000, "effect": "blink"}]}`, 10 * time.Second,
+			[]string{"0s idle", "0s thinking", "1.6s waiting", "4.6s waiting blink"}},
+		{"escalation for another state", "prompt.feed", `{` + patterns + `, "escalations": [{"state": "error", "after_ms": 3000, "effect": "blink"}]}`, 10 * time.Second,
+			[]string{"0s idle", "0s thinking", "1.6s waiting"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := testfeed.Replay(config(t, tt.config), feed(t, tt.feed), tt.until)
+			if got := changes(rec); !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStallCPU(t *testing.T) {
+	tests := []struct {
+		name  string
+		share float64 // of a core the tool uses while silent
+		want  []string
+	}{
+		{"idle", 0, []string{"0s idle", "0s thinking", "3.6s stalled"}},
+		{"working", 0.5, []string{"0s idle", "0s thinking"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := testfeed.NewClock()
+			start := clock.Now()
+			rec := testfeed.NewRecorder(clock)
+			m := state.NewMonitor(config(t, `{`+patterns+`, "stall": {"after_ms": 3000}}`), rec)
+			m.Clock = clock
+			samples := 0
+			m.CPU = func() (time.Duration, bool) {
+				samples++
+				return time.Duration(float64(clock.Now().Sub(start)) * tt.share), true
+			}
+			testfeed.Play(m, clock, feed(t, "stall.feed"), 5*time.Second)
+			if got := changes(rec); !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			// About once a second over 4.4s of silence, not every tick
+			if samples > 6 {
+				t.Errorf("CPU read %d times", samples)
+			}
+		})
+	}
+}
+
+func TestExpect(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   []string
+		sent   int
+	}{
+		{"answered", `{` + patterns + `, "expect": [{"pattern": "\\(y/n\\)", "text": "y", "keys": ["enter"]}]}`,
+			[]string{"0s idle", "0s thinking"}, 3},
+		{"max reached", `{` + patterns + `, "expect": [{"pattern": "\\(y/n\\)", "text": "y", "keys": ["enter"], "max": 2}]}`,
+			[]string{"0s idle", "0s thinking", "5.1s waiting"}, 2},
+		{"no reaction", `{` + patterns + `, "expect": [{"pattern": "\\(y/n\\)", "text": "y", "timeout_ms": 500}]}`,
+			[]string{"0s idle", "0s thinking", "1.6s waiting", "2s thinking", "3.6s waiting", "4s thinking", "5.6s waiting"}, 3},
+		{"other prompt", `{` + patterns + `, "expect": [{"pattern": "Overwrite\\?", "text": "y"}]}`,
+			[]string{"0s idle", "0s thinking", "1.1s waiting", "2s thinking", "3.1s waiting", "4s thinking", "5.1s waiting"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := testfeed.NewClock()
+			rec := testfeed.NewRecorder(clock)
+			m := state.NewMonitor(config(t, tt.config), rec)
+			m.Clock = clock
+			var sent []string
+			m.Send = func(b []byte) { sent = append(sent, string(b)) }
+			testfeed.Play(m, clock, feed(t, "expect.feed"), 6*time.Second)
+			if got := changes(rec); !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if len(sent) != tt.sent {
+				t.Errorf("sent %q, want %d answers", sent, tt.sent)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package state
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strconv"
+
+	"github.com/f0i/status-light/internal/dbus"
+)
+
+// desktopNotify shows a desktop notification, on Linux through the
+// notification service on D-Bus and elsewhere with the platform's command
+// line tool. It is best effort: missing tools are silently ignored.
+func desktopNotify(title, message string) {
+	if DryRunf("desktop notification: %q %q", title, message) {
+		return
+	}
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", strconv.Quote(message), strconv.Quote(title))
+		cmd = exec.Command("osascript", "-e", script)
+	default:
+		if dbusNotify(title, message) == nil {
+			return
+		}
+		cmd = exec.Command("notify-send", "--app-name=sl", title, message)
+	}
+	_ = cmd.Run()
+}
+
+// dbusNotify calls org.freedesktop.Notifications.Notify on the session
+// bus.
+func dbusNotify(title, message string) error {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Call("org.freedesktop.Notifications", "/org/freedesktop/Notifications", "org.freedesktop.Notifications", "Notify",
+		"sl", uint32(0), "", title, message, []string{}, map[string]any{}, int32(-1))
+	return err
+}
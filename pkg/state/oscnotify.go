@@ -0,0 +1,106 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// OSCNotify reacts to the desktop notifications a tool sends through the
+// terminal with OSC 9 (iTerm2, Windows Terminal) or OSC 777 (urxvt, foot,
+// VTE), a signal that needs no patterns. Action is as for Bell: "waiting"
+// (the default), "notify" for a desktop notification from sl, or "both".
+// The sequences still reach the terminal sl runs in unless Reemit is
+// false.
+type OSCNotify struct {
+	Action string `json:"action"`
+	Reemit *bool  `json:"reemit"`
+}
+
+// Swallows reports whether notifications are kept from the terminal.
+func (o *OSCNotify) Swallows() bool {
+	return o != nil && o.Reemit != nil && !*o.Reemit
+}
+
+const (
+	maxOSC   = 4096 // longer OSC strings aren't notifications
+	maxNotes = 16   // notifications kept between two chunks
+)
+
+// conEmuOSC matches ConEmu's OSC 9 commands, such as 9;4 for progress,
+// which share the number with iTerm2's notifications.
+var conEmuOSC = regexp.MustCompile(`^[0-9]+(;|$)`)
+
+// oscNotification returns the message of an OSC string that is a desktop
+// notification: "9;message" or "777;notify;title;body".
+func oscNotification(payload string) (string, bool) {
+	if msg, ok := strings.CutPrefix(payload, "9;"); ok {
+		return msg, !conEmuOSC.MatchString(msg)
+	}
+	if rest, ok := strings.CutPrefix(payload, "777;notify;"); ok {
+		title, body, _ := strings.Cut(rest, ";")
+		switch {
+		case title == "":
+			return body, true
+		case body == "":
+			return title, true
+		}
+		return title + ": " + body, true
+	}
+	return "", false
+}
+
+// notified handles the notifications in the output fed to the screen
+// last.
+func (m *Monitor) notified(now time.Time) {
+	notes := m.Screen.Notifications()
+	if m.oscNotify == nil {
+		return
+	}
+	for _, msg := range notes {
+		if m.debug {
+			fmt.Fprintf(os.Stderr, "[DEBUG] Notification: %q\n", msg)
+		}
+		m.attention(now, m.oscNotify.Action, "notification", m.Label(), msg)
+	}
+}
+
+// NotificationFilter removes the notifications oscNotification knows
+// from output on its way to the terminal, also when a sequence is split
+// across chunks. Other escape sequences pass unchanged.
+type NotificationFilter struct {
+	held []byte // an OSC string read so far
+	out  []byte
+}
+
+// Filter returns p without notifications. The result is only valid until
+// the next call.
+func (f *NotificationFilter) Filter(p []byte) []byte {
+	f.out = f.out[:0]
+	for _, b := range p {
+		if len(f.held) == 0 {
+			if b == 0x1b {
+				f.held = append(f.held, b)
+			} else {
+				f.out = append(f.out, b)
+			}
+			continue
+		}
+		f.held = append(f.held, b)
+		n := len(f.held)
+		switch {
+		case n == 2 && b != ']', n > maxOSC:
+			f.out = append(f.out, f.held...)
+			f.held = f.held[:0]
+		case n > 2 && b == 0x07, n > 3 && b == '\\' && f.held[n-2] == 0x1b:
+			payload := strings.TrimSuffix(strings.TrimSuffix(string(f.held[2:]), "\a"), "\x1b\\")
+			if _, ok := oscNotification(payload); !ok {
+				f.out = append(f.out, f.held...)
+			}
+			f.held = f.held[:0]
+		}
+	}
+	return f.out
+}
@@ -0,0 +1,55 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// PatternList is a list of regular expressions. In configs an entry may
+// also be an object, so prompts can be added without escaping them:
+//
+//	{"text": "(y/n)", "literal": true, "ignore_case": true}
+//
+// Objects are turned into the equivalent regular expression when loading.
+type PatternList []string
+
+// patternObject is the object form of a pattern.
+type patternObject struct {
+	Text       string `json:"text"`
+	Literal    bool   `json:"literal"`
+	IgnoreCase bool   `json:"ignore_case"`
+}
+
+func (o patternObject) regexp() string {
+	p := o.Text
+	if o.Literal {
+		p = regexp.QuoteMeta(p)
+	}
+	if o.IgnoreCase {
+		p = "(?i)" + p
+	}
+	return p
+}
+
+func (l *PatternList) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	out := make(PatternList, 0, len(raw))
+	for _, r := range raw {
+		var p string
+		if err := json.Unmarshal(r, &p); err == nil {
+			out = append(out, p)
+			continue
+		}
+		var o patternObject
+		if err := json.Unmarshal(r, &o); err != nil {
+			return fmt.Errorf("pattern must be a string or {\"text\": ...}: %s", r)
+		}
+		out = append(out, o.regexp())
+	}
+	*l = out
+	return nil
+}
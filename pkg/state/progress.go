@@ -0,0 +1,94 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// Progress follows a task runner's place in its queue, such as "task 3/10"
+// from a batch of agent runs. Unlike the progress of a thinking phase, it
+// is read in every state and kept across state changes, so the bar picks
+// up where it was when the next task starts thinking. Pattern replaces the
+// built-in one: two groups are the tasks done and the total, one group is
+// a percentage.
+type Progress struct {
+	Pattern string `json:"pattern"`
+}
+
+// queuePattern matches the counters task runners print.
+const queuePattern = `(?i)\b(?:task|job|item|step|run)\s+(\d+)\s*(?:/|of)\s*(\d+)\b`
+
+// newQueue compiles the pattern of p.
+func newQueue(p Progress) (*regexp.Regexp, error) {
+	pattern := p.Pattern
+	if pattern == "" {
+		pattern = queuePattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if n := re.NumSubexp(); n != 1 && n != 2 {
+		return nil, fmt.Errorf("progress pattern needs one group for a percentage or two for a count, has %d", n)
+	}
+	return re, nil
+}
+
+var (
+	percentRe  = regexp.MustCompile(`(\d{1,3}(?:\.\d+)?)\s?%`)
+	fractionRe = regexp.MustCompile(`\b(\d+)\s*(?:/|of)\s*(\d+)\b`)
+)
+
+// parseProgress extracts the most recent progress indicator from output:
+// a percentage ("42%") or a count ("3/10", "3 of 10"). It returns a value
+// between 0 and 1.
+func parseProgress(data []byte) (float64, bool) {
+	best, bestPos := 0.0, -1
+	for _, m := range percentRe.FindAllSubmatchIndex(data, -1) {
+		p, err := strconv.ParseFloat(string(data[m[2]:m[3]]), 64)
+		if err == nil && p <= 100 && m[0] > bestPos {
+			best, bestPos = p/100, m[0]
+		}
+	}
+	for _, m := range fractionRe.FindAllSubmatchIndex(data, -1) {
+		x, err1 := strconv.Atoi(string(data[m[2]:m[3]]))
+		y, err2 := strconv.Atoi(string(data[m[4]:m[5]]))
+		// Ignore things like dates and "0/0" that don't look like counts
+		if err1 == nil && err2 == nil && y >= 2 && x <= y && m[0] > bestPos {
+			best, bestPos = float64(x)/float64(y), m[0]
+		}
+	}
+	return best, bestPos >= 0
+}
+
+// matchQueue takes the last count in data as the queue's progress.
+func (m *Monitor) matchQueue(data []byte) {
+	matches := m.queue.FindAllSubmatch(data, -1)
+	if len(matches) == 0 {
+		return
+	}
+	match := matches[len(matches)-1]
+	x, err := strconv.ParseFloat(string(match[1]), 64)
+	if err != nil {
+		return
+	}
+	p := x / 100
+	if len(match) > 2 {
+		y, err := strconv.ParseFloat(string(match[2]), 64)
+		if err != nil || y <= 0 {
+			return
+		}
+		p = x / y
+	}
+	p = min(max(p, 0), 1)
+	if p == m.Progress {
+		return
+	}
+	if m.debug {
+		fmt.Fprintf(os.Stderr, "[DEBUG] Queue progress: %q (%.2f)\n", match[0], p)
+	}
+	m.Progress = p
+	m.led.SetProgress(p)
+}
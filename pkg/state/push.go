@@ -0,0 +1,171 @@
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Push sends a phone notification through ntfy, Pushover or the Home
+// Assistant app when the tool starts waiting, with the prompt line as the
+// message, and with Errors also when it hits an error. MinThinkingMs skips
+// prompts that follow less thinking than that, so quick back and forth at
+// the keyboard doesn't buzz the phone. Watch sends them with high priority,
+// which makes a paired smartwatch vibrate instead of staying silent.
+type Push struct {
+	Ntfy          *Ntfy     `json:"ntfy"`
+	Pushover      *Pushover `json:"pushover"`
+	MinThinkingMs int       `json:"min_thinking_ms"`
+
+	HomeAssistant *HomeAssistant `json:"home_assistant"`
+	Errors        bool           `json:"errors"`
+	Watch         bool           `json:"watch"`
+}
+
+// Ntfy publishes to a topic on ntfy.sh or a self-hosted server.
+type Ntfy struct {
+	Server string `json:"server"` // default https://ntfy.sh
+	Topic  string `json:"topic"`
+	Token  string `json:"token"` // access token for protected topics
+}
+
+// Pushover sends through the Pushover API with an application token and
+// a user key.
+type Pushover struct {
+	Token string `json:"token"`
+	User  string `json:"user"`
+}
+
+// HomeAssistant sends through a notify service of Home Assistant, such as
+// the one of its mobile app on a phone or watch. URL is the address of the
+// Home Assistant instance and Token a long-lived access token.
+type HomeAssistant struct {
+	URL     string `json:"url"`
+	Token   string `json:"token"`
+	Service string `json:"service"` // e.g. mobile_app_pixel_watch
+}
+
+// sendPush notifies about a new prompt or error after thought of thinking.
+func (m *Monitor) sendPush(st State, thought time.Duration) {
+	p := m.push
+	// The daemon's profile can send them elsewhere
+	if o, ok := m.led.(interface{ Push() *Push }); ok && o.Push() != nil {
+		p = o.Push()
+	}
+	if p == nil || st == Error && !p.Errors || thought < time.Duration(p.MinThinkingMs)*time.Millisecond || m.Suppressed() {
+		return
+	}
+	title, message := m.Label()+" is waiting", "waiting for input"
+	if st == Error {
+		title, message = m.Label()+" hit an error", "error"
+	}
+	if m.Label() == "" {
+		title = "Waiting for input"
+		if st == Error {
+			title = "Error"
+		}
+	}
+	if lines := m.Screen.Lines(); len(lines) > 0 {
+		message = strings.TrimSpace(lines[len(lines)-1])
+	}
+	if p.Ntfy != nil && p.Ntfy.Topic != "" {
+		go publishNtfy(*p.Ntfy, title, message, p.Watch)
+	}
+	if p.Pushover != nil && p.Pushover.Token != "" {
+		go publishPushover(*p.Pushover, title, message, p.Watch)
+	}
+	if p.HomeAssistant != nil && p.HomeAssistant.Service != "" {
+		go publishHomeAssistant(*p.HomeAssistant, title, message, p.Watch)
+	}
+}
+
+func publishNtfy(n Ntfy, title, message string, watch bool) {
+	server := n.Server
+	if server == "" {
+		server = "https://ntfy.sh"
+	}
+	req, err := http.NewRequest("POST", strings.TrimRight(server, "/")+"/"+n.Topic, strings.NewReader(message))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Title", title)
+	req.Header.Set("Tags", "bell")
+	if watch {
+		// High priority vibrates longer, also on a watch
+		req.Header.Set("Priority", "high")
+		req.Header.Set("Tags", "bell,watch")
+	}
+	if n.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+	doPush("ntfy", req)
+}
+
+func publishPushover(p Pushover, title, message string, watch bool) {
+	form := url.Values{
+		"token":   {p.Token},
+		"user":    {p.User},
+		"title":   {title},
+		"message": {message},
+	}
+	if watch {
+		// Priority 1 alerts and vibrates even in the device's quiet hours
+		form.Set("priority", "1")
+	}
+	req, err := http.NewRequest("POST", "https://api.pushover.net/1/messages.json", strings.NewReader(form.Encode()))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	doPush("Pushover", req)
+}
+
+func publishHomeAssistant(h HomeAssistant, title, message string, watch bool) {
+	msg := map[string]any{"title": title, "message": message}
+	if watch {
+		msg["data"] = map[string]any{
+			// Android and Wear OS: deliver at once and vibrate
+			"ttl":              0,
+			"priority":         "high",
+			"vibrationPattern": "100, 500, 100, 500",
+			// iOS and watchOS: break through focus modes
+			"push": map[string]any{"interruption-level": "time-sensitive"},
+		}
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest("POST", strings.TrimRight(h.URL, "/")+"/api/services/notify/"+h.Service, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+h.Token)
+	doPush("Home Assistant", req)
+}
+
+// doPush performs req. Like webhooks, failures are only reported in
+// debug mode.
+func doPush(service string, req *http.Request) {
+	if dryRunRequest(req) {
+		return
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err == nil {
+		resp.Body.Close()
+	}
+	if os.Getenv("DEBUG_SL") != "" {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[DEBUG] %s push failed: %v\n", service, err)
+		} else {
+			fmt.Fprintf(os.Stderr, "[DEBUG] %s push: %s\n", service, resp.Status)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package state
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Sampling caps the time spent matching patterns on commands that print
+// megabytes per second. With Every set to N, only every Nth chunk of
+// output is matched. With Lines set to N, patterns only see the last N
+// complete lines of a chunk, assembled across chunk borders; a carriage
+// return ends a line too, so redrawn spinners still count. The screen
+// model, and with it the waiting scan, always gets everything.
+type Sampling struct {
+	Every int `json:"every"`
+	Lines int `json:"lines"`
+}
+
+// maxPartialLine is how much of an unfinished line is kept for the next
+// chunk.
+const maxPartialLine = 1024
+
+// coverageInterval is how often the debug output reports how much of the
+// output was analyzed.
+const coverageInterval = 10 * time.Second
+
+// sampler picks the output of one stream that is matched.
+type sampler struct {
+	Sampling
+	chunks  int
+	partial []byte // unfinished last line
+}
+
+// sample returns the part of data to match, or nil to skip it.
+func (s *sampler) sample(data []byte) []byte {
+	if s.Every > 1 {
+		s.chunks++
+		if s.chunks%s.Every != 0 {
+			s.partial = s.partial[:0]
+			return nil
+		}
+	}
+	if s.Lines <= 0 {
+		return data
+	}
+	buf := append(s.partial, data...)
+	end := bytes.LastIndexAny(buf, "\r\n")
+	if end < 0 {
+		s.partial = keepTail(buf)
+		return nil
+	}
+	// Go back over the last Lines line ends before end
+	start := 0
+	for i, n := end-1, 0; i >= 0; i-- {
+		if buf[i] == '\n' || buf[i] == '\r' {
+			if n++; n == s.Lines {
+				start = i + 1
+				break
+			}
+		}
+	}
+	out := bytes.Clone(buf[start : end+1])
+	s.partial = keepTail(append(s.partial[:0], buf[end+1:]...))
+	return out
+}
+
+// keepTail shortens an unfinished line to maxPartialLine bytes.
+func keepTail(b []byte) []byte {
+	if len(b) > maxPartialLine {
+		b = append(b[:0], b[len(b)-maxPartialLine:]...)
+	}
+	return b
+}
+
+// sample applies the sampling of stream to data and counts the coverage.
+func (m *Monitor) sample(data []byte, stream string) []byte {
+	if m.sampling == nil {
+		return data
+	}
+	s := m.samplers[stream]
+	if s == nil {
+		s = &sampler{Sampling: *m.sampling}
+		m.samplers[stream] = s
+	}
+	out := s.sample(data)
+	m.fedBytes += int64(len(data))
+	m.analyzedBytes += int64(len(out))
+	return out
+}
+
+// reportCoverage prints how much of the output was matched against
+// patterns since the last report.
+func (m *Monitor) reportCoverage(now time.Time) {
+	if !m.debug || m.sampling == nil || now.Sub(m.coverageSince) < coverageInterval {
+		return
+	}
+	if m.fedBytes > 0 {
+		fmt.Fprintf(os.Stderr, "[DEBUG] Sampling: analyzed %d of %d bytes (%.1f%%) in %s\n",
+			m.analyzedBytes, m.fedBytes, 100*float64(m.analyzedBytes)/float64(m.fedBytes), now.Sub(m.coverageSince).Round(time.Second))
+	}
+	m.fedBytes, m.analyzedBytes, m.coverageSince = 0, 0, now
+}
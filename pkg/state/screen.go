@@ -0,0 +1,478 @@
+package state
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Screen is a minimal VT100-style terminal model. It applies the escape
+// sequences that move the cursor or erase text so pattern matching sees what
+// is actually visible instead of everything that was ever printed.
+type Screen struct {
+	// AutoCR treats a bare line feed as CR LF, for output that did not pass
+	// through a PTY line discipline.
+	AutoCR bool
+
+	width, height int
+	cells         [][]rune
+	row, col      int
+	savedRow      int
+	savedCol      int
+	scrollTop     int
+	scrollBottom  int
+
+	// Main screen contents while the alternate screen is active
+	altSaved [][]rune
+	altRow   int
+	altCol   int
+
+	state  int
+	params []byte
+	pend   []byte // partial UTF-8 sequence
+
+	// The bell rang on the main or the alternate screen
+	bell, altBell bool
+	// The OSC string being read, and the notifications it sent
+	osc   []byte
+	inOSC bool
+	notes []string
+}
+
+const (
+	stGround = iota
+	stEscape
+	stCSI
+	stOSC
+	stOSCEscape
+	stCharset
+)
+
+func NewScreen(width, height int) *Screen {
+	if width <= 0 {
+		width = 80
+	}
+	if height <= 0 {
+		height = 24
+	}
+	s := &Screen{width: width, height: height}
+	s.cells = s.blank(height)
+	s.scrollBottom = height - 1
+	return s
+}
+
+func (s *Screen) blank(n int) [][]rune {
+	rows := make([][]rune, n)
+	for i := range rows {
+		rows[i] = s.blankRow()
+	}
+	return rows
+}
+
+func (s *Screen) blankRow() []rune {
+	r := make([]rune, s.width)
+	for i := range r {
+		r[i] = ' '
+	}
+	return r
+}
+
+// Resize changes the screen dimensions, keeping the bottom of the content
+// anchored like most terminals do.
+func (s *Screen) Resize(width, height int) {
+	if width <= 0 || height <= 0 || (width == s.width && height == s.height) {
+		return
+	}
+	resize := func(rows [][]rune) [][]rune {
+		if rows == nil {
+			return nil
+		}
+		if len(rows) > height {
+			rows = rows[len(rows)-height:]
+		}
+		out := s.blank(height)
+		for i, r := range rows {
+			copy(out[i], r)
+		}
+		return out
+	}
+	shift := 0
+	if len(s.cells) > height {
+		shift = len(s.cells) - height
+	}
+	s.width, s.height = width, height
+	s.cells = resize(s.cells)
+	s.altSaved = resize(s.altSaved)
+	s.row = clamp(s.row-shift, 0, height-1)
+	s.col = clamp(s.col, 0, width-1)
+	// Saved cursors must fit too, or restoring one indexes past the rows
+	s.savedRow = clamp(s.savedRow-shift, 0, height-1)
+	s.savedCol = clamp(s.savedCol, 0, width-1)
+	s.altRow = clamp(s.altRow-shift, 0, height-1)
+	s.altCol = clamp(s.altCol, 0, width-1)
+	s.scrollTop = 0
+	s.scrollBottom = height - 1
+}
+
+// Write feeds raw terminal output into the model.
+func (s *Screen) Write(p []byte) (int, error) {
+	for i := 0; i < len(p); i++ {
+		b := p[i]
+		switch s.state {
+		case stGround:
+			s.ground(b)
+		case stEscape:
+			s.escape(b)
+		case stCSI:
+			if b >= 0x40 && b <= 0x7e {
+				s.csi(b)
+				s.state = stGround
+			} else if b == 0x1b {
+				s.state = stEscape
+			} else {
+				s.params = append(s.params, b)
+			}
+		case stOSC:
+			if b == 0x07 {
+				s.state = stGround
+				s.endOSC()
+			} else if b == 0x1b {
+				s.state = stOSCEscape
+			} else if s.inOSC && len(s.osc) < maxOSC {
+				s.osc = append(s.osc, b)
+			}
+		case stOSCEscape:
+			// ESC \ terminates the string; anything else aborts it
+			s.state = stGround
+			if b == '\\' {
+				s.endOSC()
+			} else {
+				s.escape(b)
+			}
+		case stCharset:
+			s.state = stGround
+		}
+	}
+	return len(p), nil
+}
+
+func (s *Screen) ground(b byte) {
+	if len(s.pend) > 0 || b >= 0x80 {
+		s.pend = append(s.pend, b)
+		if !utf8.FullRune(s.pend) {
+			return
+		}
+		r, _ := utf8.DecodeRune(s.pend)
+		s.pend = s.pend[:0]
+		s.put(r)
+		return
+	}
+	switch b {
+	case 0x1b:
+		s.state = stEscape
+	case '\r':
+		s.col = 0
+	case '\n', 0x0b, 0x0c:
+		if s.AutoCR {
+			s.col = 0
+		}
+		s.lineFeed()
+	case '\b':
+		if s.col > 0 {
+			s.col--
+		}
+	case '\t':
+		s.col = min((s.col/8+1)*8, s.width-1)
+	case 0x07:
+		if s.AltScreen() {
+			s.altBell = true
+		} else {
+			s.bell = true
+		}
+	default:
+		if b >= 0x20 && b != 0x7f {
+			s.put(rune(b))
+		}
+	}
+}
+
+func (s *Screen) escape(b byte) {
+	s.state = stGround
+	switch b {
+	case '[':
+		s.state = stCSI
+		s.params = s.params[:0]
+	case ']', 'P', '_', '^', 'X':
+		// OSC, DCS, APC, PM and SOS strings are skipped, except for
+		// notifications
+		s.state = stOSC
+		s.inOSC = b == ']'
+		s.osc = s.osc[:0]
+	case '(', ')', '*', '+', '#':
+		s.state = stCharset
+	case '7':
+		s.savedRow, s.savedCol = s.row, s.col
+	case '8':
+		s.row, s.col = s.savedRow, s.savedCol
+	case 'D':
+		s.lineFeed()
+	case 'E':
+		s.col = 0
+		s.lineFeed()
+	case 'M':
+		if s.row == s.scrollTop {
+			s.scrollDown(1)
+		} else if s.row > 0 {
+			s.row--
+		}
+	case 'c':
+		autoCR := s.AutoCR
+		*s = *NewScreen(s.width, s.height)
+		s.AutoCR = autoCR
+	}
+}
+
+func (s *Screen) put(r rune) {
+	if s.col >= s.width {
+		s.col = 0
+		s.lineFeed()
+	}
+	s.cells[s.row][s.col] = r
+	s.col++
+}
+
+func (s *Screen) lineFeed() {
+	if s.row == s.scrollBottom {
+		s.scrollUp(1)
+	} else if s.row < s.height-1 {
+		s.row++
+	}
+}
+
+// scrollUp scrolls the region up by n lines. More lines than the region
+// has only clear it, so n is capped there; a program asking for millions
+// doesn't stall sl.
+func (s *Screen) scrollUp(n int) {
+	for n = min(n, s.scrollBottom-s.scrollTop+1); n > 0; n-- {
+		copy(s.cells[s.scrollTop:s.scrollBottom+1], s.cells[s.scrollTop+1:s.scrollBottom+1])
+		s.cells[s.scrollBottom] = s.blankRow()
+	}
+}
+
+func (s *Screen) scrollDown(n int) {
+	for n = min(n, s.scrollBottom-s.scrollTop+1); n > 0; n-- {
+		copy(s.cells[s.scrollTop+1:s.scrollBottom+1], s.cells[s.scrollTop:s.scrollBottom])
+		s.cells[s.scrollTop] = s.blankRow()
+	}
+}
+
+func (s *Screen) csi(final byte) {
+	// "?" starts DEC modes; ">", "=" and "<" start other private
+	// sequences, such as keyboard protocol requests, that must not be
+	// mistaken for cursor movement
+	private := len(s.params) > 0 && strings.IndexByte("?>=<", s.params[0]) >= 0
+	args := parseParams(s.params)
+	arg := func(i, def int) int {
+		if i < len(args) && args[i] > 0 {
+			return args[i]
+		}
+		return def
+	}
+
+	if private {
+		if s.params[0] == '?' && (final == 'h' || final == 'l') {
+			for _, a := range args {
+				if a == 1049 || a == 1047 || a == 47 {
+					s.setAltScreen(final == 'h')
+				}
+			}
+		}
+		return
+	}
+
+	switch final {
+	case 'A':
+		s.row = clamp(s.row-arg(0, 1), 0, s.height-1)
+	case 'B', 'e':
+		s.row = clamp(s.row+arg(0, 1), 0, s.height-1)
+	case 'C', 'a':
+		s.col = clamp(s.col+arg(0, 1), 0, s.width-1)
+	case 'D':
+		s.col = clamp(s.col-arg(0, 1), 0, s.width-1)
+	case 'E':
+		s.row = clamp(s.row+arg(0, 1), 0, s.height-1)
+		s.col = 0
+	case 'F':
+		s.row = clamp(s.row-arg(0, 1), 0, s.height-1)
+		s.col = 0
+	case 'G', '`':
+		s.col = clamp(arg(0, 1)-1, 0, s.width-1)
+	case 'd':
+		s.row = clamp(arg(0, 1)-1, 0, s.height-1)
+	case 'H', 'f':
+		s.row = clamp(arg(0, 1)-1, 0, s.height-1)
+		s.col = clamp(arg(1, 1)-1, 0, s.width-1)
+	case 'J':
+		switch arg(0, 0) {
+		case 0:
+			s.eraseLine(s.row, s.col, s.width)
+			for r := s.row + 1; r < s.height; r++ {
+				s.cells[r] = s.blankRow()
+			}
+		case 1:
+			for r := 0; r < s.row; r++ {
+				s.cells[r] = s.blankRow()
+			}
+			s.eraseLine(s.row, 0, s.col+1)
+		case 2, 3:
+			s.cells = s.blank(s.height)
+		}
+	case 'K':
+		switch arg(0, 0) {
+		case 0:
+			s.eraseLine(s.row, s.col, s.width)
+		case 1:
+			s.eraseLine(s.row, 0, s.col+1)
+		case 2:
+			s.eraseLine(s.row, 0, s.width)
+		}
+	case 'X':
+		s.eraseLine(s.row, s.col, s.col+arg(0, 1))
+	case 'P':
+		line := s.cells[s.row]
+		n := min(arg(0, 1), s.width-s.col)
+		copy(line[s.col:], line[s.col+n:])
+		s.eraseLine(s.row, s.width-n, s.width)
+	case '@':
+		line := s.cells[s.row]
+		n := min(arg(0, 1), s.width-s.col)
+		copy(line[s.col+n:], line[s.col:s.width-n])
+		s.eraseLine(s.row, s.col, s.col+n)
+	case 'L':
+		if s.row >= s.scrollTop && s.row <= s.scrollBottom {
+			top := s.scrollTop
+			s.scrollTop = s.row
+			s.scrollDown(arg(0, 1))
+			s.scrollTop = top
+		}
+	case 'M':
+		if s.row >= s.scrollTop && s.row <= s.scrollBottom {
+			top := s.scrollTop
+			s.scrollTop = s.row
+			s.scrollUp(arg(0, 1))
+			s.scrollTop = top
+		}
+	case 'S':
+		s.scrollUp(arg(0, 1))
+	case 'T':
+		s.scrollDown(arg(0, 1))
+	case 'r':
+		top := clamp(arg(0, 1)-1, 0, s.height-1)
+		bottom := clamp(arg(1, s.height)-1, 0, s.height-1)
+		if top < bottom {
+			s.scrollTop, s.scrollBottom = top, bottom
+			s.row, s.col = 0, 0
+		}
+	case 's':
+		s.savedRow, s.savedCol = s.row, s.col
+	case 'u':
+		s.row, s.col = s.savedRow, s.savedCol
+	}
+}
+
+func (s *Screen) setAltScreen(on bool) {
+	if on && s.altSaved == nil {
+		s.altSaved = s.cells
+		s.altRow, s.altCol = s.row, s.col
+		s.cells = s.blank(s.height)
+		s.row, s.col = 0, 0
+	} else if !on && s.altSaved != nil {
+		s.cells = s.altSaved
+		s.row, s.col = s.altRow, s.altCol
+		s.altSaved = nil
+	}
+}
+
+// AltScreen reports whether a full-screen program switched to the
+// alternate screen.
+func (s *Screen) AltScreen() bool {
+	return s.altSaved != nil
+}
+
+// Bells reports whether the bell rang on the main and on the alternate
+// screen since the last call. A BEL ending an OSC string doesn't count.
+func (s *Screen) Bells() (main, alt bool) {
+	main, alt = s.bell, s.altBell
+	s.bell, s.altBell = false, false
+	return main, alt
+}
+
+// endOSC notes a notification in the OSC string just read.
+func (s *Screen) endOSC() {
+	if !s.inOSC {
+		return
+	}
+	if msg, ok := oscNotification(string(s.osc)); ok && len(s.notes) < maxNotes {
+		s.notes = append(s.notes, msg)
+	}
+}
+
+// Notifications returns the desktop notifications sent since the last
+// call, see oscNotification.
+func (s *Screen) Notifications() []string {
+	notes := s.notes
+	s.notes = nil
+	return notes
+}
+
+func (s *Screen) eraseLine(row, from, to int) {
+	from = clamp(from, 0, s.width)
+	to = clamp(to, 0, s.width)
+	line := s.cells[row]
+	for i := from; i < to; i++ {
+		line[i] = ' '
+	}
+}
+
+// Lines returns the visible rows with trailing blanks removed. Empty rows
+// below the last written line are dropped.
+func (s *Screen) Lines() []string {
+	lines := make([]string, len(s.cells))
+	last := -1
+	for i, r := range s.cells {
+		lines[i] = strings.TrimRight(string(r), " ")
+		if lines[i] != "" {
+			last = i
+		}
+	}
+	return lines[:last+1]
+}
+
+func (s *Screen) String() string {
+	return strings.Join(s.Lines(), "\n")
+}
+
+func parseParams(p []byte) []int {
+	if len(p) == 0 {
+		return nil
+	}
+	parts := strings.Split(strings.TrimLeft(string(p), "?>=<"), ";")
+	args := make([]int, len(parts))
+	for i, part := range parts {
+		if j := strings.IndexByte(part, ':'); j >= 0 {
+			part = part[:j]
+		}
+		args[i], _ = strconv.Atoi(part)
+	}
+	return args
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
@@ -0,0 +1,121 @@
+package state_test
+
+import (
+	"testing"
+
+	"github.com/f0i/status-light/pkg/state"
+)
+
+func TestScreen(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		// Cursor movement
+		{"carriage return", "Running\rDone", "Doneing"},
+		{"spinner", "⠋ Thinking\r⠙ Thinking\r\x1b[2K✓ Done", "✓ Done"},
+		{"backspace", "ab\bc", "ac"},
+		{"tab", "a\tb", "a       b"},
+		{"absolute position", "\x1b[2;3Hx\x1b[Hy", "y\n  x"},
+		{"up and back", "one\r\ntwo\x1b[A\rONE", "ONE\ntwo"},
+		{"column", "abcdef\x1b[3GX", "abXdef"},
+		{"clamped to the screen", "\x1b[99;99Hx\x1b[99A\x1b[99Dy", "y\n\n\n         x"},
+		{"save and restore", "\x1b7ab\r\n\x1b8X", "Xb"},
+		{"csi save and restore", "\x1b[2;2H\x1b[s\x1b[Hx\x1b[uy", "x\n y"},
+		{"wrap", "0123456789ab", "0123456789\nab"},
+
+		// Erasing
+		{"erase to end of line", "Go? (y/n)\r\x1b[3C\x1b[K", "Go?"},
+		{"erase to start of line", "abcdef\x1b[3G\x1b[1K", "   def"},
+		{"erase line", "abc\x1b[2K", ""},
+		{"erase below", "one\r\ntwo\r\nthree\x1b[2;2H\x1b[J", "one\nt"},
+		{"erase above", "one\r\ntwo\r\nthree\x1b[2;2H\x1b[1J", "\n  o\nthree"},
+		{"erase screen", "one\r\ntwo\x1b[2J", ""},
+		{"erase characters", "abcdef\x1b[2G\x1b[2X", "a  def"},
+		{"delete characters", "abcdef\x1b[2G\x1b[2P", "adef"},
+		{"insert characters", "abcdef\x1b[2G\x1b[2@", "a  bcdef"},
+
+		// Scrolling
+		{"scroll at the bottom", "1\r\n2\r\n3\r\n4\r\n5", "2\n3\n4\n5"},
+		{"scroll up", "1\r\n2\r\n3\r\n4\x1b[S", "2\n3\n4"},
+		{"scroll down", "1\r\n2\r\n3\r\n4\x1b[T", "\n1\n2\n3"},
+		{"scroll region", "top\x1b[2;3r\x1b[2;1Ha\r\nb\r\nc\x1b[4;1Hbottom", "top\nb\nc\nbottom"},
+		{"insert line", "1\r\n2\r\n3\x1b[2H\x1b[L", "1\n\n2\n3"},
+		{"delete line", "1\r\n2\r\n3\x1b[2H\x1b[M", "1\n3"},
+		{"reverse index at the top", "1\r\n2\x1b[H\x1bM0", "0\n1\n2"},
+		{"huge scroll count", "1\r\n2\x1b[999999999S", ""},
+
+		// Private sequences aren't cursor movement
+		{"keyboard protocol", "ab\x1b[>1u\x1b[<u\x1b[=1;1uc", "abc"},
+		{"alternate screen", "main\x1b[?1049hfull\x1b[?1049l", "main"},
+		{"charset", "\x1b(Bok", "ok"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := state.NewScreen(10, 4)
+			s.Write([]byte(tt.output))
+			if got := s.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScreenSplitWrites(t *testing.T) {
+	// Sequences and characters cut between reads
+	output := "\x1b[2;3Hé\x1b[1K✓"
+	whole := state.NewScreen(10, 4)
+	whole.Write([]byte(output))
+	split := state.NewScreen(10, 4)
+	for i := range len(output) {
+		split.Write([]byte{output[i]})
+	}
+	if whole.String() != split.String() {
+		t.Errorf("byte by byte %q, at once %q", split.String(), whole.String())
+	}
+}
+
+func TestScreenResize(t *testing.T) {
+	tests := []struct {
+		name          string
+		before        string
+		width, height int
+		after         string
+		want          string
+	}{
+		// The bottom stays where it was
+		{"shorter", "1\r\n2\r\n3\r\n4", 10, 2, "x", "3\n4x"},
+		{"narrower", "0123456789", 4, 4, "\rx", "x123"},
+		{"taller", "1\r\n2", 10, 6, "x", "1\n2x"},
+		// Cursors saved below or right of the new size are clamped, and
+		// restoring them doesn't index past the rows
+		{"saved cursor", "\x1b[4;10H\x1b7", 5, 2, "\x1b8x", "\n    x"},
+		{"csi saved cursor", "\x1b[4;10H\x1b[s", 5, 2, "\x1b[ux", "\n    x"},
+		{"alternate screen cursor", "\x1b[4;10H\x1b[?1049h", 5, 2, "\x1b[?1049lx", "\n    x"},
+		{"scroll region", "\x1b[2;3r", 10, 2, "\x1b[2H1\r\n2", "1\n2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := state.NewScreen(10, 4)
+			s.Write([]byte(tt.before))
+			s.Resize(tt.width, tt.height)
+			s.Write([]byte(tt.after))
+			if got := s.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if n := len(s.Lines()); n > tt.height {
+				t.Errorf("%d lines on a screen of %d", n, tt.height)
+			}
+		})
+	}
+}
+
+func TestScreenAutoCR(t *testing.T) {
+	s := state.NewScreen(10, 4)
+	s.AutoCR = true
+	s.Write([]byte("one\ntwo"))
+	if got := s.String(); got != "one\ntwo" {
+		t.Errorf("got %q, want %q", got, "one\ntwo")
+	}
+}
@@ -0,0 +1,107 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Sequence recognizes a prompt by what came before it, for tools that
+// print a banner and then an input line no single pattern can tell from
+// other output. Once the output has matched the After patterns in order,
+// the next silence with a prompt-looking last line on screen means State.
+// After that prompt is gone, the banner has to come again.
+type Sequence struct {
+	After  PatternList `json:"after"`
+	Prompt string      `json:"prompt"` // default promptPattern
+	State  string      `json:"state"`  // default "waiting"
+}
+
+// promptPattern is a short line ending like a shell or REPL prompt.
+const promptPattern = `^.{0,40}[>$#%:?»❯›]\s*$`
+
+// sequence is a Sequence as a small state machine.
+type sequence struct {
+	after  []*regexp.Regexp
+	prompt *regexp.Regexp
+	state  State
+	step   int  // patterns of after matched so far
+	fired  bool // the prompt has been shown as state
+}
+
+func newSequence(s Sequence) (*sequence, error) {
+	if len(s.After) == 0 {
+		return nil, fmt.Errorf("sequence needs \"after\" patterns")
+	}
+	q := &sequence{state: Waiting}
+	for _, p := range s.After {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		q.after = append(q.after, re)
+	}
+	prompt := s.Prompt
+	if prompt == "" {
+		prompt = promptPattern
+	}
+	var err error
+	if q.prompt, err = regexp.Compile(prompt); err != nil {
+		return nil, err
+	}
+	if s.State != "" {
+		var ok bool
+		if q.state, ok = ParseState(s.State); !ok {
+			return nil, fmt.Errorf("unknown state %q", s.State)
+		}
+	}
+	return q, nil
+}
+
+// feed advances the sequence through the patterns data matches, in order.
+func (q *sequence) feed(data []byte) {
+	for q.step < len(q.after) {
+		loc := q.after[q.step].FindIndex(data)
+		if loc == nil {
+			return
+		}
+		data = data[loc[1]:]
+		q.step++
+	}
+}
+
+// sequenceState checks the sequences that have seen their banner against
+// the last line on screen during silence, and returns the state of the
+// first one showing its prompt.
+func (m *Monitor) sequenceState(lines []string, now time.Time) (State, bool) {
+	last := ""
+	for i := len(lines) - 1; i >= 0; i-- {
+		if strings.TrimSpace(lines[i]) != "" {
+			last = lines[i]
+			break
+		}
+	}
+	for _, q := range m.sequences {
+		if q.step < len(q.after) {
+			continue
+		}
+		if !q.prompt.MatchString(last) {
+			if q.fired {
+				// The prompt was answered, wait for the banner again
+				q.step, q.fired = 0, false
+			}
+			continue
+		}
+		if !q.fired && m.debug {
+			fmt.Fprintf(os.Stderr, "[DEBUG] Sequence ended in prompt %q: %s\n", last, q.state)
+		}
+		if m.State != q.state {
+			m.observe(Event{Time: now, State: q.state, Pattern: q.prompt.String(), Stream: "screen"})
+		}
+		q.fired = true
+		return q.state, true
+	}
+	return Idle, false
+}
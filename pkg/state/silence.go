@@ -0,0 +1,91 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Silence tunes how long the output must pause before the monitor decides
+// between Waiting and Idle, beyond the one idle_threshold_ms. StatesMs
+// sets the pause per state the tool is in, e.g. a longer one while it
+// thinks. Adaptive learns the usual gap between chunks of output while
+// the tool thinks and raises the threshold to a few of those gaps, so a
+// tool that streams slowly isn't taken for one that stopped; MaxMs caps
+// what it learns.
+type Silence struct {
+	StatesMs map[string]int `json:"states_ms"`
+	Adaptive bool           `json:"adaptive"`
+	MaxMs    int            `json:"max_ms"` // default 5s
+}
+
+const (
+	// adaptiveGaps is how many usual gaps make a silence
+	adaptiveGaps = 4
+	// adaptiveSamples are needed before the learned gap is used
+	adaptiveSamples    = 20
+	defaultAdaptiveMax = 5 * time.Second
+)
+
+// silenceFor returns how long the output must pause in st.
+func (m *Monitor) silenceFor(st State) time.Duration {
+	threshold := m.silence
+	if d, ok := m.silences[st]; ok {
+		threshold = d
+	}
+	if m.adaptiveMax > 0 && m.gapSamples >= adaptiveSamples {
+		learned := min(m.gap*adaptiveGaps, m.adaptiveMax)
+		threshold = max(threshold, learned)
+	}
+	return threshold
+}
+
+// learnGap records the pause before a chunk of output that came in st
+// while the tool thinks. Longer pauses would have ended the thinking, so every gap seen
+// here is shorter than the threshold.
+func (m *Monitor) learnGap(st State, now time.Time) {
+	if m.adaptiveMax == 0 || st != Thinking || m.lastOutputTime.IsZero() {
+		return
+	}
+	gap := now.Sub(m.lastOutputTime)
+	if gap <= 0 {
+		return
+	}
+	if m.gapSamples == 0 {
+		m.gap = gap
+	} else {
+		// Moving average, so the tool's current pace counts most
+		m.gap += (gap - m.gap) / 10
+	}
+	m.gapSamples++
+	if m.debug && m.gapSamples == adaptiveSamples {
+		fmt.Fprintf(os.Stderr, "[DEBUG] Learned output gap %dms: silence_threshold=%dms\n", m.gap.Milliseconds(), m.silenceFor(Thinking).Milliseconds())
+	}
+}
+
+// newSilence applies the idle threshold and s to m.
+func (m *Monitor) newSilence(idleMs int, s *Silence) {
+	m.silence = silenceThreshold
+	if idleMs > 0 {
+		m.silence = time.Duration(idleMs) * time.Millisecond
+	}
+	if s == nil {
+		return
+	}
+	for name, ms := range s.StatesMs {
+		if st, ok := ParseState(name); ok && ms > 0 {
+			if m.silences == nil {
+				m.silences = map[State]time.Duration{}
+			}
+			m.silences[st] = time.Duration(ms) * time.Millisecond
+		} else if m.debug {
+			fmt.Fprintf(os.Stderr, "[DEBUG] Ignoring silence for %q\n", name)
+		}
+	}
+	if s.Adaptive {
+		m.adaptiveMax = defaultAdaptiveMax
+		if s.MaxMs > 0 {
+			m.adaptiveMax = time.Duration(s.MaxMs) * time.Millisecond
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package state
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// spinnerFamilies are the glyph cycles of common spinners. Braille
+// spinners, the most common ones, use any of the braille patterns.
+var spinnerFamilies = []string{
+	`|/-\`,
+	"◐◓◑◒",
+	"◴◷◶◵",
+	"◜◠◝◞◡◟",
+	"▖▘▝▗",
+	"✶✸✹✺✻✽✳✢·",
+}
+
+// spinnerFrame matches a glyph drawn over the previous frame: after a
+// carriage return, a backspace or cursor movement, and colors or blanks.
+var spinnerFrame = regexp.MustCompile(`(?:\r|\x08|\x1b\[[0-9;]*[ABDGHK])(?:\x1b\[[0-9;]*m|[ \t])*([\x{2800}-\x{28ff}` + spinnerGlyphs() + `])`)
+
+// spinnerGlyphs lists the glyphs of spinnerFamilies for a character class.
+func spinnerGlyphs() string {
+	var b strings.Builder
+	for _, f := range spinnerFamilies {
+		for _, r := range f {
+			fmt.Fprintf(&b, `\x{%x}`, r)
+		}
+	}
+	return b.String()
+}
+
+// spinnerGap is the longest pause between two frames of one spinner.
+const spinnerGap = time.Second
+
+// spinnerFamily returns which of the spinners glyph belongs to.
+func spinnerFamily(glyph rune) int {
+	if glyph >= 0x2800 && glyph <= 0x28ff {
+		return len(spinnerFamilies)
+	}
+	for i, f := range spinnerFamilies {
+		if strings.ContainsRune(f, glyph) {
+			return i
+		}
+	}
+	return -1
+}
+
+// spinning reports whether data redraws a spinner with its next frame: a
+// different glyph of the same spinner shortly after the last one. A
+// single glyph at the start of a line is too common to count.
+func (m *Monitor) spinning(data []byte, now time.Time) bool {
+	if !m.spinner {
+		return false
+	}
+	found := false
+	for _, match := range spinnerFrame.FindAllSubmatch(data, -1) {
+		glyph, _ := utf8.DecodeRune(match[1])
+		family := spinnerFamily(glyph)
+		if family == m.spinFamily && glyph != m.spinGlyph && now.Sub(m.spinAt) <= spinnerGap {
+			found = true
+		}
+		m.spinGlyph, m.spinFamily, m.spinAt = glyph, family, now
+	}
+	return found
+}
@@ -0,0 +1,63 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Stall tells a hung request apart from deep thinking: when the tool goes
+// silent while thinking and uses almost no processor time for AfterMs,
+// the state becomes Stalled until it prints again; until then it stays
+// Thinking. Without a CPU source, e.g. in watch mode, silence alone counts.
+type Stall struct {
+	AfterMs int `json:"after_ms"`
+}
+
+// stallMaxCPU is the share of one core below which a silent tool counts
+// as doing nothing.
+const stallMaxCPU = 0.01
+
+// cpuSample is how often the CPU time is read during silence.
+const cpuSample = time.Second
+
+// stalled reports whether the tool has been silent since it last printed
+// while thinking, and idle for a whole stall window, or, while that window
+// lasts, that it can't tell yet. It is called on every tick of silence.
+func (m *Monitor) stalled(now time.Time) (stalled, undecided bool) {
+	if m.stallAfter == 0 || m.outputState != Thinking {
+		return false, false
+	}
+	// The window starts when the silence does
+	start := m.cpuSince.Before(m.lastOutputTime)
+	used, ok := m.sampleCPU(now, start)
+	if start {
+		m.cpuSince, m.cpuUsed = now, used
+	}
+	window := now.Sub(m.cpuSince)
+	if window < m.stallAfter {
+		return false, true
+	}
+	if ok && used-m.cpuUsed > time.Duration(float64(window)*stallMaxCPU) {
+		// Working without printing; check the next window
+		m.cpuSince, m.cpuUsed = now, used
+		return false, true
+	}
+	if m.debug {
+		fmt.Fprintf(os.Stderr, "[DEBUG] Silent and idle for %s while thinking: stalled\n", window.Round(time.Second))
+	}
+	return true, false
+}
+
+// sampleCPU returns the CPU time used, read again at most every cpuSample
+// unless fresh is set.
+func (m *Monitor) sampleCPU(now time.Time, fresh bool) (time.Duration, bool) {
+	if m.CPU == nil {
+		return 0, false
+	}
+	if fresh || now.Sub(m.cpuAt) >= cpuSample {
+		m.cpuLast, m.cpuOK = m.CPU()
+		m.cpuAt = now
+	}
+	return m.cpuLast, m.cpuOK
+}
@@ -0,0 +1,175 @@
+// Package state detects what a wrapped command is doing (idle, thinking,
+// waiting for input, failed) from its terminal output.
+//
+// A Monitor is fed output chunks and ticked periodically; it reports every
+// change to an Indicator, such as an LED from package backend.
+package state
+
+type State int
+
+const (
+	Idle State = iota
+	Thinking
+	Waiting
+	Error
+	Stalled    // silent while thinking, e.g. a hung request
+	Syncing    // waiting on a git or other network operation
+	Success    // a task finished cleanly, shown for a while
+	Responding // the user is typing at a prompt
+)
+
+func (s State) String() string {
+	switch s {
+	case Idle:
+		return "idle"
+	case Thinking:
+		return "thinking"
+	case Waiting:
+		return "waiting"
+	case Error:
+		return "error"
+	case Stalled:
+		return "stalled"
+	case Syncing:
+		return "syncing"
+	case Success:
+		return "success"
+	case Responding:
+		return "responding"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseState is the inverse of State.String.
+func ParseState(name string) (State, bool) {
+	for s := Idle; s <= Responding; s++ {
+		if s.String() == name {
+			return s, true
+		}
+	}
+	return Idle, false
+}
+
+// Effect is how a state is rendered on the LED.
+type Effect string
+
+const (
+	EffectSolid Effect = ""
+	EffectBlink Effect = "blink"
+	EffectOff   Effect = "off" // state is kept but nothing is shown
+	EffectDim   Effect = "dim"
+	EffectSafe  Effect = "safe" // thinking long enough to look away, green
+	// EffectBudget shows idle in a warning color once the session has
+	// spent more than its budget
+	EffectBudget Effect = "budget"
+)
+
+// Indicator is anything that can show a state: the local LED or a daemon
+// that aggregates several sessions.
+type Indicator interface {
+	SetState(state State)
+	SetEffect(state State, effect Effect)
+	SetProgress(progress float64)
+	TurnOff()
+}
+
+// Suppressor decides whether notifications are held back, e.g. during quiet
+// hours. One that also has an Escalating() bool method can report that the
+// user is away and wants escalations right away.
+type Suppressor interface {
+	Suppressed() bool
+}
+
+type Patterns struct {
+	Waiting  PatternList `json:"waiting"`
+	Thinking PatternList `json:"thinking"`
+	Error    PatternList `json:"error"`
+	Success  PatternList `json:"success"`
+}
+
+// Config holds the detection settings of a tool config.
+type Config struct {
+	Patterns Patterns `json:"patterns"`
+	// Used instead of Patterns for stderr when it is analyzed separately
+	StderrPatterns *Patterns `json:"stderr_patterns"`
+	// How much of the screen waiting patterns are checked against, for all
+	// of them and per pattern; "last_line" anchors prompts to the final
+	// visible line
+	WaitingWindow   Window            `json:"waiting_window"`
+	WaitingWindows  map[string]Window `json:"waiting_windows"`
+	IdleThresholdMs int               `json:"idle_threshold_ms"`
+	OffAfterIdleMs  int               `json:"off_after_idle_ms"`
+	Escalations     []Escalation      `json:"escalations"`
+	// Commands run on entering or leaving a state, keyed by state name
+	Hooks map[string]Hook `json:"hooks"`
+	Focus *Focus          `json:"focus"`
+	Stall *Stall          `json:"stall"`
+	Push  *Push           `json:"push"`
+	// While the user has typed into the program this recently, state
+	// changes don't reach the LED and notifications are held back
+	TypingMs int `json:"typing_ms"`
+	// Skip the waiting scan while a full-screen program such as an editor
+	// or pager uses the alternate screen
+	IgnoreAltScreen bool `json:"ignore_alt_screen"`
+	// Match only part of the output of very chatty commands
+	Sampling *Sampling `json:"sampling"`
+	// Show git and other network operations as Syncing
+	Sync *Sync `json:"sync"`
+	// Follow the session's cost and warn when it exceeds a budget
+	Cost *Cost `json:"cost"`
+	// Prompts recognized by the output that came before them
+	Sequences []Sequence `json:"sequences"`
+	// Routine prompts answered without showing Waiting
+	Expect []Expect `json:"expect"`
+	// How long a success pattern is shown before the usual state returns
+	SuccessHoldMs int `json:"success_hold_ms"`
+	// Spinners redrawn in place count as thinking unless this is false
+	Spinner *bool `json:"spinner"`
+	// Treat the terminal bell as a prompt
+	Bell *Bell `json:"bell"`
+	// Treat desktop notifications sent with OSC 9 or 777 as a prompt
+	OSCNotify *OSCNotify `json:"osc_notify"`
+	// Follow a task runner's queue ("task 3/10") as the progress
+	Progress *Progress `json:"progress"`
+	// Silence thresholds per state, and one learned from the tool's pace
+	Silence *Silence `json:"silence"`
+	// While the user typed at a prompt this recently, it is Responding
+	// instead of Waiting; 0 keeps it Waiting
+	RespondingMs int `json:"responding_ms"`
+	// Visible characters a chunk of output needs to count as activity
+	// without matching a pattern; 1 by default
+	MinOutputChars int `json:"min_output_chars"`
+}
+
+// DefaultConfig is used when no config file is found.
+func DefaultConfig() Config {
+	return Config{
+		Patterns: Patterns{
+			Waiting:  []string{"wait", "Wait", "\\(y/n\\)"},
+			Thinking: []string{"Imagining", "imagining", "Running", "running"},
+		},
+		IdleThresholdMs: 500,
+	}
+}
+
+// Action is a request from the user to the monitor, e.g. from a hotkey.
+type Action string
+
+const (
+	ActionCycleMode   Action = "cycle"
+	ActionAcknowledge Action = "ack"
+)
+
+// Mode is the user's override of how bright the light should be.
+type Mode int
+
+const (
+	ModeNormal Mode = iota
+	ModeDim
+	ModeOff
+)
+
+func (m Mode) String() string {
+	return [...]string{"normal", "dim", "off"}[m]
+}
@@ -0,0 +1,75 @@
+package state
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Sync tells network waits apart from thinking: once output of a git or
+// other network operation (clone, fetch, pull, push) has lasted AfterMs,
+// the state becomes Syncing. It stays through silence while the last line
+// on screen still shows the operation, and ends with any other output.
+// Patterns replaces the built-in git patterns.
+type Sync struct {
+	Patterns PatternList `json:"patterns"`
+	AfterMs  int         `json:"after_ms"`
+}
+
+// syncPatterns are git's progress and transfer messages.
+var syncPatterns = []string{
+	`Cloning into '`,
+	`(Enumerating|Counting|Compressing|Receiving|Writing) objects:`,
+	`Resolving deltas:`,
+	`Updating files:`,
+	`Fetching \S+`,
+	`Pushing to \S+`,
+	`From (https?://|git@|ssh://)\S+`,
+	`To (https?://|git@|ssh://)\S+`,
+	`remote: (Enumerating|Counting|Compressing|Total)`,
+}
+
+// defaultSyncAfter is how long an operation must last before it shows.
+const defaultSyncAfter = 2 * time.Second
+
+// matchSync notes output of a network operation in data and returns
+// whether it has lasted long enough to show. Other output ends it, but
+// blank lines and cursor movement don't.
+func (m *Monitor) matchSync(data []byte, stream string, now time.Time) bool {
+	if m.sync == nil {
+		return false
+	}
+	pattern, ok := m.sync.Which(data)
+	if !ok {
+		if len(bytes.TrimSpace(data)) > 0 {
+			m.syncSince = time.Time{}
+		}
+		return false
+	}
+	if m.syncSince.IsZero() {
+		m.syncSince = now
+		if m.debug {
+			fmt.Fprintf(os.Stderr, "[DEBUG] Sync pattern matched on %s: %s\n", stream, pattern)
+		}
+		m.observe(Event{Time: now, State: Syncing, Pattern: pattern, Stream: stream})
+	}
+	return now.Sub(m.syncSince) >= m.syncAfter
+}
+
+// syncing reports whether a network operation that has lasted long
+// enough is still on the last line of the screen, during silence.
+func (m *Monitor) syncing(now time.Time) bool {
+	if m.sync == nil || m.syncSince.IsZero() || now.Sub(m.syncSince) < m.syncAfter {
+		return false
+	}
+	lines := m.Screen.Lines()
+	for i := len(lines) - 1; i >= 0; i-- {
+		if strings.TrimSpace(lines[i]) != "" {
+			_, ok := m.sync.WhichString(lines[i])
+			return ok
+		}
+	}
+	return false
+}
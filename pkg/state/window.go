@@ -0,0 +1,132 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+)
+
+// Window is how much of the screen a waiting pattern is checked against:
+// the last N lines, only the last line or the whole screen. In configs it
+// is a number, "last_line" or "screen".
+type Window int
+
+const (
+	WindowScreen   Window = -1
+	WindowLastLine Window = 1
+
+	// DefaultWindow is used when the config sets no window
+	DefaultWindow Window = 20
+)
+
+func (w Window) String() string {
+	switch {
+	case w == WindowScreen:
+		return "screen"
+	case w == WindowLastLine:
+		return "last_line"
+	}
+	return strconv.Itoa(int(w))
+}
+
+func (w Window) MarshalJSON() ([]byte, error) {
+	if w == WindowScreen || w == WindowLastLine {
+		return json.Marshal(w.String())
+	}
+	return json.Marshal(int(w))
+}
+
+func (w *Window) UnmarshalJSON(data []byte) error {
+	var n int
+	if err := json.Unmarshal(data, &n); err == nil {
+		if n < 1 {
+			return fmt.Errorf("window must be at least 1 line, got %d", n)
+		}
+		*w = Window(n)
+		return nil
+	}
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return fmt.Errorf("window must be a number of lines, \"last_line\" or \"screen\"")
+	}
+	switch name {
+	case "last_line":
+		*w = WindowLastLine
+	case "screen":
+		*w = WindowScreen
+	default:
+		return fmt.Errorf("unknown window %q", name)
+	}
+	return nil
+}
+
+// Tail returns the lines a pattern with this window is checked against.
+func (w Window) Tail(lines []string) []string {
+	if w == WindowScreen || int(w) >= len(lines) {
+		return lines
+	}
+	return lines[len(lines)-int(w):]
+}
+
+// windowMatcher holds the waiting patterns that share a window.
+type windowMatcher struct {
+	window Window
+	*Matcher
+}
+
+// WindowKey reports whether key in waiting_windows names pattern: by its
+// regular expression or, for a pattern given as an object, by its text.
+func WindowKey(key, pattern string) bool {
+	if key == pattern {
+		return true
+	}
+	for _, literal := range []bool{false, true} {
+		for _, ignoreCase := range []bool{false, true} {
+			if (patternObject{Text: key, Literal: literal, IgnoreCase: ignoreCase}).regexp() == pattern {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// windowFor returns the window windows sets for pattern.
+func windowFor(pattern string, windows map[string]Window) (Window, bool) {
+	if w, ok := windows[pattern]; ok {
+		return w, true
+	}
+	for _, key := range slices.Sorted(maps.Keys(windows)) {
+		if WindowKey(key, pattern) {
+			return windows[key], true
+		}
+	}
+	return 0, false
+}
+
+// waitingMatchers groups waiting patterns by their window, keeping the
+// order in which the windows first appear. Patterns without a window of
+// their own use def, or DefaultWindow if that is unset.
+func waitingMatchers(patterns []string, def Window, windows map[string]Window) []windowMatcher {
+	if def == 0 {
+		def = DefaultWindow
+	}
+	var order []Window
+	groups := map[Window][]string{}
+	for _, p := range patterns {
+		w, ok := windowFor(p, windows)
+		if !ok {
+			w = def
+		}
+		if _, seen := groups[w]; !seen {
+			order = append(order, w)
+		}
+		groups[w] = append(groups[w], p)
+	}
+	out := make([]windowMatcher, len(order))
+	for i, w := range order {
+		out[i] = windowMatcher{w, NewMatcher(groups[w])}
+	}
+	return out
+}
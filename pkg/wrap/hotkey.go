@@ -0,0 +1,89 @@
+package wrap
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// Hotkeys filters stdin for a prefix key. The prefix pressed twice cycles
+// the LED mode, prefix + 'a' acknowledges a Waiting alert; anything else is
+// passed through unchanged, so the wrapped program never sees the shortcuts.
+type Hotkeys struct {
+	prefix  byte
+	armed   bool
+	actions chan<- state.Action
+}
+
+func NewHotkeys(key string, actions chan<- state.Action) (*Hotkeys, error) {
+	prefix, err := parseKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Hotkeys{prefix: prefix, actions: actions}, nil
+}
+
+// Filter appends the bytes of in that should reach the program to out.
+func (h *Hotkeys) Filter(in, out []byte) []byte {
+	for _, b := range in {
+		out = h.key(b, out)
+	}
+	return out
+}
+
+// key handles one typed byte.
+func (h *Hotkeys) key(b byte, out []byte) []byte {
+	if !h.armed {
+		if b == h.prefix {
+			h.armed = true
+			return out
+		}
+		return append(out, b)
+	}
+	h.armed = false
+	switch b {
+	case h.prefix:
+		h.send(state.ActionCycleMode)
+	case 'a', 'A':
+		h.send(state.ActionAcknowledge)
+	default:
+		out = append(out, h.prefix, b)
+	}
+	return out
+}
+
+// release passes on a prefix that is still waiting for its second key,
+// e.g. when an escape sequence follows it.
+func (h *Hotkeys) release(out []byte) []byte {
+	if h.armed {
+		h.armed = false
+		out = append(out, h.prefix)
+	}
+	return out
+}
+
+func (h *Hotkeys) send(a state.Action) {
+	select {
+	case h.actions <- a:
+	default:
+	}
+}
+
+// parseKey understands "ctrl-x" style names and single characters.
+func parseKey(key string) (byte, error) {
+	k := strings.ToLower(key)
+	if rest, ok := strings.CutPrefix(k, "ctrl-"); ok && len(rest) == 1 {
+		c := rest[0]
+		switch {
+		case c >= 'a' && c <= 'z':
+			return c - 'a' + 1, nil
+		case c >= '[' && c <= '_':
+			return c - '[' + 0x1b, nil
+		}
+	}
+	if len(key) == 1 {
+		return key[0], nil
+	}
+	return 0, fmt.Errorf("unknown key %q", key)
+}
@@ -0,0 +1,109 @@
+package wrap
+
+// Input follows the escape sequences in what the user sends to the
+// program. Pastes, mouse reports and other sequences pass through as they
+// are, so hotkeys are only taken from what was typed, and mouse movement
+// or focus changes don't count as typing.
+type Input struct {
+	Hotkeys *Hotkeys // nil without hotkeys
+
+	state   int
+	params  []byte
+	mouse   int // bytes left of an X10 mouse report
+	pasting bool
+}
+
+const (
+	inGround = iota
+	inEscape
+	inCSI
+	inSS3
+	inMouse
+)
+
+// Filter appends the bytes of data that should reach the program to out.
+// typed reports whether data held anything but mouse and focus reports.
+func (in *Input) Filter(data, out []byte) (_ []byte, typed bool) {
+	for _, b := range data {
+		switch in.state {
+		case inGround:
+			switch {
+			case b == 0x1b && (in.Hotkeys == nil || in.Hotkeys.prefix != 0x1b):
+				if in.Hotkeys != nil {
+					out = in.Hotkeys.release(out)
+				}
+				out = append(out, b)
+				in.state = inEscape
+			case in.Hotkeys == nil || in.pasting:
+				out = append(out, b)
+				typed = true
+			default:
+				out = in.Hotkeys.key(b, out)
+				typed = true
+			}
+		case inEscape:
+			out = append(out, b)
+			switch b {
+			case '[':
+				in.state = inCSI
+				in.params = in.params[:0]
+			case 'O':
+				in.state = inSS3
+			default:
+				// Alt + key
+				in.state = inGround
+				typed = true
+			}
+		case inSS3:
+			out = append(out, b)
+			in.state = inGround
+			typed = true
+		case inCSI:
+			out = append(out, b)
+			if b < 0x40 || b > 0x7e {
+				if len(in.params) < 32 {
+					in.params = append(in.params, b)
+				}
+				continue
+			}
+			in.state = inGround
+			if in.report(b) {
+				continue
+			}
+			// Bracketed paste
+			switch string(in.params) + string(b) {
+			case "200~":
+				in.pasting = true
+			case "201~":
+				in.pasting = false
+			}
+			typed = true
+		case inMouse:
+			out = append(out, b)
+			if in.mouse--; in.mouse == 0 {
+				in.state = inGround
+			}
+		}
+	}
+	// A lone escape is the Escape key
+	if in.state == inEscape {
+		typed = true
+	}
+	return out, typed
+}
+
+// report reports whether the CSI sequence ending in final is a mouse or
+// focus report rather than a key. X10 mouse reports continue with three
+// raw bytes.
+func (in *Input) report(final byte) bool {
+	switch {
+	case final == 'M' && len(in.params) == 0:
+		in.state, in.mouse = inMouse, 3
+		return true
+	case (final == 'M' || final == 'm') && len(in.params) > 0 && in.params[0] == '<':
+		return true
+	case (final == 'I' || final == 'O') && len(in.params) == 0:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+//go:build !windows
+
+package wrap
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"github.com/creack/pty"
+)
+
+type unixPTY struct {
+	*os.File
+}
+
+func (p unixPTY) resize() (cols, rows int, ok bool) {
+	if err := pty.InheritSize(os.Stdin, p.File); err != nil {
+		return 0, 0, false
+	}
+	rows, cols, err := pty.Getsize(p.File)
+	return cols, rows, err == nil
+}
+
+func (p unixPTY) setSize(cols, rows int) error {
+	return pty.Setsize(p.File, &pty.Winsize{Cols: uint16(cols), Rows: uint16(rows)})
+}
+
+// StartPTY runs cmd on a new pseudo-terminal. With splitStderr the child's
+// stderr goes through a separate pipe so it can be analyzed on its own; it is
+// still written to the terminal.
+func StartPTY(cmd *exec.Cmd, splitStderr bool) (*Session, error) {
+	return startPTY(cmd, splitStderr, os.Stdout)
+}
+
+func startPTY(cmd *exec.Cmd, splitStderr bool, echo io.Writer) (*Session, error) {
+	s := newSession(cmd, splitStderr)
+	var errR, errW *os.File
+	if splitStderr {
+		var err error
+		if errR, errW, err = os.Pipe(); err != nil {
+			return nil, err
+		}
+		cmd.Stderr = errW
+	}
+	ptmx, err := pty.Start(cmd)
+	if errW != nil {
+		errW.Close()
+	}
+	if err != nil {
+		if errR != nil {
+			errR.Close()
+		}
+		return nil, err
+	}
+	s.tty = unixPTY{ptmx}
+	// pty.Start makes the child a session leader
+	s.supervise(cmd.Wait, newTree(cmd.Process.Pid, true))
+	s.pump(ptmx, echo, s.Output)
+	if errR != nil {
+		s.pump(errR, Stderr, s.Stderr)
+	}
+	go s.finish()
+	return s, nil
+}
+
+// NotifyResize delivers a signal on c whenever the terminal is resized.
+func NotifyResize(c chan os.Signal) (stop func()) {
+	signal.Notify(c, syscall.SIGWINCH)
+	return func() { signal.Stop(c) }
+}
@@ -0,0 +1,194 @@
+//go:build windows
+
+package wrap
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/term"
+)
+
+// conPTY is a Windows pseudo console (ConPTY, Windows 10 1809 and later).
+type conPTY struct {
+	hpc windows.Handle
+	in  *os.File // console input, written by us
+	out *os.File // console output, read by the pump
+}
+
+func (c *conPTY) Write(p []byte) (int, error) {
+	return c.in.Write(p)
+}
+
+func (c *conPTY) Close() error {
+	c.in.Close()
+	return c.out.Close()
+}
+
+func (c *conPTY) resize() (cols, rows int, ok bool) {
+	cols, rows, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		return 0, 0, false
+	}
+	return cols, rows, c.setSize(cols, rows) == nil
+}
+
+func (c *conPTY) setSize(cols, rows int) error {
+	return windows.ResizePseudoConsole(c.hpc, windows.Coord{X: int16(cols), Y: int16(rows)})
+}
+
+// StartPTY runs cmd on a new pseudo console. ConPTY has a single output
+// stream, so stderr can't be split off; use pipe mode for that.
+func StartPTY(cmd *exec.Cmd, splitStderr bool) (*Session, error) {
+	return startPTY(cmd, splitStderr, os.Stdout)
+}
+
+func startPTY(cmd *exec.Cmd, splitStderr bool, echo io.Writer) (*Session, error) {
+	if cmd.Err != nil {
+		return nil, cmd.Err
+	}
+	// The console output contains VT sequences; let the real console
+	// interpret them
+	stdout := windows.Handle(os.Stdout.Fd())
+	var mode uint32
+	if windows.GetConsoleMode(stdout, &mode) == nil {
+		windows.SetConsoleMode(stdout, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING)
+	}
+
+	cols, rows := 80, 24
+	if w, h, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+		cols, rows = w, h
+	}
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		inR.Close()
+		inW.Close()
+		return nil, err
+	}
+	var hpc windows.Handle
+	err = windows.CreatePseudoConsole(windows.Coord{X: int16(cols), Y: int16(rows)}, windows.Handle(inR.Fd()), windows.Handle(outW.Fd()), 0, &hpc)
+	// The console duplicated its ends of the pipes
+	inR.Close()
+	outW.Close()
+	if err != nil {
+		inW.Close()
+		outR.Close()
+		return nil, err
+	}
+	c := &conPTY{hpc: hpc, in: inW, out: outR}
+
+	proc, t, err := startInConsole(cmd, hpc)
+	if err != nil {
+		windows.ClosePseudoConsole(hpc)
+		c.Close()
+		return nil, err
+	}
+
+	s := newSession(nil, false)
+	s.tty = c
+	s.supervise(func() error {
+		state, err := proc.Wait()
+		if err == nil && !state.Success() {
+			err = &exec.ExitError{ProcessState: state}
+		}
+		// The output pipe only ends once the console is gone
+		windows.ClosePseudoConsole(hpc)
+		return err
+	}, t)
+	s.pump(outR, echo, s.Output)
+	go s.finish()
+	return s, nil
+}
+
+// startInConsole creates the process for cmd attached to the pseudo console.
+// os/exec can't pass the console attribute, so this calls CreateProcess. The
+// process starts suspended until it is in its job, so nothing it starts can
+// escape the job.
+func startInConsole(cmd *exec.Cmd, hpc windows.Handle) (*os.Process, *tree, error) {
+	attrs, err := windows.NewProcThreadAttributeList(1)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer attrs.Delete()
+	// The attribute value is the HPCON itself, not a pointer to it
+	if err := attrs.Update(windows.PROC_THREAD_ATTRIBUTE_PSEUDOCONSOLE, *(*unsafe.Pointer)(unsafe.Pointer(&hpc)), unsafe.Sizeof(hpc)); err != nil {
+		return nil, nil, err
+	}
+
+	si := new(windows.StartupInfoEx)
+	si.Cb = uint32(unsafe.Sizeof(*si))
+	si.Flags = windows.STARTF_USESTDHANDLES
+	si.ProcThreadAttributeList = attrs.List()
+
+	app, err := windows.UTF16PtrFromString(cmd.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	cmdLine, err := windows.UTF16PtrFromString(windows.ComposeCommandLine(cmd.Args))
+	if err != nil {
+		return nil, nil, err
+	}
+	var dir *uint16
+	if cmd.Dir != "" {
+		if dir, err = windows.UTF16PtrFromString(cmd.Dir); err != nil {
+			return nil, nil, err
+		}
+	}
+	if cmd.Env != nil {
+		return nil, nil, errors.New("custom environment not supported with ConPTY")
+	}
+
+	var pi windows.ProcessInformation
+	flags := uint32(windows.EXTENDED_STARTUPINFO_PRESENT | windows.CREATE_UNICODE_ENVIRONMENT | windows.CREATE_SUSPENDED)
+	if err := windows.CreateProcess(app, cmdLine, nil, nil, false, flags, nil, dir, &si.StartupInfo, &pi); err != nil {
+		return nil, nil, err
+	}
+	defer windows.CloseHandle(pi.Process)
+	t := newTree(int(pi.ProcessId), false)
+	windows.ResumeThread(pi.Thread)
+	windows.CloseHandle(pi.Thread)
+	proc, err := os.FindProcess(int(pi.ProcessId))
+	return proc, t, err
+}
+
+// resizeSignal stands in for SIGWINCH, which Windows doesn't have.
+type resizeSignal struct{}
+
+func (resizeSignal) String() string { return "resize" }
+func (resizeSignal) Signal()        {}
+
+// NotifyResize polls the console size since there is no resize signal.
+func NotifyResize(c chan os.Signal) (stop func()) {
+	quit := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+		lastW, lastH, _ := term.GetSize(int(os.Stdout.Fd()))
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+			}
+			w, h, err := term.GetSize(int(os.Stdout.Fd()))
+			if err != nil || (w == lastW && h == lastH) {
+				continue
+			}
+			lastW, lastH = w, h
+			select {
+			case c <- resizeSignal{}:
+			default:
+			}
+		}
+	}()
+	return func() { close(quit) }
+}
@@ -0,0 +1,128 @@
+package wrap
+
+import (
+	"io"
+	"sync"
+)
+
+// Ring is a fixed-size byte ring buffer shared between the PTY reader and
+// the main loop. Readers copy into a caller-owned slice so the hot path never
+// allocates. Write blocks while the ring is full; Overwrite drops the oldest
+// bytes instead, for consumers that only care about recent output.
+type Ring struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    []byte
+	start  int
+	n      int
+	closed bool
+}
+
+func NewRing(size int) *Ring {
+	r := &Ring{buf: make([]byte, size)}
+	r.cond = sync.NewCond(&r.mu)
+	return r
+}
+
+// Write copies p into the ring, blocking while it is full.
+func (r *Ring) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	written := 0
+	for len(p) > 0 {
+		for r.n == len(r.buf) && !r.closed {
+			r.cond.Wait()
+		}
+		if r.closed {
+			return written, io.ErrClosedPipe
+		}
+		end := (r.start + r.n) % len(r.buf)
+		limit := len(r.buf) - r.n
+		if end+limit > len(r.buf) {
+			limit = len(r.buf) - end
+		}
+		c := copy(r.buf[end:end+limit], p)
+		r.n += c
+		written += c
+		p = p[c:]
+		r.cond.Broadcast()
+	}
+	return written, nil
+}
+
+// Overwrite copies p into the ring without blocking, discarding the oldest
+// buffered bytes when there is not enough room.
+func (r *Ring) Overwrite(p []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	if len(p) > len(r.buf) {
+		p = p[len(p)-len(r.buf):]
+	}
+	if drop := r.n + len(p) - len(r.buf); drop > 0 {
+		r.start = (r.start + drop) % len(r.buf)
+		r.n -= drop
+	}
+	for len(p) > 0 {
+		end := (r.start + r.n) % len(r.buf)
+		c := copy(r.buf[end:], p)
+		if c > len(r.buf)-r.n {
+			c = len(r.buf) - r.n
+		}
+		r.n += c
+		p = p[c:]
+	}
+	r.cond.Broadcast()
+}
+
+// Read copies buffered bytes into p, blocking while the ring is empty. It
+// returns io.EOF once the ring is empty and closed.
+func (r *Ring) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for r.n == 0 && !r.closed {
+		r.cond.Wait()
+	}
+	return r.read(p)
+}
+
+// TryRead copies buffered bytes into p without blocking. It returns 0 when
+// the ring is empty and io.EOF once it is empty and closed.
+func (r *Ring) TryRead(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.read(p)
+}
+
+// read copies buffered bytes into p. Must be called with r.mu held.
+func (r *Ring) read(p []byte) (int, error) {
+	if r.n == 0 {
+		if r.closed {
+			return 0, io.EOF
+		}
+		return 0, nil
+	}
+	c := 0
+	for c < len(p) && r.n > 0 {
+		limit := r.n
+		if r.start+limit > len(r.buf) {
+			limit = len(r.buf) - r.start
+		}
+		m := copy(p[c:], r.buf[r.start:r.start+limit])
+		c += m
+		r.start = (r.start + m) % len(r.buf)
+		r.n -= m
+	}
+	r.cond.Broadcast()
+	return c, nil
+}
+
+// Close wakes blocked writers; remaining data can still be read.
+func (r *Ring) Close() {
+	r.mu.Lock()
+	r.closed = true
+	r.cond.Broadcast()
+	r.mu.Unlock()
+}
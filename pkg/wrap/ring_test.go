@@ -0,0 +1,97 @@
+package wrap
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// drain reads everything buffered in r.
+func drain(r *Ring) string {
+	var out []byte
+	buf := make([]byte, 3) // smaller than the ring, to read across the wrap
+	for {
+		n, _ := r.TryRead(buf)
+		if n == 0 {
+			return string(out)
+		}
+		out = append(out, buf[:n]...)
+	}
+}
+
+func TestRingOverwrite(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		read   int // bytes read after the first write
+		want   string
+	}{
+		{"fits", []string{"abc", "def"}, 0, "abcdef"},
+		{"full", []string{"abcd", "efgh"}, 0, "abcdefgh"},
+		{"drops the oldest", []string{"abcdef", "ghij"}, 0, "cdefghij"},
+		{"longer than the ring", []string{"ab", "0123456789"}, 0, "23456789"},
+		{"across the end", []string{"abcdef", "gh", "ijk"}, 4, "efghijk"},
+		// The ring knows nothing of escape sequences: the analyzer gets
+		// the newest bytes, starting inside the sequence
+		{"cut inside an escape sequence", []string{"ok\x1b[31m", "red!"}, 0, "[31mred!"},
+		{"cut inside a sequence across the end", []string{"ab\x1b[3", "1mXYZW"}, 1, "[31mXYZW"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRing(8)
+			for i, w := range tt.writes {
+				r.Overwrite([]byte(w))
+				if i == 0 && tt.read > 0 {
+					r.TryRead(make([]byte, tt.read))
+				}
+			}
+			if got := drain(r); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRingWriteBlocks(t *testing.T) {
+	r := NewRing(4)
+	done := make(chan struct{})
+	go func() {
+		r.Write([]byte("abcdefgh"))
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("Write didn't wait for room")
+	case <-time.After(50 * time.Millisecond):
+	}
+	got := make([]byte, 0, 8)
+	buf := make([]byte, 8)
+	for len(got) < 8 {
+		n, err := r.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, buf[:n]...)
+	}
+	<-done
+	if string(got) != "abcdefgh" {
+		t.Errorf("got %q, want %q", got, "abcdefgh")
+	}
+}
+
+func TestRingClose(t *testing.T) {
+	r := NewRing(4)
+	r.Overwrite([]byte("ab"))
+	r.Close()
+	r.Overwrite([]byte("cd"))
+	if _, err := r.Write([]byte("ef")); err != io.ErrClosedPipe {
+		t.Errorf("Write after Close: %v", err)
+	}
+	buf := make([]byte, 4)
+	if n, err := r.Read(buf); string(buf[:n]) != "ab" || err != nil {
+		t.Errorf("got %q, %v, want the bytes from before Close", buf[:n], err)
+	}
+	if _, err := r.Read(buf); err != io.EOF {
+		t.Errorf("got %v once drained, want EOF", err)
+	}
+}
@@ -0,0 +1,64 @@
+package wrap
+
+import (
+	"os"
+	"time"
+
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// Run drives m from a session until its output ends. resize is called on
+// SIGWINCH-style notifications received on winch.
+func Run(m *state.Monitor, session *Session, winch <-chan os.Signal, resize func()) {
+	Loop(m, session, winch, resize, nil)
+}
+
+// Loop is Run with a tick callback that is called after every Tick from the
+// same goroutine, so it can safely inspect or change m (e.g. to render it).
+func Loop(m *state.Monitor, session *Session, winch <-chan os.Signal, resize func(), tick func()) {
+	chunk := make([]byte, 16*1024)
+	ticker := time.NewTicker(state.TickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-session.Notify:
+			// Drain stderr first so its errors aren't masked by stdout noise
+			pending := 0
+			if session.Stderr != nil {
+				if pending, _ = session.Stderr.TryRead(chunk); pending > 0 {
+					m.Feed(chunk[:pending], "stderr", m.Clock.Now())
+					session.signal()
+				}
+			}
+			n, err := session.Output.TryRead(chunk)
+			if err != nil {
+				if pending > 0 {
+					continue
+				}
+				return
+			}
+			if n == 0 {
+				continue
+			}
+			// More may be pending; come back for it after this chunk
+			session.signal()
+			m.Feed(chunk[:n], "stdout", m.Clock.Now())
+
+		case <-winch:
+			resize()
+
+		case a := <-m.Actions:
+			m.Handle(a)
+
+		case <-ticker.C:
+			if session.Pending() {
+				m.Flowing(m.Clock.Now())
+			}
+			m.Tick(m.Clock.Now())
+			if tick != nil {
+				tick()
+			}
+		}
+	}
+}
@@ -0,0 +1,287 @@
+// Package wrap runs a command on a pseudo-terminal (or pipes), echoes its
+// output and feeds a copy to a state.Monitor.
+package wrap
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Session is a running child process together with the plumbing that copies
+// its output to the terminal and hands a copy to the analyzer.
+type Session struct {
+	// wait waits for the child to exit; nil when there is no child
+	wait func() error
+	// tree is the child and the processes it started; nil when there is
+	// no child
+	tree *tree
+
+	// Output receives a copy of everything the child printed. Notify is
+	// signalled whenever new data is available or the output ended.
+	Output *Ring
+	Notify chan struct{}
+
+	// Stderr receives the child's stderr when it is captured separately
+	Stderr *Ring
+
+	// tty is nil when the child runs with plain pipes
+	tty terminal
+
+	readers   sync.WaitGroup
+	unwritten atomic.Int64 // bytes read but not yet echoed
+
+	// filter rewrites the output before it is echoed, see SetEchoFilter
+	filter atomic.Pointer[func([]byte) []byte]
+}
+
+// Stderr is where the child's stderr is echoed when it has one of its own.
+// It stays the terminal when sl sends its diagnostics elsewhere.
+var Stderr io.Writer = os.Stderr
+
+// terminal is the pseudo-terminal a child runs on.
+type terminal interface {
+	io.WriteCloser
+
+	// resize copies the user's terminal size to the pseudo-terminal and
+	// returns it
+	resize() (cols, rows int, ok bool)
+	setSize(cols, rows int) error
+}
+
+func newSession(cmd *exec.Cmd, splitStderr bool) *Session {
+	s := &Session{
+		Output: NewRing(64 * 1024),
+		Notify: make(chan struct{}, 1),
+	}
+	if splitStderr {
+		s.Stderr = NewRing(16 * 1024)
+	}
+	return s
+}
+
+// supervise waits for the child in the background, then terminates what
+// it left behind, so orphans can't keep the output open or linger after
+// sl exits.
+func (s *Session) supervise(wait func() error, t *tree) {
+	s.tree = t
+	done := make(chan struct{})
+	var err error
+	go func() {
+		err = wait()
+		if t != nil {
+			t.terminate()
+		}
+		close(done)
+	}()
+	s.wait = func() error {
+		<-done
+		return err
+	}
+}
+
+// CPUTime returns the processor time the child and the processes it
+// started have used so far. ok is false where it can't be measured.
+func (s *Session) CPUTime() (cpu time.Duration, ok bool) {
+	if s.tree == nil {
+		return 0, false
+	}
+	return s.tree.cpuTime()
+}
+
+// Stop terminates the child and every process it started, e.g. when sl
+// itself is asked to exit.
+func (s *Session) Stop() {
+	if s.tree != nil {
+		s.tree.terminate()
+	}
+}
+
+// StartPTYSilent runs cmd on a pseudo-terminal without echoing its output,
+// for callers that render it themselves.
+func StartPTYSilent(cmd *exec.Cmd) (*Session, error) {
+	return startPTY(cmd, false, io.Discard)
+}
+
+// StartPTYTo runs cmd on a pseudo-terminal and echoes its output to w
+// instead of the terminal, e.g. to a log file.
+func StartPTYTo(cmd *exec.Cmd, w io.Writer) (*Session, error) {
+	return startPTY(cmd, false, w)
+}
+
+// StartPipes runs cmd with its stdout and stderr connected to pipes, so
+// pipelines see ordinary non-interactive output. Stdin is inherited.
+func StartPipes(cmd *exec.Cmd, splitStderr bool) (*Session, error) {
+	s := newSession(cmd, splitStderr)
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	errR, errW, err := os.Pipe()
+	if err != nil {
+		outR.Close()
+		outW.Close()
+		return nil, err
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = outW
+	cmd.Stderr = errW
+	group := ownGroup(cmd)
+	err = cmd.Start()
+	outW.Close()
+	errW.Close()
+	if err != nil {
+		outR.Close()
+		errR.Close()
+		return nil, err
+	}
+	s.supervise(cmd.Wait, newTree(cmd.Process.Pid, group))
+	s.pump(outR, os.Stdout, s.Output)
+	if s.Stderr != nil {
+		s.pump(errR, Stderr, s.Stderr)
+	} else {
+		s.pump(errR, Stderr, s.Output)
+	}
+	go s.finish()
+	return s, nil
+}
+
+// WatchReader analyzes output read from r without running a command. The
+// output is not echoed.
+func WatchReader(r io.Reader) *Session {
+	s := newSession(nil, false)
+	s.pump(r, io.Discard, s.Output)
+	go s.finish()
+	return s
+}
+
+// pump copies r to w from its own goroutine, so the user never waits on LED
+// logic, and feeds the analyzer ring, which drops the oldest bytes if the
+// analyzer falls behind.
+//
+// The analyzer gets every chunk as soon as it is read. Echoing to w goes
+// through a queue and its own goroutine, so a slow terminal (e.g. over
+// ssh) doesn't delay state detection; when the queue is full, reading
+// stops and the child blocks on its output as it would unwrapped.
+func (s *Session) pump(r io.Reader, w io.Writer, ring *Ring) {
+	var queue *Ring
+	if w != io.Discard {
+		queue = NewRing(echoQueueSize)
+		s.readers.Add(1)
+		go s.echo(queue, w, ring == s.Output)
+	}
+	s.readers.Add(1)
+	go func() {
+		defer s.readers.Done()
+		if queue != nil {
+			defer queue.Close()
+		}
+		buf := make([]byte, 4096)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				ring.Overwrite(buf[:n])
+				s.signal()
+				if queue != nil {
+					s.unwritten.Add(int64(n))
+					queue.Write(buf[:n])
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// echoQueueSize is how far the analyzer may get ahead of the terminal.
+const echoQueueSize = 64 * 1024
+
+// echo writes the queued output to w until the queue is closed and empty.
+// Write errors drop the output, so a closed terminal can't block the child.
+// filtered applies the echo filter.
+func (s *Session) echo(queue *Ring, w io.Writer, filtered bool) {
+	defer s.readers.Done()
+	buf := make([]byte, 4096)
+	for {
+		n, err := queue.Read(buf)
+		if n > 0 {
+			out := buf[:n]
+			if f := s.filter.Load(); f != nil && filtered {
+				out = (*f)(out)
+			}
+			w.Write(out)
+			s.unwritten.Add(-int64(n))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// SetEchoFilter makes f rewrite the output on its way to the terminal;
+// the analyzer still gets it unchanged. f is only called from one
+// goroutine, and not for stderr read separately.
+func (s *Session) SetEchoFilter(f func([]byte) []byte) {
+	s.filter.Store(&f)
+}
+
+// Pending reports whether output is still waiting for a slow terminal.
+// The child may be blocked on it, so the pause isn't silence.
+func (s *Session) Pending() bool {
+	return s.unwritten.Load() > 0
+}
+
+func (s *Session) finish() {
+	s.readers.Wait()
+	s.Output.Close()
+	if s.Stderr != nil {
+		s.Stderr.Close()
+	}
+	s.signal()
+}
+
+func (s *Session) signal() {
+	select {
+	case s.Notify <- struct{}{}:
+	default:
+	}
+}
+
+// Write sends input to the child. It is a no-op in pipe mode, where the
+// child reads stdin directly.
+func (s *Session) Write(p []byte) (int, error) {
+	if s.tty == nil {
+		return len(p), nil
+	}
+	return s.tty.Write(p)
+}
+
+// Resize copies the terminal size of stdin to the PTY and returns it.
+func (s *Session) Resize() (cols, rows int, ok bool) {
+	if s.tty == nil {
+		return 0, 0, false
+	}
+	return s.tty.resize()
+}
+
+// SetSize sets the PTY size explicitly, for callers that show the output in
+// a part of the terminal.
+func (s *Session) SetSize(cols, rows int) bool {
+	return s.tty != nil && s.tty.setSize(cols, rows) == nil
+}
+
+// Wait waits for the child to exit and releases the PTY.
+func (s *Session) Wait() error {
+	if s.wait == nil {
+		return nil
+	}
+	err := s.wait()
+	if s.tty != nil {
+		s.tty.Close()
+	}
+	return err
+}
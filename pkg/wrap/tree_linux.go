@@ -0,0 +1,199 @@
+package wrap
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// tree is the wrapped command and the processes it started. sl makes
+// itself a child subreaper, so processes the command leaves behind are
+// adopted by sl instead of init and can still be found, killed and reaped.
+type tree struct {
+	pid   int
+	group bool // pid leads its own process group; in PTY mode also a session
+
+	mu sync.Mutex
+	// known are the descendants seen before, with the parent they had when
+	// first seen: one that sl started isn't an orphan sl may reap
+	known map[int]int
+
+	stop     chan struct{} // closed once the tree is reaped, ends watch
+	stopOnce sync.Once
+}
+
+// roots are the children of all trees, so a tree doesn't take the child
+// of another session in the same sl for an adopted orphan.
+var roots sync.Map
+
+func newTree(pid int, group bool) *tree {
+	unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0)
+	t := &tree{pid: pid, group: group, known: map[int]int{}, stop: make(chan struct{})}
+	roots.Store(pid, t)
+	go t.watch()
+	return t
+}
+
+// watch keeps track of the tree and reaps adopted processes that exited,
+// which would otherwise stay zombies until sl exits. It ends when the tree
+// is reaped.
+func (t *tree) watch() {
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+		}
+		members := t.members()
+		t.mu.Lock()
+		known := map[int]int{}
+		for pid, p := range members {
+			if parent, ok := t.known[pid]; ok {
+				known[pid] = parent
+			} else {
+				known[pid] = p.ppid
+			}
+		}
+		t.known = known
+		t.mu.Unlock()
+		t.reapFrom(members)
+	}
+}
+
+// proc is what /proc/<pid>/stat tells about a process.
+type proc struct {
+	ppid, pgrp, sid int
+	zombie          bool
+	cpu             time.Duration // user and system time
+}
+
+// clockTick is the unit of CPU times in /proc, USER_HZ, which is 100 on
+// all common architectures.
+const clockTick = 10 * time.Millisecond
+
+func procs() map[int]proc {
+	out := map[int]proc{}
+	entries, _ := os.ReadDir("/proc")
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile("/proc/" + e.Name() + "/stat")
+		if err != nil {
+			continue
+		}
+		// The command name in parentheses may contain anything
+		s := string(data)
+		fields := strings.Fields(s[strings.LastIndexByte(s, ')')+1:])
+		if len(fields) < 13 {
+			continue
+		}
+		p := proc{zombie: fields[0] == "Z"}
+		p.ppid, _ = strconv.Atoi(fields[1])
+		p.pgrp, _ = strconv.Atoi(fields[2])
+		p.sid, _ = strconv.Atoi(fields[3])
+		utime, _ := strconv.ParseInt(fields[11], 10, 64)
+		stime, _ := strconv.ParseInt(fields[12], 10, 64)
+		p.cpu = time.Duration(utime+stime) * clockTick
+		out[pid] = p
+	}
+	return out
+}
+
+// members returns the processes in the child's session or group, those
+// seen in the tree before, such as daemons that called setsid, and the
+// descendants of all of these. sl's other children, like led commands in
+// their own process groups, aren't members.
+func (t *tree) members() map[int]proc {
+	self := os.Getpid()
+	all := procs()
+	t.mu.Lock()
+	in := map[int]proc{}
+	for pid, p := range all {
+		if _, root := roots.Load(pid); pid == self || root && pid != t.pid {
+			continue
+		}
+		if _, known := t.known[pid]; pid == t.pid || known || t.inGroup(p) {
+			in[pid] = p
+		}
+	}
+	t.mu.Unlock()
+	for grown := true; grown; {
+		grown = false
+		for pid, p := range all {
+			if _, ok := in[pid]; !ok && pid != self {
+				if _, parent := in[p.ppid]; parent {
+					in[pid] = p
+					grown = true
+				}
+			}
+		}
+	}
+	return in
+}
+
+func (t *tree) signal(sig syscall.Signal) {
+	for pid, p := range t.members() {
+		if !p.zombie {
+			syscall.Kill(pid, sig)
+		}
+	}
+}
+
+func (t *tree) alive() bool {
+	for _, p := range t.members() {
+		if !p.zombie {
+			return true
+		}
+	}
+	return false
+}
+
+// cpuTime adds up the CPU time of the running members. Processes that
+// exited take theirs with them.
+func (t *tree) cpuTime() (time.Duration, bool) {
+	var sum time.Duration
+	for _, p := range t.members() {
+		sum += p.cpu
+	}
+	return sum, true
+}
+
+func (t *tree) reap() {
+	t.reapFrom(t.members())
+	roots.Delete(t.pid)
+	t.stopOnce.Do(func() { close(t.stop) })
+}
+
+// inGroup reports whether p is in the child's session or process group.
+func (t *tree) inGroup(p proc) bool {
+	return t.group && (p.sid == t.pid || p.pgrp == t.pid)
+}
+
+// reapFrom reaps the exited members that sl adopted. The child itself is
+// left to its exec.Cmd, and so is every other process sl started: Wait
+// would fail if it was reaped here.
+func (t *tree) reapFrom(members map[int]proc) {
+	self := os.Getpid()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for pid, p := range members {
+		if !p.zombie || p.ppid != self || pid == t.pid {
+			continue
+		}
+		// Adopted: it had another parent when first seen, or it is in the
+		// child's group, where sl starts nothing
+		if parent, ok := t.known[pid]; ok && parent != self || t.inGroup(p) {
+			var ws syscall.WaitStatus
+			syscall.Wait4(pid, &ws, syscall.WNOHANG, nil)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package wrap
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// running reports whether pid exists and hasn't exited.
+func running(pid int) bool {
+	p, ok := procs()[pid]
+	return ok && !p.zombie
+}
+
+// startTree runs script in its own process group, like a pipe-mode child,
+// and returns it with the pids it prints.
+func startTree(t *testing.T, script string, pids int) (*exec.Cmd, *tree, []int) {
+	t.Helper()
+	cmd := exec.Command("sh", "-c", script)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	tr := newTree(cmd.Process.Pid, true)
+	var found []int
+	scanner := bufio.NewScanner(out)
+	for len(found) < pids && scanner.Scan() {
+		pid, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		found = append(found, pid)
+	}
+	return cmd, tr, found
+}
+
+func TestTreeTerminate(t *testing.T) {
+	// Started like a led command: sl's child in a group of its own
+	other := exec.Command("sleep", "60")
+	other.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := other.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer other.Process.Kill()
+
+	cmd, tr, pids := startTree(t, "sleep 60 & echo $!; sh -c 'sleep 60 & echo $!; wait' & wait", 2)
+	for _, pid := range pids {
+		if !running(pid) {
+			t.Fatalf("grandchild %d isn't running", pid)
+		}
+	}
+	if _, ok := tr.members()[other.Process.Pid]; ok {
+		t.Errorf("unrelated child %d is a member", other.Process.Pid)
+	}
+
+	tr.terminate()
+	cmd.Wait()
+	for _, pid := range pids {
+		if running(pid) {
+			t.Errorf("grandchild %d survived", pid)
+		}
+	}
+	if !running(other.Process.Pid) {
+		t.Fatalf("unrelated child %d was terminated", other.Process.Pid)
+	}
+	// Its exec.Cmd still gets to reap it
+	other.Process.Kill()
+	var exitErr *exec.ExitError
+	if err := other.Wait(); !errors.As(err, &exitErr) {
+		t.Errorf("waiting for the unrelated child: %v", err)
+	}
+}
+
+func TestTreeReapsOrphans(t *testing.T) {
+	// The shell exits at once and leaves its child to sl, the subreaper
+	cmd, tr, pids := startTree(t, "sleep 60 & echo $!", 1)
+	cmd.Wait()
+	orphan := pids[0]
+	for deadline := time.Now().Add(2 * time.Second); procs()[orphan].ppid != os.Getpid(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("orphan %d wasn't adopted", orphan)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, ok := tr.members()[orphan]; !ok {
+		t.Fatalf("orphan %d isn't a member", orphan)
+	}
+
+	tr.terminate()
+	if _, ok := procs()[orphan]; ok {
+		t.Errorf("orphan %d wasn't reaped", orphan)
+	}
+}
@@ -0,0 +1,41 @@
+//go:build !windows && !linux
+
+package wrap
+
+import (
+	"syscall"
+	"time"
+)
+
+// tree is the wrapped command and, when it leads its own process group,
+// the processes it started in it. Orphans are reaped by init.
+type tree struct {
+	pid   int
+	group bool
+}
+
+func newTree(pid int, group bool) *tree {
+	return &tree{pid: pid, group: group}
+}
+
+func (t *tree) target() int {
+	if t.group {
+		return -t.pid
+	}
+	return t.pid
+}
+
+func (t *tree) signal(sig syscall.Signal) {
+	syscall.Kill(t.target(), sig)
+}
+
+func (t *tree) alive() bool {
+	return syscall.Kill(t.target(), 0) == nil
+}
+
+func (t *tree) reap() {}
+
+// cpuTime isn't measured here.
+func (t *tree) cpuTime() (time.Duration, bool) {
+	return 0, false
+}
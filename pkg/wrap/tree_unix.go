@@ -0,0 +1,45 @@
+//go:build !windows
+
+package wrap
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// killGrace is how long processes get to exit after SIGTERM before they
+// are killed.
+const killGrace = 2 * time.Second
+
+// ownGroup puts a pipe-mode child in its own process group so the group
+// can be signalled as a whole. A child that reads from the terminal stays
+// in sl's group, since a background group would be stopped by SIGTTIN.
+func ownGroup(cmd *exec.Cmd) bool {
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		return false
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return true
+}
+
+// terminate asks the child and everything it started to exit, kills what
+// is left after killGrace and reaps it.
+func (t *tree) terminate() {
+	t.signal(syscall.SIGTERM)
+	// Stopped processes only see SIGTERM once they continue
+	t.signal(syscall.SIGCONT)
+	for deadline := time.Now().Add(killGrace); t.alive() && time.Now().Before(deadline); {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if t.alive() {
+		t.signal(syscall.SIGKILL)
+		for deadline := time.Now().Add(killGrace); t.alive() && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	t.reap()
+}
@@ -0,0 +1,68 @@
+package wrap
+
+import (
+	"os/exec"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// tree is the wrapped command and the processes it started, held together
+// by a job object. The job kills them all when sl exits, however it ends.
+type tree struct {
+	job windows.Handle
+}
+
+// ownGroup is a no-op on Windows; the job object does the grouping.
+func ownGroup(cmd *exec.Cmd) bool {
+	return false
+}
+
+func newTree(pid int, group bool) *tree {
+	job, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		return nil
+	}
+	info := windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION{}
+	info.BasicLimitInformation.LimitFlags = windows.JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE
+	windows.SetInformationJobObject(job, windows.JobObjectExtendedLimitInformation, uintptr(unsafe.Pointer(&info)), uint32(unsafe.Sizeof(info)))
+	proc, err := windows.OpenProcess(windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE, false, uint32(pid))
+	if err != nil {
+		windows.CloseHandle(job)
+		return nil
+	}
+	defer windows.CloseHandle(proc)
+	if windows.AssignProcessToJobObject(job, proc) != nil {
+		windows.CloseHandle(job)
+		return nil
+	}
+	return &tree{job: job}
+}
+
+// jobAccounting is JOBOBJECT_BASIC_ACCOUNTING_INFORMATION; times are in
+// 100 ns units.
+type jobAccounting struct {
+	TotalUserTime             int64
+	TotalKernelTime           int64
+	ThisPeriodTotalUserTime   int64
+	ThisPeriodTotalKernelTime int64
+	TotalPageFaultCount       uint32
+	TotalProcesses            uint32
+	ActiveProcesses           uint32
+	TotalTerminatedProcesses  uint32
+}
+
+// cpuTime returns the CPU time of every process that ran in the job.
+func (t *tree) cpuTime() (time.Duration, bool) {
+	var info jobAccounting
+	if windows.QueryInformationJobObject(t.job, windows.JobObjectBasicAccountingInformation, uintptr(unsafe.Pointer(&info)), uint32(unsafe.Sizeof(info)), nil) != nil {
+		return 0, false
+	}
+	return time.Duration(info.TotalUserTime+info.TotalKernelTime) * 100, true
+}
+
+// terminate kills every process in the job.
+func (t *tree) terminate() {
+	windows.TerminateJobObject(t.job, 1)
+}
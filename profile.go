@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/f0i/status-light/pkg/backend"
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// profilesPath holds the launch profiles.
+const profilesPath = "configs/profiles.json"
+
+// Profile is a named way to launch a command, run with "sl run @name", so
+// several agent setups don't need wrapper scripts.
+type Profile struct {
+	Command []string          `json:"command"`
+	Env     map[string]string `json:"env"` // $VARS in values are expanded
+	// Config name to load; the command's name by default
+	Tool string `json:"tool"`
+	// sl options such as "--stderr" or "--restart=on-failure"
+	Options []string `json:"options"`
+	// Settings laid over the tool config, e.g. { "theme": "high-contrast" }
+	Config json.RawMessage `json:"config"`
+	// "local" never uses the daemon, "daemon" requires it; by default the
+	// daemon is used when it runs
+	Backend string `json:"backend"`
+}
+
+// profile is set when the command was started with "sl run".
+var profile *Profile
+
+func loadProfiles() (map[string]*Profile, error) {
+	data, err := os.ReadFile(profilesPath)
+	if err != nil {
+		return nil, err
+	}
+	var profiles map[string]*Profile
+	if err := json.Unmarshal(stripComments(data), &profiles); err != nil {
+		return nil, fmt.Errorf("%s: %w", profilesPath, err)
+	}
+	return profiles, nil
+}
+
+// cmdRun handles "sl run [--name label] @name [args...]": it selects the
+// profile, sets its environment and returns the arguments the wrapper is
+// run with, the profile's options and command followed by args. Options
+// and a command instead of a profile name are run as they are. Without
+// either it lists the profiles and returns nil.
+func cmdRun(args []string) ([]string, error) {
+	var out []string
+	if len(args) > 0 {
+		if label, ok := strings.CutPrefix(args[0], "--name="); ok {
+			out, args = []string{"--name", label}, args[1:]
+		} else if args[0] == "--name" && len(args) > 1 {
+			out, args = []string{"--name", args[1]}, args[2:]
+		}
+	}
+	if len(args) > 0 && !strings.HasPrefix(args[0], "@") {
+		return append(out, args...), nil
+	}
+	profiles, err := loadProfiles()
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		names := make([]string, 0, len(profiles))
+		for name := range profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("@%-20s %s\n", name, strings.Join(profiles[name].Command, " "))
+		}
+		return nil, nil
+	}
+	name, ok := strings.CutPrefix(args[0], "@")
+	p := profiles[name]
+	if !ok || p == nil {
+		return nil, fmt.Errorf("unknown profile %q (see %s)", args[0], profilesPath)
+	}
+	if len(p.Command) == 0 {
+		return nil, fmt.Errorf("profile @%s has no command", name)
+	}
+	switch p.Backend {
+	case "", "auto", "local", "daemon":
+	default:
+		return nil, fmt.Errorf("profile @%s: unknown backend %q", name, p.Backend)
+	}
+	for k, v := range p.Env {
+		os.Setenv(k, os.ExpandEnv(v))
+	}
+	profile = p
+	out = append(out, p.Options...)
+	out = append(out, "--")
+	out = append(out, p.Command...)
+	return append(out, args[1:]...), nil
+}
+
+// apply lays the profile's settings over cfg.
+func (p *Profile) apply(cfg *Config) error {
+	if p == nil || len(p.Config) == 0 {
+		return nil
+	}
+	return json.Unmarshal(p.Config, cfg)
+}
+
+// indicator connects to the daemon as the profile's backend asks.
+func (p *Profile) indicator(tool, name string, local state.Indicator) (state.Indicator, error) {
+	if p == nil {
+		return newIndicator(tool, name, local), nil
+	}
+	switch p.Backend {
+	case "local":
+		return local, nil
+	case "daemon":
+		return backend.DialDaemon(backend.SocketPath(), tool, name, local)
+	}
+	return newIndicator(tool, name, local), nil
+}
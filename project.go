@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// projectFile is a project's own config, found in the current directory
+// or above it, so repositories can carry patterns and colors tuned for
+// their agents:
+//
+//	{
+//	  "theme": "high-contrast",
+//	  "tools": { "aider": { "patterns": { "waiting": ["^deploy\\? "] } } }
+//	}
+//
+// Its settings are laid over the tool config for every tool, those under
+// "tools" only over the one named.
+const projectFile = ".statuslight.json"
+
+// projectKeys are the settings a project file may have. A cloned
+// repository isn't trusted to run commands, send requests or drive the
+// hardware, so besides the detection settings of a pattern pack it can
+// only choose the theme and a few harmless thresholds.
+var projectKeys = append(slices.Clone(packKeys), "theme", "redact", "cost", "stall", "off_after_idle_ms")
+
+// findProjectConfig returns the nearest project file from the current
+// directory up, or "".
+func findProjectConfig() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	for {
+		path := filepath.Join(dir, projectFile)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// projectSections splits a project file into the settings for every
+// tool, under "", and those of each tool in "tools".
+func projectSections(data []byte) (map[string]json.RawMessage, error) {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(stripComments(data), &keys); err != nil {
+		return nil, err
+	}
+	sections := map[string]json.RawMessage{}
+	if raw, ok := keys["tools"]; ok {
+		if err := json.Unmarshal(raw, &sections); err != nil {
+			return nil, fmt.Errorf("tools: %w", err)
+		}
+		delete(keys, "tools")
+	}
+	for name, raw := range sections {
+		var toolKeys map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &toolKeys); err != nil {
+			return nil, fmt.Errorf("tools.%s: %w", name, err)
+		}
+		if err := checkProjectKeys(toolKeys); err != nil {
+			return nil, fmt.Errorf("tools.%s: %w", name, err)
+		}
+	}
+	if err := checkProjectKeys(keys); err != nil {
+		return nil, err
+	}
+	sections[""], _ = json.Marshal(keys)
+	return sections, nil
+}
+
+func checkProjectKeys(keys map[string]json.RawMessage) error {
+	for key := range keys {
+		if !slices.Contains(projectKeys, key) {
+			return fmt.Errorf("has %q, which a project file may not set", key)
+		}
+	}
+	return nil
+}
+
+// applyProject lays the settings of the nearest project file for tool over
+// cfg. A file that is broken or sets what it may not is ignored as a
+// whole, with a warning.
+func applyProject(cfg *Config, tool string) {
+	path := findProjectConfig()
+	if path == "" {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ignoring %v\n", err)
+		return
+	}
+	sections, err := projectSections(data)
+	if err == nil {
+		merged := *cfg
+		if err = json.Unmarshal(sections[""], &merged); err == nil && sections[tool] != nil {
+			err = json.Unmarshal(sections[tool], &merged)
+		}
+		if err == nil {
+			*cfg = merged
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ignoring %s: %v\n", path, err)
+		return
+	}
+	if os.Getenv("DEBUG_SL") != "" {
+		fmt.Fprintf(os.Stderr, "[DEBUG] Project config: %s\n", path)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// redactPatterns find secrets that commonly show up in agent sessions:
+// API keys and tokens of the big services, bearer tokens, JWTs and email
+// addresses.
+var redactPatterns = []string{
+	`\bsk-[A-Za-z0-9_-]{20,}`,                                         // Anthropic, OpenAI
+	`\bgh[pousr]_[A-Za-z0-9]{30,}`,                                    // GitHub
+	`\bgithub_pat_[A-Za-z0-9_]{30,}`,                                  // GitHub fine-grained
+	`\bAKIA[0-9A-Z]{16}\b`,                                            // AWS access key
+	`\bxox[abprs]-[A-Za-z0-9-]{10,}`,                                  // Slack
+	`\bAIza[0-9A-Za-z_-]{35}`,                                         // Google
+	`(?i)\bbearer\s+[A-Za-z0-9._~+/-]{20,}=*`,                         // Authorization headers
+	`\beyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`, // JWT
+	`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,                  // email
+}
+
+// redacted replaces what a redactor finds.
+var redacted = []byte("[REDACTED]")
+
+// redactMaxHold bounds the end of a chunk held back for the next one.
+const redactMaxHold = 256
+
+// redactor removes secrets from output before it is written to disk. A
+// secret may be split between two chunks, so a run of characters that
+// could be part of one at the end of a chunk is held back until the next.
+type redactor struct {
+	re   *regexp.Regexp
+	held []byte
+}
+
+// newRedactor finds the built-in patterns and extra. Invalid extra
+// patterns are skipped.
+func newRedactor(extra []string) *redactor {
+	patterns := append([]string{}, redactPatterns...)
+	for _, p := range extra {
+		if _, err := regexp.Compile(p); err != nil {
+			if os.Getenv("DEBUG_SL") != "" {
+				fmt.Fprintf(os.Stderr, "[DEBUG] Ignoring redact pattern %q: %v\n", p, err)
+			}
+			continue
+		}
+		patterns = append(patterns, p)
+	}
+	var alt bytes.Buffer
+	for i, p := range patterns {
+		if i > 0 {
+			alt.WriteByte('|')
+		}
+		alt.WriteString("(?:" + p + ")")
+	}
+	return &redactor{re: regexp.MustCompile(alt.String())}
+}
+
+// redact returns the part of data that can be written, with secrets
+// replaced.
+func (r *redactor) redact(data []byte) []byte {
+	data = append(r.held, data...)
+	r.held = nil
+	start := len(data)
+	for start > 0 && len(data)-start < redactMaxHold && tokenChar(data[start-1]) {
+		start--
+	}
+	if start < len(data) && (start == 0 || !tokenChar(data[start-1])) {
+		r.held = append([]byte{}, data[start:]...)
+		data = data[:start]
+	}
+	return r.re.ReplaceAll(data, redacted)
+}
+
+// tokenChar reports whether b may be part of a key, token or email
+// address. Prompts end in other characters, such as "?", ")" or an
+// escape sequence, so they aren't held back.
+func tokenChar(b byte) bool {
+	return b >= 'A' && b <= 'Z' || b >= 'a' && b <= 'z' || b >= '0' && b <= '9' || bytes.IndexByte([]byte("._%+@/~=-_"), b) >= 0
+}
+
+// flush returns what is still held back.
+func (r *redactor) flush() []byte {
+	data := r.re.ReplaceAll(r.held, redacted)
+	r.held = nil
+	return data
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/f0i/status-light/internal/testfeed"
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// cmdReplay runs a copy made with --tee through the detection again, with
+// a fake clock, and prints what the LED showed when. A maintainer can see
+// how a user's session was taken apart, and how a changed config or
+// pattern takes it apart now.
+func cmdReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	tool := fs.String("tool", "", "config `name` to detect with (default: the command in the file)")
+	identity := fs.String("i", "", "age or SSH private `key` to decrypt an encrypted file with")
+	print := fs.Bool("print", false, "print the (decrypted) output script instead of replaying it")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s replay [options] <file>\n\nFiles are written with sl --tee, by hand in the same format, or are\nasciinema v2 recordings (.cast).\n\nOptions:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	data, err := readMaybeEncrypted(fs.Arg(0), *identity)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if *print {
+		os.Stdout.Write(data)
+		return 0
+	}
+	var steps []testfeed.Step
+	cast := bytes.HasPrefix(data, []byte("{"))
+	cols, rows, command := 80, 24, ""
+	if cast {
+		steps, cols, rows, command, err = parseCast(data)
+	} else {
+		steps, err = testfeed.Parse(bytes.NewReader(data))
+		command = replayCommand(data)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", fs.Arg(0), err)
+		return 1
+	}
+	if *tool == "" {
+		*tool = "default"
+		if fields := strings.Fields(command); len(fields) > 0 {
+			*tool = detectTool(fields)
+		}
+	}
+
+	cfg := loadConfig(*tool).Config
+	// Only detection is replayed; nothing may run commands or send requests
+	cfg.Hooks, cfg.Push, cfg.Escalations = nil, nil, nil
+	var until time.Duration
+	if len(steps) > 0 {
+		until = steps[len(steps)-1].At
+	}
+	clock := testfeed.NewClock()
+	rec := testfeed.NewRecorder(clock)
+	mon := state.NewMonitor(cfg, rec)
+	mon.Clock = clock
+	mon.Screen.Resize(cols, rows)
+	testfeed.Play(mon, clock, steps, until+time.Second)
+	for _, c := range rec.Changes {
+		fmt.Println(c)
+	}
+	return 0
+}
+
+// replayCommand returns the command in the first line of a --tee copy,
+// such as "# claude --resume, started ...".
+func replayCommand(data []byte) string {
+	line, _, _ := bytes.Cut(data, []byte("\n"))
+	command, ok := strings.CutPrefix(string(line), "# ")
+	if !ok {
+		return ""
+	}
+	command, _, _ = strings.Cut(command, ",")
+	return command
+}
+
+// parseCast reads an asciinema v2 recording: its output events, the
+// terminal size and the command recorded, if any. Resizes during the
+// recording and input are left out.
+func parseCast(data []byte) (steps []testfeed.Step, cols, rows int, command string, err error) {
+	lines := strings.Split(string(data), "\n")
+	var header castHeader
+	if err := json.Unmarshal([]byte(lines[0]), &header); err != nil {
+		return nil, 0, 0, "", fmt.Errorf("cast header: %w", err)
+	}
+	if header.Version != 2 {
+		return nil, 0, 0, "", fmt.Errorf("asciinema version %d isn't supported, only 2", header.Version)
+	}
+	cols, rows = header.Width, header.Height
+	if cols <= 0 || rows <= 0 {
+		cols, rows = 80, 24
+	}
+	for n, line := range lines[1:] {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var event [3]any
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			return nil, 0, 0, "", fmt.Errorf("line %d: %w", n+2, err)
+		}
+		at, ok1 := event[0].(float64)
+		kind, ok2 := event[1].(string)
+		text, ok3 := event[2].(string)
+		if !ok1 || !ok2 || !ok3 {
+			return nil, 0, 0, "", fmt.Errorf("line %d: not an event", n+2)
+		}
+		if kind != "o" {
+			continue
+		}
+		step := testfeed.Step{At: time.Duration(at * float64(time.Second)), Stream: "stdout", Data: text}
+		if len(steps) > 0 && step.At < steps[len(steps)-1].At {
+			step.At = steps[len(steps)-1].At
+		}
+		steps = append(steps, step)
+	}
+	return steps, cols, rows, header.Command, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/f0i/status-light/pkg/wrap"
+)
+
+// Restart backoff: the first relaunch waits restartMinDelay, every further
+// one twice as long up to restartMaxDelay. A run that lasted longer than
+// restartMaxDelay counts as healthy and starts over at the minimum.
+const (
+	restartMinDelay = time.Second
+	restartMaxDelay = time.Minute
+)
+
+// restartPolicy is parsed from --restart.
+type restartPolicy struct {
+	onFailure bool
+	max       int // restarts allowed; 0 for no limit
+}
+
+// parseRestart accepts "no", "on-failure" and "on-failure:N".
+func parseRestart(s string) (restartPolicy, error) {
+	if s == "" || s == "no" {
+		return restartPolicy{}, nil
+	}
+	mode, count, hasCount := strings.Cut(s, ":")
+	if mode != "on-failure" {
+		return restartPolicy{}, fmt.Errorf("unknown restart policy %q", s)
+	}
+	p := restartPolicy{onFailure: true}
+	if hasCount {
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 1 {
+			return restartPolicy{}, fmt.Errorf("invalid restart count %q", count)
+		}
+		p.max = n
+	}
+	return p, nil
+}
+
+// allows reports whether the command may be relaunched after restarts
+// earlier relaunches.
+func (p restartPolicy) allows(restarts int) bool {
+	return p.onFailure && (p.max == 0 || restarts < p.max)
+}
+
+// restartDelay returns how long to wait before the next relaunch, given
+// the previous delay and how long the crashed run lasted.
+func restartDelay(prev, ran time.Duration) time.Duration {
+	if prev == 0 || ran > restartMaxDelay {
+		return restartMinDelay
+	}
+	return min(2*prev, restartMaxDelay)
+}
+
+// child is the session of the command's current run. Input forwarding
+// writes through it, so it reaches the command across restarts.
+type child struct {
+	session atomic.Pointer[wrap.Session]
+}
+
+func (c *child) Write(p []byte) (int, error) {
+	return c.session.Load().Write(p)
+}
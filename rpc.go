@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/f0i/status-light/pkg/backend"
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// JSON-RPC 2.0 error codes.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcNoMethod       = -32601
+	rpcInvalidParams  = -32602
+)
+
+// rpcMaxMessage bounds one request, which may carry a lot of output.
+const rpcMaxMessage = 16 << 20
+
+// rpcRequest is a JSON-RPC request or, without an id, a notification.
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// rpcMessage is what sl writes: a response, or a notification when Method
+// is set.
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  any             `json:"params,omitempty"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// rpcParams are the parameters of all methods; each uses some of them.
+type rpcParams struct {
+	Session int    `json:"session"`
+	Tool    string `json:"tool"`
+	Data    string `json:"data"`
+	Stream  string `json:"stream"`
+	Cols    int    `json:"cols"`
+	Rows    int    `json:"rows"`
+	Action  string `json:"action"`
+}
+
+// rpcState is the state of a session as reported to the client.
+type rpcState struct {
+	Session  int      `json:"session"`
+	State    string   `json:"state"`
+	Effect   string   `json:"effect"`
+	Progress *float64 `json:"progress,omitempty"`
+	Cost     *float64 `json:"cost,omitempty"`
+}
+
+// rpcSession is a monitor driven by the client's terminal capture.
+type rpcSession struct {
+	id     int
+	mon    *state.Monitor
+	led    state.Indicator
+	report func()
+
+	// what the monitor last showed
+	state  state.State
+	effect state.Effect
+}
+
+// rpcServer serves "sl rpc". All sessions are driven from one goroutine.
+type rpcServer struct {
+	mu       sync.Mutex // guards writes to out
+	out      *json.Encoder
+	sessions map[int]*rpcSession
+	next     int
+}
+
+// cmdRPC runs "sl rpc", which lets an editor extension or other program
+// embed the detection: it sends the output of its own terminals as
+// JSON-RPC 2.0 requests on stdin, one per line, and gets the states back
+// on stdout, while sl drives the configured backends as for a wrapped
+// command.
+func cmdRPC(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintf(os.Stderr, "Usage: %s rpc\n\nReads JSON-RPC 2.0 requests from stdin, one per line, and writes responses\nand \"state\" notifications to stdout. Methods:\n  start  {tool, cols, rows}             start a session, returns its id\n  feed   {session, data, stream}        analyze output (stream \"stdout\" or \"stderr\")\n  typed  {session}                      the user typed into the terminal\n  resize {session, cols, rows}          the terminal was resized\n  action {session, action}              \"ack\" or \"cycle\", as the hotkeys do\n  state  {session}                      returns the state\n  stop   {session}                      end the session and turn its light off\n", os.Args[0])
+		return 2
+	}
+	s := &rpcServer{
+		out:      json.NewEncoder(os.Stdout),
+		sessions: map[int]*rpcSession{},
+	}
+	requests := make(chan []byte)
+	go func() {
+		defer close(requests)
+		in := bufio.NewScanner(os.Stdin)
+		in.Buffer(make([]byte, 64*1024), rpcMaxMessage)
+		for in.Scan() {
+			line := append([]byte{}, in.Bytes()...)
+			if len(line) > 0 {
+				requests <- line
+			}
+		}
+		if err := in.Err(); err != nil {
+			fmt.Fprintf(os.Stderr, "Reading requests: %v\n", err)
+		}
+	}()
+
+	ticker := time.NewTicker(state.TickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case line, ok := <-requests:
+			if !ok {
+				// The client went away; nothing else turns its lights off
+				for _, ss := range s.sessions {
+					backend.Shutdown(ss.led)
+				}
+				return 0
+			}
+			s.handle(line)
+		case <-ticker.C:
+			for _, ss := range s.sessions {
+				ss.mon.Tick(ss.mon.Clock.Now())
+				if ss.report != nil {
+					ss.report()
+				}
+			}
+		}
+	}
+}
+
+// handle answers one request.
+func (s *rpcServer) handle(line []byte) {
+	var req rpcRequest
+	if err := json.Unmarshal(line, &req); err != nil {
+		s.send(rpcMessage{ID: json.RawMessage("null"), Error: &rpcError{rpcParseError, err.Error()}})
+		return
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		s.reply(req, nil, &rpcError{rpcInvalidRequest, "not a JSON-RPC 2.0 request"})
+		return
+	}
+	var p rpcParams
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &p); err != nil {
+			s.reply(req, nil, &rpcError{rpcInvalidParams, err.Error()})
+			return
+		}
+	}
+	if req.Method == "start" {
+		s.reply(req, s.start(p), nil)
+		return
+	}
+	ss := s.sessions[p.Session]
+	if ss == nil {
+		s.reply(req, nil, &rpcError{rpcInvalidParams, fmt.Sprintf("no session %d", p.Session)})
+		return
+	}
+	now := ss.mon.Clock.Now()
+	switch req.Method {
+	case "feed":
+		stream := p.Stream
+		if stream == "" {
+			stream = "stdout"
+		}
+		if stream != "stdout" && stream != "stderr" {
+			s.reply(req, nil, &rpcError{rpcInvalidParams, fmt.Sprintf("unknown stream %q", stream)})
+			return
+		}
+		ss.mon.Feed([]byte(p.Data), stream, now)
+	case "typed":
+		ss.mon.Typed(now)
+	case "resize":
+		if p.Cols <= 0 || p.Rows <= 0 {
+			s.reply(req, nil, &rpcError{rpcInvalidParams, "cols and rows must be positive"})
+			return
+		}
+		ss.mon.Screen.Resize(p.Cols, p.Rows)
+	case "action":
+		a := state.Action(p.Action)
+		if a != state.ActionAcknowledge && a != state.ActionCycleMode {
+			s.reply(req, nil, &rpcError{rpcInvalidParams, fmt.Sprintf("unknown action %q (ack or cycle)", p.Action)})
+			return
+		}
+		ss.mon.Handle(a)
+	case "state":
+	case "stop":
+		backend.Shutdown(ss.led)
+		delete(s.sessions, ss.id)
+		s.reply(req, true, nil)
+		return
+	default:
+		s.reply(req, nil, &rpcError{rpcNoMethod, fmt.Sprintf("unknown method %q", req.Method)})
+		return
+	}
+	s.reply(req, ss.snapshot(), nil)
+}
+
+// start creates a session with the config of p.Tool.
+func (s *rpcServer) start(p rpcParams) rpcState {
+	tool := p.Tool
+	if tool == "" {
+		tool = "default"
+	}
+	s.next++
+	ss := &rpcSession{id: s.next}
+	cfg := loadConfig(tool)
+	local := ledFromConfig(cfg)
+	ss.led = newIndicator(tool, "", localBackends(cfg, local))
+	ss.mon = state.NewMonitor(cfg.Config, &rpcIndicator{Indicator: ss.led, server: s, session: ss})
+	ss.mon.Tool = tool
+	ss.mon.Quiet = backend.StartQuiet(cfg.QuietHours, cfg.Presence, local)
+	cols, rows := 80, 24
+	if p.Cols > 0 && p.Rows > 0 {
+		cols, rows = p.Cols, p.Rows
+	}
+	ss.mon.Screen.Resize(cols, rows)
+	ss.report = reportSession(ss.led, ss.mon)
+	s.sessions[ss.id] = ss
+	ss.mon.Start(ss.mon.Clock.Now())
+	return ss.snapshot()
+}
+
+func (ss *rpcSession) snapshot() rpcState {
+	st := rpcState{Session: ss.id, State: ss.state.String(), Effect: effectName(ss.effect)}
+	if ss.mon.Progress >= 0 {
+		st.Progress = &ss.mon.Progress
+	}
+	if ss.mon.Cost > 0 {
+		st.Cost = &ss.mon.Cost
+	}
+	return st
+}
+
+// effectName names the solid effect, which is empty in configs.
+func effectName(effect state.Effect) string {
+	if effect == state.EffectSolid {
+		return "solid"
+	}
+	return string(effect)
+}
+
+func (s *rpcServer) reply(req rpcRequest, result any, err *rpcError) {
+	if req.ID == nil {
+		// Notifications get no response
+		return
+	}
+	s.send(rpcMessage{ID: req.ID, Result: result, Error: err})
+}
+
+func (s *rpcServer) send(msg rpcMessage) {
+	msg.JSONRPC = "2.0"
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.out.Encode(msg)
+}
+
+// rpcIndicator passes what a session's monitor shows on to its backends
+// and tells the client with a "state" notification.
+type rpcIndicator struct {
+	state.Indicator
+	server  *rpcServer
+	session *rpcSession
+}
+
+func (i *rpcIndicator) SetState(st state.State) {
+	i.SetEffect(st, state.EffectSolid)
+}
+
+func (i *rpcIndicator) SetEffect(st state.State, effect state.Effect) {
+	i.Indicator.SetEffect(st, effect)
+	ss := i.session
+	if st == ss.state && effect == ss.effect {
+		return
+	}
+	ss.state, ss.effect = st, effect
+	i.server.send(rpcMessage{Method: "state", Params: rpcState{Session: ss.id, State: st.String(), Effect: effectName(effect)}})
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// version is the release this binary was built from, set with
+// -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
+// releaseKey is the base64 ed25519 public key release checksums are signed
+// with, set with -ldflags "-X main.releaseKey=...". Builds without it can't
+// update themselves.
+var releaseKey = ""
+
+const (
+	releaseRepo     = "f0i/status-light"
+	checksumsAsset  = "SHA256SUMS"
+	signatureAsset  = "SHA256SUMS.sig"
+	maxReleaseAsset = 100 << 20
+	updateTimeout   = 5 * time.Minute
+)
+
+type release struct {
+	Tag    string `json:"tag_name"`
+	Assets []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// cmdSelfUpdate replaces the running binary with the latest GitHub release
+// for this platform, for installs that no package manager keeps current.
+func cmdSelfUpdate(args []string) int {
+	fs := flag.NewFlagSet("self-update", flag.ExitOnError)
+	check := fs.Bool("check", false, "only report whether a newer release exists")
+	force := fs.Bool("force", false, "install the release even if it is the running version")
+	repo := fs.String("repo", releaseRepo, "GitHub `owner/name` to take releases from")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s self-update [options]\n\nOptions:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	client := &http.Client{Timeout: updateTimeout}
+	rel, err := latestRelease(client, *repo)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Checking for updates: %v\n", err)
+		return 1
+	}
+	if rel.Tag == version && !*force {
+		fmt.Printf("sl %s is up to date\n", version)
+		return 0
+	}
+	// A release may have been pulled, or the tag forged: never go back
+	if older, err := olderVersion(rel.Tag, version); err != nil {
+		fmt.Fprintf(os.Stderr, "Release %s: %v\n", rel.Tag, err)
+		return 1
+	} else if older {
+		fmt.Printf("sl %s is newer than the latest release %s\n", version, rel.Tag)
+		return 0
+	}
+	if *check {
+		fmt.Printf("sl %s is available (running %s)\n", rel.Tag, version)
+		return 0
+	}
+	if releaseKey == "" {
+		fmt.Fprintf(os.Stderr, "This build has no release key to verify updates with; download %s from https://github.com/%s/releases instead\n", rel.Tag, *repo)
+		return 1
+	}
+
+	name := releaseAssetName(runtime.GOOS, runtime.GOARCH)
+	binary, err := rel.download(client, name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	sums, err := rel.download(client, checksumsAsset)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	sig, err := rel.download(client, signatureAsset)
+	if err == nil {
+		err = verifySignature(sums, sig)
+	}
+	if err == nil {
+		// The tag isn't signed, so an old release could be published
+		// again under a new one; the listing names its version
+		err = verifyVersion(sums, rel.Tag)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Verifying %s: %v\n", checksumsAsset, err)
+		return 1
+	}
+	if err := verifyChecksum(sums, name, binary); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	path, err := replaceExecutable(binary)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Installing %s: %v\n", rel.Tag, err)
+		return 1
+	}
+	fmt.Printf("Updated %s from %s to %s\n", path, version, rel.Tag)
+	return 0
+}
+
+// olderVersion reports whether the release tag is older than running.
+// Development builds take any release.
+func olderVersion(tag, running string) (bool, error) {
+	r, err := parseVersion(tag)
+	if err != nil {
+		return false, err
+	}
+	v, err := parseVersion(running)
+	if err != nil {
+		return false, nil
+	}
+	for i := range r {
+		if r[i] != v[i] {
+			return r[i] < v[i], nil
+		}
+	}
+	return false, nil
+}
+
+// parseVersion reads a tag like v1.2.3 or v1.2.3-rc1. A pre-release sorts
+// before its release.
+func parseVersion(tag string) ([4]int, error) {
+	var v [4]int
+	s, ok := strings.CutPrefix(tag, "v")
+	if !ok {
+		return v, fmt.Errorf("version %q doesn't look like v1.2.3", tag)
+	}
+	s, pre, _ := strings.Cut(s, "-")
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return v, fmt.Errorf("version %q doesn't look like v1.2.3", tag)
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("version %q doesn't look like v1.2.3", tag)
+		}
+		v[i] = n
+	}
+	if pre == "" {
+		v[3] = 1
+	}
+	return v, nil
+}
+
+// releaseAssetName is the binary a release provides for a platform, e.g.
+// sl-linux-arm64 or sl-windows-amd64.exe.
+func releaseAssetName(goos, goarch string) string {
+	name := "sl-" + goos + "-" + goarch
+	if goos == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+func latestRelease(client *http.Client, repo string) (*release, error) {
+	req, err := http.NewRequest("GET", "https://api.github.com/repos/"+repo+"/releases/latest", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	data, err := fetch(client, req)
+	if err != nil {
+		return nil, err
+	}
+	var rel release
+	if err := json.Unmarshal(data, &rel); err != nil {
+		return nil, fmt.Errorf("release of %s: %w", repo, err)
+	}
+	return &rel, nil
+}
+
+// download fetches the asset called name.
+func (r *release) download(client *http.Client, name string) ([]byte, error) {
+	for _, a := range r.Assets {
+		if a.Name != name {
+			continue
+		}
+		req, err := http.NewRequest("GET", a.URL, nil)
+		if err != nil {
+			return nil, err
+		}
+		data, err := fetch(client, req)
+		if err != nil {
+			return nil, fmt.Errorf("downloading %s: %w", name, err)
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("release %s has no %s", r.Tag, name)
+}
+
+func fetch(client *http.Client, req *http.Request) ([]byte, error) {
+	req.Header.Set("User-Agent", "sl/"+version)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", req.URL, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxReleaseAsset+1))
+	if err == nil && len(data) > maxReleaseAsset {
+		err = fmt.Errorf("%s: larger than %d bytes", req.URL, maxReleaseAsset)
+	}
+	return data, err
+}
+
+// verifyChecksum checks data against its line in a sha256sum listing.
+func verifyChecksum(sums []byte, name string, data []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || strings.TrimPrefix(fields[1], "*") != name {
+			continue
+		}
+		want, err := hex.DecodeString(fields[0])
+		if err != nil {
+			return fmt.Errorf("%s: bad checksum for %s", checksumsAsset, name)
+		}
+		got := sha256.Sum256(data)
+		if !bytes.Equal(got[:], want) {
+			return fmt.Errorf("checksum mismatch for %s", name)
+		}
+		return nil
+	}
+	return fmt.Errorf("%s lists no checksum for %s", checksumsAsset, name)
+}
+
+// verifyVersion checks that the signed checksums are those of release
+// tag: they must have a "# version v1.2.3" line, which sha256sum -c skips.
+func verifyVersion(sums []byte, tag string) error {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "# version "); ok {
+			if v = strings.TrimSpace(v); v != tag {
+				return fmt.Errorf("signed for %s, not %s", v, tag)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("no version line")
+}
+
+// verifySignature checks the ed25519 signature of the checksums, raw or
+// base64 encoded, against releaseKey.
+func verifySignature(sums, sig []byte) error {
+	key, err := base64.StdEncoding.DecodeString(releaseKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("bad release key")
+	}
+	if len(sig) != ed25519.SignatureSize {
+		if sig, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig))); err != nil {
+			return fmt.Errorf("bad signature")
+		}
+	}
+	if !ed25519.Verify(key, sums, sig) {
+		return fmt.Errorf("signature does not match")
+	}
+	return nil
+}
+
+// replaceExecutable writes binary next to the running executable and
+// renames it over it, so a failed download never leaves half a binary.
+// Windows can't replace a running executable, but it can rename it, so
+// the old one is moved aside first.
+func replaceExecutable(binary []byte) (string, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	if path, err = filepath.EvalSymlinks(path); err != nil {
+		return "", err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".sl-update-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(binary); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()|0o111); err != nil {
+		return "", err
+	}
+	if runtime.GOOS == "windows" {
+		old := path + ".old"
+		os.Remove(old)
+		if err := os.Rename(path, old); err != nil {
+			return "", err
+		}
+		if err := os.Rename(tmp.Name(), path); err != nil {
+			os.Rename(old, path)
+			return "", err
+		}
+		return path, nil
+	}
+	return path, os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestOlderVersion(t *testing.T) {
+	tests := []struct {
+		tag, running string
+		older        bool
+		err          bool
+	}{
+		{"v1.2.3", "v1.2.3", false, false},
+		{"v1.2.4", "v1.2.3", false, false},
+		{"v1.10.0", "v1.9.9", false, false},
+		{"v1.2.2", "v1.2.3", true, false},
+		{"v0.9.0", "v1.0.0", true, false},
+		{"v1.2.3-rc1", "v1.2.3", true, false},
+		{"v1.2.3", "v1.2.3-rc1", false, false},
+		{"v1.0.0", "dev", false, false},
+		{"latest", "v1.0.0", false, true},
+		{"v1.2", "v1.0.0", false, true},
+	}
+	for _, tt := range tests {
+		older, err := olderVersion(tt.tag, tt.running)
+		if older != tt.older || (err != nil) != tt.err {
+			t.Errorf("olderVersion(%q, %q) = %v, %v; want %v, error %v", tt.tag, tt.running, older, err, tt.older, tt.err)
+		}
+	}
+}
+
+func TestParseVersionRejects(t *testing.T) {
+	for _, tag := range []string{"", "1.2.3", "v1.2", "v1.2.3.4", "v1.x.3", "v1.-2.3", "latest", "v1..3"} {
+		if _, err := parseVersion(tag); err == nil {
+			t.Errorf("parseVersion(%q) accepted it", tag)
+		}
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	binary := []byte("binary")
+	sum := sha256.Sum256(binary)
+	line := hex.EncodeToString(sum[:])
+	tests := []struct {
+		name string
+		sums string
+		err  string
+	}{
+		{"text mode", line + "  sl-linux-amd64\n", ""},
+		{"binary mode", line + " *sl-linux-amd64\n", ""},
+		{"among others", "# version v1.0.0\n" + strings.Repeat("0", 64) + "  sl-darwin-arm64\n" + line + "  sl-linux-amd64\n", ""},
+		{"mismatch", strings.Repeat("0", 64) + "  sl-linux-amd64\n", "checksum mismatch"},
+		{"not hex", "zz  sl-linux-amd64\n", "bad checksum"},
+		{"not listed", line + "  sl-linux-arm64\n", "lists no checksum"},
+		{"prefix of another name", line + "  sl-linux-amd64.exe\n", "lists no checksum"},
+	}
+	for _, tt := range tests {
+		err := verifyChecksum([]byte(tt.sums), "sl-linux-amd64", binary)
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherPriv, _ := ed25519.GenerateKey(nil)
+	defer func(key string) { releaseKey = key }(releaseKey)
+	releaseKey = base64.StdEncoding.EncodeToString(pub)
+
+	sums := []byte("# version v1.2.3\n" + strings.Repeat("0", 64) + "  sl-linux-amd64\n")
+	sig := ed25519.Sign(priv, sums)
+	tests := []struct {
+		name string
+		sums []byte
+		sig  []byte
+		key  string
+		err  string
+	}{
+		{"raw", sums, sig, releaseKey, ""},
+		{"base64", sums, []byte(base64.StdEncoding.EncodeToString(sig) + "\n"), releaseKey, ""},
+		{"tampered", append([]byte("# "), sums...), sig, releaseKey, "does not match"},
+		{"other key", sums, ed25519.Sign(otherPriv, sums), releaseKey, "does not match"},
+		{"garbage", sums, []byte("not a signature"), releaseKey, "bad signature"},
+		{"empty", sums, nil, releaseKey, "does not match"},
+		{"bad key", sums, sig, "c2hvcnQ=", "bad release key"},
+	}
+	for _, tt := range tests {
+		releaseKey = tt.key
+		err := verifySignature(tt.sums, tt.sig)
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestVerifyVersion(t *testing.T) {
+	sums := []byte("# version v1.2.3\n" + strings.Repeat("0", 64) + "  sl-linux-amd64\n")
+	if err := verifyVersion(sums, "v1.2.3"); err != nil {
+		t.Errorf("matching version: %v", err)
+	}
+	// An old signed listing republished under a newer tag
+	if err := verifyVersion(sums, "v1.3.0"); err == nil {
+		t.Errorf("listing of v1.2.3 accepted for v1.3.0")
+	}
+	if err := verifyVersion([]byte(strings.Repeat("0", 64)+"  sl-linux-amd64\n"), "v1.2.3"); err == nil {
+		t.Errorf("listing without a version accepted")
+	}
+}
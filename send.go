@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/subtle"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/f0i/status-light/pkg/backend"
+)
+
+// RemoteInput allows "sl send" to type into sessions. Token must be given
+// with every request; input is only accepted while the session is in one of
+// States, by default only while waiting.
+type RemoteInput struct {
+	Token  string   `json:"token"`
+	States []string `json:"states"`
+}
+
+// send passes msg.Data to the session named by msg.Session, which is a
+// session id, a session's --name or a tool name. It returns why it didn't,
+// or "". Must be called with d.mu held.
+func (d *Daemon) send(msg backend.Message) string {
+	r := d.remoteInput
+	if r == nil || r.Token == "" {
+		return "remote input is disabled, set remote_input in configs/daemon.json"
+	}
+	if subtle.ConstantTimeCompare([]byte(msg.Token), []byte(r.Token)) != 1 {
+		return "invalid token"
+	}
+	var target *daemonSession
+	if s, ok := d.sessions[msg.Session]; ok {
+		target = s
+	} else {
+		for _, s := range d.sessions {
+			if s.Tool != msg.Session && s.Name != msg.Session {
+				continue
+			}
+			if target != nil {
+				return fmt.Sprintf("several %s sessions, use the session id", msg.Session)
+			}
+			target = s
+		}
+	}
+	if target == nil {
+		return fmt.Sprintf("no session %s", msg.Session)
+	}
+	states := r.States
+	if len(states) == 0 {
+		states = []string{"waiting"}
+	}
+	if !slices.Contains(states, target.State.String()) {
+		return fmt.Sprintf("session %s is %s, input is accepted while %v", target.ID, target.State, states)
+	}
+	if d.debug {
+		fmt.Fprintf(os.Stderr, "[DEBUG] Sending %d bytes to session %s\n", len(msg.Data), target.ID)
+	}
+	if !target.out.queue(backend.Message{Type: "input", Data: msg.Data}) {
+		return fmt.Sprintf("session %s isn't reading its input", target.ID)
+	}
+	return ""
+}
+
+// cmdSend types text into a session through the daemon, e.g. to answer a
+// prompt from another machine over ssh.
+func cmdSend(args []string) int {
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	token := fs.String("token", os.Getenv("SL_TOKEN"), "remote_input token of the daemon (default $SL_TOKEN)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s send [--token t] <session> <text>\n\n<session> is a session id, name or tool from \"sl status\". Escapes like \\n and \\r in <text> are interpreted.\n\nOptions:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+	text, err := strconv.Unquote(`"` + fs.Arg(1) + `"`)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid text %q: %v\n", fs.Arg(1), err)
+		return 2
+	}
+
+	var reply backend.Message
+	msg := backend.Message{Type: "send", Session: fs.Arg(0), Data: text, Token: *token}
+	if err := queryDaemon(backend.SocketPath(), msg, &reply); err != nil {
+		fmt.Fprintf(os.Stderr, "No daemon running on %s: %v\n", backend.SocketPath(), err)
+		return 1
+	}
+	if reply.Error != "" {
+		fmt.Fprintf(os.Stderr, "Not sent: %s\n", reply.Error)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/f0i/status-light/pkg/backend"
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// cmdSet asks the daemon to show a state no matter what the sessions do,
+// e.g. "waiting" as a busy light during a meeting, until the hold runs out
+// or "sl set auto".
+func cmdSet(args []string) int {
+	fs := flag.NewFlagSet("set", flag.ExitOnError)
+	hold := fs.Duration("for", 0, "return to automatic mode after `duration` (default: hold until \"sl set auto\")")
+	effect := fs.String("effect", "", "show the state `blink`ing or dim")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s set <state>|auto [options]\n\nStates: idle, thinking, waiting, error, stalled, syncing, success, responding\n\nOptions:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if len(args) == 0 {
+		fs.Usage()
+		return 2
+	}
+	name := args[0]
+	fs.Parse(args[1:])
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	if _, ok := state.ParseState(name); !ok && name != "auto" {
+		fmt.Fprintf(os.Stderr, "Unknown state %q\n", name)
+		return 2
+	}
+	switch state.Effect(*effect) {
+	case state.EffectSolid, state.EffectBlink, state.EffectDim:
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown effect %q (blink or dim)\n", *effect)
+		return 2
+	}
+	if *hold < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid duration %s\n", *hold)
+		return 2
+	}
+
+	path := backend.SocketPath()
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "No daemon running on %s: %v\n", path, err)
+		return 1
+	}
+	defer conn.Close()
+	msg := backend.Message{Type: "set", State: name, Effect: state.Effect(*effect), DurationMs: hold.Milliseconds()}
+	if err := json.NewEncoder(conn).Encode(msg); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to talk to daemon: %v\n", err)
+		return 1
+	}
+	switch {
+	case name == "auto":
+		fmt.Println("Showing the sessions again")
+	case *hold > 0:
+		fmt.Printf("Showing %s until %s\n", name, time.Now().Add(*hold).Format("15:04"))
+	default:
+		fmt.Printf("Showing %s until \"sl set auto\"\n", name)
+	}
+	return 0
+}
@@ -2,328 +2,493 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
-	"regexp"
+	"syscall"
 	"time"
 
-	"github.com/creack/pty"
+	"github.com/f0i/status-light/pkg/backend"
+	"github.com/f0i/status-light/pkg/state"
+	"github.com/f0i/status-light/pkg/wrap"
 	"golang.org/x/term"
 )
 
-type State int
-
-const (
-	Idle State = iota
-	Thinking
-	Waiting
-)
-
-func (s State) String() string {
-	switch s {
-	case Idle:
-		return "idle"
-	case Thinking:
-		return "thinking"
-	case Waiting:
-		return "waiting"
-	default:
-		return "unknown"
-	}
-}
-
+// Config is a tool config: the detection settings plus how to show them.
 type Config struct {
-	Patterns struct {
-		Waiting  []string `json:"waiting"`
-		Thinking []string `json:"thinking"`
-	} `json:"patterns"`
-	IdleThresholdMs int `json:"idle_threshold_ms"`
+	state.Config
+	LEDCount   int                     `json:"led_count"`
+	LEDDevice  string                  `json:"led_device"`
+	Hotkey     string                  `json:"hotkey"`
+	StateFile  string                  `json:"state_file"`
+	BarSignal  int                     `json:"bar_signal"`
+	Lamp       *backend.Lamp           `json:"lamp"`
+	BLE        *backend.BLELight       `json:"ble"`
+	QuietHours *backend.QuietHours     `json:"quiet_hours"`
+	Presence   *backend.Presence       `json:"presence"`
+	Sim        *backend.SimOptions     `json:"sim"`
+	Zones      *backend.ZonesConfig    `json:"zones"`
+	Failover   *backend.FailoverConfig `json:"failover"`
+	Theme      string                  `json:"theme"`
+	Chat       *ChatConfig             `json:"chat"`
+	// Press keys at prompts nobody answers, for unattended runs
+	AutoAnswer *AutoAnswerConfig `json:"auto_answer"`
+	// Daemon only: who may type into sessions with "sl send"
+	RemoteInput *RemoteInput `json:"remote_input"`
+	HTTP        *HTTPConfig  `json:"http"`
+	FadeMs      int          `json:"fade_ms"`
+	// Daemon only: meetings shown on the LED
+	Calendar *backend.Calendar `json:"calendar"`
+	// Report the state to iTerm2 and WezTerm as user variables
+	UserVars bool `json:"user_vars"`
+	// Color the kitty tab and window border
+	Kitty *backend.Kitty `json:"kitty"`
+	// Announce state changes with text-to-speech
+	Speech *backend.Speech `json:"speech"`
+	// Patterns removed from --tee copies besides keys, tokens and emails
+	Redact []string `json:"redact"`
+	// Daemon only: tell external monitoring that it is alive
+	Heartbeat *Heartbeat `json:"heartbeat"`
+	// Daemon only: named settings switched with "sl profile use"
+	Profiles map[string]json.RawMessage `json:"profiles"`
 }
 
-type LEDController struct {
-	ledScript string
-	debug     bool
-}
-
-func NewLEDController() *LEDController {
-	exePath, _ := os.Executable()
-	dir := filepath.Dir(exePath)
-	return &LEDController{
-		ledScript: filepath.Join(dir, "led"),
-		debug:     os.Getenv("DEBUG_SL") != "",
+// loadConfig returns the config of a tool with the settings of the
+// project's .statuslight.json laid over it. The daemon's config is the
+// same wherever it is started.
+func loadConfig(toolName string) Config {
+	cfg := loadToolConfig(toolName)
+	if toolName != "daemon" {
+		applyProject(&cfg, toolName)
 	}
+	return cfg
 }
 
-func (l *LEDController) SetState(state State) {
-	// Match Python version exactly
-	var args []string
-	switch state {
-	case Idle:
-		args = []string{"a", "0", "0", "0", "255"} // blue
-	case Thinking:
-		args = []string{"a", "0", "255", "255", "0"} // yellow
-	case Waiting:
-		args = []string{"a", "0", "100", "0", "0"} // red
-	}
-
-	if l.debug {
-		fmt.Fprintf(os.Stderr, "[DEBUG] LED State: %s -> ./led %v\n", state, args)
+func loadToolConfig(toolName string) Config {
+	// Try loading config; packs installed with "sl patterns install" come
+	// after the configs/ of the current directory
+	configPaths := []string{"configs/" + toolName + ".json"}
+	if dir, err := packDir(); err == nil {
+		configPaths = append(configPaths, filepath.Join(dir, toolName+".json"))
 	}
-
-	cmd := exec.Command(l.ledScript, args...)
-	cmd.Stdout = nil
-	cmd.Stderr = nil
-	_ = cmd.Run()
-}
-
-func (l *LEDController) TurnOff() {
-	if l.debug {
-		fmt.Fprintf(os.Stderr, "[DEBUG] LED: turning off\n")
-	}
-	cmd := exec.Command(l.ledScript, "o")
-	cmd.Stdout = nil
-	cmd.Stderr = nil
-	_ = cmd.Run()
-}
-
-func loadConfig(toolName string) Config {
-	// Try loading config
-	configPaths := []string{
-		"configs/" + toolName + ".json",
+	configPaths = append(configPaths,
 		"configs/claude.json",
 		"configs/default.json",
-	}
+	)
 
 	for _, path := range configPaths {
 		if data, err := os.ReadFile(path); err == nil {
 			var cfg Config
-			if json.Unmarshal(data, &cfg) == nil {
+			if json.Unmarshal(stripComments(data), &cfg) == nil {
 				return cfg
 			}
 		}
 	}
 
 	// Default config
-	return Config{
-		Patterns: struct {
-			Waiting  []string `json:"waiting"`
-			Thinking []string `json:"thinking"`
-		}{
-			Waiting:  []string{"wait", "Wait", "\\(y/n\\)"},
-			Thinking: []string{"Imagining", "imagining", "Running", "running"},
-		},
-		IdleThresholdMs: 500,
-	}
+	return Config{Config: state.DefaultConfig()}
 }
 
-func compilePatterns(patterns []string) []*regexp.Regexp {
-	var compiled []*regexp.Regexp
-	for _, p := range patterns {
-		if re, err := regexp.Compile(p); err == nil {
-			compiled = append(compiled, re)
-		}
-	}
-	return compiled
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: %s [options] <command> [args...]
+       %s run [--name label] [@profile [args...] | command...]
+       %s run --split <command> --split <command>... [--logs dir]
+       %s exec --status-only [--hold 5s] <command> [args...]
+       %s watch [-f file]
+       %s rpc
+       %s attach <pid>
+       %s daemon [install-service]
+       %s snooze <duration>|off
+       %s set <state>|auto [--for 10m]
+       %s status [--json] [--follow]
+       %s profile [use <name>|off]
+       %s auto-answer [on|off]
+       %s history [--since 1h] [--json]
+       %s send [--token t] <session> <text>
+       %s bar [--format waybar|i3blocks] [--follow]
+       %s backend scan-ble [--duration 10s]
+       %s config init <tool>
+       %s config graph [--format dot|mermaid] <tool>
+       %s config lint [tool...]
+       %s patterns list|install <tool>
+       %s tune <command> [args...]
+       %s learn [-o file] <command> [args...]
+       %s replay [--tool name] [-i key] <file>
+       %s completion bash|zsh|fish
+       %s self-update [--check]
+       %s doctor
+       %s led test [--tool name] [--delay 1.5s] [--dry-run]
+
+Use "--" before the command to wrap a program named like a subcommand.
+
+Options:
+`, os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+	flag.PrintDefaults()
 }
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "Usage: %s <command> [args...]\n", os.Args[0])
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "watch":
+			os.Exit(cmdWatch(os.Args[2:]))
+		case "attach":
+			os.Exit(cmdAttach(os.Args[2:]))
+		case "daemon":
+			os.Exit(cmdDaemon(os.Args[2:]))
+		case "snooze":
+			os.Exit(cmdSnooze(os.Args[2:]))
+		case "set":
+			os.Exit(cmdSet(os.Args[2:]))
+		case "status":
+			os.Exit(cmdStatus(os.Args[2:]))
+		case "history":
+			os.Exit(cmdHistory(os.Args[2:]))
+		case "send":
+			os.Exit(cmdSend(os.Args[2:]))
+		case "bar":
+			os.Exit(cmdBar(os.Args[2:]))
+		case "backend":
+			os.Exit(cmdBackend(os.Args[2:]))
+		case "config":
+			os.Exit(cmdConfig(os.Args[2:]))
+		case "patterns":
+			os.Exit(cmdPatterns(os.Args[2:]))
+		case "profile":
+			os.Exit(cmdProfile(os.Args[2:]))
+		case "auto-answer":
+			os.Exit(cmdAutoAnswer(os.Args[2:]))
+		case "tune":
+			os.Exit(cmdTune(os.Args[2:]))
+		case "learn":
+			os.Exit(cmdLearn(os.Args[2:]))
+		case "replay":
+			os.Exit(cmdReplay(os.Args[2:]))
+		case "completion":
+			os.Exit(cmdCompletion(os.Args[2:]))
+		case "led":
+			os.Exit(cmdLED(os.Args[2:]))
+		case "doctor":
+			os.Exit(cmdDoctor(os.Args[2:]))
+		case "self-update":
+			os.Exit(cmdSelfUpdate(os.Args[2:]))
+		case "exec":
+			os.Exit(cmdExec(os.Args[2:]))
+		case "rpc":
+			os.Exit(cmdRPC(os.Args[2:]))
+		case "run":
+			if isSplit(os.Args[2:]) {
+				os.Exit(cmdSplit(os.Args[2:]))
+			}
+			args, err := cmdRun(os.Args[2:])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			if args == nil {
+				os.Exit(0)
+			}
+			os.Args = append(os.Args[:1], args...)
+		}
+	}
+
+	noPTY := flag.Bool("no-pty", false, "run the command with plain pipes instead of a PTY (default when stdout is not a terminal)")
+	splitStderr := flag.Bool("stderr", false, "capture stderr separately and match it against stderr_patterns")
+	sim := flag.Bool("sim", false, "show a simulated LED in the top right corner of the terminal")
+	pprofAddr := flag.String("pprof", "", "serve runtime profiles on `addr`, e.g. :6060")
+	porcelain := flag.Int("porcelain", 0, "write state changes as stable tab-separated lines to file descriptor `fd`, e.g. 3")
+	debugFile := flag.String("debug-file", "", "append debug output and other messages of sl to `file` instead of the terminal; turns debug output on")
+	debugFD := flag.Int("debug-fd", 0, "like --debug-file, for file descriptor `fd`")
+	teePath := flag.String("tee", "", "write a timestamped copy of the command's output to `file`, in the format of replay scripts, or an asciinema recording for a .cast file")
+	teePlain := flag.Bool("tee-plain", false, "leave escape sequences out of the --tee copy")
+	encrypt := flag.String("encrypt", "", "encrypt the --tee copy with age to `recipient`, an age or SSH public key or a file of them")
+	dryRun := flag.Bool("dry-run", false, "print what the backends would do (led script arguments, serial writes, commands, requests) to stderr instead of doing it; implies the local backends rather than the daemon")
+	name := flag.String("name", "", "label the session, e.g. backend-api, in the daemon's status, the dashboard and notifications")
+	restartFlag := flag.String("restart", "no", "relaunch the command when it exits with an error: `on-failure` or on-failure:N for at most N restarts")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
 		os.Exit(1)
 	}
+	args := flag.Args()
+	if err := redirectDiagnostics(*debugFile, *debugFD); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid debug output: %v\n", err)
+		os.Exit(1)
+	}
+	if *dryRun {
+		state.DryRun = os.Stderr
+	}
+	if *pprofAddr != "" {
+		servePprof(*pprofAddr)
+	}
+	restart, err := parseRestart(*restartFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --restart: %v\n", err)
+		os.Exit(1)
+	}
+	usePTY := !*noPTY && term.IsTerminal(int(os.Stdout.Fd()))
+	if *name == "" && os.Getenv("TMUX") != "" {
+		*name = tmuxName(os.Getenv("TMUX_PANE"))
+		if *name != "" && os.Getenv("DEBUG_SL") != "" {
+			fmt.Fprintf(os.Stderr, "[DEBUG] Session name from tmux: %s\n", *name)
+		}
+	}
 
-	debug := os.Getenv("DEBUG_SL") != ""
-	toolName := filepath.Base(os.Args[1])
+	toolName := detectTool(args)
+	if profile != nil && profile.Tool != "" {
+		toolName = profile.Tool
+	}
 	cfg := loadConfig(toolName)
-	waitingPatterns := compilePatterns(cfg.Patterns.Waiting)
-	thinkingPatterns := compilePatterns(cfg.Patterns.Thinking)
-	led := NewLEDController()
-
-	if debug {
-		fmt.Fprintf(os.Stderr, "[DEBUG] Thinking patterns: %d\n", len(thinkingPatterns))
-		fmt.Fprintf(os.Stderr, "[DEBUG] Starting timing-first approach: silence_threshold=2000ms\n")
+	if err := profile.apply(&cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid profile config: %v\n", err)
+		os.Exit(1)
+	}
+	local := ledFromConfig(cfg)
+	quiet := backend.StartQuiet(cfg.QuietHours, cfg.Presence, local)
+	led := localBackends(cfg, local)
+	// The daemon wouldn't know it is a dry run
+	if !*dryRun {
+		if led, err = profile.indicator(toolName, *name, led); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to connect to the daemon: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	client, _ := led.(*backend.DaemonClient)
+	if *sim && cfg.Sim == nil {
+		cfg.Sim = &backend.SimOptions{}
+	}
+	if cfg.Sim != nil {
+		if *sim {
+			cfg.Sim.Corner = true
+		}
+		// Shows this session even when the daemon owns the LED
+		s, err := backend.NewSim(*cfg.Sim, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Ignoring sim: %v\n", err)
+		} else {
+			led = backend.Indicators{led, s}
+		}
+	}
+	if cfg.UserVars && usePTY {
+		// Per tab, so also when the daemon owns the LED
+		led = backend.Indicators{led, backend.NewUserVars(os.Stdout, toolName)}
+	}
+	if *porcelain > 0 {
+		f := os.NewFile(uintptr(*porcelain), "porcelain")
+		if _, err := f.Stat(); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --porcelain: %v\n", err)
+			os.Exit(1)
+		}
+		led = backend.Indicators{led, backend.NewPorcelain(f)}
+	}
+	if cfg.Speech != nil {
+		// Per session, so each tool is named also when the daemon owns the LED
+		label := toolName
+		if *name != "" {
+			label = *name
+		}
+		led = backend.Indicators{led, backend.NewSpeaker(*cfg.Speech, label)}
+	}
+	if cfg.Kitty != nil && usePTY {
+		if k, err := backend.NewKittyTab(*cfg.Kitty, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Ignoring kitty: %v\n", err)
+		} else {
+			led = backend.Indicators{led, k}
+		}
+	}
+	mon := state.NewMonitor(cfg.Config, led)
+	mon.Tool = toolName
+	mon.Name = *name
+	mon.Quiet = quiet
+	var tee *teeFile
+	if *teePath != "" {
+		if tee, err = openTee(*teePath, teeOptions{*teePlain, cfg.Redact, *encrypt}, args, mon.Clock.Now()); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --tee: %v\n", err)
+			os.Exit(1)
+		}
+		mon.Tee = tee.write
 	}
 
-	// Setup PTY
-	cmd := exec.Command(os.Args[1], os.Args[2:]...)
-	ptmx, err := pty.Start(cmd)
+	// Start the command
+	start := func() (session *wrap.Session, err error) {
+		cmd := exec.Command(args[0], args[1:]...)
+		if usePTY {
+			session, err = wrap.StartPTY(cmd, *splitStderr)
+		} else {
+			session, err = wrap.StartPipes(cmd, *splitStderr)
+		}
+		if err == nil && cfg.OSCNotify.Swallows() {
+			session.SetEchoFilter((&state.NotificationFilter{}).Filter)
+		}
+		return session, err
+	}
+	session, err := start()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to start PTY: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Failed to start command: %v\n", err)
 		os.Exit(1)
 	}
-	defer ptmx.Close()
+	current := &child{}
+	current.session.Store(session)
+	if client != nil {
+		client.SetInput(func(data []byte) { current.Write(data) })
+	}
+	mon.Send = func(data []byte) { current.Write(data) }
+
+	// Take the whole process tree down when sl is told to exit
+	stopping := make(chan struct{})
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		<-sigs
+		close(stopping)
+		current.session.Load().Stop()
+	}()
+	mon.CPU = func() (time.Duration, bool) { return current.session.Load().CPUTime() }
+	if chat := startChat(cfg.Chat, mon, current); chat != nil {
+		mon.Observe = chat.observe
+	}
+
+	// Screen model sized like the user's terminal so wrapping matches
+	mon.Screen.AutoCR = !usePTY
+	resize := func() {
+		if cols, rows, ok := current.session.Load().Resize(); ok {
+			mon.Screen.Resize(cols, rows)
+		}
+	}
+	resize()
+	winch := make(chan os.Signal, 1)
+	defer wrap.NotifyResize(winch)()
 
-	// Set raw mode if stdin is a TTY
+	// Set raw mode if stdin is a TTY and the child has one too
 	var oldState *term.State
-	if term.IsTerminal(int(os.Stdin.Fd())) {
+	if usePTY && term.IsTerminal(int(os.Stdin.Fd())) {
 		oldState, _ = term.MakeRaw(int(os.Stdin.Fd()))
 		if oldState != nil {
 			defer term.Restore(int(os.Stdin.Fd()), oldState)
 		}
 	}
 
-	// State tracking
-	currentState := Idle
-	lastOutputTime := time.Now()
-	lastStateChange := time.Now()
-	lineBuffer := make([]string, 0, 100)
-	const minStateDuration = 200 * time.Millisecond
-	const silenceThreshold = 500 * time.Millisecond
+	mon.Start(mon.Clock.Now())
 
-	led.SetState(currentState)
-
-	// Channel for PTY output
-	ptyOutput := make(chan []byte, 100)
-	go func() {
-		buf := make([]byte, 1024)
-		for {
-			n, err := ptmx.Read(buf)
-			if err != nil {
-				close(ptyOutput)
-				return
-			}
-			data := make([]byte, n)
-			copy(data, buf[:n])
-			ptyOutput <- data
+	// Forward stdin to the PTY, minus our own hotkeys
+	var hotkeys *wrap.Hotkeys
+	if cfg.Hotkey != "" {
+		if hotkeys, err = wrap.NewHotkeys(cfg.Hotkey, mon.Actions); err != nil {
+			fmt.Fprintf(os.Stderr, "Ignoring hotkey: %v\r\n", err)
 		}
-	}()
-
-	// Channel for stdin
-	stdinChan := make(chan []byte, 10)
-	if term.IsTerminal(int(os.Stdin.Fd())) {
+	}
+	if usePTY && term.IsTerminal(int(os.Stdin.Fd())) {
 		go func() {
+			input := &wrap.Input{Hotkeys: hotkeys}
 			buf := make([]byte, 1024)
+			out := make([]byte, 0, 2048)
 			for {
 				n, err := os.Stdin.Read(buf)
 				if err != nil {
 					return
 				}
-				data := make([]byte, n)
-				copy(data, buf[:n])
-				stdinChan <- data
-			}
-		}()
-	}
-
-	ticker := time.NewTicker(100 * time.Millisecond)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case data, ok := <-ptyOutput:
-			if !ok {
-				goto cleanup
-			}
-
-			// Write to stdout
-			os.Stdout.Write(data)
-
-			// Update line buffer
-			for _, b := range data {
-				if b == '\n' {
-					if len(lineBuffer) >= 100 {
-						lineBuffer = lineBuffer[1:]
-					}
+				var typed bool
+				out, typed = input.Filter(buf[:n], out[:0])
+				if typed {
+					mon.Typed(mon.Clock.Now())
 				}
-			}
-			lineBuffer = append(lineBuffer, string(data))
-			if len(lineBuffer) > 100 {
-				lineBuffer = lineBuffer[len(lineBuffer)-100:]
-			}
-
-			// Update timing
-			now := time.Now()
-			lastOutputTime = now
-
-			// Check for thinking patterns in the output
-			foundThinking := false
-			outputStr := string(data)
-			for _, pattern := range thinkingPatterns {
-				if pattern.MatchString(outputStr) {
-					foundThinking = true
-					if debug {
-						fmt.Fprintf(os.Stderr, "[DEBUG] Thinking pattern matched: %s\n", pattern.String())
-					}
-					break
+				if len(out) > 0 {
+					current.Write(out)
 				}
 			}
+		}()
+	}
 
-			if foundThinking {
-				if currentState != Thinking {
-					if debug {
-						fmt.Fprintf(os.Stderr, "[DEBUG] State change (thinking pattern): %s -> thinking\n", currentState)
-					}
-					currentState = Thinking
-					lastStateChange = now
-					led.SetState(currentState)
-				}
-			} else if debug {
-				fmt.Fprintf(os.Stderr, "[DEBUG] No thinking patterns in output: %d bytes (state=%s)\n", len(data), currentState)
+	// Run the command until it exits for good, relaunching it after
+	// crashes if asked to
+	tick := reportSession(led, mon)
+	if answer := startAutoAnswer(cfg.AutoAnswer, mon, current); answer != nil {
+		report := tick
+		tick = func() {
+			if report != nil {
+				report()
 			}
-
-		case data := <-stdinChan:
-			ptmx.Write(data)
-
-		case <-ticker.C:
-			// Check for silence
-			now := time.Now()
-			timeSinceOutput := now.Sub(lastOutputTime)
-			timeInState := now.Sub(lastStateChange)
-
-			if timeSinceOutput > silenceThreshold && timeInState >= minStateDuration {
-				// Check last 20 lines for waiting patterns
-				foundWaiting := false
-				checkCount := 20
-				if len(lineBuffer) < checkCount {
-					checkCount = len(lineBuffer)
-				}
-
-				if checkCount > 0 {
-					startIdx := len(lineBuffer) - checkCount
-					for i := startIdx; i < len(lineBuffer); i++ {
-						for _, pattern := range waitingPatterns {
-							if pattern.MatchString(lineBuffer[i]) {
-								foundWaiting = true
-								if debug {
-									fmt.Fprintf(os.Stderr, "[DEBUG] Silence > %dms: Found waiting pattern in recent lines\n", int(timeSinceOutput.Milliseconds()))
-								}
-								break
-							}
-						}
-						if foundWaiting {
-							break
-						}
-					}
-				}
-
-				newState := Idle
-				if foundWaiting {
-					newState = Waiting
-				}
-
-				if newState != currentState {
-	if debug {
-		fmt.Fprintf(os.Stderr, "[DEBUG] Starting timing-first approach: silence_threshold=%dms\n", int(silenceThreshold.Milliseconds()))
+			answer.tick()
+		}
 	}
-					currentState = newState
-					lastStateChange = now
-					led.SetState(currentState)
-				}
-			}
+	var delay time.Duration
+run:
+	for restarts := 0; ; restarts++ {
+		began := time.Now()
+		wrap.Loop(mon, session, winch, resize, tick)
+
+		// Wait for command to finish
+		err = session.Wait()
+		select {
+		case <-stopping:
+			break run
+		default:
 		}
+		if err == nil || !restart.allows(restarts) {
+			break
+		}
+		delay = restartDelay(delay, time.Since(began))
+		mon.Fail(mon.Clock.Now(), "crashed: "+err.Error())
+		fmt.Fprintf(os.Stderr, "[sl] %s exited (%v), restarting in %s\r\n", toolName, err, delay)
+		select {
+		case <-time.After(delay):
+		case <-stopping:
+			break run
+		}
+		if session, err = start(); err != nil {
+			fmt.Fprintf(os.Stderr, "[sl] Failed to restart command: %v\r\n", err)
+			break
+		}
+		current.session.Store(session)
+		select {
+		case <-stopping:
+			// The signal may have stopped the old session instead
+			session.Stop()
+		default:
+		}
+		resize()
+		mon.Start(mon.Clock.Now())
 	}
 
-cleanup:
-	// Wait for command to finish
-	cmd.Wait()
-
 	// Turn off LED immediately
-	led.TurnOff()
+	backend.Shutdown(led)
+	if tee != nil {
+		tee.close(mon.Clock.Now())
+	}
 
 	// Restore terminal
 	if oldState != nil {
 		term.Restore(int(os.Stdin.Fd()), oldState)
 	}
+	// Exit like the command did the last time it ran
+	os.Exit(exitCode(err))
+}
+
+// redirectDiagnostics sends sl's own messages, debug output included, to
+// a file or file descriptor, where they can't corrupt the wrapped
+// program's display. The command's stderr still goes to the terminal.
+func redirectDiagnostics(path string, fd int) error {
+	var f *os.File
+	switch {
+	case path != "" && fd > 0:
+		return fmt.Errorf("use either --debug-file or --debug-fd")
+	case path != "":
+		var err error
+		if f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err != nil {
+			return err
+		}
+	case fd > 0:
+		f = os.NewFile(uintptr(fd), "debug")
+		if _, err := f.Stat(); err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+	os.Stderr = f
+	os.Setenv("DEBUG_SL", "1")
+	return nil
 }
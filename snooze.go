@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/f0i/status-light/pkg/backend"
+)
+
+// cmdSnooze asks the daemon to stop changing the LED and to hold back
+// notifications for a while, e.g. during a meeting.
+func cmdSnooze(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s snooze <duration>|off\n\nExamples: 30m, 1h30m, off\n", os.Args[0])
+		return 2
+	}
+	var d time.Duration
+	if args[0] != "off" {
+		var err error
+		if d, err = time.ParseDuration(args[0]); err != nil || d <= 0 {
+			fmt.Fprintf(os.Stderr, "Invalid duration %q\n", args[0])
+			return 2
+		}
+	}
+
+	path := backend.SocketPath()
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "No daemon running on %s: %v\n", path, err)
+		return 1
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(backend.Message{Type: "snooze", DurationMs: d.Milliseconds()}); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to talk to daemon: %v\n", err)
+		return 1
+	}
+	if d == 0 {
+		fmt.Println("Snooze ended")
+	} else {
+		fmt.Printf("Snoozed until %s\n", time.Now().Add(d).Format("15:04"))
+	}
+	return 0
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/f0i/status-light/internal/shell"
+	"github.com/f0i/status-light/pkg/backend"
+	"github.com/f0i/status-light/pkg/state"
+	"github.com/f0i/status-light/pkg/wrap"
+	"golang.org/x/term"
+)
+
+// splitCommands collects the repeated --split flags.
+type splitCommands []string
+
+func (c *splitCommands) String() string { return strings.Join(*c, ", ") }
+
+func (c *splitCommands) Set(s string) error {
+	if len(strings.Fields(s)) == 0 {
+		return fmt.Errorf("empty command")
+	}
+	*c = append(*c, s)
+	return nil
+}
+
+// isSplit reports whether "sl run" was given --split commands rather than
+// a profile.
+func isSplit(args []string) bool {
+	for _, a := range args {
+		if a == "--split" || a == "-split" || strings.HasPrefix(a, "--split=") || strings.HasPrefix(a, "-split=") {
+			return true
+		}
+	}
+	return false
+}
+
+// splitPane is one command of "sl run --split" with its own session and
+// monitor. Only its loop goroutine touches the monitor.
+type splitPane struct {
+	tool    string
+	command string
+	mon     *state.Monitor
+	led     state.Indicator
+	session *wrap.Session
+	log     *os.File
+
+	shown state.State // last state reported in log mode
+	err   error       // how the command exited, once done
+	done  bool
+}
+
+// splitView draws the panes stacked on top of each other, each below a
+// title line with its state, and sends keystrokes to the focused one.
+type splitView struct {
+	mu    sync.Mutex // one pane draws at a time
+	panes []*splitPane
+	focus atomic.Int32
+}
+
+// cmdSplit runs "sl run --split <command> --split <command>...": several
+// commands in one process, each on its own PTY and tracked on its own,
+// shown in panes or written to log files. The LED shows the most urgent
+// of them.
+func cmdSplit(args []string) int {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	var commands splitCommands
+	fs.Var(&commands, "split", "run `command` in a pane of its own; repeat for more")
+	logs := fs.String("logs", "", "write each command's output to a log file in `dir` instead of showing panes")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s run --split <command> [--split <command>...] [--logs dir]\n\nCommands run with the shell. In panes, press ctrl-t then:\n  1-9    type into that pane\n  n      type into the next pane\n  q      stop all commands\n  ctrl-t send ctrl-t to the pane\n\nOptions:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if len(commands) == 0 || fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+	if *logs == "" && (!term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd()))) {
+		fmt.Fprintln(os.Stderr, "sl run --split needs a terminal for its panes; use --logs <dir>")
+		return 1
+	}
+	if *logs != "" {
+		if err := os.MkdirAll(*logs, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+	}
+
+	// The first command's config decides the LED and its theme, and the
+	// LED follows the most urgent pane like the daemon follows sessions
+	v := &splitView{}
+	for _, c := range commands {
+		tool := detectTool(strings.Fields(c))
+		v.panes = append(v.panes, &splitPane{tool: tool, command: c})
+	}
+	cfg := loadConfig(v.panes[0].tool)
+	light := &splitLight{out: localBackends(cfg, ledFromConfig(cfg))}
+	defer backend.Shutdown(light.out)
+	for i, p := range v.panes {
+		pcfg := cfg
+		if i > 0 {
+			pcfg = loadConfig(p.tool)
+		}
+		// With the daemon running, each pane is a session of its own there
+		p.led = newIndicator(p.tool, "", light.slot())
+		p.mon = state.NewMonitor(pcfg.Config, p.led)
+		p.mon.Tool = p.tool
+
+		cmd := shell.Command(p.command)
+		var err error
+		if *logs != "" {
+			path := filepath.Join(*logs, fmt.Sprintf("%d-%s.log", i+1, p.tool))
+			if p.log, err = os.Create(path); err == nil {
+				defer p.log.Close()
+				p.session, err = wrap.StartPTYTo(cmd, p.log)
+			}
+		} else {
+			p.session, err = wrap.StartPTYSilent(cmd)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to start %s: %v\n", p.command, err)
+			for _, started := range v.panes[:i] {
+				started.session.Stop()
+				started.session.Wait()
+				started.led.TurnOff()
+			}
+			return 1
+		}
+	}
+	stop := func() {
+		for _, p := range v.panes {
+			p.session.Stop()
+		}
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		<-sigs
+		stop()
+	}()
+
+	if *logs == "" {
+		if oldState, err := term.MakeRaw(int(os.Stdin.Fd())); err == nil {
+			defer term.Restore(int(os.Stdin.Fd()), oldState)
+		}
+		// Alternate screen, hidden cursor
+		os.Stdout.WriteString("\x1b[?1049h\x1b[?25l")
+		go v.readKeys(stop)
+	}
+
+	var wg sync.WaitGroup
+	for i, p := range v.panes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			report := reportSession(p.led, p.mon)
+			update := func() {
+				if report != nil {
+					report()
+				}
+				if *logs == "" {
+					v.render(i)
+				} else {
+					v.logState(i)
+				}
+			}
+			var winch chan os.Signal
+			resize := func() {}
+			if *logs == "" {
+				winch = make(chan os.Signal, 1)
+				defer wrap.NotifyResize(winch)()
+				resize = func() {
+					_, rows, width := v.place(i)
+					p.session.SetSize(width, rows)
+					p.mon.Screen.Resize(width, rows)
+				}
+			} else {
+				p.session.SetSize(80, 24)
+			}
+			resize()
+			p.mon.Start(p.mon.Clock.Now())
+			wrap.Loop(p.mon, p.session, winch, resize, update)
+			p.err = p.session.Wait()
+			p.done = true
+			p.led.TurnOff()
+			update()
+		}()
+	}
+	wg.Wait()
+
+	if *logs == "" {
+		os.Stdout.WriteString("\x1b[?25h\x1b[?1049l")
+	}
+	code := 0
+	for i, p := range v.panes {
+		if p.err != nil {
+			if *logs == "" {
+				fmt.Fprintf(os.Stderr, "[sl] %d %s exited (%v)\r\n", i+1, p.tool, p.err)
+			}
+			code = 1
+		}
+	}
+	return code
+}
+
+// place returns the row of pane i's title line, how many rows of output
+// it shows below it and how wide it is. The panes share the terminal's
+// height evenly; the last one gets what is left over.
+func (v *splitView) place(i int) (title, rows, width int) {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		width, height = 80, 24
+	}
+	each := height / len(v.panes)
+	rows = each - 1
+	if i == len(v.panes)-1 {
+		rows = height - i*each - 1
+	}
+	return i*each + 1, max(rows, 1), width
+}
+
+// render redraws pane i. It is called from the pane's loop goroutine,
+// which owns its monitor.
+func (v *splitView) render(i int) {
+	p := v.panes[i]
+	title, rows, width := v.place(i)
+	label := p.mon.State.String()
+	if p.done {
+		label = "exited"
+		if p.err != nil {
+			label = "failed"
+		}
+	}
+	r, g, b := backend.StateColor(p.mon.State)
+	style := "\x1b[2m"
+	if int(v.focus.Load()) == i {
+		style = "\x1b[1;7m"
+	}
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "\x1b[%d;1H\x1b[7m\x1b[38;2;%d;%d;%dm %-8s \x1b[0m%s%s\x1b[0m", title, r, g, b, label, style, fit(fmt.Sprintf(" %d %s", i+1, p.command), width-10))
+	lines := p.mon.Screen.Lines()
+	for y := 0; y < rows; y++ {
+		fmt.Fprintf(&out, "\x1b[%d;1H", title+1+y)
+		l := ""
+		if y < len(lines) {
+			l = lines[y]
+		}
+		out.WriteString(fit(l, width))
+	}
+	v.mu.Lock()
+	os.Stdout.Write(out.Bytes())
+	v.mu.Unlock()
+}
+
+// logState prints pane i's state changes and exit, for log mode where the
+// output goes to files.
+func (v *splitView) logState(i int) {
+	p := v.panes[i]
+	line := ""
+	switch {
+	case p.done && p.err != nil:
+		line = fmt.Sprintf("exited (%v)", p.err)
+	case p.done:
+		line = "exited"
+	case p.mon.State != p.shown:
+		p.shown = p.mon.State
+		line = p.shown.String()
+	default:
+		return
+	}
+	v.mu.Lock()
+	fmt.Fprintf(os.Stderr, "[sl] %d %s: %s\n", i+1, p.tool, line)
+	v.mu.Unlock()
+}
+
+// readKeys sends keystrokes to the focused pane, except for the ctrl-t
+// commands that switch panes or stop everything.
+func (v *splitView) readKeys(stop func()) {
+	input := &wrap.Input{}
+	send := func(data []byte) {
+		if len(data) == 0 {
+			return
+		}
+		p := v.panes[v.focus.Load()]
+		out, typed := input.Filter(data, nil)
+		if typed {
+			p.mon.Typed(p.mon.Clock.Now())
+		}
+		p.session.Write(out)
+	}
+	prefix := false
+	buf := make([]byte, 1024)
+	var keys []byte
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+		keys = keys[:0]
+		for _, b := range buf[:n] {
+			if !prefix {
+				if b == tunePrefix {
+					prefix = true
+				} else {
+					keys = append(keys, b)
+				}
+				continue
+			}
+			prefix = false
+			switch {
+			case b == tunePrefix:
+				keys = append(keys, b)
+			case b >= '1' && b <= '9' && int(b-'1') < len(v.panes):
+				send(keys)
+				keys = keys[:0]
+				v.focus.Store(int32(b - '1'))
+			case b == 'n' || b == '\t':
+				send(keys)
+				keys = keys[:0]
+				v.focus.Store((v.focus.Load() + 1) % int32(len(v.panes)))
+			case b == 'q':
+				stop()
+			}
+		}
+		send(keys)
+	}
+}
+
+// splitLight shows the most urgent of several panes on one indicator, the
+// way the daemon does for separate sessions.
+type splitLight struct {
+	mu    sync.Mutex
+	out   state.Indicator
+	slots []*splitSlot
+
+	lit      bool
+	state    state.State
+	effect   state.Effect
+	progress float64
+}
+
+// splitSlot is the indicator of one pane.
+type splitSlot struct {
+	light    *splitLight
+	on       bool
+	state    state.State
+	effect   state.Effect
+	progress float64
+}
+
+func (l *splitLight) slot() *splitSlot {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := &splitSlot{light: l, progress: -1}
+	l.slots = append(l.slots, s)
+	return s
+}
+
+func (s *splitSlot) SetState(st state.State) {
+	s.SetEffect(st, state.EffectSolid)
+}
+
+func (s *splitSlot) SetEffect(st state.State, effect state.Effect) {
+	s.light.mu.Lock()
+	defer s.light.mu.Unlock()
+	if !s.on || st != s.state {
+		s.progress = -1
+	}
+	s.on, s.state, s.effect = true, st, effect
+	s.light.update()
+}
+
+func (s *splitSlot) SetProgress(progress float64) {
+	s.light.mu.Lock()
+	defer s.light.mu.Unlock()
+	s.progress = progress
+	s.light.update()
+}
+
+func (s *splitSlot) TurnOff() {
+	s.light.mu.Lock()
+	defer s.light.mu.Unlock()
+	s.on = false
+	s.light.update()
+}
+
+// update shows the most urgent pane. Must be called with l.mu held.
+func (l *splitLight) update() {
+	var winner *splitSlot
+	for _, s := range l.slots {
+		if s.on && (winner == nil || statePriority[s.state] > statePriority[winner.state] ||
+			s.state == winner.state && effectRank[s.effect] > effectRank[winner.effect]) {
+			winner = s
+		}
+	}
+	if winner == nil {
+		if l.lit {
+			l.out.TurnOff()
+			l.lit = false
+		}
+		return
+	}
+	if !l.lit || winner.state != l.state || winner.effect != l.effect {
+		l.out.SetEffect(winner.state, winner.effect)
+		l.lit, l.state, l.effect, l.progress = true, winner.state, winner.effect, -1
+	}
+	if winner.progress != l.progress {
+		l.out.SetProgress(winner.progress)
+		l.progress = winner.progress
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/f0i/status-light/pkg/backend"
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// Status is the daemon's answer to a "status" message.
+type Status struct {
+	State        string          `json:"state"`
+	Effect       state.Effect    `json:"effect,omitempty"`
+	Since        int64           `json:"since,omitempty"`
+	UptimeS      int64           `json:"uptime_s"`
+	SnoozedUntil int64           `json:"snoozed_until,omitempty"`
+	Sessions     []SessionStatus `json:"sessions"`
+	// State forced with "sl set", until OverrideUntil or "sl set auto"
+	Override      string `json:"override,omitempty"`
+	OverrideUntil int64  `json:"override_until,omitempty"`
+	// Calendar event shown by the daemon
+	Meeting      string `json:"meeting,omitempty"`
+	MeetingUntil int64  `json:"meeting_until,omitempty"`
+	// Dollars spent by all sessions, as far as they print it
+	Cost float64 `json:"cost,omitempty"`
+	// Led script, device and lamps that have failed since the daemon started
+	Backends []BackendStatus `json:"backends,omitempty"`
+	// Profile in use and those in configs/daemon.json
+	Profile  string   `json:"profile,omitempty"`
+	Profiles []string `json:"profiles,omitempty"`
+}
+
+type BackendStatus struct {
+	Backend  string `json:"backend"`
+	Failures int    `json:"failures"`
+	Streak   int    `json:"streak"` // failures in a row
+	Error    string `json:"error"`
+	Since    int64  `json:"since,omitempty"` // of the streak
+	// Failing persistently rather than once in a while
+	Failing bool `json:"failing"`
+}
+
+type SessionStatus struct {
+	ID       string       `json:"id"`
+	Tool     string       `json:"tool"`
+	Name     string       `json:"name,omitempty"`
+	PID      int          `json:"pid"`
+	State    string       `json:"state"`
+	Effect   state.Effect `json:"effect,omitempty"`
+	Progress float64      `json:"progress"`
+	Since    int64        `json:"since"`
+	Lines    []string     `json:"lines,omitempty"`
+	Cost     float64      `json:"cost,omitempty"`
+}
+
+// sessionLabel names a session by the label it was given with --name, else
+// by its tool.
+func sessionLabel(tool, name string) string {
+	if name != "" {
+		return name
+	}
+	return tool
+}
+
+// queryStatus asks the daemon at path for its status.
+func queryStatus(path string) (Status, error) {
+	var st Status
+	err := queryDaemon(path, backend.Message{Type: "status"}, &st)
+	return st, err
+}
+
+// queryDaemon sends msg to the daemon at path and decodes its one-line
+// answer into v.
+func queryDaemon(path string, msg backend.Message, v any) error {
+	conn, err := net.DialTimeout("unix", path, 2*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if err := json.NewEncoder(conn).Encode(msg); err != nil {
+		return err
+	}
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(line, v)
+}
+
+// followStatus prints the daemon's events as JSON lines until it goes
+// away, for programs that can run a command but not open a socket.
+func followStatus(path string) int {
+	conn, err := net.DialTimeout("unix", path, 2*time.Second)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "No daemon running on %s: %v\n", path, err)
+		return 1
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(backend.Message{Type: "subscribe"}); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	io.Copy(os.Stdout, conn)
+	fmt.Fprintln(os.Stderr, "Daemon stopped")
+	return 1
+}
+
+// cmdStatus prints the daemon's aggregated and per-session state, for
+// shell prompts and scripts.
+func cmdStatus(args []string) int {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print machine-readable JSON")
+	follow := fs.Bool("follow", false, "keep running and print every change as a JSON line, e.g. for an editor's statusline")
+	fs.Parse(args)
+	if *follow {
+		return followStatus(backend.SocketPath())
+	}
+
+	st, err := queryStatus(backend.SocketPath())
+	if err != nil {
+		if *asJSON {
+			fmt.Println(`{"state":"unknown"}`)
+		} else {
+			fmt.Fprintf(os.Stderr, "No daemon running on %s: %v\n", backend.SocketPath(), err)
+		}
+		return 1
+	}
+	if *asJSON {
+		json.NewEncoder(os.Stdout).Encode(st)
+		return 0
+	}
+
+	now := time.Now()
+	ago := func(unix int64) string {
+		return now.Sub(time.Unix(unix, 0)).Round(time.Second).String()
+	}
+	shown := st.State
+	if st.Effect != state.EffectSolid {
+		shown += " (" + string(st.Effect) + ")"
+	}
+	if st.Since != 0 {
+		shown += ", for " + ago(st.Since)
+	}
+	fmt.Printf("State:   %s\n", shown)
+	fmt.Printf("Uptime:  %s\n", (time.Duration(st.UptimeS) * time.Second).String())
+	if st.SnoozedUntil != 0 {
+		fmt.Printf("Snoozed: until %s\n", time.Unix(st.SnoozedUntil, 0).Format("15:04"))
+	}
+	if st.Override != "" {
+		until := "sl set auto"
+		if st.OverrideUntil != 0 {
+			until = time.Unix(st.OverrideUntil, 0).Format("15:04")
+		}
+		fmt.Printf("Set:     %s until %s\n", st.Override, until)
+	}
+	if st.Profile != "" {
+		fmt.Printf("Profile: %s\n", st.Profile)
+	}
+	if st.Cost > 0 {
+		fmt.Printf("Cost:    $%.2f\n", st.Cost)
+	}
+	if st.Meeting != "" {
+		fmt.Printf("Meeting: %s until %s\n", st.Meeting, time.Unix(st.MeetingUntil, 0).Format("15:04"))
+	}
+	for _, b := range st.Backends {
+		if b.Failing {
+			fmt.Printf("Failing: %s for %s, %d times in a row: %s\n", b.Backend, ago(b.Since), b.Streak, b.Error)
+		}
+	}
+	if len(st.Sessions) == 0 {
+		fmt.Println("No sessions")
+		return 0
+	}
+	fmt.Println("Sessions:")
+	for _, s := range st.Sessions {
+		line := fmt.Sprintf("  %-8d %-12s %-9s %s", s.PID, sessionLabel(s.Tool, s.Name), s.State, ago(s.Since))
+		if s.Progress >= 0 {
+			line += fmt.Sprintf(" %3.0f%%", s.Progress*100)
+		}
+		if s.Cost > 0 {
+			line += fmt.Sprintf(" $%.2f", s.Cost)
+		}
+		fmt.Println(line)
+	}
+	return 0
+}
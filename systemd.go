@@ -0,0 +1,191 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// activationListener returns the socket passed by systemd socket activation
+// (LISTEN_FDS/LISTEN_PID), or nil when the daemon was started directly.
+func activationListener() (net.Listener, bool, error) {
+	pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	n, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if pid != os.Getpid() || n < 1 {
+		return nil, false, nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	// Passed descriptors start at 3; only the first one is used
+	f := os.NewFile(3, "LISTEN_FD_3")
+	l, err := net.FileListener(f)
+	f.Close()
+	if err != nil {
+		return nil, false, err
+	}
+	return l, true, nil
+}
+
+// sdNotify sends a state string to systemd when running as a Type=notify
+// service. It does nothing otherwise.
+func sdNotify(state string) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return
+	}
+	if strings.HasPrefix(path, "@") {
+		path = "\x00" + path[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.Write([]byte(state))
+}
+
+const systemdSocketUnit = `[Unit]
+Description=Status light daemon socket
+
+[Socket]
+ListenStream=%t/status-light.sock
+SocketMode=0600
+
+[Install]
+WantedBy=sockets.target
+`
+
+const systemdServiceUnit = `[Unit]
+Description=Status light daemon
+Requires=status-light.socket
+After=status-light.socket
+
+[Service]
+Type=notify
+ExecStart=%s daemon
+WorkingDirectory=%s
+Restart=on-failure
+
+[Install]
+WantedBy=default.target
+`
+
+// systemdSpecifiers escapes the % that systemd expands in unit settings,
+// as in %h or %t.
+func systemdSpecifiers(s string) string {
+	return strings.ReplaceAll(s, "%", "%%")
+}
+
+// systemdQuote quotes s as one word of a command line in a unit, so a
+// path with spaces, quotes, % or $ reaches the daemon as it is.
+func systemdQuote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", "$$").Replace(s)
+	return `"` + systemdSpecifiers(s) + `"`
+}
+
+func systemdUnitDir() (string, error) {
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+	if configDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		configDir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(configDir, "systemd", "user"), nil
+}
+
+// installSystemd writes user units for the daemon and its socket.
+func installSystemd(exe, workDir string, printOnly bool) int {
+	service := fmt.Sprintf(systemdServiceUnit, systemdQuote(exe), systemdSpecifiers(workDir))
+	if printOnly {
+		fmt.Printf("# status-light.socket\n%s\n# status-light.service\n%s", systemdSocketUnit, service)
+		return 0
+	}
+
+	unitDir, err := systemdUnitDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot find home directory: %v\n", err)
+		return 1
+	}
+	if err := os.MkdirAll(unitDir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create %s: %v\n", unitDir, err)
+		return 1
+	}
+	units := map[string]string{
+		"status-light.socket":  systemdSocketUnit,
+		"status-light.service": service,
+	}
+	for name, content := range units {
+		path := filepath.Join(unitDir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write %s: %v\n", path, err)
+			return 1
+		}
+		fmt.Printf("Wrote %s\n", path)
+	}
+	fmt.Println("\nEnable with:")
+	fmt.Println("  systemctl --user daemon-reload")
+	fmt.Println("  systemctl --user enable --now status-light.socket")
+	fmt.Println("To start it at boot without logging in (e.g. on a Raspberry Pi):")
+	fmt.Println("  loginctl enable-linger $USER")
+	return 0
+}
+
+// uninstallSystemd removes the units written by installSystemd.
+func uninstallSystemd() int {
+	unitDir, err := systemdUnitDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot find home directory: %v\n", err)
+		return 1
+	}
+	fmt.Println("Disable first with:")
+	fmt.Println("  systemctl --user disable --now status-light.socket status-light.service")
+	for _, name := range []string{"status-light.socket", "status-light.service"} {
+		path := filepath.Join(unitDir, name)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Failed to remove %s: %v\n", path, err)
+			return 1
+		}
+		fmt.Printf("Removed %s\n", path)
+	}
+	return 0
+}
+
+// cmdInstallService installs the daemon as a systemd user service, or as a
+// launchd agent on macOS.
+func cmdInstallService(args []string) int {
+	fs := flag.NewFlagSet("install-service", flag.ExitOnError)
+	printOnly := fs.Bool("print", false, "print the service files instead of installing them")
+	noLoad := fs.Bool("no-load", false, "macOS: write the plist without loading it")
+	fs.Parse(args)
+
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot locate sl binary: %v\n", err)
+		return 1
+	}
+	// Configs are looked up relative to the working directory
+	workDir, _ := os.Getwd()
+	if runtime.GOOS == "darwin" {
+		return installLaunchd(exe, workDir, *printOnly, !*noLoad)
+	}
+	return installSystemd(exe, workDir, *printOnly)
+}
+
+func cmdUninstallService(args []string) int {
+	if runtime.GOOS == "darwin" {
+		return uninstallLaunchd()
+	}
+	return uninstallSystemd()
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/f0i/status-light/pkg/state"
+	"golang.org/x/term"
+)
+
+// teeFile keeps a timestamped copy of a session's output, for an audit of
+// what an agent did or to replay it through the detection later, with
+// secrets redacted. It is written in the format of internal/testfeed
+// scripts, one chunk per line with the time since the start, and a last
+// line with the time it ended:
+//
+//	# claude --resume, started 2026-10-16T09:12:03+02:00
+//	0s "Welcome back\r\n"
+//	1.204s stderr "warning: slow network\n"
+//	5.9s
+//
+// A path ending in .cast gets an asciinema v2 recording instead, which
+// asciinema plays back. Encrypted with age, the copy can hold proprietary
+// code and still be sent to a maintainer, who replays it with their key.
+type teeFile struct {
+	f      io.WriteCloser
+	start  time.Time
+	plain  bool
+	redact *redactor
+	cast   bool
+	split  []byte // start of a character cut off at the end of a chunk
+}
+
+// teeOptions are how a copy is written.
+type teeOptions struct {
+	plain     bool     // without escape sequences
+	redact    []string // patterns to remove besides the built-in ones
+	recipient string   // encrypt to this age or SSH key, or a file of them
+}
+
+// castHeader is the first line of an asciinema v2 recording.
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Command   string            `json:"command,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// isCast reports whether path names an asciinema recording, possibly
+// encrypted.
+func isCast(path string) bool {
+	return strings.HasSuffix(strings.TrimSuffix(path, ".age"), ".cast")
+}
+
+// openTee creates the copy at path for the command args started at now.
+func openTee(path string, opts teeOptions, args []string, now time.Time) (*teeFile, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	var f io.WriteCloser = file
+	if opts.recipient != "" {
+		if f, err = ageEncrypt(file, opts.recipient); err != nil {
+			file.Close()
+			os.Remove(path)
+			return nil, err
+		}
+	}
+	t := &teeFile{f: f, start: now, plain: opts.plain, redact: newRedactor(opts.redact), cast: isCast(path)}
+	command := strings.Join(args, " ")
+	if !t.cast {
+		fmt.Fprintf(f, "# %s, started %s\n", command, now.Format(time.RFC3339))
+		return t, nil
+	}
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		width, height = 80, 24
+	}
+	header, _ := json.Marshal(castHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: now.Unix(),
+		Command:   command,
+		Env:       map[string]string{"TERM": os.Getenv("TERM"), "SHELL": os.Getenv("SHELL")},
+	})
+	fmt.Fprintf(f, "%s\n", header)
+	return t, nil
+}
+
+// write adds a chunk of output. Write errors are ignored, so a full disk
+// doesn't take the session down.
+func (t *teeFile) write(data []byte, stream string, now time.Time) {
+	if t.plain {
+		data = state.StripEscapes(data)
+	}
+	t.line(t.redact.redact(data), stream, now)
+}
+
+func (t *teeFile) line(data []byte, stream string, now time.Time) {
+	if !t.cast {
+		if len(data) == 0 {
+			return
+		}
+		prefix := ""
+		if stream != "stdout" {
+			prefix = stream + " "
+		}
+		fmt.Fprintf(t.f, "%s %s%s\n", t.since(now), prefix, strconv.Quote(string(data)))
+		return
+	}
+	// Casts are JSON, which can't hold half a character; stderr is output
+	// like any other there
+	data = append(t.split, data...)
+	t.split = nil
+	if cut := utf8Cut(data); cut < len(data) {
+		t.split = append([]byte{}, data[cut:]...)
+		data = data[:cut]
+	}
+	if len(data) == 0 {
+		return
+	}
+	text, _ := json.Marshal(string(data))
+	fmt.Fprintf(t.f, "[%.6f, \"o\", %s]\n", now.Sub(t.start).Seconds(), text)
+}
+
+// utf8Cut returns where an incomplete character at the end of data starts,
+// or len(data).
+func utf8Cut(data []byte) int {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(data)
+}
+
+// close records when the command ended.
+func (t *teeFile) close(now time.Time) {
+	t.line(t.redact.flush(), "stdout", now)
+	if !t.cast {
+		fmt.Fprintln(t.f, t.since(now))
+	}
+	t.f.Close()
+}
+
+func (t *teeFile) since(now time.Time) time.Duration {
+	return now.Sub(t.start).Round(time.Millisecond)
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/f0i/status-light/pkg/backend"
+	"github.com/f0i/status-light/pkg/state"
+	"github.com/f0i/status-light/pkg/wrap"
+	"golang.org/x/term"
+)
+
+const (
+	tunePrefix    = 0x14 // ctrl-t
+	tuneMaxEvents = 200
+)
+
+// tuneCommand is a request from the keyboard to the tuner: add a pattern
+// ("waiting", "thinking", "error"), "delete" one by its id, "save" or
+// "quit". prompt updates the input line.
+type tuneCommand struct {
+	kind   string
+	text   string
+	prompt *string
+}
+
+// tuner runs a command in the left half of the terminal and shows the
+// patterns and what they matched in the right half.
+type tuner struct {
+	tool     string
+	path     string
+	patterns state.Patterns
+	mon      *state.Monitor
+	session  *wrap.Session
+
+	hits    map[string]int
+	events  []string
+	prompt  string
+	message string
+	quit    bool
+
+	width, height int
+	leftWidth     int
+}
+
+// cmdTune runs `sl tune <command>`.
+func cmdTune(args []string) int {
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: %s tune <command> [args...]\n\nRuns the command next to a live view of the patterns. Press ctrl-t then:\n  w/t/e  add a waiting/thinking/error pattern\n  d      delete a pattern by its id (e.g. t2)\n  s      save configs/<tool>.json\n  q      quit\n  ctrl-t send ctrl-t to the command\n", os.Args[0])
+		return 2
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		fmt.Fprintln(os.Stderr, "sl tune needs a terminal")
+		return 1
+	}
+	w, h, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || w < 40 || h < 10 {
+		fmt.Fprintln(os.Stderr, "Terminal too small for sl tune")
+		return 1
+	}
+
+	tool := detectTool(args)
+	cfg := loadConfig(tool)
+	local := ledFromConfig(cfg)
+	led := newIndicator(tool, "", localBackends(cfg, local))
+	t := &tuner{
+		tool:      tool,
+		path:      filepath.Join("configs", tool+".json"),
+		patterns:  cfg.Patterns,
+		hits:      make(map[string]int),
+		width:     w,
+		height:    h,
+		leftWidth: w * 3 / 5,
+	}
+	t.mon = state.NewMonitor(cfg.Config, led)
+	t.mon.Tool = tool
+	t.mon.Observe = t.observe
+	t.mon.Screen.Resize(t.leftWidth, h-1)
+
+	session, err := wrap.StartPTYSilent(exec.Command(args[0], args[1:]...))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to start command: %v\n", err)
+		return 1
+	}
+	t.session = session
+	session.SetSize(t.leftWidth, h-1)
+
+	oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err == nil {
+		defer term.Restore(int(os.Stdin.Fd()), oldState)
+	}
+	// Alternate screen, hidden cursor
+	os.Stdout.WriteString("\x1b[?1049h\x1b[?25l")
+	defer os.Stdout.WriteString("\x1b[?25h\x1b[?1049l")
+
+	commands := make(chan tuneCommand, 16)
+	go t.readKeys(session, commands)
+
+	update := func() {
+		for drained := false; !drained; {
+			select {
+			case c := <-commands:
+				t.handle(c)
+			default:
+				drained = true
+			}
+		}
+		t.render()
+	}
+	t.mon.Start(t.mon.Clock.Now())
+	wrap.Loop(t.mon, session, nil, nil, update)
+	session.Wait()
+	backend.Shutdown(led)
+
+	// Keep the view so the patterns can still be saved
+	if !t.quit {
+		t.message = "command exited, ctrl-t q to quit"
+	}
+	for !t.quit {
+		update()
+		time.Sleep(100 * time.Millisecond)
+	}
+	return 0
+}
+
+// readKeys forwards keystrokes to the command except for the ctrl-t
+// commands, which are sent to the tuner.
+func (t *tuner) readKeys(session *wrap.Session, commands chan<- tuneCommand) {
+	const (
+		normal = iota
+		menu
+		editing
+	)
+	mode, kind := normal, ""
+	var line []byte
+	setPrompt := func(p string) {
+		commands <- tuneCommand{prompt: &p}
+	}
+	buf := make([]byte, 256)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, b := range buf[:n] {
+			switch mode {
+			case normal:
+				if b == tunePrefix {
+					mode = menu
+					setPrompt("w/t/e add, d delete, s save, q quit")
+				} else {
+					session.Write([]byte{b})
+				}
+			case menu:
+				mode = normal
+				setPrompt("")
+				switch b {
+				case 'w', 't', 'e':
+					kind = map[byte]string{'w': "waiting", 't': "thinking", 'e': "error"}[b]
+					mode, line = editing, line[:0]
+					setPrompt("add " + kind + " pattern: ")
+				case 'd':
+					kind = "delete"
+					mode, line = editing, line[:0]
+					setPrompt("delete pattern id: ")
+				case 's':
+					commands <- tuneCommand{kind: "save"}
+				case 'q':
+					commands <- tuneCommand{kind: "quit"}
+				case tunePrefix:
+					session.Write([]byte{b})
+				}
+			case editing:
+				label := "add " + kind + " pattern: "
+				if kind == "delete" {
+					label = "delete pattern id: "
+				}
+				switch {
+				case b == '\r' || b == '\n':
+					mode = normal
+					commands <- tuneCommand{kind: kind, text: string(line)}
+					setPrompt("")
+				case b == 0x1b || b == 0x03:
+					mode = normal
+					setPrompt("")
+				case b == 0x7f || b == 0x08:
+					if len(line) > 0 {
+						_, size := utf8.DecodeLastRune(line)
+						line = line[:len(line)-size]
+					}
+					setPrompt(label + string(line))
+				case b >= 0x20:
+					line = append(line, b)
+					setPrompt(label + string(line))
+				}
+			}
+		}
+	}
+}
+
+func (t *tuner) observe(e state.Event) {
+	line := e.Time.Format("15:04:05") + " "
+	if e.Pattern != "" {
+		t.hits[e.State.String()+"\x00"+e.Pattern]++
+		line += fmt.Sprintf("%s match %q (%s)", e.State, e.Pattern, e.Stream)
+	} else {
+		line += fmt.Sprintf("-> %s (%s)", e.State, e.Reason)
+	}
+	t.events = append(t.events, line)
+	if len(t.events) > tuneMaxEvents {
+		t.events = t.events[len(t.events)-tuneMaxEvents:]
+	}
+}
+
+// patternList is one of the tuner's pattern lists; patterns are referred
+// to by id prefix and 1-based index, e.g. t2.
+type patternList struct {
+	id   string
+	name string
+	list *state.PatternList
+}
+
+func (t *tuner) lists() []patternList {
+	return []patternList{
+		{"w", "waiting", &t.patterns.Waiting},
+		{"t", "thinking", &t.patterns.Thinking},
+		{"e", "error", &t.patterns.Error},
+	}
+}
+
+func (t *tuner) handle(c tuneCommand) {
+	if c.prompt != nil {
+		t.prompt = *c.prompt
+		return
+	}
+	switch c.kind {
+	case "waiting", "thinking", "error":
+		if c.text == "" {
+			return
+		}
+		if _, err := regexp.Compile(c.text); err != nil {
+			t.message = "invalid pattern: " + err.Error()
+			return
+		}
+		for _, l := range t.lists() {
+			if l.name == c.kind {
+				*l.list = append(*l.list, c.text)
+			}
+		}
+		t.mon.SetPatterns(t.patterns)
+		t.message = "added " + c.kind + " pattern"
+	case "delete":
+		id := strings.TrimSpace(c.text)
+		for _, l := range t.lists() {
+			if !strings.HasPrefix(id, l.id) {
+				continue
+			}
+			i, err := strconv.Atoi(id[len(l.id):])
+			if err != nil || i < 1 || i > len(*l.list) {
+				break
+			}
+			*l.list = append((*l.list)[:i-1], (*l.list)[i:]...)
+			t.mon.SetPatterns(t.patterns)
+			t.message = "deleted " + id
+			return
+		}
+		t.message = "no pattern " + id
+	case "save":
+		if err := savePatterns(t.path, t.patterns); err != nil {
+			t.message = "save failed: " + err.Error()
+		} else {
+			t.message = "saved " + t.path
+		}
+	case "quit":
+		t.quit = true
+		go t.session.Stop()
+	}
+}
+
+// render redraws the whole screen: output on the left, patterns and events
+// on the right, and a status line.
+func (t *tuner) render() {
+	rows := t.height - 1
+	rightWidth := t.width - t.leftWidth - 1
+
+	left := t.mon.Screen.Lines()
+	var right []string
+	right = append(right, "Patterns (ctrl-t for commands)")
+	for _, l := range t.lists() {
+		for i, p := range *l.list {
+			right = append(right, fmt.Sprintf("%s%-2d %4d  %s", l.id, i+1, t.hits[l.name+"\x00"+p], p))
+		}
+	}
+	right = append(right, "", "Events")
+	room := rows - len(right)
+	events := t.events
+	if room < 0 {
+		room = 0
+	}
+	if len(events) > room {
+		events = events[len(events)-room:]
+	}
+	right = append(right, events...)
+
+	var out bytes.Buffer
+	out.WriteString("\x1b[H")
+	for y := 0; y < rows; y++ {
+		fmt.Fprintf(&out, "\x1b[%d;1H", y+1)
+		l := ""
+		if y < len(left) {
+			l = left[y]
+		}
+		out.WriteString(fit(l, t.leftWidth))
+		out.WriteString("\x1b[0m│")
+		r := ""
+		if y < len(right) {
+			r = right[y]
+		}
+		out.WriteString(fit(r, rightWidth))
+	}
+
+	r, g, b := backend.StateColor(t.mon.State)
+	status := t.prompt
+	if status == "" {
+		status = t.message
+	}
+	fmt.Fprintf(&out, "\x1b[%d;1H\x1b[7m\x1b[38;2;%d;%d;%dm %-8s \x1b[0m\x1b[7m %s\x1b[0m", t.height, r, g, b, t.mon.State, fit(status, t.width-12))
+	os.Stdout.Write(out.Bytes())
+}
+
+// fit cuts or pads s to width runes. Escape sequences are dropped since the
+// screen lines are plain text already.
+func fit(s string, width int) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 0x20 {
+			return -1
+		}
+		return r
+	}, s)
+	if n := utf8.RuneCountInString(s); n < width {
+		return s + strings.Repeat(" ", width-n)
+	}
+	runes := []rune(s)
+	return string(runes[:width])
+}
+
+// savePatterns writes patterns into the config at path, keeping its other
+// settings. Comments are not preserved.
+func savePatterns(path string, patterns state.Patterns) error {
+	settings := map[string]json.RawMessage{}
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(stripComments(data), &settings); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	} else {
+		settings["idle_threshold_ms"] = json.RawMessage("500")
+	}
+	// "error" and "success" are only written when used, the Zig version
+	// doesn't know them
+	lists := map[string][]string{
+		"waiting":  append([]string{}, patterns.Waiting...),
+		"thinking": append([]string{}, patterns.Thinking...),
+	}
+	if len(patterns.Error) > 0 {
+		lists["error"] = patterns.Error
+	}
+	if len(patterns.Success) > 0 {
+		lists["success"] = patterns.Success
+	}
+	p, err := json.Marshal(lists)
+	if err != nil {
+		return err
+	}
+	settings["patterns"] = p
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
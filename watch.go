@@ -0,0 +1,145 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/f0i/status-light/pkg/backend"
+	"github.com/f0i/status-light/pkg/state"
+	"github.com/f0i/status-light/pkg/wrap"
+	"golang.org/x/term"
+)
+
+// cmdWatch drives the LED from output that is produced elsewhere: stdin
+// (e.g. `tmux pipe-pane 'sl watch'`) or a log file that keeps growing.
+func cmdWatch(args []string) int {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	file := fs.String("f", "", "follow `file` like tail -f instead of reading stdin")
+	tool := fs.String("tool", "default", "config `name` to load from configs/")
+	cols := fs.Int("cols", 0, "screen width of the watched program (default: this terminal or 80)")
+	rows := fs.Int("rows", 0, "screen height of the watched program (default: this terminal or 24)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s watch [options]\n\nOptions:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	var src io.Reader = os.Stdin
+	if *file != "" {
+		src = &followReader{path: *file}
+	}
+
+	cfg := loadConfig(*tool)
+	local := ledFromConfig(cfg)
+	quiet := backend.StartQuiet(cfg.QuietHours, cfg.Presence, local)
+	led := newIndicator(*tool, "", localBackends(cfg, local))
+	mon := state.NewMonitor(cfg.Config, led)
+	mon.Tool = *tool
+	mon.Quiet = quiet
+	mon.Screen.AutoCR = true
+	w, h := 80, 24
+	if tw, th, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+		w, h = tw, th
+	}
+	if *cols > 0 {
+		w = *cols
+	}
+	if *rows > 0 {
+		h = *rows
+	}
+	mon.Screen.Resize(w, h)
+
+	// Nothing else turns the LED off when the user interrupts a tail
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		backend.Shutdown(led)
+		os.Exit(0)
+	}()
+
+	session := wrap.WatchReader(src)
+	mon.Start(mon.Clock.Now())
+	wrap.Loop(mon, session, nil, nil, reportSession(led, mon))
+	backend.Shutdown(led)
+	return 0
+}
+
+// followReader reads a file like tail -F: it starts at the current end,
+// waits for new data at EOF and reopens the file when it is rotated or
+// truncated. If alive is set, the stream ends once it reports false.
+type followReader struct {
+	path  string
+	alive func() bool
+	f     *os.File
+	info  os.FileInfo
+	off   int64
+}
+
+func (r *followReader) Read(p []byte) (int, error) {
+	for {
+		if r.alive != nil && !r.alive() {
+			return 0, io.EOF
+		}
+		if r.f == nil {
+			if err := r.open(r.info == nil); err != nil {
+				time.Sleep(250 * time.Millisecond)
+				continue
+			}
+		}
+		n, err := r.f.Read(p)
+		r.off += int64(n)
+		if n > 0 {
+			return n, nil
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		time.Sleep(100 * time.Millisecond)
+		r.checkRotation()
+	}
+}
+
+// open opens the file, seeking to the end the first time so old output
+// doesn't replay into the LED state.
+func (r *followReader) open(atEnd bool) error {
+	f, err := os.Open(r.path)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.off = 0
+	if atEnd {
+		if r.off, err = f.Seek(0, io.SeekEnd); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	r.f, r.info = f, info
+	return nil
+}
+
+func (r *followReader) checkRotation() {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return
+	}
+	if !os.SameFile(info, r.info) {
+		r.f.Close()
+		r.f = nil
+		return
+	}
+	if info.Size() < r.off {
+		r.f.Seek(0, io.SeekStart)
+		r.off = 0
+	}
+}
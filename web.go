@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/f0i/status-light/pkg/backend"
+	"github.com/f0i/status-light/pkg/state"
+)
+
+// HTTPConfig makes the daemon serve a dashboard, e.g. for a phone on the
+// LAN. With Token set, requests must carry it as ?token= or a bearer token.
+// Without one, the dashboard shows what is on screen to this machine only.
+type HTTPConfig struct {
+	Listen string `json:"listen"` // e.g. ":7777"
+	Token  string `json:"token"`
+}
+
+// address is where to listen: Listen, or without a token its port on
+// 127.0.0.1. Other addresses need a token.
+func (c HTTPConfig) address() (string, error) {
+	if c.Token != "" {
+		return c.Listen, nil
+	}
+	host, port, err := net.SplitHostPort(c.Listen)
+	if err != nil {
+		return "", err
+	}
+	if host == "" {
+		return net.JoinHostPort("127.0.0.1", port), nil
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return "", fmt.Errorf("listening on %s needs a token, or the dashboard shows every session's screen to anyone who can reach it", c.Listen)
+	}
+	return c.Listen, nil
+}
+
+//go:embed web/dashboard.html
+var dashboardHTML string
+
+// serveHTTP serves the dashboard and its API until the process ends.
+func (d *Daemon) serveHTTP(cfg HTTPConfig) {
+	addr, err := cfg.address()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Dashboard: %v\n", err)
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, strings.Replace(dashboardHTML, "/*COLORS*/{}", stateColors(), 1))
+	})
+	mux.HandleFunc("GET /events", d.serveEvents)
+	mux.HandleFunc("GET /api/status", func(w http.ResponseWriter, r *http.Request) {
+		d.mu.Lock()
+		st := d.status()
+		d.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(st)
+	})
+	if d.debug {
+		fmt.Fprintf(os.Stderr, "[DEBUG] Dashboard on http://%s/\n", addr)
+	}
+	if err := http.ListenAndServe(addr, requireToken(cfg.Token, mux)); err != nil {
+		fmt.Fprintf(os.Stderr, "Dashboard: %v\n", err)
+	}
+}
+
+// requireToken rejects requests without the token, if there is one.
+func requireToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := r.URL.Query().Get("token")
+		if h, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			got = h
+		}
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// stateColors returns the theme's colors as a JSON object for the page.
+func stateColors() string {
+	colors := map[string]string{"off": "#808080"}
+	for st := state.Idle; st <= state.Responding; st++ {
+		r, g, b := backend.StateColor(st)
+		colors[st.String()] = fmt.Sprintf("#%02x%02x%02x", r, g, b)
+	}
+	data, _ := json.Marshal(colors)
+	return string(data)
+}